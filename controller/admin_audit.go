@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"billing3/database"
+	"billing3/database/types"
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// auditLogResp is an audit log entry with the diff as JSON instead of bytes.
+type auditLogResp struct {
+	ID         int32           `json:"id"`
+	ActorID    pgtype.Int4     `json:"actor_id"` // null for actions of the system
+	ActorName  string          `json:"actor_name"`
+	Ip         string          `json:"ip"`
+	EntityType string          `json:"entity_type"`
+	EntityID   string          `json:"entity_id"`
+	Action     string          `json:"action"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	CreatedAt  types.Timestamp `json:"created_at"`
+}
+
+func rawJSON(b []byte) json.RawMessage {
+	if b == nil {
+		return json.RawMessage("null")
+	}
+	return b
+}
+
+// writeAuditLogs writes a page of the audit log entries matching the filters.
+func writeAuditLogs(w http.ResponseWriter, r *http.Request, filters database.SearchAuditLogsCountParams) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	count, err := database.Q.SearchAuditLogsCount(r.Context(), filters)
+	if err != nil {
+		slog.Error("admin list audit logs", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	totalPages := int(math.Ceil(float64(count) / float64(itemPerPage)))
+
+	logs, err := database.Q.SearchAuditLogsPaged(r.Context(), database.SearchAuditLogsPagedParams{
+		Limit:      itemPerPage,
+		Offset:     int32((page - 1) * itemPerPage),
+		ActorID:    filters.ActorID,
+		EntityType: filters.EntityType,
+		EntityID:   filters.EntityID,
+		Action:     filters.Action,
+	})
+	if err != nil {
+		slog.Error("admin list audit logs", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	result := make([]auditLogResp, len(logs))
+	for i, l := range logs {
+		result[i] = auditLogResp{
+			ID:         l.ID,
+			ActorID:    l.ActorID,
+			ActorName:  l.ActorName,
+			Ip:         l.Ip,
+			EntityType: l.EntityType,
+			EntityID:   l.EntityID,
+			Action:     l.Action,
+			Before:     rawJSON(l.Before),
+			After:      rawJSON(l.After),
+			CreatedAt:  l.CreatedAt,
+		}
+	}
+
+	writeResp(w, http.StatusOK, D{"logs": result, "total_pages": totalPages})
+}
+
+func adminAuditList(w http.ResponseWriter, r *http.Request) {
+	// actor_id is ignored if it is not a number
+	actorId, _ := strconv.Atoi(r.URL.Query().Get("actor_id"))
+
+	writeAuditLogs(w, r, database.SearchAuditLogsCountParams{
+		ActorID:    int32(actorId),
+		EntityType: r.URL.Query().Get("entity_type"),
+		EntityID:   r.URL.Query().Get("entity_id"),
+		Action:     r.URL.Query().Get("action"),
+	})
+}
+
+// adminAuditHistory returns a handler that lists the audit log entries of the entity in the url, e.g.
+// /admin/user/{id}/audit.
+func adminAuditHistory(entityType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		writeAuditLogs(w, r, database.SearchAuditLogsCountParams{
+			EntityType: entityType,
+			EntityID:   strconv.Itoa(id),
+			Action:     r.URL.Query().Get("action"),
+		})
+	}
+}
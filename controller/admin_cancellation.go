@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"billing3/database"
+	"billing3/service"
+	"billing3/service/audit"
+	"billing3/service/extension"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func adminCancellationList(w http.ResponseWriter, r *http.Request) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	status := r.URL.Query().Get("status")
+
+	count, err := database.Q.SearchCancellationRequestsCount(r.Context(), status)
+	if err != nil {
+		slog.Error("admin list cancellation requests", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	totalPages := int(math.Ceil(float64(count) / float64(itemPerPage)))
+
+	requests, err := database.Q.SearchCancellationRequestsPaged(r.Context(), database.SearchCancellationRequestsPagedParams{
+		Limit:  itemPerPage,
+		Offset: int32((page - 1) * itemPerPage),
+		Status: status,
+	})
+	if err != nil {
+		slog.Error("admin list cancellation requests", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"cancellations": requests, "total_pages": totalPages})
+}
+
+func adminServiceCancellations(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	requests, err := database.Q.ListCancellationRequestsByService(r.Context(), int32(id))
+	if err != nil {
+		slog.Error("admin list cancellation requests", "err", err, "service id", id)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"cancellations": requests})
+}
+
+func adminCancellationApprove(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	err = service.ApproveCancellation(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, service.ErrCancellationNotPending) || errors.Is(err, extension.ErrActionRunning) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		slog.Error("admin approve cancellation", "err", err, "id", id)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(r.Context(), audit.EntityCancellation, id, "approve", nil, nil)
+
+	writeResp(w, http.StatusOK, D{})
+}
+
+func adminCancellationReject(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	err = service.RejectCancellation(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, service.ErrCancellationNotPending) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		slog.Error("admin reject cancellation", "err", err, "id", id)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(r.Context(), audit.EntityCancellation, id, "reject", nil, nil)
+
+	writeResp(w, http.StatusOK, D{})
+}
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"billing3/database"
+	"billing3/service/audit"
 	"errors"
 	"github.com/jackc/pgx/v5/pgconn"
 	"log/slog"
@@ -36,16 +37,19 @@ func adminCategoryCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, err := database.Q.CreateCategory(r.Context(), database.CreateCategoryParams{
+	params := database.CreateCategoryParams{
 		Name:        req.Name,
 		Description: req.Description,
-	})
+	}
+	id, err := database.Q.CreateCategory(r.Context(), params)
 	if err != nil {
 		slog.Error("admin create category", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	audit.Log(r.Context(), audit.EntityCategory, id, "create", nil, params)
+
 	writeResp(w, http.StatusOK, D{
 		"id": id,
 	})
@@ -68,17 +72,31 @@ func adminCategoryUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = database.Q.UpdateCategory(r.Context(), database.UpdateCategoryParams{
+	category, err := database.Q.FindCategoryById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("admin update category", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	params := database.UpdateCategoryParams{
 		Name:        req.Name,
 		Description: req.Description,
 		ID:          int32(id),
-	})
+	}
+	err = database.Q.UpdateCategory(r.Context(), params)
 	if err != nil {
 		slog.Error("admin update category", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	audit.Log(r.Context(), audit.EntityCategory, id, "update", category, params)
+
 	writeResp(w, http.StatusOK, D{})
 }
 
@@ -110,6 +128,17 @@ func adminCategoryDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	category, err := database.Q.FindCategoryById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("admin delete category", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
 	err = database.Q.DeleteCategory(r.Context(), int32(id))
 	if err != nil {
 		if err, ok := err.(*pgconn.PgError); ok && err.Code == "23503" {
@@ -121,5 +150,7 @@ func adminCategoryDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	audit.Log(r.Context(), audit.EntityCategory, id, "delete", category, nil)
+
 	writeResp(w, http.StatusOK, D{})
 }
@@ -0,0 +1,220 @@
+package controller
+
+import (
+	"billing3/database"
+	"billing3/database/types"
+	"billing3/service"
+	"billing3/service/audit"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/shopspring/decimal"
+)
+
+type adminCouponReq struct {
+	Code           string          `json:"code" validate:"required,max=200"`
+	Type           string          `json:"type" validate:"required,oneof=percentage fixed"`
+	Value          decimal.Decimal `json:"value"`
+	Recurring      bool            `json:"recurring"`
+	ProductIds     []int32         `json:"product_ids"` // empty means all products
+	Durations      []int32         `json:"durations"`   // empty means all billing cycles
+	MaxUses        int32           `json:"max_uses" validate:"min=0"`
+	MaxUsesPerUser int32           `json:"max_uses_per_user" validate:"min=0"`
+	ExpiresAt      types.Timestamp `json:"expires_at"`
+	Enabled        bool            `json:"enabled"`
+}
+
+// validateCouponReq returns a message to be shown to the admin, or an empty string if the request is valid
+func validateCouponReq(req *adminCouponReq) string {
+	if req.Value.LessThanOrEqual(decimal.Zero) {
+		return "value must be positive"
+	}
+	if req.Type == service.CouponPercentage && req.Value.GreaterThan(decimal.NewFromInt(100)) {
+		return "percentage must not exceed 100"
+	}
+	if req.ProductIds == nil {
+		req.ProductIds = make([]int32, 0)
+	}
+	if req.Durations == nil {
+		req.Durations = make([]int32, 0)
+	}
+	return ""
+}
+
+func adminCouponList(w http.ResponseWriter, r *http.Request) {
+	coupons, err := database.Q.ListCoupons(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		slog.Error("admin list coupon", "err", err)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{
+		"coupons": coupons,
+	})
+}
+
+func adminCouponCreate(w http.ResponseWriter, r *http.Request) {
+	req, err := decode[adminCouponReq](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if msg := validateCouponReq(req); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	params := database.CreateCouponParams{
+		Code:           service.NormalizeCouponCode(req.Code),
+		Type:           req.Type,
+		Value:          req.Value,
+		Recurring:      req.Recurring,
+		ProductIds:     req.ProductIds,
+		Durations:      req.Durations,
+		MaxUses:        req.MaxUses,
+		MaxUsesPerUser: req.MaxUsesPerUser,
+		ExpiresAt:      req.ExpiresAt,
+		Enabled:        req.Enabled,
+	}
+	id, err := database.Q.CreateCoupon(r.Context(), params)
+	if err != nil {
+		if err, ok := err.(*pgconn.PgError); ok && err.Code == database.PGErrorUniqueViolation {
+			writeError(w, http.StatusBadRequest, "coupon code already exists")
+			return
+		}
+		slog.Error("admin create coupon", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(r.Context(), audit.EntityCoupon, id, "create", nil, params)
+
+	writeResp(w, http.StatusOK, D{
+		"id": id,
+	})
+}
+
+func adminCouponUpdate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	req, err := decode[adminCouponReq](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if msg := validateCouponReq(req); msg != "" {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	coupon, err := database.Q.FindCouponById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("admin update coupon", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// services with a recurring coupon keep the discount of the updated coupon
+	params := database.UpdateCouponParams{
+		Code:           service.NormalizeCouponCode(req.Code),
+		Type:           req.Type,
+		Value:          req.Value,
+		Recurring:      req.Recurring,
+		ProductIds:     req.ProductIds,
+		Durations:      req.Durations,
+		MaxUses:        req.MaxUses,
+		MaxUsesPerUser: req.MaxUsesPerUser,
+		ExpiresAt:      req.ExpiresAt,
+		Enabled:        req.Enabled,
+		ID:             int32(id),
+	}
+	err = database.Q.UpdateCoupon(r.Context(), params)
+	if err != nil {
+		if err, ok := err.(*pgconn.PgError); ok && err.Code == database.PGErrorUniqueViolation {
+			writeError(w, http.StatusBadRequest, "coupon code already exists")
+			return
+		}
+		slog.Error("admin update coupon", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(r.Context(), audit.EntityCoupon, id, "update", coupon, params)
+
+	writeResp(w, http.StatusOK, D{})
+}
+
+func adminCouponGet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	c, err := database.Q.FindCouponById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		slog.Error("admin coupon get", "err", err)
+		return
+	}
+
+	uses, err := database.Q.CountCouponUses(r.Context(), c.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		slog.Error("admin coupon get", "err", err)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"coupon": c, "uses": uses})
+}
+
+// adminCouponDelete deletes the coupon. Recurring discounts of services using the coupon are removed.
+func adminCouponDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	coupon, err := database.Q.FindCouponById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("admin delete coupon", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = database.Q.DeleteCoupon(r.Context(), int32(id))
+	if err != nil {
+		slog.Error("admin delete coupon", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(r.Context(), audit.EntityCoupon, id, "delete", coupon, nil)
+
+	writeResp(w, http.StatusOK, D{})
+}
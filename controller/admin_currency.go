@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"billing3/database"
+	"billing3/service"
+	"billing3/service/audit"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+)
+
+func adminCurrencyList(w http.ResponseWriter, r *http.Request) {
+	rates, err := database.Q.ListExchangeRates(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		slog.Error("admin list exchange rates", "err", err)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{
+		"base":  service.BaseCurrency(r.Context()),
+		"rates": rates,
+	})
+}
+
+func adminCurrencyUpdate(w http.ResponseWriter, r *http.Request) {
+	currency := service.NormalizeCurrency(chi.URLParam(r, "currency"))
+	if !service.IsValidCurrencyCode(currency) {
+		writeError(w, http.StatusBadRequest, "invalid currency")
+		return
+	}
+
+	type reqStruct struct {
+		Rate decimal.Decimal `json:"rate"` // amount of the currency that equals 1 unit of the base currency
+	}
+	req, err := decode[reqStruct](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.Rate.LessThanOrEqual(decimal.Zero) {
+		writeError(w, http.StatusBadRequest, "rate must be greater than zero")
+		return
+	}
+
+	if currency == service.BaseCurrency(r.Context()) {
+		writeError(w, http.StatusBadRequest, "the base currency does not need an exchange rate")
+		return
+	}
+
+	// before is nil if the currency is new
+	var before any
+	rate, err := database.Q.FindExchangeRate(r.Context(), currency)
+	if err == nil {
+		before = rate
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		slog.Error("admin update exchange rate", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	params := database.UpsertExchangeRateParams{
+		Currency: currency,
+		Rate:     req.Rate,
+	}
+	err = database.Q.UpsertExchangeRate(r.Context(), params)
+	if err != nil {
+		slog.Error("admin update exchange rate", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("update exchange rate", "currency", currency, "rate", req.Rate)
+	audit.Log(r.Context(), audit.EntityCurrency, currency, "update", before, params)
+
+	writeResp(w, http.StatusOK, D{})
+}
+
+func adminCurrencyDelete(w http.ResponseWriter, r *http.Request) {
+	currency := service.NormalizeCurrency(chi.URLParam(r, "currency"))
+
+	rate, err := database.Q.FindExchangeRate(r.Context(), currency)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("admin delete exchange rate", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// existing services and invoices keep the currency, but it can not be chosen any more
+	err = database.Q.DeleteExchangeRate(r.Context(), currency)
+	if err != nil {
+		slog.Error("admin delete exchange rate", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(r.Context(), audit.EntityCurrency, currency, "delete", rate, nil)
+
+	writeResp(w, http.StatusOK, D{})
+}
+
+// listCurrencies returns the currencies that clients can choose
+func listCurrencies(w http.ResponseWriter, r *http.Request) {
+	currencies, err := service.ListCurrencies(r.Context())
+	if err != nil {
+		slog.Error("list currencies", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"currencies": currencies})
+}
@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"billing3/database"
+	"billing3/service/audit"
+	"billing3/service/email"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+func adminEmailList(w http.ResponseWriter, r *http.Request) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	status := r.URL.Query().Get("status")
+	recipient := r.URL.Query().Get("recipient")
+
+	count, err := database.Q.SearchEmailLogsCount(r.Context(), database.SearchEmailLogsCountParams{
+		Status:    status,
+		Recipient: recipient,
+	})
+	if err != nil {
+		slog.Error("admin list emails", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	totalPages := int(math.Ceil(float64(count) / float64(itemPerPage)))
+
+	emails, err := database.Q.SearchEmailLogsPaged(r.Context(), database.SearchEmailLogsPagedParams{
+		Limit:     itemPerPage,
+		Offset:    int32((page - 1) * itemPerPage),
+		Status:    status,
+		Recipient: recipient,
+	})
+	if err != nil {
+		slog.Error("admin list emails", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"emails": emails, "total_pages": totalPages})
+}
+
+func adminEmailGet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	e, err := database.Q.FindEmailLogById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("admin get email", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// the body has a secret link until it is sent
+	if e.Sensitive {
+		e.Html = ""
+		e.Text = ""
+	}
+
+	writeResp(w, http.StatusOK, D{"email": e})
+}
+
+func adminEmailResend(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	err = email.Resend(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, email.ErrEmailQueued) || errors.Is(err, email.ErrEmailSensitive) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		slog.Error("admin resend email", "err", err, "id", id)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(r.Context(), audit.EntityEmail, id, "resend", nil, nil)
+
+	writeResp(w, http.StatusOK, D{})
+}
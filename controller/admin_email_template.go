@@ -0,0 +1,173 @@
+package controller
+
+import (
+	"billing3/database"
+	"billing3/service/audit"
+	"billing3/service/notification"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+func adminEmailTemplateList(w http.ResponseWriter, r *http.Request) {
+	edited, err := database.Q.ListEmailTemplates(r.Context())
+	if err != nil {
+		slog.Error("admin list email templates", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	editedEvents := make(map[string]bool)
+	for _, t := range edited {
+		editedEvents[t.Event] = true
+	}
+
+	type respStruct struct {
+		Event       string `json:"event"`
+		Description string `json:"description"`
+		Edited      bool   `json:"edited"`
+	}
+
+	resp := make([]respStruct, len(notification.Events))
+	for i, e := range notification.Events {
+		resp[i] = respStruct{
+			Event:       e.Name,
+			Description: e.Description,
+			Edited:      editedEvents[e.Name],
+		}
+	}
+
+	writeResp(w, http.StatusOK, D{"templates": resp})
+}
+
+func adminEmailTemplateGet(w http.ResponseWriter, r *http.Request) {
+	event, err := notification.FindEvent(chi.URLParam(r, "event"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	t, edited, err := notification.GetTemplate(r.Context(), event)
+	if err != nil {
+		slog.Error("admin get email template", "err", err, "event", event.Name)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{
+		"event":       event.Name,
+		"description": event.Description,
+		"edited":      edited,
+		"template":    t,
+		"default":     event.Default,
+	})
+}
+
+func adminEmailTemplateUpdate(w http.ResponseWriter, r *http.Request) {
+	event, err := notification.FindEvent(chi.URLParam(r, "event"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	req, err := decode[notification.Template](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// catch errors that only happen when the template is executed
+	err = req.Validate()
+	if err == nil {
+		_, err = notification.Preview(r.Context(), event, req)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid template: "+err.Error())
+		return
+	}
+
+	// before is nil if the default template was used
+	var before any
+	template, err := database.Q.FindEmailTemplate(r.Context(), event.Name)
+	if err == nil {
+		before = template
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		slog.Error("admin update email template", "err", err, "event", event.Name)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	params := database.UpsertEmailTemplateParams{
+		Event:   event.Name,
+		Subject: req.Subject,
+		Html:    req.HTML,
+		Text:    req.Text,
+	}
+	err = database.Q.UpsertEmailTemplate(r.Context(), params)
+	if err != nil {
+		slog.Error("admin update email template", "err", err, "event", event.Name)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(r.Context(), audit.EntityEmailTemplate, event.Name, "update", before, params)
+
+	writeResp(w, http.StatusOK, D{})
+}
+
+func adminEmailTemplateReset(w http.ResponseWriter, r *http.Request) {
+	event, err := notification.FindEvent(chi.URLParam(r, "event"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	template, err := database.Q.FindEmailTemplate(r.Context(), event.Name)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			// already the default template
+			writeResp(w, http.StatusOK, D{})
+			return
+		}
+		slog.Error("admin reset email template", "err", err, "event", event.Name)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = database.Q.DeleteEmailTemplate(r.Context(), event.Name)
+	if err != nil {
+		slog.Error("admin reset email template", "err", err, "event", event.Name)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(r.Context(), audit.EntityEmailTemplate, event.Name, "reset", template, nil)
+
+	writeResp(w, http.StatusOK, D{})
+}
+
+// adminEmailTemplatePreview renders the template in the request body with the sample variables of the event
+func adminEmailTemplatePreview(w http.ResponseWriter, r *http.Request) {
+	event, err := notification.FindEvent(chi.URLParam(r, "event"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	req, err := decode[notification.Template](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	m, err := notification.Preview(r.Context(), event, req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid template: "+err.Error())
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"preview": m})
+}
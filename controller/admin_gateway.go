@@ -2,6 +2,7 @@ package controller
 
 import (
 	"billing3/database"
+	"billing3/service/audit"
 	"billing3/service/gateways"
 	"errors"
 	"fmt"
@@ -138,15 +139,16 @@ func adminGatewayUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = database.Q.UpdateGateway(r.Context(), database.UpdateGatewayParams{
+	params := database.UpdateGatewayParams{
 		DisplayName: req.DisplayName,
 		Settings:    cleanedSettings,
 		Enabled:     req.Enabled,
 		Fee:         pgtype.Text{Valid: true, String: req.Fee},
 		Name:        gateway.Name,
-	})
+	}
+	err = database.Q.UpdateGateway(r.Context(), params)
 	if err != nil {
-		if err, ok := err.(*pgconn.PgError); ok && err.Code == PGErrorUniqueViolation {
+		if err, ok := err.(*pgconn.PgError); ok && err.Code == database.PGErrorUniqueViolation {
 			writeError(w, http.StatusForbidden, "duplicated display name")
 			return
 		}
@@ -154,6 +156,8 @@ func adminGatewayUpdate(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+
+	audit.Log(r.Context(), audit.EntityGateway, id, "update", gateway, params)
 }
 
 func adminGatewaySettings(w http.ResponseWriter, r *http.Request) {
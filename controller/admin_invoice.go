@@ -4,6 +4,8 @@ import (
 	"billing3/database"
 	"billing3/database/types"
 	"billing3/service"
+	"billing3/service/audit"
+	"billing3/service/gateways"
 	"errors"
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v5"
@@ -16,7 +18,7 @@ import (
 
 func adminInvoiceList(w http.ResponseWriter, r *http.Request) {
 	status := r.URL.Query().Get("status")
-	if status != service.InvoiceUnpaid && status != service.InvoicePaid && status != service.InvoiceCancelled {
+	if status != service.InvoiceUnpaid && status != service.InvoicePaid && status != service.InvoiceCancelled && status != service.InvoiceRefunded {
 		status = ""
 	}
 
@@ -68,6 +70,16 @@ func adminInvoiceGet(w http.ResponseWriter, r *http.Request) {
 	writeResp(w, http.StatusOK, D{"invoice": invoice, "items": items})
 }
 
+func adminInvoicePDF(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	writeInvoicePDF(w, r, int32(id))
+}
+
 func adminInvoiceEdit(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
@@ -76,7 +88,7 @@ func adminInvoiceEdit(w http.ResponseWriter, r *http.Request) {
 	}
 
 	type reqStruct struct {
-		Status             string          `json:"status" validate:"required,oneof=PAID UNPAID CANCELLED"`
+		Status             string          `json:"status" validate:"required,oneof=PAID UNPAID CANCELLED REFUNDED"`
 		CancellationReason pgtype.Text     `json:"cancellation_reason" `
 		PaidAt             types.Timestamp `json:"paid_at"`
 		DueAt              types.Timestamp `json:"due_at" validate:"required"`
@@ -87,20 +99,73 @@ func adminInvoiceEdit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	tx, err := database.Conn.Begin(r.Context())
+	if err != nil {
+		slog.Error("begin tx", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer rollbackTx(r.Context(), tx)
+
+	qtx := database.New(tx)
+
+	invoice, err := qtx.FindInvoiceById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		slog.Error("admin update invoice", "err", err)
+		return
+	}
+
 	// update invoice
-	err = database.Q.UpdateInvoice(r.Context(), database.UpdateInvoiceParams{
+	params := database.UpdateInvoiceParams{
 		Status:             req.Status,
 		CancellationReason: req.CancellationReason,
 		PaidAt:             req.PaidAt,
 		DueAt:              req.DueAt,
 		ID:                 int32(id),
-	})
+	}
+	err = qtx.UpdateInvoice(r.Context(), params)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		slog.Error("admin update invoice", "err", err)
 		return
 	}
 
+	if req.Status == service.InvoiceCancelled && invoice.Status != service.InvoiceCancelled {
+		err = service.ReleaseOrderCoupons(r.Context(), qtx, int32(id))
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			slog.Error("admin update invoice", "err", err)
+			return
+		}
+	}
+
+	if req.Status == service.InvoicePaid {
+		err = service.AssignInvoiceNumber(r.Context(), qtx, int32(id))
+		if err != nil {
+			if errors.Is(err, service.ErrNotFound) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			slog.Error("admin update invoice", "err", err)
+			return
+		}
+	}
+
+	audit.LogTx(r.Context(), tx, audit.EntityInvoice, id, "update", invoice, params)
+
+	err = tx.Commit(r.Context())
+	if err != nil {
+		slog.Error("commit tx", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
 	writeResp(w, http.StatusOK, D{})
 }
 
@@ -125,26 +190,29 @@ func adminInvoiceAddItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = database.Q.CreateInvoiceItem(r.Context(), database.CreateInvoiceItemParams{
+	params := database.CreateInvoiceItemParams{
 		InvoiceID:   int32(invoiceId),
 		Description: req.Description,
 		Amount:      req.Amount,
 		Type:        service.InvoiceItemNone,
 		ItemID:      pgtype.Int4{Valid: false},
-	})
+	}
+	err = database.Q.CreateInvoiceItem(r.Context(), params)
 	if err != nil {
 		slog.Error("admin invoice add item", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	err = database.Q.UpdateInvoiceAmount(r.Context(), int32(invoiceId))
+	err = service.RecalculateInvoice(r.Context(), int32(invoiceId))
 	if err != nil {
 		slog.Error("admin invoice add item", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	audit.Log(r.Context(), audit.EntityInvoice, invoiceId, "item.add", nil, params)
+
 	writeResp(w, http.StatusCreated, D{})
 }
 
@@ -171,13 +239,15 @@ func adminInvoiceRemoveItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = database.Q.UpdateInvoiceAmount(r.Context(), int32(invoiceId))
+	err = service.RecalculateInvoice(r.Context(), int32(invoiceId))
 	if err != nil {
 		slog.Error("admin invoice remove item", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	audit.Log(r.Context(), audit.EntityInvoice, invoiceId, "item.remove", D{"item_id": id}, nil)
+
 	writeResp(w, http.StatusOK, D{})
 }
 
@@ -208,25 +278,28 @@ func adminInvoiceUpdateItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = database.Q.UpdateInvoiceItem(r.Context(), database.UpdateInvoiceItemParams{
+	params := database.UpdateInvoiceItemParams{
 		Description: req.Description,
 		Amount:      req.Amount,
 		ID:          int32(id),
 		InvoiceID:   int32(invoiceId),
-	})
+	}
+	err = database.Q.UpdateInvoiceItem(r.Context(), params)
 	if err != nil {
 		slog.Error("admin invoice update item", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	err = database.Q.UpdateInvoiceAmount(r.Context(), int32(invoiceId))
+	err = service.RecalculateInvoice(r.Context(), int32(invoiceId))
 	if err != nil {
 		slog.Error("admin invoice remove item", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	audit.Log(r.Context(), audit.EntityInvoice, invoiceId, "item.update", nil, params)
+
 	writeResp(w, http.StatusOK, D{})
 }
 
@@ -272,6 +345,71 @@ func adminAddInvoicePayment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	audit.Log(r.Context(), audit.EntityInvoice, id, "payment.add", nil, req)
+
+	writeResp(w, http.StatusOK, D{})
+}
+
+func adminRefundInvoicePayment(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	type reqStruct struct {
+		PaymentId     int32           `json:"payment_id" validate:"required"`
+		Amount        decimal.Decimal `json:"amount"` // zero for a full refund
+		Reason        string          `json:"reason" validate:"max=150"`
+		ServiceAction string          `json:"service_action" validate:"omitempty,oneof=suspend cancel"`
+	}
+
+	req, err := decode[reqStruct](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	payment, err := database.Q.FindInvoicePaymentById(r.Context(), req.PaymentId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "payment not found")
+			return
+		}
+		slog.Error("admin refund invoice payment", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if payment.InvoiceID != int32(id) {
+		writeError(w, http.StatusNotFound, "payment not found")
+		return
+	}
+
+	_, err = gateways.RefundPayment(r.Context(), payment.ID, req.Amount.Round(2), req.Reason)
+	if err != nil {
+		if errors.Is(err, gateways.ErrRefundExceedsPayment) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, service.ErrNotFound) {
+			writeError(w, http.StatusNotFound, "payment not found")
+			return
+		}
+		slog.Error("admin refund invoice payment", "err", err)
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	audit.Log(r.Context(), audit.EntityInvoice, id, "payment.refund", nil, req)
+
+	err = service.RefundServiceAction(r.Context(), payment.InvoiceID, req.ServiceAction, req.Reason)
+	if err != nil {
+		slog.Error("admin refund invoice payment", "err", err)
+		writeError(w, http.StatusInternalServerError, "refunded, but service action failed: "+err.Error())
+		return
+	}
+
 	writeResp(w, http.StatusOK, D{})
 }
 
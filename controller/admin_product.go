@@ -3,11 +3,14 @@ package controller
 import (
 	"billing3/database"
 	"billing3/database/types"
+	"billing3/service"
+	"billing3/service/audit"
 	"billing3/service/extension"
 	"database/sql"
 	"errors"
 	"fmt"
 	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/shopspring/decimal"
 	"log/slog"
@@ -28,6 +31,7 @@ type adminProductReqStruct struct {
 	Settings     map[string]string   `json:"settings"`
 	Stock        int32               `json:"stock" validate:"min=0"`
 	StockControl int32               `json:"stock_control" validate:"oneof=1 2"`
+	Dunning      types.DunningPolicy `json:"dunning"`
 	Options      []struct {
 		DisplayName string                    `json:"display_name" validate:"required"`
 		Name        string                    `json:"name" validate:"required"`
@@ -56,6 +60,7 @@ func adminProductList(w http.ResponseWriter, r *http.Request) {
 		Settings     types.ProductSettings `json:"settings"`
 		Stock        int32                 `json:"stock"`
 		StockControl int32                 `json:"stock_control"`
+		Dunning      types.DunningPolicy   `json:"dunning"`
 	}
 
 	products := make([]productStruct, 0)
@@ -80,12 +85,27 @@ func adminProductList(w http.ResponseWriter, r *http.Request) {
 			Settings:     p.Settings,
 			Stock:        p.Stock,
 			StockControl: p.StockControl,
+			Dunning:      p.Dunning,
 		})
 	}
 
 	writeResp(w, http.StatusOK, D{"products": products})
 }
 
+// validateCurrencyPrices validates the prices of a product or option in other currencies
+func validateCurrencyPrices(prices map[string]types.CurrencyPrice) error {
+	for currency, price := range prices {
+		if !service.IsValidCurrencyCode(currency) {
+			return fmt.Errorf("invalid currency: %s", currency)
+		}
+
+		if price.Price.LessThan(decimal.Zero) || price.SetupFee.LessThan(decimal.Zero) {
+			return fmt.Errorf("price and setup fee must not be negative")
+		}
+	}
+	return nil
+}
+
 // returns cleaned extension settings
 func adminProductReqValidate(req *adminProductReqStruct) (map[string]string, error) {
 	// validation
@@ -113,6 +133,11 @@ func adminProductReqValidate(req *adminProductReqStruct) (map[string]string, err
 			return nil, fmt.Errorf("setup fee must not be negative")
 		}
 
+		err := validateCurrencyPrices(p.Currencies)
+		if err != nil {
+			return nil, err
+		}
+
 		// pricing display names must be unique
 		if _, ok := pricingDisplayNames[p.DisplayName]; ok {
 			return nil, fmt.Errorf("duplicated pricing: %s", p.DisplayName)
@@ -127,6 +152,11 @@ func adminProductReqValidate(req *adminProductReqStruct) (map[string]string, err
 		pricingDisplayNames[p.DisplayName] = true
 	}
 
+	err := service.ValidateDunningOverride(&req.Dunning)
+	if err != nil {
+		return nil, err
+	}
+
 	// validating product settings
 	settings, err := ext.ProductSettings(req.Settings)
 	if err != nil {
@@ -199,6 +229,11 @@ func adminProductReqValidate(req *adminProductReqStruct) (map[string]string, err
 						return nil, fmt.Errorf("price and setup fee must not be negative")
 					}
 
+					err := validateCurrencyPrices(price.Currencies)
+					if err != nil {
+						return nil, err
+					}
+
 					found := false
 					for _, productPrice := range req.Pricing {
 						if productPrice.Duration == price.Duration {
@@ -243,7 +278,7 @@ func adminProductCreate(w http.ResponseWriter, r *http.Request) {
 	qtx := database.Q.WithTx(tx)
 
 	// insert product
-	id, err := qtx.CreateProduct(r.Context(), database.CreateProductParams{
+	params := database.CreateProductParams{
 		Name:         req.Name,
 		Description:  req.Description,
 		CategoryID:   req.CategoryId,
@@ -253,9 +288,11 @@ func adminProductCreate(w http.ResponseWriter, r *http.Request) {
 		Settings:     cleanedProductSettings,
 		Stock:        req.Stock,
 		StockControl: req.StockControl,
-	})
+		Dunning:      req.Dunning,
+	}
+	id, err := qtx.CreateProduct(r.Context(), params)
 	if err != nil {
-		if err, ok := err.(*pgconn.PgError); ok && err.Code == PGErrorUniqueViolation {
+		if err, ok := err.(*pgconn.PgError); ok && err.Code == database.PGErrorUniqueViolation {
 			writeError(w, http.StatusForbidden, "duplicated product name")
 			return
 		}
@@ -276,7 +313,7 @@ func adminProductCreate(w http.ResponseWriter, r *http.Request) {
 			Values:      option.Values,
 		})
 		if err != nil {
-			if err, ok := err.(*pgconn.PgError); ok && err.Code == PGErrorUniqueViolation {
+			if err, ok := err.(*pgconn.PgError); ok && err.Code == database.PGErrorUniqueViolation {
 				writeError(w, http.StatusBadRequest, "duplicated option name: "+option.Name)
 				return
 			}
@@ -286,6 +323,8 @@ func adminProductCreate(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	audit.LogTx(r.Context(), tx, audit.EntityProduct, id, "create", nil, params)
+
 	err = tx.Commit(r.Context())
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -325,8 +364,19 @@ func adminProductUpdate(w http.ResponseWriter, r *http.Request) {
 	defer rollbackTx(r.Context(), tx)
 	qtx := database.Q.WithTx(tx)
 
+	product, err := qtx.FindProductById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("admin update product", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
 	// update product
-	err = qtx.UpdateProduct(r.Context(), database.UpdateProductParams{
+	params := database.UpdateProductParams{
 		ID:           int32(id),
 		Name:         req.Name,
 		Description:  req.Description,
@@ -337,9 +387,11 @@ func adminProductUpdate(w http.ResponseWriter, r *http.Request) {
 		Settings:     cleanedProductSettings,
 		Stock:        req.Stock,
 		StockControl: req.StockControl,
-	})
+		Dunning:      req.Dunning,
+	}
+	err = qtx.UpdateProduct(r.Context(), params)
 	if err != nil {
-		if err, ok := err.(*pgconn.PgError); ok && err.Code == PGErrorUniqueViolation {
+		if err, ok := err.(*pgconn.PgError); ok && err.Code == database.PGErrorUniqueViolation {
 			writeError(w, http.StatusForbidden, "Duplicated product name")
 			return
 		}
@@ -368,7 +420,7 @@ func adminProductUpdate(w http.ResponseWriter, r *http.Request) {
 			Values:      option.Values,
 		})
 		if err != nil {
-			if err, ok := err.(*pgconn.PgError); ok && err.Code == PGErrorUniqueViolation {
+			if err, ok := err.(*pgconn.PgError); ok && err.Code == database.PGErrorUniqueViolation {
 				writeError(w, http.StatusBadRequest, "duplicated option name: "+option.Name)
 				return
 			}
@@ -378,6 +430,8 @@ func adminProductUpdate(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	audit.LogTx(r.Context(), tx, audit.EntityProduct, id, "update", product, params)
+
 	// commit tx
 	err = tx.Commit(r.Context())
 	if err != nil {
@@ -396,6 +450,17 @@ func adminProductDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	product, err := database.Q.FindProductById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("admin delete product", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
 	err = database.Q.DeleteProductOptionsByProduct(r.Context(), int32(id))
 	if err != nil {
 		slog.Error("admin delete product", "err", err)
@@ -410,6 +475,8 @@ func adminProductDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	audit.Log(r.Context(), audit.EntityProduct, id, "delete", product, nil)
+
 	writeResp(w, http.StatusOK, D{})
 }
 
@@ -450,6 +517,7 @@ func adminProductGet(w http.ResponseWriter, r *http.Request) {
 		"options":       options,
 		"stock":         product.Stock,
 		"stock_control": product.StockControl,
+		"dunning":       product.Dunning,
 	}})
 }
 
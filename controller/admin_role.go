@@ -0,0 +1,259 @@
+package controller
+
+import (
+	"billing3/controller/middlewares"
+	"billing3/database"
+	"billing3/service"
+	"billing3/service/audit"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// requireRoleEdit writes an error and returns false if the current user does not have service.PermRoleEdit. It is
+// needed to create or edit admins, because an admin without a staff role has every permission.
+func requireRoleEdit(w http.ResponseWriter, r *http.Request) bool {
+	ok, err := service.HasPermission(r.Context(), middlewares.MustGetUser(r), service.PermRoleEdit)
+	if err != nil {
+		slog.Error("check permission", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		writeError(w, http.StatusForbidden, "Permission denied")
+		return false
+	}
+	return true
+}
+
+func adminRoleList(w http.ResponseWriter, r *http.Request) {
+	roles, err := database.Q.ListStaffRoles(r.Context())
+	if err != nil {
+		slog.Error("admin list staff roles", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{
+		"roles":       roles,
+		"permissions": service.Permissions,
+	})
+}
+
+func adminRoleGet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	role, err := database.Q.FindStaffRoleById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("admin get staff role", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"role": role})
+}
+
+type adminRoleReq struct {
+	Name        string   `json:"name" validate:"required,max=100"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions" validate:"required"`
+}
+
+func adminRoleCreate(w http.ResponseWriter, r *http.Request) {
+	req, err := decode[adminRoleReq](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = service.CheckPermissions(req.Permissions)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	params := database.CreateStaffRoleParams{
+		Name:        req.Name,
+		Description: req.Description,
+		Permissions: req.Permissions,
+	}
+	id, err := database.Q.CreateStaffRole(r.Context(), params)
+	if err != nil {
+		if err, ok := err.(*pgconn.PgError); ok && err.Code == database.PGErrorUniqueViolation {
+			writeError(w, http.StatusForbidden, "Duplicated name")
+			return
+		}
+		slog.Error("admin create staff role", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(r.Context(), audit.EntityStaffRole, id, "create", nil, params)
+
+	writeResp(w, http.StatusOK, D{"id": id})
+}
+
+func adminRoleUpdate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	req, err := decode[adminRoleReq](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = service.CheckPermissions(req.Permissions)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	role, err := database.Q.FindStaffRoleById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("admin update staff role", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	params := database.UpdateStaffRoleParams{
+		ID:          int32(id),
+		Name:        req.Name,
+		Description: req.Description,
+		Permissions: req.Permissions,
+	}
+	n, err := database.Q.UpdateStaffRole(r.Context(), params)
+	if err != nil {
+		if err, ok := err.(*pgconn.PgError); ok && err.Code == database.PGErrorUniqueViolation {
+			writeError(w, http.StatusForbidden, "Duplicated name")
+			return
+		}
+		slog.Error("admin update staff role", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if n == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	audit.Log(r.Context(), audit.EntityStaffRole, id, "update", role, params)
+
+	writeResp(w, http.StatusOK, D{})
+}
+
+func adminRoleDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	role, err := database.Q.FindStaffRoleById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("admin delete staff role", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	_, err = database.Q.DeleteStaffRole(r.Context(), int32(id))
+	if err != nil {
+		if err, ok := err.(*pgconn.PgError); ok && err.Code == "23503" {
+			writeError(w, http.StatusForbidden, "the role cannot be deleted if it is assigned to users")
+			return
+		}
+		slog.Error("admin delete staff role", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(r.Context(), audit.EntityStaffRole, id, "delete", role, nil)
+
+	writeResp(w, http.StatusOK, D{})
+}
+
+// adminUserSetRole assigns a staff role to a user, or removes it with a null role_id. An admin without a staff
+// role has every permission.
+func adminUserSetRole(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	type reqStruct struct {
+		RoleID *int32 `json:"role_id"`
+	}
+	req, err := decode[reqStruct](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, err := database.Q.FindUserById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("admin set user role", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	roleId := pgtype.Int4{}
+	if req.RoleID != nil {
+		err = service.CheckStaffRole(r.Context(), *req.RoleID)
+		if err != nil {
+			if errors.Is(err, service.ErrStaffRoleNotFound) {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			slog.Error("admin set user role", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		roleId = pgtype.Int4{Int32: *req.RoleID, Valid: true}
+	}
+
+	params := database.UpdateUserStaffRoleParams{
+		ID:          int32(id),
+		StaffRoleID: roleId,
+	}
+	_, err = database.Q.UpdateUserStaffRole(r.Context(), params)
+	if err != nil {
+		slog.Error("admin set user role", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("staff role changed", "user_id", id, "role_id", req.RoleID, "admin", middlewares.MustGetUser(r).ID)
+	audit.Log(r.Context(), audit.EntityUser, id, "role.assign", user, params)
+
+	writeResp(w, http.StatusOK, D{})
+}
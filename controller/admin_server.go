@@ -2,6 +2,7 @@ package controller
 
 import (
 	"billing3/database"
+	"billing3/service/audit"
 	"billing3/service/extension"
 	"errors"
 	"fmt"
@@ -105,17 +106,20 @@ func adminServerAdd(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, err := database.Q.CreateServer(r.Context(), database.CreateServerParams{
+	params := database.CreateServerParams{
 		Label:     req.Label,
 		Extension: req.Extension,
 		Settings:  cleanedSettings,
-	})
+	}
+	id, err := database.Q.CreateServer(r.Context(), params)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		slog.Error("admin server add", "err", err)
 		return
 	}
 
+	audit.Log(r.Context(), audit.EntityServer, id, "create", nil, params)
+
 	writeResp(w, http.StatusCreated, D{"server": id})
 }
 
@@ -138,18 +142,32 @@ func adminServerEdit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = database.Q.UpdateServer(r.Context(), database.UpdateServerParams{
+	server, err := database.Q.FindServerById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("admin server edit", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	params := database.UpdateServerParams{
 		Label:     req.Label,
 		Settings:  cleanedSettings,
 		ID:        int32(id),
 		Extension: req.Extension,
-	})
+	}
+	err = database.Q.UpdateServer(r.Context(), params)
 	if err != nil {
 		slog.Error("admin server edit", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	audit.Log(r.Context(), audit.EntityServer, id, "update", server, params)
+
 	writeResp(w, http.StatusOK, D{})
 }
 
@@ -160,6 +178,17 @@ func adminServerDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	server, err := database.Q.FindServerById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("admin server delete", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
 	count, err := database.Q.CountServicesByServer(r.Context(), int32(id))
 	if err != nil {
 		slog.Error("admin server delete", "err", err)
@@ -179,6 +208,8 @@ func adminServerDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	audit.Log(r.Context(), audit.EntityServer, id, "delete", server, nil)
+
 	writeResp(w, http.StatusOK, D{})
 }
 
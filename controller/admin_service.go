@@ -4,6 +4,7 @@ import (
 	"billing3/database"
 	"billing3/database/types"
 	"billing3/service"
+	"billing3/service/audit"
 	"billing3/service/extension"
 	"errors"
 	"log/slog"
@@ -123,19 +124,33 @@ func adminServiceUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = database.Q.UpdateService(r.Context(), database.UpdateServiceParams{
+	s, err := database.Q.FindServiceById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("admin update service", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	params := database.UpdateServiceParams{
 		Label:        req.Label,
 		BillingCycle: int32(req.BillingCycle),
 		Price:        req.Price,
 		ExpiresAt:    req.ExpiresAt,
 		ID:           int32(id),
-	})
+	}
+	err = database.Q.UpdateService(r.Context(), params)
 	if err != nil {
 		slog.Error("admin update service", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	audit.Log(r.Context(), audit.EntityService, id, "update", s, params)
+
 	writeResp(w, http.StatusOK, D{})
 }
 
@@ -152,16 +167,30 @@ func adminServiceUpdateSettings(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = database.Q.UpdateServiceSettings(r.Context(), database.UpdateServiceSettingsParams{
+	s, err := database.Q.FindServiceById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("update service settings", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	params := database.UpdateServiceSettingsParams{
 		ID:       int32(id),
 		Settings: *req,
-	})
+	}
+	err = database.Q.UpdateServiceSettings(r.Context(), params)
 	if err != nil {
 		slog.Error("update service settings", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	audit.Log(r.Context(), audit.EntityService, id, "settings.update", s, params)
+
 	writeResp(w, http.StatusOK, D{})
 }
 
@@ -183,7 +212,7 @@ func adminServiceGenerateInvoice(w http.ResponseWriter, r *http.Request) {
 	qtx := database.New(tx)
 	invoiceId, err := service.CreateRenewalInvoice(r.Context(), qtx, int32(id), decimal.NewFromInt(0))
 	if err != nil {
-		if errors.Is(err, service.ErrServiceCancelled) || errors.Is(err, service.ErrUnpaidInvoiceExists) {
+		if errors.Is(err, service.ErrServiceCancelled) || errors.Is(err, service.ErrUnpaidInvoiceExists) || errors.Is(err, service.ErrCancellationPending) {
 			writeError(w, http.StatusBadRequest, err.Error())
 			return
 		}
@@ -192,6 +221,8 @@ func adminServiceGenerateInvoice(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	audit.LogTx(r.Context(), tx, audit.EntityService, id, "invoice.generate", nil, D{"invoice_id": invoiceId})
+
 	err = tx.Commit(r.Context())
 	if err != nil {
 		slog.Error("commit tx", "err", err)
@@ -199,6 +230,8 @@ func adminServiceGenerateInvoice(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	service.NotifyInvoiceCreated(r.Context(), invoiceId)
+
 	writeResp(w, http.StatusOK, D{"invoice": invoiceId})
 }
 
@@ -293,6 +326,11 @@ func adminServiceInfoPage(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		slog.Error("service admin page", "err", err, "service id", s.ID, "extension", s.Extension)
 	}
+
+	// the submitted form is not saved, because it may contain passwords
+	if r.Method == http.MethodPost {
+		audit.Log(r.Context(), audit.EntityService, s.ID, "info.submit", nil, nil)
+	}
 }
 
 func adminServiceUpdateStatus(w http.ResponseWriter, r *http.Request) {
@@ -381,6 +419,12 @@ func adminServiceUpdateStatus(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// with an action, the status is changed and logged by the extension job
+		audit.Log(r.Context(), audit.EntityService, s.ID, "status.update", s, D{
+			"status":              req.Status,
+			"cancellation_reason": req.CancellationReason,
+		})
+
 	}
 }
 
@@ -434,3 +478,37 @@ func adminServiceGetJobs(w http.ResponseWriter, r *http.Request) {
 
 	writeResp(w, http.StatusOK, D{"jobs": jobsResp})
 }
+
+func adminServiceUpgrades(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	upgrades, err := database.Q.ListServiceUpgrades(r.Context(), int32(id))
+	if err != nil {
+		slog.Error("admin list service upgrades", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"upgrades": upgrades})
+}
+
+func adminServiceDunningSteps(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	steps, err := database.Q.ListServiceDunningSteps(r.Context(), int32(id))
+	if err != nil {
+		slog.Error("admin list service dunning steps", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"steps": steps})
+}
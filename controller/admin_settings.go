@@ -2,6 +2,7 @@ package controller
 
 import (
 	"billing3/service"
+	"billing3/service/audit"
 	"net/http"
 )
 
@@ -23,11 +24,53 @@ func adminSettingsUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if v, ok := (*req)[service.SettingInvoiceTemplate.Key()]; ok {
+		if _, err := service.ParseInvoiceTemplate(v); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid invoice template: "+err.Error())
+			return
+		}
+	}
+
+	if v, ok := (*req)[service.SettingDunningReminderDays.Key()]; ok {
+		if _, err := service.ParseDunningDays(v); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid dunning reminder days: "+err.Error())
+			return
+		}
+	}
+	_, suspendOk := (*req)[service.SettingDunningSuspendDays.Key()]
+	_, terminateOk := (*req)[service.SettingDunningTerminateDays.Key()]
+	if suspendOk || terminateOk {
+		// the one that is not updated is checked against the other as well
+		suspendDays := service.SettingDunningSuspendDays.Get(r.Context())
+		if suspendOk {
+			suspendDays = (*req)[service.SettingDunningSuspendDays.Key()]
+		}
+		terminateDays := service.SettingDunningTerminateDays.Get(r.Context())
+		if terminateOk {
+			terminateDays = (*req)[service.SettingDunningTerminateDays.Key()]
+		}
+		if err := service.ValidateDunningSettings(suspendDays, terminateDays); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid dunning settings: "+err.Error())
+			return
+		}
+	}
+
+	if v, ok := (*req)[service.SettingRequireAdminTwoFactor.Key()]; ok && v != "true" && v != "false" {
+		writeError(w, http.StatusBadRequest, "invalid "+service.SettingRequireAdminTwoFactor.Key())
+		return
+	}
+
+	before := make(map[string]string)
+	after := make(map[string]string)
 	for _, s := range service.Settings {
 		if v, ok := (*req)[s.Key()]; ok {
+			before[s.Key()] = s.Get(r.Context())
+			after[s.Key()] = v
 			s.Set(r.Context(), v)
 		}
 	}
 
+	audit.Log(r.Context(), audit.EntitySettings, "settings", "update", before, after)
+
 	writeResp(w, http.StatusOK, D{})
 }
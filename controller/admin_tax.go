@@ -0,0 +1,179 @@
+package controller
+
+import (
+	"billing3/database"
+	"billing3/service/audit"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/shopspring/decimal"
+)
+
+type adminTaxRuleReq struct {
+	Name          string          `json:"name" validate:"required,max=200"`
+	Country       string          `json:"country" validate:"required,max=200"`
+	State         string          `json:"state" validate:"max=200"` // empty means the whole country
+	Rate          decimal.Decimal `json:"rate"`                     // percentage
+	ReverseCharge bool            `json:"reverse_charge"`
+}
+
+func adminTaxRuleList(w http.ResponseWriter, r *http.Request) {
+	rules, err := database.Q.ListTaxRules(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		slog.Error("admin list tax rule", "err", err)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{
+		"rules": rules,
+	})
+}
+
+func adminTaxRuleCreate(w http.ResponseWriter, r *http.Request) {
+	req, err := decode[adminTaxRuleReq](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.Rate.LessThan(decimal.Zero) || req.Rate.GreaterThanOrEqual(decimal.NewFromInt(100)) {
+		writeError(w, http.StatusBadRequest, "rate must be between 0 and 100")
+		return
+	}
+
+	params := database.CreateTaxRuleParams{
+		Name:          req.Name,
+		Country:       strings.ToUpper(req.Country),
+		State:         req.State,
+		Rate:          req.Rate,
+		ReverseCharge: req.ReverseCharge,
+	}
+	id, err := database.Q.CreateTaxRule(r.Context(), params)
+	if err != nil {
+		if err, ok := err.(*pgconn.PgError); ok && err.Code == database.PGErrorUniqueViolation {
+			writeError(w, http.StatusBadRequest, "a tax rule for the country and state already exists")
+			return
+		}
+		slog.Error("admin create tax rule", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(r.Context(), audit.EntityTaxRule, id, "create", nil, params)
+
+	writeResp(w, http.StatusOK, D{
+		"id": id,
+	})
+}
+
+func adminTaxRuleUpdate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	req, err := decode[adminTaxRuleReq](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.Rate.LessThan(decimal.Zero) || req.Rate.GreaterThanOrEqual(decimal.NewFromInt(100)) {
+		writeError(w, http.StatusBadRequest, "rate must be between 0 and 100")
+		return
+	}
+
+	rule, err := database.Q.FindTaxRuleById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("admin update tax rule", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// existing invoices are not changed
+	params := database.UpdateTaxRuleParams{
+		Name:          req.Name,
+		Country:       strings.ToUpper(req.Country),
+		State:         req.State,
+		Rate:          req.Rate,
+		ReverseCharge: req.ReverseCharge,
+		ID:            int32(id),
+	}
+	err = database.Q.UpdateTaxRule(r.Context(), params)
+	if err != nil {
+		if err, ok := err.(*pgconn.PgError); ok && err.Code == database.PGErrorUniqueViolation {
+			writeError(w, http.StatusBadRequest, "a tax rule for the country and state already exists")
+			return
+		}
+		slog.Error("admin update tax rule", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(r.Context(), audit.EntityTaxRule, id, "update", rule, params)
+
+	writeResp(w, http.StatusOK, D{})
+}
+
+func adminTaxRuleGet(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	rule, err := database.Q.FindTaxRuleById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		slog.Error("admin tax rule get", "err", err)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"rule": rule})
+}
+
+func adminTaxRuleDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	rule, err := database.Q.FindTaxRuleById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("admin delete tax rule", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = database.Q.DeleteTaxRule(r.Context(), int32(id))
+	if err != nil {
+		slog.Error("admin delete tax rule", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(r.Context(), audit.EntityTaxRule, id, "delete", rule, nil)
+
+	writeResp(w, http.StatusOK, D{})
+}
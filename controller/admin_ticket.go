@@ -0,0 +1,312 @@
+package controller
+
+import (
+	"billing3/controller/middlewares"
+	"billing3/database"
+	"billing3/service"
+	"billing3/service/audit"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// adminFindTicket returns the ticket in the url, otherwise it writes the error response.
+func adminFindTicket(w http.ResponseWriter, r *http.Request) (*database.Ticket, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, false
+	}
+
+	ticket, err := database.Q.FindTicketById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return nil, false
+		}
+		slog.Error("admin get ticket", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return &ticket, true
+}
+
+func adminTicketList(w http.ResponseWriter, r *http.Request) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	// filters are ignored if they are not numbers
+	status := r.URL.Query().Get("status")
+	userId, _ := strconv.Atoi(r.URL.Query().Get("user_id"))
+	departmentId, _ := strconv.Atoi(r.URL.Query().Get("department_id"))
+	assignedTo, _ := strconv.Atoi(r.URL.Query().Get("assigned_to"))
+
+	count, err := database.Q.SearchTicketsCount(r.Context(), database.SearchTicketsCountParams{
+		Status:       status,
+		UserID:       int32(userId),
+		DepartmentID: int32(departmentId),
+		AssignedTo:   int32(assignedTo),
+	})
+	if err != nil {
+		slog.Error("admin list tickets", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	totalPages := int(math.Ceil(float64(count) / float64(itemPerPage)))
+
+	tickets, err := database.Q.SearchTicketsPaged(r.Context(), database.SearchTicketsPagedParams{
+		Limit:        itemPerPage,
+		Offset:       int32((page - 1) * itemPerPage),
+		Status:       status,
+		UserID:       int32(userId),
+		DepartmentID: int32(departmentId),
+		AssignedTo:   int32(assignedTo),
+	})
+	if err != nil {
+		slog.Error("admin list tickets", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"tickets": tickets, "total_pages": totalPages})
+}
+
+func adminTicketGet(w http.ResponseWriter, r *http.Request) {
+	ticket, ok := adminFindTicket(w, r)
+	if !ok {
+		return
+	}
+
+	writeTicket(w, r, ticket)
+}
+
+func adminTicketUpdate(w http.ResponseWriter, r *http.Request) {
+	ticket, ok := adminFindTicket(w, r)
+	if !ok {
+		return
+	}
+
+	type reqStruct struct {
+		DepartmentID int32       `json:"department_id" validate:"required"`
+		Priority     string      `json:"priority" validate:"required,oneof=LOW MEDIUM HIGH"`
+		AssignedTo   pgtype.Int4 `json:"assigned_to"`
+		ServiceID    pgtype.Int4 `json:"service_id"`
+		InvoiceID    pgtype.Int4 `json:"invoice_id"`
+	}
+	req, err := decode[reqStruct](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	params := database.UpdateTicketParams{
+		ID:           ticket.ID,
+		DepartmentID: req.DepartmentID,
+		Priority:     req.Priority,
+		AssignedTo:   req.AssignedTo,
+		ServiceID:    req.ServiceID,
+		InvoiceID:    req.InvoiceID,
+	}
+	err = service.UpdateTicket(r.Context(), params)
+	if err != nil {
+		writeTicketError(w, err)
+		return
+	}
+
+	audit.Log(r.Context(), audit.EntityTicket, ticket.ID, "update", ticket, params)
+
+	writeResp(w, http.StatusOK, D{})
+}
+
+func adminTicketReply(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.MustGetUser(r)
+	ticket, ok := adminFindTicket(w, r)
+	if !ok {
+		return
+	}
+
+	type reqStruct struct {
+		Body        string                     `json:"body" validate:"required"`
+		Attachments []service.TicketAttachment `json:"attachments" validate:"dive"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, ticketMaxBodySize)
+	req, err := decode[reqStruct](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	id, err := service.ReplyTicket(r.Context(), ticket.ID, user, true, req.Body, req.Attachments)
+	if err != nil {
+		writeTicketError(w, err)
+		return
+	}
+
+	audit.Log(r.Context(), audit.EntityTicket, ticket.ID, "reply", nil, D{"message_id": id})
+
+	writeResp(w, http.StatusOK, D{"id": id})
+}
+
+func adminTicketClose(w http.ResponseWriter, r *http.Request) {
+	ticket, ok := adminFindTicket(w, r)
+	if !ok {
+		return
+	}
+
+	err := service.CloseTicket(r.Context(), ticket.ID)
+	if err != nil {
+		slog.Error("admin close ticket", "err", err, "ticket_id", ticket.ID)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(r.Context(), audit.EntityTicket, ticket.ID, "close", D{"status": ticket.Status}, D{"status": service.TicketClosed})
+
+	writeResp(w, http.StatusOK, D{})
+}
+
+func adminTicketAttachment(w http.ResponseWriter, r *http.Request) {
+	ticket, ok := adminFindTicket(w, r)
+	if !ok {
+		return
+	}
+
+	writeTicketAttachment(w, r, ticket.ID)
+}
+
+func adminServiceTickets(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	tickets, err := database.Q.SearchTicketsPaged(r.Context(), database.SearchTicketsPagedParams{
+		Limit:     math.MaxInt32,
+		ServiceID: int32(id),
+	})
+	if err != nil {
+		slog.Error("admin list service tickets", "err", err, "service id", id)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"tickets": tickets})
+}
+
+func adminTicketDepartmentCreate(w http.ResponseWriter, r *http.Request) {
+	type reqStruct struct {
+		Name        string `json:"name" validate:"required,max=200"`
+		Description string `json:"description"`
+	}
+	req, err := decode[reqStruct](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	params := database.CreateTicketDepartmentParams{
+		Name:        req.Name,
+		Description: req.Description,
+	}
+	id, err := database.Q.CreateTicketDepartment(r.Context(), params)
+	if err != nil {
+		slog.Error("admin create ticket department", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(r.Context(), audit.EntityTicketDepartment, id, "create", nil, params)
+
+	writeResp(w, http.StatusOK, D{"id": id})
+}
+
+func adminTicketDepartmentUpdate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	type reqStruct struct {
+		Name        string `json:"name" validate:"required,max=200"`
+		Description string `json:"description"`
+	}
+	req, err := decode[reqStruct](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	department, err := database.Q.FindTicketDepartmentById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("admin update ticket department", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	params := database.UpdateTicketDepartmentParams{
+		Name:        req.Name,
+		Description: req.Description,
+		ID:          int32(id),
+	}
+	err = database.Q.UpdateTicketDepartment(r.Context(), params)
+	if err != nil {
+		slog.Error("admin update ticket department", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(r.Context(), audit.EntityTicketDepartment, id, "update", department, params)
+
+	writeResp(w, http.StatusOK, D{})
+}
+
+func adminTicketDepartmentDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	department, err := database.Q.FindTicketDepartmentById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("admin delete ticket department", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = database.Q.DeleteTicketDepartment(r.Context(), int32(id))
+	if err != nil {
+		if err, ok := err.(*pgconn.PgError); ok && err.Code == "23503" {
+			writeError(w, http.StatusForbidden, "the department cannot be deleted if there are tickets belonging to it")
+			return
+		}
+		slog.Error("admin delete ticket department", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(r.Context(), audit.EntityTicketDepartment, id, "delete", department, nil)
+
+	writeResp(w, http.StatusOK, D{})
+}
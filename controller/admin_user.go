@@ -1,7 +1,11 @@
 package controller
 
 import (
+	"billing3/controller/middlewares"
 	"billing3/database"
+	"billing3/database/types"
+	"billing3/service"
+	"billing3/service/audit"
 	"billing3/utils"
 	"errors"
 	"log/slog"
@@ -13,6 +17,7 @@ import (
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
 )
 
 func adminUserList(w http.ResponseWriter, r *http.Request) {
@@ -63,15 +68,17 @@ func adminUserEdit(w http.ResponseWriter, r *http.Request) {
 	}
 
 	type reqStruct struct {
-		Email    string `json:"email" validate:"required"`
-		Name     string `json:"name" validate:"required"`
-		Password string `json:"password" validate:"printascii,max=72"`
-		Role     string `json:"role" validate:"required"`
-		Address  string `json:"address"`
-		City     string `json:"city"`
-		State    string `json:"state"`
-		Country  string `json:"country"`
-		ZipCode  string `json:"zip_code"`
+		Email     string `json:"email" validate:"required"`
+		Name      string `json:"name" validate:"required"`
+		Password  string `json:"password" validate:"printascii,max=72"`
+		Role      string `json:"role" validate:"required"`
+		Address   string `json:"address"`
+		City      string `json:"city"`
+		State     string `json:"state"`
+		Country   string `json:"country"`
+		ZipCode   string `json:"zip_code"`
+		VatNumber string `json:"vat_number" validate:"max=200"`
+		Currency  string `json:"currency"` // unchanged if empty
 	}
 	req, err := decode[reqStruct](r)
 	if err != nil {
@@ -79,6 +86,38 @@ func adminUserEdit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	user, err := database.Q.FindUserById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("admin user edit", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if (user.Role == "admin" || req.Role == "admin") && !requireRoleEdit(w, r) {
+		return
+	}
+
+	// existing services and invoices keep their currency
+	currency := service.NormalizeCurrency(req.Currency)
+	if currency == "" {
+		currency = user.Currency
+	} else if currency != user.Currency {
+		err = service.CheckCurrency(r.Context(), currency)
+		if err != nil {
+			if errors.Is(err, service.ErrCurrencyUnsupported) {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			slog.Error("admin user edit", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// update password if provided
 	if req.Password != "" {
 		err = database.Q.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
@@ -90,25 +129,31 @@ func adminUserEdit(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+		audit.Log(r.Context(), audit.EntityUser, id, "password.update", nil, nil)
 	}
 
-	err = database.Q.UpdateUser(r.Context(), database.UpdateUserParams{
-		ID:      int32(id),
-		Email:   req.Email,
-		Name:    req.Name,
-		Role:    req.Role,
-		Address: pgtype.Text{Valid: req.Address != "", String: req.Address},
-		City:    pgtype.Text{Valid: req.City != "", String: req.City},
-		State:   pgtype.Text{Valid: req.State != "", String: req.State},
-		Country: pgtype.Text{Valid: req.Country != "", String: req.Country},
-		ZipCode: pgtype.Text{Valid: req.ZipCode != "", String: req.ZipCode},
-	})
+	params := database.UpdateUserParams{
+		ID:        int32(id),
+		Email:     req.Email,
+		Name:      req.Name,
+		Role:      req.Role,
+		Address:   pgtype.Text{Valid: req.Address != "", String: req.Address},
+		City:      pgtype.Text{Valid: req.City != "", String: req.City},
+		State:     pgtype.Text{Valid: req.State != "", String: req.State},
+		Country:   pgtype.Text{Valid: req.Country != "", String: req.Country},
+		ZipCode:   pgtype.Text{Valid: req.ZipCode != "", String: req.ZipCode},
+		VatNumber: service.NormalizeVatNumber(req.VatNumber),
+		Currency:  currency,
+	}
+	err = database.Q.UpdateUser(r.Context(), params)
 	if err != nil {
 		slog.Error("admin user edit", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
+	audit.Log(r.Context(), audit.EntityUser, id, "update", user, params)
+
 	writeResp(w, http.StatusOK, D{})
 }
 
@@ -130,8 +175,17 @@ func adminUserGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	lockedUntil, err := service.AccountLockedUntil(r.Context(), user.Email)
+	if err != nil {
+		slog.Error("admin user get", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
 	writeResp(w, http.StatusOK, D{
 		"user": user,
+		// null unless logging in is locked after too many failed attempts, see DELETE /admin/user/{id}/lockout
+		"locked_until": types.Timestamp{Timestamp: pgtype.Timestamp{Valid: !lockedUntil.IsZero(), Time: lockedUntil}},
 	})
 }
 
@@ -146,6 +200,7 @@ func adminUserCreate(w http.ResponseWriter, r *http.Request) {
 		State    string `json:"state"`
 		Country  string `json:"country"`
 		ZipCode  string `json:"zip_code"`
+		Currency string `json:"currency"` // base currency if empty
 	}
 	req, err := decode[reqStruct](r)
 	if err != nil {
@@ -153,7 +208,26 @@ func adminUserCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, err := database.Q.CreateUser(r.Context(), database.CreateUserParams{
+	if req.Role == "admin" && !requireRoleEdit(w, r) {
+		return
+	}
+
+	currency := service.NormalizeCurrency(req.Currency)
+	if currency == "" {
+		currency = service.BaseCurrency(r.Context())
+	}
+	err = service.CheckCurrency(r.Context(), currency)
+	if err != nil {
+		if errors.Is(err, service.ErrCurrencyUnsupported) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		slog.Error("admin create user", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	params := database.CreateUserParams{
 		Email:    req.Email,
 		Name:     req.Name,
 		Password: utils.HashPassword(req.Password),
@@ -163,9 +237,11 @@ func adminUserCreate(w http.ResponseWriter, r *http.Request) {
 		State:    pgtype.Text{Valid: req.State != "", String: req.State},
 		Country:  pgtype.Text{Valid: req.Country != "", String: req.Country},
 		ZipCode:  pgtype.Text{Valid: req.ZipCode != "", String: req.ZipCode},
-	})
+		Currency: currency,
+	}
+	id, err := database.Q.CreateUser(r.Context(), params)
 	if err != nil {
-		if err, ok := err.(*pgconn.PgError); ok && err.Code == PGErrorUniqueViolation {
+		if err, ok := err.(*pgconn.PgError); ok && err.Code == database.PGErrorUniqueViolation {
 			writeError(w, http.StatusForbidden, "Duplicated email")
 			return
 		}
@@ -174,7 +250,279 @@ func adminUserCreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	audit.Log(r.Context(), audit.EntityUser, id, "create", nil, params)
+
 	writeResp(w, http.StatusOK, D{
 		"id": id,
 	})
 }
+
+func adminUserCredit(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	user, err := database.Q.FindUserById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("admin user credit", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// the balance in other currencies can be viewed with ?currency=
+	currency := service.NormalizeCurrency(r.URL.Query().Get("currency"))
+	if currency == "" {
+		currency = user.Currency
+	}
+
+	balance, err := service.CreditBalance(r.Context(), user.ID, currency)
+	if err != nil {
+		slog.Error("admin user credit", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	transactions, err := database.Q.ListCreditTransactionsByUser(r.Context(), int32(id))
+	if err != nil {
+		slog.Error("admin user credit", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"balance": balance, "currency": currency, "transactions": transactions})
+}
+
+func adminUserAdjustCredit(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	type reqStruct struct {
+		Amount      decimal.Decimal `json:"amount" validate:"required"`
+		Description string          `json:"description" validate:"required,max=200"`
+		Currency    string          `json:"currency"` // currency of the user if empty
+	}
+	req, err := decode[reqStruct](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, err := database.Q.FindUserById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("admin adjust credit", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	currency := service.NormalizeCurrency(req.Currency)
+	if currency == "" {
+		currency = user.Currency
+	}
+	if !service.IsValidCurrencyCode(currency) {
+		writeError(w, http.StatusBadRequest, "invalid currency")
+		return
+	}
+
+	err = service.AdjustCredit(r.Context(), user.ID, req.Amount.Round(2), currency, req.Description)
+	if err != nil {
+		slog.Error("admin adjust credit", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(r.Context(), audit.EntityUser, id, "credit.adjust", nil, D{
+		"amount":      req.Amount.Round(2),
+		"currency":    currency,
+		"description": req.Description,
+	})
+
+	writeResp(w, http.StatusOK, D{})
+}
+
+// adminUserDisableTwoFactor turns off two-factor authentication of a user who lost their authenticator and
+// recovery codes.
+func adminUserDisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	user, err := database.Q.FindUserById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("admin disable two factor", "err", err, "user_id", id)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if user.Role == "admin" && !requireRoleEdit(w, r) {
+		return
+	}
+
+	err = service.DisableTwoFactor(r.Context(), int32(id))
+	if err != nil {
+		slog.Error("admin disable two factor", "err", err, "user_id", id)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(r.Context(), audit.EntityUser, id, "2fa.disable", nil, nil)
+
+	writeResp(w, http.StatusOK, D{})
+}
+
+// adminUserUnlock removes the lockout and the failed attempts of the user's account. Lockouts of ip addresses are
+// not affected.
+func adminUserUnlock(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	user, err := database.Q.FindUserById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("admin unlock user", "err", err, "user_id", id)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = service.UnlockAccount(r.Context(), user.Email)
+	if err != nil {
+		slog.Error("admin unlock user", "err", err, "user_id", id)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(r.Context(), audit.EntityUser, id, "lockout.clear", nil, nil)
+
+	writeResp(w, http.StatusOK, D{})
+}
+
+func adminUserSessions(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	sessions, err := database.Q.ListSessionsByUser(r.Context(), int32(id))
+	if err != nil {
+		slog.Error("admin list user sessions", "err", err, "user_id", id)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeSessions(w, sessions, "")
+}
+
+func adminUserRevokeSession(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	sessionId, err := strconv.Atoi(chi.URLParam(r, "session_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	n, err := database.Q.DeleteSessionByUser(r.Context(), database.DeleteSessionByUserParams{
+		ID:     int32(sessionId),
+		UserID: int32(id),
+	})
+	if err != nil {
+		slog.Error("admin revoke user session", "err", err, "user_id", id)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if n == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	audit.Log(r.Context(), audit.EntityUser, id, "session.revoke", nil, D{"session_id": sessionId})
+
+	writeResp(w, http.StatusOK, D{})
+}
+
+// adminUserRevokeSessions logs the user out everywhere.
+func adminUserRevokeSessions(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	err = database.Q.DeleteSessionsByUser(r.Context(), int32(id))
+	if err != nil {
+		slog.Error("admin revoke user sessions", "err", err, "user_id", id)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("all sessions revoked by admin", "user_id", id, "admin", middlewares.MustGetUser(r).ID)
+	audit.Log(r.Context(), audit.EntityUser, id, "sessions.revoke", nil, nil)
+
+	writeResp(w, http.StatusOK, D{})
+}
+
+// adminUserImpersonate returns a session token with which the admin is logged in as the user, to see what the user
+// sees. The session expires after service.ImpersonationDuration, and sensitive actions are blocked in it.
+func adminUserImpersonate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	user, err := database.Q.FindUserById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("admin impersonate", "err", err, "user_id", id)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	token, expiresAt, err := service.Impersonate(r.Context(), &user, middlewares.MustGetUser(r), r.UserAgent(), utils.ClientIP(r))
+	if err != nil {
+		if errors.Is(err, service.ErrInternalError) {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeError(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	audit.Log(r.Context(), audit.EntityUser, id, "impersonate.start", nil, D{"expires_at": expiresAt})
+
+	writeResp(w, http.StatusOK, D{
+		"token":      token,
+		"expires_at": types.Timestamp{Timestamp: pgtype.Timestamp{Time: expiresAt, Valid: true}},
+	})
+}
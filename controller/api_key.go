@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"billing3/controller/middlewares"
+	"billing3/database"
+	"billing3/database/types"
+	"billing3/service"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func apiKeyList(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.MustGetUser(r)
+
+	keys, err := database.Q.ListApiKeysByUser(r.Context(), user.ID)
+	if err != nil {
+		slog.Error("list api keys", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// the hashes are not needed by the frontend
+	type apiKey struct {
+		ID         int32           `json:"id"`
+		Name       string          `json:"name"`
+		Prefix     string          `json:"prefix"`
+		Scopes     []string        `json:"scopes"`
+		AllowedIps []string        `json:"allowed_ips"`
+		ExpiresAt  types.Timestamp `json:"expires_at"`
+		LastUsedAt types.Timestamp `json:"last_used_at"`
+		LastUsedIp string          `json:"last_used_ip"`
+		CreatedAt  types.Timestamp `json:"created_at"`
+	}
+	result := make([]apiKey, len(keys))
+	for i, k := range keys {
+		result[i] = apiKey{
+			ID:         k.ID,
+			Name:       k.Name,
+			Prefix:     k.Prefix,
+			Scopes:     k.Scopes,
+			AllowedIps: k.AllowedIps,
+			ExpiresAt:  k.ExpiresAt,
+			LastUsedAt: k.LastUsedAt,
+			LastUsedIp: k.LastUsedIp.String,
+			CreatedAt:  k.CreatedAt,
+		}
+	}
+
+	writeResp(w, http.StatusOK, D{"api_keys": result, "scopes": service.Scopes})
+}
+
+// apiKeyCreate returns the new key, which can not be shown again.
+func apiKeyCreate(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.MustGetUser(r)
+
+	type reqStruct struct {
+		Name       string          `json:"name" validate:"required,max=100"`
+		Scopes     []string        `json:"scopes" validate:"required,min=1"`
+		AllowedIps []string        `json:"allowed_ips" validate:"max=50"`
+		ExpiresAt  types.Timestamp `json:"expires_at"`
+	}
+	req, err := decode[reqStruct](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.AllowedIps == nil {
+		req.AllowedIps = []string{}
+	}
+
+	id, key, err := service.CreateApiKey(r.Context(), user, req.Name, req.Scopes, req.AllowedIps, req.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, service.ErrInternalError) {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"id": id, "key": key})
+}
+
+func apiKeyDelete(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.MustGetUser(r)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	n, err := database.Q.DeleteApiKey(r.Context(), database.DeleteApiKeyParams{
+		ID:     int32(id),
+		UserID: user.ID,
+	})
+	if err != nil {
+		slog.Error("delete api key", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if n == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	slog.Info("api key deleted", "user_id", user.ID, "id", id)
+
+	writeResp(w, http.StatusOK, D{})
+}
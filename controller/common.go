@@ -15,8 +15,6 @@ import (
 	"github.com/go-playground/validator/v10"
 )
 
-const PGErrorUniqueViolation = "23505"
-
 const itemPerPage = 20
 
 var publicDomain string
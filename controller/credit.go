@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"billing3/controller/middlewares"
+	"billing3/database"
+	"billing3/service"
+	"log/slog"
+	"net/http"
+
+	"github.com/shopspring/decimal"
+)
+
+func getCredit(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.MustGetUser(r)
+
+	balance, err := service.CreditBalance(r.Context(), user.ID, user.Currency)
+	if err != nil {
+		slog.Error("get credit", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	transactions, err := database.Q.ListCreditTransactionsByUser(r.Context(), user.ID)
+	if err != nil {
+		slog.Error("get credit", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"balance": balance, "currency": user.Currency, "transactions": transactions})
+}
+
+func creditTopUp(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.MustGetUser(r)
+
+	type reqStruct struct {
+		Amount decimal.Decimal `json:"amount"`
+	}
+	req, err := decode[reqStruct](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.Amount.LessThanOrEqual(decimal.Zero) {
+		writeError(w, http.StatusBadRequest, "amount must be greater than zero")
+		return
+	}
+
+	invoiceId, err := service.CreateCreditTopUpInvoice(r.Context(), user.ID, req.Amount.RoundUp(2), user.Currency)
+	if err != nil {
+		slog.Error("credit top-up", "err", err, "user_id", user.ID)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"invoice": invoiceId})
+}
@@ -7,6 +7,7 @@ import (
 	"billing3/service"
 	"billing3/service/gateways"
 	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/jackc/pgx/v5"
 	"github.com/shopspring/decimal"
@@ -14,7 +15,6 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
-	"time"
 )
 
 func getInvoice(w http.ResponseWriter, r *http.Request) {
@@ -52,6 +52,54 @@ func getInvoice(w http.ResponseWriter, r *http.Request) {
 	writeResp(w, http.StatusOK, D{"invoice": invoice, "items": items})
 }
 
+func getInvoicePDF(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	user := middlewares.MustGetUser(r)
+
+	invoice, err := database.Q.FindInvoiceById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		slog.Error("get invoice pdf", "err", err)
+		return
+	}
+
+	if invoice.UserID != user.ID {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	writeInvoicePDF(w, r, invoice.ID)
+}
+
+// writeInvoicePDF renders the invoice and sends it as a download
+func writeInvoicePDF(w http.ResponseWriter, r *http.Request, invoiceId int32) {
+	pdf, err := service.RenderInvoicePDF(r.Context(), invoiceId)
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		slog.Error("render invoice pdf", "err", err, "invoice", invoiceId)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"invoice-%d.pdf\"", invoiceId))
+	w.Header().Set("Content-Length", strconv.Itoa(len(pdf)))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(pdf)
+}
+
 func listInvoices(w http.ResponseWriter, r *http.Request) {
 	user := middlewares.MustGetUser(r)
 
@@ -139,14 +187,20 @@ func makePayment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// invoice must be unpaid and not overdue
-	if invoice.Status != service.InvoiceUnpaid || invoice.DueAt.Time.Before(time.Now()) {
+	// invoice must be unpaid, overdue invoices are cancelled by a cron job unless they are renewal invoices in dunning
+	if invoice.Status != service.InvoiceUnpaid {
 		writeError(w, http.StatusBadRequest, "invoice is not payable")
 		return
 	}
 
-	// calculate total amount
-	total := invoice.Amount
+	// calculate total amount, excluding what has already been paid (e.g. by account credit)
+	paid, err := database.Q.TotalInvoicePayment(r.Context(), invoice.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		slog.Error("make payment", "err", err)
+		return
+	}
+	total := invoice.Amount.Sub(paid)
 	percentage := strings.HasSuffix(dbGateway.Fee.String, "%")
 	fee, err := decimal.NewFromString(strings.TrimSuffix(dbGateway.Fee.String, "%"))
 	if err != nil {
@@ -156,6 +210,13 @@ func makePayment(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !percentage {
+		// fixed fees are in the base currency
+		fee, err = service.ConvertAmount(r.Context(), database.Q, fee, service.BaseCurrency(r.Context()), invoice.Currency)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			slog.Error("convert gateway fee", "err", err, "currency", invoice.Currency, "gateway", gatewayName)
+			return
+		}
 		total = total.Add(fee)
 	} else {
 		total = total.Add(total.Mul(fee.Div(decimal.NewFromInt(100))))
@@ -165,12 +226,16 @@ func makePayment(w http.ResponseWriter, r *http.Request) {
 	// start payment
 	paymentUrl, err := gateway.Pay(&invoice, user, total)
 	if err != nil {
+		if errors.Is(err, service.ErrInsufficientCredit) || errors.Is(err, service.ErrInvoiceNotPayable) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		w.WriteHeader(http.StatusInternalServerError)
 		slog.Error("make payment", "err", err, "gateway", gatewayName, "invoice_id", invoice.ID, "total", total.String(), "user_id", user.ID)
 		return
 	}
 
-	slog.Info("payment start", "invoice_id", invoice.ID, "total", total.String(), "user_id", user.ID, "payment_url", paymentUrl, "gateway", gatewayName, "gateway_fee", dbGateway.Fee.String)
+	slog.Info("payment start", "invoice_id", invoice.ID, "total", total.String(), "currency", invoice.Currency, "user_id", user.ID, "payment_url", paymentUrl, "gateway", gatewayName, "gateway_fee", dbGateway.Fee.String)
 
 	writeResp(w, http.StatusOK, D{"payment_url": paymentUrl})
 }
@@ -3,10 +3,11 @@ package controller
 import (
 	"billing3/database"
 	"billing3/service"
-	"billing3/service/email"
+	"billing3/service/notification"
 	"billing3/utils"
 	"errors"
 	"log/slog"
+	"math"
 	"net/http"
 	"strconv"
 	"time"
@@ -27,25 +28,61 @@ func login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ip := utils.ClientIP(r)
+
+	var found *database.User
 	user, err := database.Q.FindUserByEmail(r.Context(), req.Email)
-	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			// user does not exist
-			writeError(w, http.StatusOK, "Wrong email or password")
-			return
-		}
+	if err == nil {
+		found = &user
+	} else if !errors.Is(err, pgx.ErrNoRows) {
 		w.WriteHeader(http.StatusInternalServerError)
 		slog.Error("login", "err", err)
 		return
 	}
 
-	if !utils.ComparePassword(user.Password, req.Password) {
-		// wrong password
+	// the attempt is counted before the password is compared, and stays counted if it is wrong
+	if !attemptThrottle(w, r, service.LoginThrottle, req.Email, ip, found) {
+		return
+	}
+
+	// an unknown email address is counted like a wrong password to avoid enumeration
+	if found == nil || !utils.ComparePassword(user.Password, req.Password) {
 		writeError(w, http.StatusOK, "Wrong email or password")
 		return
 	}
 
-	token, err := service.NewSessionToken(r.Context(), user.ID)
+	methods, err := service.TwoFactorMethods(r.Context(), user.ID)
+	if err != nil {
+		slog.Error("login", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(methods) > 0 {
+		// the failed attempts are kept until the second step, so that two-factor codes are counted too
+		err = service.LoginThrottle.Release(r.Context(), user.Email, ip)
+		if err != nil {
+			slog.Error("login", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// the session token is issued by loginTwoFactor or webauthnTwoFactorFinish
+		writeResp(w, http.StatusOK, D{
+			"two_factor_required": true,
+			"two_factor_token":    service.NewTwoFactorLoginToken(user.ID),
+			"two_factor_methods":  methods,
+		})
+		return
+	}
+
+	err = service.LoginThrottle.Succeed(r.Context(), user.Email, ip)
+	if err != nil {
+		slog.Error("login", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	token, err := service.NewSessionToken(r.Context(), user.ID, r.UserAgent(), ip)
 	if err != nil {
 		slog.Error("login", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -57,6 +94,23 @@ func login(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// attemptThrottle counts an attempt in the throttle, see service.Throttle.Attempt. It writes 429 and returns false if
+// the email address or the ip address is locked.
+func attemptThrottle(w http.ResponseWriter, r *http.Request, throttle *service.Throttle, email string, ip string, user *database.User) bool {
+	until, err := throttle.Attempt(r.Context(), email, ip, user)
+	if err != nil {
+		slog.Error("throttle attempt", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	if !until.IsZero() {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(until).Seconds()))))
+		writeError(w, http.StatusTooManyRequests, "Too many failed attempts, please try again later")
+		return false
+	}
+	return true
+}
+
 func resetPassword(w http.ResponseWriter, r *http.Request) {
 	type reqStruct struct {
 		Email string `json:"email" validate:"required,email"`
@@ -67,6 +121,12 @@ func resetPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// every request counts, since each one sends an email
+	ip := utils.ClientIP(r)
+	if !attemptThrottle(w, r, service.PasswordResetThrottle, req.Email, ip, nil) {
+		return
+	}
+
 	user, err := database.Q.FindUserByEmail(r.Context(), req.Email)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -85,7 +145,7 @@ func resetPassword(w http.ResponseWriter, r *http.Request) {
 	}, 30*time.Minute)
 
 	link := publicDomain + "/auth/reset-password2?token=" + token
-	err = email.SendMailAsync(r.Context(), user.Email, "Reset Password", "Click here to reset your password: "+link)
+	err = notification.SendToUser(r.Context(), notification.EventResetPassword, user.ID, map[string]any{"Link": link})
 	if err != nil {
 		slog.Error("reset password: send mail async", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -140,5 +200,13 @@ func resetPassword2(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// whoever knew the old password may still be logged in
+	err = database.Q.DeleteSessionsByUser(r.Context(), int32(userID))
+	if err != nil {
+		slog.Error("reset password 2", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
 	writeResp(w, http.StatusOK, D{})
 }
@@ -3,6 +3,9 @@ package controller
 import (
 	"billing3/controller/middlewares"
 	"billing3/database"
+	"billing3/service"
+	"billing3/service/audit"
+	"errors"
 	"fmt"
 	"github.com/jackc/pgx/v5/pgtype"
 	"log/slog"
@@ -18,6 +21,19 @@ func me(w http.ResponseWriter, r *http.Request) {
 		address = "unknown"
 	}
 
+	permissions, err := service.UserPermissions(r.Context(), user)
+	if err != nil {
+		slog.Error("me", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// the admin who is logged in as the user, see adminUserImpersonate
+	var impersonator D
+	if admin := middlewares.GetImpersonator(r); admin != nil {
+		impersonator = D{"id": admin.ID, "name": admin.Name, "email": admin.Email}
+	}
+
 	writeResp(w, http.StatusOK, D{
 		"email":        user.Email,
 		"name":         user.Name,
@@ -28,6 +44,10 @@ func me(w http.ResponseWriter, r *http.Request) {
 		"state":        user.State,
 		"country":      user.Country,
 		"zip_code":     user.ZipCode,
+		"vat_number":   user.VatNumber,
+		"currency":     user.Currency,
+		"permissions":  permissions,
+		"impersonator": impersonator,
 	})
 }
 
@@ -41,6 +61,10 @@ func logout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if middlewares.GetImpersonator(r) != nil {
+		audit.Log(r.Context(), audit.EntityUser, middlewares.MustGetUser(r).ID, "impersonate.end", nil, nil)
+	}
+
 	writeResp(w, http.StatusOK, D{})
 }
 
@@ -48,12 +72,14 @@ func updateProfile(w http.ResponseWriter, r *http.Request) {
 	user := middlewares.MustGetUser(r)
 
 	type reqStruct struct {
-		Name    string `json:"name" valid:"required"`
-		Address string `json:"address"`
-		City    string `json:"city"`
-		State   string `json:"state"`
-		Country string `json:"country"`
-		ZipCode string `json:"zip_code"`
+		Name      string `json:"name" valid:"required"`
+		Address   string `json:"address"`
+		City      string `json:"city"`
+		State     string `json:"state"`
+		Country   string `json:"country"`
+		ZipCode   string `json:"zip_code"`
+		VatNumber string `json:"vat_number"` // only admins can change it, since it enables reverse charge
+		Currency  string `json:"currency"`   // unchanged if empty
 	}
 	req, err := decode[reqStruct](r)
 	if err != nil {
@@ -61,14 +87,38 @@ func updateProfile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if service.NormalizeVatNumber(req.VatNumber) != user.VatNumber {
+		writeError(w, http.StatusBadRequest, "the VAT number can only be changed by an admin")
+		return
+	}
+
+	// existing services and invoices keep their currency
+	currency := service.NormalizeCurrency(req.Currency)
+	if currency == "" {
+		currency = user.Currency
+	} else if currency != user.Currency {
+		err = service.CheckCurrency(r.Context(), currency)
+		if err != nil {
+			if errors.Is(err, service.ErrCurrencyUnsupported) {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			slog.Error("update user profile", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
 	err = database.Q.UpdateUserProfile(r.Context(), database.UpdateUserProfileParams{
-		Name:    req.Name,
-		Address: pgtype.Text{String: req.Address, Valid: req.Address != ""},
-		City:    pgtype.Text{String: req.City, Valid: req.City != ""},
-		State:   pgtype.Text{String: req.State, Valid: req.State != ""},
-		Country: pgtype.Text{String: req.Country, Valid: req.Country != ""},
-		ZipCode: pgtype.Text{String: req.ZipCode, Valid: req.ZipCode != ""},
-		ID:      user.ID,
+		Name:      req.Name,
+		Address:   pgtype.Text{String: req.Address, Valid: req.Address != ""},
+		City:      pgtype.Text{String: req.City, Valid: req.City != ""},
+		State:     pgtype.Text{String: req.State, Valid: req.State != ""},
+		Country:   pgtype.Text{String: req.Country, Valid: req.Country != ""},
+		ZipCode:   pgtype.Text{String: req.ZipCode, Valid: req.ZipCode != ""},
+		VatNumber: user.VatNumber,
+		Currency:  currency,
+		ID:        user.ID,
 	})
 	if err != nil {
 		slog.Error("update user profile", "err", err)
@@ -0,0 +1,93 @@
+package middlewares
+
+import (
+	"billing3/database"
+	"billing3/service"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// apiKeyScopes maps the user routes that can be used with API keys to the scope they need. Admin routes need
+// service.ScopeAdminRead for GET and service.ScopeAdminWrite for anything else. API keys can not be used with
+// any other route, e.g. to manage API keys, passkeys or two-factor authentication.
+var apiKeyScopes = map[string]string{
+	"GET /invoice":                                service.ScopeInvoicesRead,
+	"GET /invoice/{id}":                           service.ScopeInvoicesRead,
+	"GET /invoice/{id}/pdf":                       service.ScopeInvoicesRead,
+	"GET /invoice/gateways":                       service.ScopeInvoicesRead,
+	"GET /invoice/{id}/payments":                  service.ScopeInvoicesRead,
+	"POST /invoice/{id}/pay":                      service.ScopeInvoicesPay,
+	"GET /credit":                                 service.ScopeInvoicesRead,
+	"POST /credit/top-up":                         service.ScopeInvoicesPay,
+	"GET /service":                                service.ScopeServicesRead,
+	"GET /service/{id}":                           service.ScopeServicesRead,
+	"GET /service/{id}/action":                    service.ScopeServicesRead,
+	"GET /service/{id}/info":                      service.ScopeServicesRead,
+	"GET /service/{id}/jobs":                      service.ScopeServicesRead,
+	"GET /service/{id}/cancel":                    service.ScopeServicesRead,
+	"GET /service/{id}/ticket":                    service.ScopeTicketsRead,
+	"POST /service/{id}/info":                     service.ScopeServicesAction,
+	"POST /service/{id}/action":                   service.ScopeServicesAction,
+	"POST /service/{id}/upgrade/calculate":        service.ScopeServicesRead,
+	"POST /service/{id}/upgrade":                  service.ScopeServicesAction,
+	"POST /service/{id}/cancel":                   service.ScopeServicesAction,
+	"DELETE /service/{id}/cancel":                 service.ScopeServicesAction,
+	"GET /ticket":                                 service.ScopeTicketsRead,
+	"GET /ticket/department":                      service.ScopeTicketsRead,
+	"GET /ticket/{id}":                            service.ScopeTicketsRead,
+	"GET /ticket/{id}/attachment/{attachment_id}": service.ScopeTicketsRead,
+	"POST /ticket":                                service.ScopeTicketsWrite,
+	"POST /ticket/{id}/reply":                     service.ScopeTicketsWrite,
+	"POST /ticket/{id}/close":                     service.ScopeTicketsWrite,
+}
+
+// requiredScope returns the scope an API key needs for the route of the request, or "" if the route can not be
+// used with API keys. The route must have been matched, i.e. it must be called from a middleware of a group.
+func requiredScope(r *http.Request) string {
+	pattern := chi.RouteContext(r.Context()).RoutePattern()
+
+	if strings.HasPrefix(pattern, "/admin/") {
+		if r.Method == http.MethodGet {
+			return service.ScopeAdminRead
+		}
+		return service.ScopeAdminWrite
+	}
+
+	return apiKeyScopes[r.Method+" "+pattern]
+}
+
+// checkApiKeyScope writes an error and returns false if the request is authenticated with an API key that does
+// not have the scope of the route. Requests authenticated with a session token are not limited.
+func checkApiKeyScope(w http.ResponseWriter, r *http.Request) bool {
+	apiKey := GetApiKey(r)
+	if apiKey == nil {
+		return true
+	}
+
+	scope := requiredScope(r)
+	if scope != "" && service.ApiKeyHasScope(apiKey, scope) {
+		return true
+	}
+
+	msg := "This endpoint can not be used with API keys"
+	if scope != "" {
+		msg = "API key does not have the scope " + scope
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+	return false
+}
+
+// GetApiKey returns the API key the request is authenticated with, or nil if it is authenticated with a session
+// token or not at all.
+func GetApiKey(r *http.Request) *database.ApiKey {
+	apiKey, ok := r.Context().Value(authCtx("API_KEY")).(*database.ApiKey)
+	if !ok || apiKey == nil {
+		return nil
+	}
+	return apiKey
+}
@@ -2,21 +2,30 @@ package middlewares
 
 import (
 	"billing3/database"
+	"billing3/service"
+	"billing3/service/audit"
+	"billing3/utils"
 	"context"
 	"errors"
 	"io"
 	"log/slog"
 	"net/http"
+	"strings"
 
 	"github.com/jackc/pgx/v5"
 )
 
 type authCtx string
 
-// Auth adds user to context if session token is valid
+// Auth adds user to context if session token is valid. API keys are accepted in the Authorization header, with or
+// without "Bearer ", but not in the cookie.
 func Auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if service.IsApiKey(token) {
+			apiKeyAuth(next, w, r, token)
+			return
+		}
 		if token == "" {
 			cookie, err := r.Cookie("token")
 			if err == nil {
@@ -45,13 +54,69 @@ func Auth(next http.Handler) http.Handler {
 			return
 		}
 
+		impersonator, err := service.SessionImpersonator(ctx, &session, &user)
+		if err != nil {
+			if errors.Is(err, service.ErrImpersonationInvalid) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			slog.Error("find session impersonator", "err", err, "id", session.ID)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// the last seen time is only updated once a minute
+		err = database.Q.UpdateSessionLastSeen(ctx, database.UpdateSessionLastSeenParams{
+			ID: session.ID,
+			Ip: utils.ClientIP(r),
+		})
+		if err != nil {
+			slog.Error("update session last seen", "err", err, "id", session.ID)
+		}
+
 		ctx = context.WithValue(ctx, authCtx("USER"), &user)
 		ctx = context.WithValue(ctx, authCtx("TOKEN"), token)
+		if impersonator != nil {
+			// changes made while impersonating are logged as the admin's
+			ctx = context.WithValue(ctx, authCtx("IMPERSONATOR"), impersonator)
+			ctx = audit.WithActor(ctx, impersonator.ID, utils.ClientIP(r))
+		} else {
+			ctx = audit.WithActor(ctx, user.ID, utils.ClientIP(r))
+		}
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-// MustAuth blocks any request that does not have a user
+func apiKeyAuth(next http.Handler, w http.ResponseWriter, r *http.Request, key string) {
+	ctx := r.Context()
+
+	apiKey, err := service.AuthenticateApiKey(ctx, key, utils.ClientIP(r))
+	if err != nil {
+		if errors.Is(err, service.ErrApiKeyInvalid) {
+			// continue if api key is invalid
+			next.ServeHTTP(w, r)
+			return
+		}
+		slog.Error("find api key", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	user, err := database.Q.FindUserById(ctx, apiKey.UserID)
+	if err != nil {
+		slog.Error("find api key", "err", err, "id", apiKey.ID)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	ctx = context.WithValue(ctx, authCtx("USER"), &user)
+	ctx = context.WithValue(ctx, authCtx("API_KEY"), apiKey)
+	ctx = audit.WithActor(ctx, user.ID, utils.ClientIP(r))
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// MustAuth blocks any request that does not have a user, or that is authenticated with an API key without the
+// scope of the route
 func MustAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if GetUser(r) == nil {
@@ -60,6 +125,9 @@ func MustAuth(next http.Handler) http.Handler {
 			io.WriteString(w, "{\"error\": \"Login required\"}")
 			return
 		}
+		if !checkApiKeyScope(w, r) {
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
@@ -75,12 +143,57 @@ func RequireRole(role string) func(next http.Handler) http.Handler {
 				io.WriteString(w, "{\"error\": \"unauthorized\"}")
 				return
 			}
+			if !checkApiKeyScope(w, r) {
+				return
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
 
 }
 
+// RequireTwoFactor blocks users who have to enable two-factor authentication but have not, see
+// service.TwoFactorRequired. It must be used after MustAuth or RequireRole.
+func RequireTwoFactor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		required, err := service.TwoFactorRequired(r.Context(), MustGetUser(r))
+		if err != nil {
+			slog.Error("require two factor", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if required {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, "{\"error\": \"Two-factor authentication required\"}")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequirePermission blocks admins whose staff role does not have the permission. It must be used after
+// RequireRole("admin").
+func RequirePermission(permission string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ok, err := service.HasPermission(r.Context(), MustGetUser(r), permission)
+			if err != nil {
+				slog.Error("require permission", "err", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusForbidden)
+				io.WriteString(w, "{\"error\": \"Permission denied\"}")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // MustGetUser panics if user does not exist
 func MustGetUser(r *http.Request) *database.User {
 	user, ok := r.Context().Value(authCtx("USER")).(*database.User)
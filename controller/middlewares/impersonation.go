@@ -0,0 +1,31 @@
+package middlewares
+
+import (
+	"billing3/database"
+	"io"
+	"net/http"
+)
+
+// NotImpersonating blocks sensitive actions, e.g. two-factor authentication and payments, in sessions created by
+// an admin with POST /admin/user/{id}/impersonate.
+func NotImpersonating(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if GetImpersonator(r) != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, "{\"error\": \"Not allowed while impersonating\"}")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GetImpersonator returns the admin who is logged in as the user, or nil if the session is not an impersonation
+// session
+func GetImpersonator(r *http.Request) *database.User {
+	user, ok := r.Context().Value(authCtx("IMPERSONATOR")).(*database.User)
+	if !ok || user == nil {
+		return nil
+	}
+	return user
+}
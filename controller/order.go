@@ -20,6 +20,11 @@ func calculatePrice(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// logged in users always see prices in their own currency
+	if user := middlewares.GetUser(r); user != nil {
+		req.Currency = user.Currency
+	}
+
 	_, _, _, pricing, err := service.CalculatePricing(r.Context(), *req)
 	if err != nil {
 		if errors.Is(err, service.ErrInternalError) {
@@ -42,6 +47,9 @@ func order(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// services are billed in the currency of the user
+	req.Currency = user.Currency
+
 	// calculate price
 	product, options, redactedOptions, pricing, err := service.CalculatePricing(r.Context(), *req)
 	if err != nil {
@@ -101,6 +109,9 @@ func order(w http.ResponseWriter, r *http.Request) {
 		Extension:    product.Extension,
 		Settings:     serviceSettings,
 		ExpiresAt:    types.Timestamp{Timestamp: pgtype.Timestamp{Valid: true, Time: time.Now().UTC()}},
+		ProductID:    pgtype.Int4{Valid: true, Int32: product.ID},
+		CouponID:     pgtype.Int4{Valid: pricing.CouponID != 0, Int32: pricing.CouponID},
+		Currency:     pricing.Currency,
 	})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -108,6 +119,20 @@ func order(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// coupon
+	if pricing.CouponID != 0 {
+		err = service.UseCoupon(r.Context(), qtx, pricing.CouponID, user.ID, serviceId)
+		if err != nil {
+			if errors.Is(err, service.ErrCouponUnavailable) {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			w.WriteHeader(http.StatusInternalServerError)
+			slog.Error("use coupon", "err", err, "coupon", pricing.CouponID)
+			return
+		}
+	}
+
 	// create invoice
 	invoiceId, err := service.CreateRenewalInvoice(r.Context(), qtx, serviceId, pricing.SetupFee)
 	if err != nil {
@@ -124,7 +149,9 @@ func order(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	slog.Info("new order", "product", product.ID, "label", product.Name, "duration", pricing.Duration, "billing cycle", pricing.BillingCycle, "options", redactedOptions, "product settings", product.Settings, "recurring fee", pricing.RecurringFee, "setup fee", pricing.SetupFee, "user", user.ID, "service id", serviceId, "invoice id", invoiceId)
+	service.NotifyInvoiceCreated(r.Context(), invoiceId)
+
+	slog.Info("new order", "product", product.ID, "label", product.Name, "duration", pricing.Duration, "billing cycle", pricing.BillingCycle, "options", redactedOptions, "product settings", product.Settings, "recurring fee", pricing.RecurringFee, "setup fee", pricing.SetupFee, "currency", pricing.Currency, "coupon", pricing.Coupon, "discount", pricing.Discount, "user", user.ID, "service id", serviceId, "invoice id", invoiceId)
 
 	writeResp(w, http.StatusOK, D{"invoice": invoiceId})
 }
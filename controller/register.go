@@ -73,9 +73,10 @@ func registerStep2(w http.ResponseWriter, r *http.Request) {
 		Name:     req.Name,
 		Role:     "user",
 		Password: utils.HashPassword(req.Password),
+		Currency: service.BaseCurrency(r.Context()),
 	})
 	if err != nil {
-		if err, ok := err.(*pgconn.PgError); ok && err.Code == PGErrorUniqueViolation {
+		if err, ok := err.(*pgconn.PgError); ok && err.Code == database.PGErrorUniqueViolation {
 			// user with same email already exists
 			writeError(w, http.StatusForbidden, "Invalid token")
 			return
@@ -85,7 +86,7 @@ func registerStep2(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	authToken, err := service.NewSessionToken(r.Context(), userId)
+	authToken, err := service.NewSessionToken(r.Context(), userId, r.UserAgent(), utils.ClientIP(r))
 	if err != nil {
 		slog.Error("register", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
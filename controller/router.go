@@ -2,6 +2,8 @@ package controller
 
 import (
 	"billing3/controller/middlewares"
+	"billing3/service"
+	"billing3/service/audit"
 	"billing3/service/extension"
 	"billing3/service/gateways"
 	"log/slog"
@@ -29,6 +31,7 @@ func Route(r *chi.Mux) {
 	// auth
 	r.Group(func(r chi.Router) {
 		r.With(middlewares.CloudflareTurnstile).Post("/auth/login", login)
+		r.Post("/auth/login/2fa", loginTwoFactor)
 		r.With(middlewares.CloudflareTurnstile).Post("/auth/register", register)
 		r.With(middlewares.CloudflareTurnstile).Post("/auth/register2", registerStep2)
 		r.With(middlewares.CloudflareTurnstile).Post("/auth/reset-password", resetPassword)
@@ -36,69 +39,164 @@ func Route(r *chi.Mux) {
 
 		r.With(middlewares.MustAuth).Get("/auth/me", me)
 		r.With(middlewares.MustAuth).Post("/auth/logout", logout)
-		r.With(middlewares.MustAuth).Put("/auth/profile", updateProfile)
+		r.With(middlewares.MustAuth).Get("/auth/sessions", sessionList)
+		r.With(middlewares.MustAuth, middlewares.NotImpersonating).Delete("/auth/sessions", sessionRevokeAll)
+		r.With(middlewares.MustAuth, middlewares.NotImpersonating).Delete("/auth/sessions/{id}", sessionRevoke)
+		r.With(middlewares.MustAuth, middlewares.NotImpersonating).Put("/auth/profile", updateProfile)
+		r.With(middlewares.MustAuth).Get("/auth/2fa", twoFactorStatus)
+		r.With(middlewares.MustAuth, middlewares.NotImpersonating).Post("/auth/2fa/enroll", twoFactorEnroll)
+		r.With(middlewares.MustAuth, middlewares.NotImpersonating).Post("/auth/2fa/confirm", twoFactorConfirm)
+		r.With(middlewares.MustAuth, middlewares.NotImpersonating).Post("/auth/2fa/disable", twoFactorDisable)
+		r.With(middlewares.MustAuth, middlewares.NotImpersonating).Post("/auth/2fa/recovery-codes", twoFactorRecoveryCodes)
+
+		r.With(middlewares.CloudflareTurnstile).Post("/auth/webauthn/login/begin", webauthnLoginBegin)
+		r.Post("/auth/webauthn/login/finish", webauthnLoginFinish)
+		r.Post("/auth/webauthn/2fa/begin", webauthnTwoFactorBegin)
+		r.Post("/auth/webauthn/2fa/finish", webauthnTwoFactorFinish)
+		r.With(middlewares.MustAuth, middlewares.NotImpersonating).Post("/auth/webauthn/register/begin", webauthnRegisterBegin)
+		r.With(middlewares.MustAuth, middlewares.NotImpersonating).Post("/auth/webauthn/register/finish", webauthnRegisterFinish)
+		r.With(middlewares.MustAuth).Get("/auth/webauthn/credentials", webauthnCredentialList)
+		r.With(middlewares.MustAuth, middlewares.NotImpersonating).Put("/auth/webauthn/credentials/{id}", webauthnCredentialRename)
+		r.With(middlewares.MustAuth, middlewares.NotImpersonating).Delete("/auth/webauthn/credentials/{id}", webauthnCredentialDelete)
+
+		r.With(middlewares.MustAuth).Get("/auth/api-keys", apiKeyList)
+		r.With(middlewares.MustAuth, middlewares.NotImpersonating).Post("/auth/api-keys", apiKeyCreate)
+		r.With(middlewares.MustAuth, middlewares.NotImpersonating).Delete("/auth/api-keys/{id}", apiKeyDelete)
 	})
 
 	// admin
 	r.Group(func(r chi.Router) {
 		r.Use(middlewares.MustAuth)
 		r.Use(middlewares.RequireRole("admin"))
+		r.Use(middlewares.RequireTwoFactor)
+
+		perm := middlewares.RequirePermission
+
+		r.With(perm(service.PermUserView)).Get("/admin/user", adminUserList)
+		r.With(perm(service.PermUserEdit)).Post("/admin/user", adminUserCreate)
+		r.With(perm(service.PermUserEdit)).Put("/admin/user/{id}", adminUserEdit)
+		r.With(perm(service.PermUserView)).Get("/admin/user/{id}", adminUserGet)
+		r.With(perm(service.PermUserView)).Get("/admin/user/{id}/credit", adminUserCredit)
+		r.With(perm(service.PermUserEdit)).Post("/admin/user/{id}/credit", adminUserAdjustCredit)
+		r.With(perm(service.PermUserEdit)).Delete("/admin/user/{id}/2fa", adminUserDisableTwoFactor)
+		r.With(perm(service.PermUserEdit)).Delete("/admin/user/{id}/lockout", adminUserUnlock)
+		r.With(perm(service.PermUserView)).Get("/admin/user/{id}/sessions", adminUserSessions)
+		r.With(perm(service.PermUserEdit)).Delete("/admin/user/{id}/sessions", adminUserRevokeSessions)
+		r.With(perm(service.PermUserEdit)).Delete("/admin/user/{id}/sessions/{session_id}", adminUserRevokeSession)
+		r.With(perm(service.PermRoleEdit)).Put("/admin/user/{id}/role", adminUserSetRole)
+		r.With(perm(service.PermAuditView)).Get("/admin/user/{id}/audit", adminAuditHistory(audit.EntityUser))
+		r.With(perm(service.PermUserImpersonate)).Post("/admin/user/{id}/impersonate", adminUserImpersonate)
+
+		r.With(perm(service.PermRoleEdit)).Get("/admin/role", adminRoleList)
+		r.With(perm(service.PermRoleEdit)).Post("/admin/role", adminRoleCreate)
+		r.With(perm(service.PermRoleEdit)).Get("/admin/role/{id}", adminRoleGet)
+		r.With(perm(service.PermRoleEdit)).Put("/admin/role/{id}", adminRoleUpdate)
+		r.With(perm(service.PermRoleEdit)).Delete("/admin/role/{id}", adminRoleDelete)
+
+		r.With(perm(service.PermProductView)).Get("/admin/category", adminCategoryList)
+		r.With(perm(service.PermProductEdit)).Post("/admin/category", adminCategoryCreate)
+		r.With(perm(service.PermProductEdit)).Put("/admin/category/{id}", adminCategoryUpdate)
+		r.With(perm(service.PermProductView)).Get("/admin/category/{id}", adminCategoryGet)
+		r.With(perm(service.PermProductEdit)).Delete("/admin/category/{id}", adminCategoryDelete)
+
+		r.With(perm(service.PermProductView)).Get("/admin/coupon", adminCouponList)
+		r.With(perm(service.PermProductEdit)).Post("/admin/coupon", adminCouponCreate)
+		r.With(perm(service.PermProductEdit)).Put("/admin/coupon/{id}", adminCouponUpdate)
+		r.With(perm(service.PermProductView)).Get("/admin/coupon/{id}", adminCouponGet)
+		r.With(perm(service.PermProductEdit)).Delete("/admin/coupon/{id}", adminCouponDelete)
+
+		r.With(perm(service.PermProductView)).Get("/admin/tax", adminTaxRuleList)
+		r.With(perm(service.PermProductEdit)).Post("/admin/tax", adminTaxRuleCreate)
+		r.With(perm(service.PermProductEdit)).Put("/admin/tax/{id}", adminTaxRuleUpdate)
+		r.With(perm(service.PermProductView)).Get("/admin/tax/{id}", adminTaxRuleGet)
+		r.With(perm(service.PermProductEdit)).Delete("/admin/tax/{id}", adminTaxRuleDelete)
+
+		r.With(perm(service.PermProductView)).Get("/admin/currency", adminCurrencyList)
+		r.With(perm(service.PermProductEdit)).Put("/admin/currency/{currency}", adminCurrencyUpdate)
+		r.With(perm(service.PermProductEdit)).Delete("/admin/currency/{currency}", adminCurrencyDelete)
+
+		r.With(perm(service.PermProductView)).Get("/admin/product", adminProductList)
+		r.With(perm(service.PermProductView)).Get("/admin/product/extension-list", adminProductExtensionList)
+		r.With(perm(service.PermProductView)).Post("/admin/product/extension-settings", adminProductExtensionSettings)
+		r.With(perm(service.PermProductEdit)).Post("/admin/product", adminProductCreate)
+		r.With(perm(service.PermProductEdit)).Put("/admin/product/{id}", adminProductUpdate)
+		r.With(perm(service.PermProductView)).Get("/admin/product/{id}", adminProductGet)
+		r.With(perm(service.PermProductEdit)).Delete("/admin/product/{id}", adminProductDelete)
+		r.With(perm(service.PermAuditView)).Get("/admin/product/{id}/audit", adminAuditHistory(audit.EntityProduct))
 
-		r.Get("/admin/user", adminUserList)
-		r.Post("/admin/user", adminUserCreate)
-		r.Put("/admin/user/{id}", adminUserEdit)
-		r.Get("/admin/user/{id}", adminUserGet)
-
-		r.Get("/admin/category", adminCategoryList)
-		r.Post("/admin/category", adminCategoryCreate)
-		r.Put("/admin/category/{id}", adminCategoryUpdate)
-		r.Get("/admin/category/{id}", adminCategoryGet)
-		r.Delete("/admin/category/{id}", adminCategoryDelete)
-
-		r.Get("/admin/product", adminProductList)
-		r.Get("/admin/product/extension-list", adminProductExtensionList)
-		r.Post("/admin/product/extension-settings", adminProductExtensionSettings)
-		r.Post("/admin/product", adminProductCreate)
-		r.Put("/admin/product/{id}", adminProductUpdate)
-		r.Get("/admin/product/{id}", adminProductGet)
-		r.Delete("/admin/product/{id}", adminProductDelete)
-
-		r.Get("/admin/invoice", adminInvoiceList)
-		r.Get("/admin/invoice/{id}", adminInvoiceGet)
-		r.Put("/admin/invoice/{id}", adminInvoiceEdit)
-		r.Post("/admin/invoice/{id}/item", adminInvoiceAddItem)
-		r.Delete("/admin/invoice/{id}/item/{item_id}", adminInvoiceRemoveItem)
-		r.Put("/admin/invoice/{id}/item/{item_id}", adminInvoiceUpdateItem)
-		r.Get("/admin/invoice/{id}/payment", adminListInvoicePayment)
-		r.Post("/admin/invoice/{id}/payment", adminAddInvoicePayment)
-
-		r.Get("/admin/gateway", adminListGateways)
-		r.Get("/admin/gateway/{id}", adminGatewayGet)
-		r.Put("/admin/gateway/{id}", adminGatewayUpdate)
-		r.Get("/admin/gateway/settings", adminGatewaySettings)
-
-		r.Get("/admin/service", adminServiceList)
-		r.Get("/admin/service/{id}", adminServiceGet)
-		r.Put("/admin/service/{id}", adminServiceUpdate)
-		r.Get("/admin/service/{id}/invoice", adminInvoiceListByService)
-		r.Post("/admin/service/{id}/invoice", adminServiceGenerateInvoice)
-		r.Get("/admin/service/{id}/action", adminServiceActions)
-		r.Post("/admin/service/{id}/action", adminServicePerformAction)
-		r.Get("/admin/service/{id}/info", adminServiceInfoPage)
-		r.Post("/admin/service/{id}/info", adminServiceInfoPage)
-		r.Put("/admin/service/{id}/status", adminServiceUpdateStatus)
-		r.Put("/admin/service/{id}/settings", adminServiceUpdateSettings)
-		r.Get("/admin/service/{id}/jobs", adminServiceGetJobs)
-
-		r.Get("/admin/server", adminServerList)
-		r.Get("/admin/server/{id}", adminServerGet)
-		r.Put("/admin/server/{id}", adminServerEdit)
-		r.Post("/admin/server", adminServerAdd)
-		r.Delete("/admin/server/{id}", adminServerDelete)
-		r.Get("/admin/server/extension-settings", adminExtensionServerSettings)
-
-		r.Get("/admin/setting", adminSettingsList)
-		r.Put("/admin/setting", adminSettingsUpdate)
+		r.With(perm(service.PermInvoiceView)).Get("/admin/invoice", adminInvoiceList)
+		r.With(perm(service.PermInvoiceView)).Get("/admin/invoice/{id}", adminInvoiceGet)
+		r.With(perm(service.PermInvoiceView)).Get("/admin/invoice/{id}/pdf", adminInvoicePDF)
+		r.With(perm(service.PermInvoiceEdit)).Put("/admin/invoice/{id}", adminInvoiceEdit)
+		r.With(perm(service.PermInvoiceEdit)).Post("/admin/invoice/{id}/item", adminInvoiceAddItem)
+		r.With(perm(service.PermInvoiceEdit)).Delete("/admin/invoice/{id}/item/{item_id}", adminInvoiceRemoveItem)
+		r.With(perm(service.PermInvoiceEdit)).Put("/admin/invoice/{id}/item/{item_id}", adminInvoiceUpdateItem)
+		r.With(perm(service.PermInvoiceView)).Get("/admin/invoice/{id}/payment", adminListInvoicePayment)
+		r.With(perm(service.PermInvoiceEdit)).Post("/admin/invoice/{id}/payment", adminAddInvoicePayment)
+		r.With(perm(service.PermInvoiceEdit)).Post("/admin/invoice/{id}/refund", adminRefundInvoicePayment)
+		r.With(perm(service.PermAuditView)).Get("/admin/invoice/{id}/audit", adminAuditHistory(audit.EntityInvoice))
+
+		r.With(perm(service.PermGatewayView)).Get("/admin/gateway", adminListGateways)
+		r.With(perm(service.PermGatewayView)).Get("/admin/gateway/{id}", adminGatewayGet)
+		r.With(perm(service.PermGatewayEdit)).Put("/admin/gateway/{id}", adminGatewayUpdate)
+		r.With(perm(service.PermAuditView)).Get("/admin/gateway/{id}/audit", adminAuditHistory(audit.EntityGateway))
+		r.With(perm(service.PermGatewayView)).Get("/admin/gateway/settings", adminGatewaySettings)
+
+		r.With(perm(service.PermServiceView)).Get("/admin/service", adminServiceList)
+		r.With(perm(service.PermServiceView)).Get("/admin/service/{id}", adminServiceGet)
+		r.With(perm(service.PermServiceEdit)).Put("/admin/service/{id}", adminServiceUpdate)
+		r.With(perm(service.PermServiceView)).Get("/admin/service/{id}/invoice", adminInvoiceListByService)
+		r.With(perm(service.PermServiceEdit)).Post("/admin/service/{id}/invoice", adminServiceGenerateInvoice)
+		r.With(perm(service.PermServiceView)).Get("/admin/service/{id}/action", adminServiceActions)
+		r.With(perm(service.PermServiceAction)).Post("/admin/service/{id}/action", adminServicePerformAction)
+		r.With(perm(service.PermServiceView)).Get("/admin/service/{id}/info", adminServiceInfoPage)
+		r.With(perm(service.PermServiceAction)).Post("/admin/service/{id}/info", adminServiceInfoPage)
+		r.With(perm(service.PermServiceEdit)).Put("/admin/service/{id}/status", adminServiceUpdateStatus)
+		r.With(perm(service.PermServiceEdit)).Put("/admin/service/{id}/settings", adminServiceUpdateSettings)
+		r.With(perm(service.PermServiceView)).Get("/admin/service/{id}/jobs", adminServiceGetJobs)
+		r.With(perm(service.PermServiceView)).Get("/admin/service/{id}/upgrade", adminServiceUpgrades)
+		r.With(perm(service.PermServiceView)).Get("/admin/service/{id}/dunning", adminServiceDunningSteps)
+		r.With(perm(service.PermServiceView)).Get("/admin/service/{id}/cancellation", adminServiceCancellations)
+		r.With(perm(service.PermAuditView)).Get("/admin/service/{id}/audit", adminAuditHistory(audit.EntityService))
+		r.With(perm(service.PermServiceView)).Get("/admin/cancellation", adminCancellationList)
+		r.With(perm(service.PermServiceEdit)).Post("/admin/cancellation/{id}/approve", adminCancellationApprove)
+		r.With(perm(service.PermServiceEdit)).Post("/admin/cancellation/{id}/reject", adminCancellationReject)
+		r.With(perm(service.PermTicketView)).Get("/admin/service/{id}/ticket", adminServiceTickets)
+
+		r.With(perm(service.PermTicketView)).Get("/admin/ticket", adminTicketList)
+		r.With(perm(service.PermTicketView)).Get("/admin/ticket/{id}", adminTicketGet)
+		r.With(perm(service.PermTicketReply)).Put("/admin/ticket/{id}", adminTicketUpdate)
+		r.With(perm(service.PermTicketReply)).Post("/admin/ticket/{id}/reply", adminTicketReply)
+		r.With(perm(service.PermTicketReply)).Post("/admin/ticket/{id}/close", adminTicketClose)
+		r.With(perm(service.PermTicketView)).Get("/admin/ticket/{id}/attachment/{attachment_id}", adminTicketAttachment)
+		r.With(perm(service.PermAuditView)).Get("/admin/ticket/{id}/audit", adminAuditHistory(audit.EntityTicket))
+		r.With(perm(service.PermTicketView)).Get("/admin/ticket-department", listTicketDepartments)
+		r.With(perm(service.PermDepartmentEdit)).Post("/admin/ticket-department", adminTicketDepartmentCreate)
+		r.With(perm(service.PermDepartmentEdit)).Put("/admin/ticket-department/{id}", adminTicketDepartmentUpdate)
+		r.With(perm(service.PermDepartmentEdit)).Delete("/admin/ticket-department/{id}", adminTicketDepartmentDelete)
+
+		r.With(perm(service.PermServerView)).Get("/admin/server", adminServerList)
+		r.With(perm(service.PermServerView)).Get("/admin/server/{id}", adminServerGet)
+		r.With(perm(service.PermServerEdit)).Put("/admin/server/{id}", adminServerEdit)
+		r.With(perm(service.PermServerEdit)).Post("/admin/server", adminServerAdd)
+		r.With(perm(service.PermServerEdit)).Delete("/admin/server/{id}", adminServerDelete)
+		r.With(perm(service.PermAuditView)).Get("/admin/server/{id}/audit", adminAuditHistory(audit.EntityServer))
+		r.With(perm(service.PermServerView)).Get("/admin/server/extension-settings", adminExtensionServerSettings)
+
+		r.With(perm(service.PermEmailView)).Get("/admin/email", adminEmailList)
+		r.With(perm(service.PermEmailView)).Get("/admin/email/{id}", adminEmailGet)
+		r.With(perm(service.PermEmailEdit)).Post("/admin/email/{id}/resend", adminEmailResend)
+
+		r.With(perm(service.PermEmailView)).Get("/admin/email-template", adminEmailTemplateList)
+		r.With(perm(service.PermEmailView)).Get("/admin/email-template/{event}", adminEmailTemplateGet)
+		r.With(perm(service.PermEmailEdit)).Put("/admin/email-template/{event}", adminEmailTemplateUpdate)
+		r.With(perm(service.PermEmailEdit)).Delete("/admin/email-template/{event}", adminEmailTemplateReset)
+		r.With(perm(service.PermEmailView)).Post("/admin/email-template/{event}/preview", adminEmailTemplatePreview)
+
+		r.With(perm(service.PermSettingsView)).Get("/admin/setting", adminSettingsList)
+		r.With(perm(service.PermSettingsEdit)).Put("/admin/setting", adminSettingsUpdate)
+
+		r.With(perm(service.PermAuditView)).Get("/admin/audit", adminAuditList)
 	})
 
 	// store
@@ -108,8 +206,9 @@ func Route(r *chi.Mux) {
 		r.Get("/store/category/{id}/product", listProductByCategory)
 		r.Get("/store/product/{id}", getProduct)
 		r.Get("/store/product/{id}/options", getProductOptions)
+		r.Get("/store/currency", listCurrencies)
 		r.Post("/store/calculate-price", calculatePrice)
-		r.With(middlewares.CloudflareTurnstile).With(middlewares.MustAuth).Post("/store/order", order)
+		r.With(middlewares.CloudflareTurnstile).With(middlewares.MustAuth, middlewares.NotImpersonating).Post("/store/order", order)
 	})
 
 	// user
@@ -118,17 +217,35 @@ func Route(r *chi.Mux) {
 
 		r.Get("/invoice", listInvoices)
 		r.Get("/invoice/{id}", getInvoice)
+		r.Get("/invoice/{id}/pdf", getInvoicePDF)
 		r.Get("/invoice/gateways", getAvailablePaymentGateways)
-		r.Post("/invoice/{id}/pay", makePayment)
+		r.With(middlewares.NotImpersonating).Post("/invoice/{id}/pay", makePayment)
 		r.Get("/invoice/{id}/payments", getInvoicePayments)
 
+		r.Get("/credit", getCredit)
+		r.With(middlewares.NotImpersonating).Post("/credit/top-up", creditTopUp)
+
 		r.Get("/service", getServices)
 		r.Get("/service/{id}", getService)
 		r.Get("/service/{id}/action", serviceClientActions)
 		r.Get("/service/{id}/info", serviceInfoPage)
 		r.Post("/service/{id}/info", serviceInfoPage)
 		r.Post("/service/{id}/action", servicePerformAction)
+		r.Post("/service/{id}/upgrade/calculate", serviceUpgradeCalculate)
+		r.With(middlewares.NotImpersonating).Post("/service/{id}/upgrade", serviceUpgrade)
 		r.Get("/service/{id}/jobs", serviceGetJobs)
+		r.Get("/service/{id}/cancel", serviceGetCancellation)
+		r.Post("/service/{id}/cancel", serviceCancel)
+		r.Delete("/service/{id}/cancel", serviceWithdrawCancellation)
+		r.Get("/service/{id}/ticket", serviceListTickets)
+
+		r.Get("/ticket", listTickets)
+		r.Post("/ticket", createTicket)
+		r.Get("/ticket/department", listTicketDepartments)
+		r.Get("/ticket/{id}", getTicket)
+		r.Post("/ticket/{id}/reply", replyTicket)
+		r.Post("/ticket/{id}/close", closeTicket)
+		r.Get("/ticket/{id}/attachment/{attachment_id}", getTicketAttachment)
 	})
 
 	for name, gateway := range gateways.Gateways {
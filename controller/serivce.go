@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/riverqueue/river"
 	"github.com/shopspring/decimal"
@@ -72,6 +73,10 @@ func getService(w http.ResponseWriter, r *http.Request) {
 
 	s, err := database.Q.FindServiceById(r.Context(), int32(id))
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
 		slog.Error("get s", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -93,6 +98,7 @@ func getService(w http.ResponseWriter, r *http.Request) {
 		"expires_at":          s.ExpiresAt,
 		"created_at":          s.CreatedAt,
 		"cancelled_at":        s.CancelledAt,
+		"product_id":          s.ProductID,
 	}})
 }
 
@@ -106,6 +112,10 @@ func serviceClientActions(w http.ResponseWriter, r *http.Request) {
 
 	s, err := database.Q.FindServiceById(r.Context(), int32(id))
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
 		slog.Error("get service", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -141,6 +151,10 @@ func serviceInfoPage(w http.ResponseWriter, r *http.Request) {
 
 	s, err := database.Q.FindServiceById(r.Context(), int32(id))
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
 		slog.Error("get service", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -180,6 +194,10 @@ func servicePerformAction(w http.ResponseWriter, r *http.Request) {
 
 	s, err := database.Q.FindServiceById(r.Context(), int32(id))
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
 		slog.Error("get service", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -231,6 +249,99 @@ func servicePerformAction(w http.ResponseWriter, r *http.Request) {
 	writeResp(w, http.StatusOK, D{})
 }
 
+func serviceUpgradeCalculate(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.MustGetUser(r)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	s, err := database.Q.FindServiceById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("get service", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if s.UserID != user.ID {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	req, err := decode[service.UpgradeRequest](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	quote, err := service.CalculateUpgrade(r.Context(), &s, *req)
+	if err != nil {
+		if errors.Is(err, service.ErrInternalError) {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"quote": quote})
+}
+
+func serviceUpgrade(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.MustGetUser(r)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	s, err := database.Q.FindServiceById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("get service", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if s.UserID != user.ID {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	req, err := decode[service.UpgradeRequest](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	invoiceId, quote, err := service.UpgradeService(r.Context(), s.ID, *req)
+	if err != nil {
+		if errors.Is(err, service.ErrInternalError) {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if errors.Is(err, service.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	slog.Info("service upgrade requested", "id", s.ID, "user id", user.ID, "invoice id", invoiceId, "amount", quote.Amount)
+
+	// invoice is 0 if the upgrade is applied without payment
+	writeResp(w, http.StatusOK, D{"invoice": invoiceId, "quote": quote})
+}
+
 func serviceGetJobs(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
@@ -242,6 +353,10 @@ func serviceGetJobs(w http.ResponseWriter, r *http.Request) {
 
 	s, err := database.Q.FindServiceById(r.Context(), int32(id))
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
 		slog.Error("get service", "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -301,3 +416,137 @@ func serviceGetJobs(w http.ResponseWriter, r *http.Request) {
 
 	writeResp(w, http.StatusOK, D{"jobs": jobsResp})
 }
+
+func serviceGetCancellation(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.MustGetUser(r)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	s, err := database.Q.FindServiceById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("get service", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if s.UserID != user.ID {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	request, err := database.Q.FindPendingCancellationRequestByService(r.Context(), s.ID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			writeResp(w, http.StatusOK, D{"cancellation": nil})
+			return
+		}
+		slog.Error("get cancellation request", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"cancellation": request})
+}
+
+func serviceCancel(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.MustGetUser(r)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	type reqStruct struct {
+		Type   string `json:"type" validate:"required,oneof=immediate end_of_term"`
+		Reason string `json:"reason" validate:"max=1000"`
+	}
+
+	req, err := decode[reqStruct](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s, err := database.Q.FindServiceById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("get service", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if s.UserID != user.ID {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	requestId, err := service.RequestCancellation(r.Context(), s.ID, req.Type, req.Reason)
+	if err != nil {
+		if errors.Is(err, service.ErrInternalError) {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if errors.Is(err, service.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	slog.Info("service cancellation requested", "id", s.ID, "user id", user.ID, "request id", requestId, "type", req.Type)
+
+	writeResp(w, http.StatusOK, D{"id": requestId})
+}
+
+func serviceWithdrawCancellation(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.MustGetUser(r)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	s, err := database.Q.FindServiceById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("get service", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if s.UserID != user.ID {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	err = service.WithdrawCancellation(r.Context(), s.ID)
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			writeError(w, http.StatusBadRequest, "no pending cancellation request")
+			return
+		}
+		if errors.Is(err, service.ErrCancellationNotPending) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		slog.Error("withdraw cancellation", "err", err, "service id", s.ID)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{})
+}
@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"billing3/controller/middlewares"
+	"billing3/database"
+	"billing3/database/types"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// sessionResp is a session without its token.
+type sessionResp struct {
+	ID         int32           `json:"id"`
+	UserAgent  string          `json:"user_agent"`
+	Ip         string          `json:"ip"`
+	CreatedAt  types.Timestamp `json:"created_at"`
+	LastSeenAt types.Timestamp `json:"last_seen_at"`
+	ExpiresAt  types.Timestamp `json:"expires_at"`
+	Current    bool            `json:"current"`
+	// created by an admin with POST /admin/user/{id}/impersonate
+	Impersonated bool `json:"impersonated"`
+}
+
+// writeSessions writes the sessions without their tokens. The session with currentToken is marked as current.
+func writeSessions(w http.ResponseWriter, sessions []database.Session, currentToken string) {
+	result := make([]sessionResp, len(sessions))
+	for i, s := range sessions {
+		result[i] = sessionResp{
+			ID:           s.ID,
+			UserAgent:    s.UserAgent,
+			Ip:           s.Ip,
+			CreatedAt:    s.CreatedAt,
+			LastSeenAt:   s.LastSeenAt,
+			ExpiresAt:    s.ExpiresAt,
+			Current:      currentToken != "" && s.Token == currentToken,
+			Impersonated: s.ImpersonatorID.Valid,
+		}
+	}
+
+	writeResp(w, http.StatusOK, D{"sessions": result})
+}
+
+func sessionList(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.MustGetUser(r)
+
+	sessions, err := database.Q.ListSessionsByUser(r.Context(), user.ID)
+	if err != nil {
+		slog.Error("list sessions", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeSessions(w, sessions, middlewares.MustGetToken(r))
+}
+
+func sessionRevoke(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.MustGetUser(r)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	n, err := database.Q.DeleteSessionByUser(r.Context(), database.DeleteSessionByUserParams{
+		ID:     int32(id),
+		UserID: user.ID,
+	})
+	if err != nil {
+		slog.Error("revoke session", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if n == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{})
+}
+
+// sessionRevokeAll logs the user out everywhere, including the current session.
+func sessionRevokeAll(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.MustGetUser(r)
+
+	err := database.Q.DeleteSessionsByUser(r.Context(), user.ID)
+	if err != nil {
+		slog.Error("revoke all sessions", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("all sessions revoked", "user_id", user.ID)
+
+	writeResp(w, http.StatusOK, D{})
+}
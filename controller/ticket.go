@@ -0,0 +1,300 @@
+package controller
+
+import (
+	"billing3/controller/middlewares"
+	"billing3/database"
+	"billing3/service"
+	"errors"
+	"log/slog"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// ticketMaxBodySize limits the request body of ticket messages, which includes base64 encoded attachments.
+const ticketMaxBodySize = 40 << 20
+
+type ticketMessage struct {
+	database.ListTicketMessagesRow
+	Attachments []database.ListTicketAttachmentsRow `json:"attachments"`
+}
+
+// writeTicket writes the ticket with its messages and their attachments.
+func writeTicket(w http.ResponseWriter, r *http.Request, ticket *database.Ticket) {
+	messages, err := database.Q.ListTicketMessages(r.Context(), ticket.ID)
+	if err != nil {
+		slog.Error("list ticket messages", "err", err, "ticket_id", ticket.ID)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	attachments, err := database.Q.ListTicketAttachments(r.Context(), ticket.ID)
+	if err != nil {
+		slog.Error("list ticket attachments", "err", err, "ticket_id", ticket.ID)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	department, err := database.Q.FindTicketDepartmentById(r.Context(), ticket.DepartmentID)
+	if err != nil {
+		slog.Error("get ticket department", "err", err, "ticket_id", ticket.ID)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	result := make([]ticketMessage, len(messages))
+	for i, m := range messages {
+		result[i] = ticketMessage{ListTicketMessagesRow: m, Attachments: []database.ListTicketAttachmentsRow{}}
+		for _, a := range attachments {
+			if a.MessageID == m.ID {
+				result[i].Attachments = append(result[i].Attachments, a)
+			}
+		}
+	}
+
+	writeResp(w, http.StatusOK, D{"ticket": ticket, "department": department, "messages": result})
+}
+
+// writeTicketAttachment sends an attachment of the ticket as a download.
+func writeTicketAttachment(w http.ResponseWriter, r *http.Request, ticketId int32) {
+	attachmentId, err := strconv.Atoi(chi.URLParam(r, "attachment_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	a, err := database.Q.FindTicketAttachment(r.Context(), database.FindTicketAttachmentParams{
+		ID:       int32(attachmentId),
+		TicketID: ticketId,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		slog.Error("get ticket attachment", "err", err, "ticket_id", ticketId)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// always downloaded, so that uploaded html is never rendered on our origin
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Length", strconv.Itoa(len(a.Data)))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(a.Data)
+}
+
+// writeTicketError writes an error returned by service.CreateTicket, service.ReplyTicket or service.UpdateTicket.
+func writeTicketError(w http.ResponseWriter, err error) {
+	if errors.Is(err, service.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, service.ErrInternalError) {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeError(w, http.StatusBadRequest, err.Error())
+}
+
+// findUserTicket returns the ticket in the url if it belongs to the user, otherwise it writes the error response.
+func findUserTicket(w http.ResponseWriter, r *http.Request) (*database.Ticket, bool) {
+	user := middlewares.MustGetUser(r)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, false
+	}
+
+	ticket, err := database.Q.FindTicketById(r.Context(), int32(id))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return nil, false
+		}
+		slog.Error("get ticket", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if ticket.UserID != user.ID {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, false
+	}
+
+	return &ticket, true
+}
+
+func listTickets(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.MustGetUser(r)
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	status := r.URL.Query().Get("status")
+
+	count, err := database.Q.SearchTicketsCount(r.Context(), database.SearchTicketsCountParams{
+		Status: status,
+		UserID: user.ID,
+	})
+	if err != nil {
+		slog.Error("list tickets", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	totalPages := int(math.Ceil(float64(count) / float64(itemPerPage)))
+
+	tickets, err := database.Q.SearchTicketsPaged(r.Context(), database.SearchTicketsPagedParams{
+		Limit:  itemPerPage,
+		Offset: int32((page - 1) * itemPerPage),
+		Status: status,
+		UserID: user.ID,
+	})
+	if err != nil {
+		slog.Error("list tickets", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"tickets": tickets, "total_pages": totalPages})
+}
+
+func listTicketDepartments(w http.ResponseWriter, r *http.Request) {
+	departments, err := database.Q.ListTicketDepartments(r.Context())
+	if err != nil {
+		slog.Error("list ticket departments", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"departments": departments})
+}
+
+func createTicket(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.MustGetUser(r)
+
+	type reqStruct struct {
+		DepartmentID int32                      `json:"department_id" validate:"required"`
+		Subject      string                     `json:"subject" validate:"required,max=200"`
+		Priority     string                     `json:"priority" validate:"required,oneof=LOW MEDIUM HIGH"`
+		ServiceID    pgtype.Int4                `json:"service_id"`
+		InvoiceID    pgtype.Int4                `json:"invoice_id"`
+		Body         string                     `json:"body" validate:"required"`
+		Attachments  []service.TicketAttachment `json:"attachments" validate:"dive"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, ticketMaxBodySize)
+	req, err := decode[reqStruct](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	id, err := service.CreateTicket(r.Context(), database.CreateTicketParams{
+		UserID:       user.ID,
+		DepartmentID: req.DepartmentID,
+		Subject:      req.Subject,
+		Priority:     req.Priority,
+		ServiceID:    req.ServiceID,
+		InvoiceID:    req.InvoiceID,
+	}, req.Body, req.Attachments)
+	if err != nil {
+		writeTicketError(w, err)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"id": id})
+}
+
+func getTicket(w http.ResponseWriter, r *http.Request) {
+	ticket, ok := findUserTicket(w, r)
+	if !ok {
+		return
+	}
+
+	writeTicket(w, r, ticket)
+}
+
+func replyTicket(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.MustGetUser(r)
+	ticket, ok := findUserTicket(w, r)
+	if !ok {
+		return
+	}
+
+	type reqStruct struct {
+		Body        string                     `json:"body" validate:"required"`
+		Attachments []service.TicketAttachment `json:"attachments" validate:"dive"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, ticketMaxBodySize)
+	req, err := decode[reqStruct](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	id, err := service.ReplyTicket(r.Context(), ticket.ID, user, false, req.Body, req.Attachments)
+	if err != nil {
+		writeTicketError(w, err)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"id": id})
+}
+
+func closeTicket(w http.ResponseWriter, r *http.Request) {
+	ticket, ok := findUserTicket(w, r)
+	if !ok {
+		return
+	}
+
+	err := service.CloseTicket(r.Context(), ticket.ID)
+	if err != nil {
+		slog.Error("close ticket", "err", err, "ticket_id", ticket.ID)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{})
+}
+
+func getTicketAttachment(w http.ResponseWriter, r *http.Request) {
+	ticket, ok := findUserTicket(w, r)
+	if !ok {
+		return
+	}
+
+	writeTicketAttachment(w, r, ticket.ID)
+}
+
+func serviceListTickets(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.MustGetUser(r)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// filtering by user makes tickets of other users' services invisible
+	tickets, err := database.Q.SearchTicketsPaged(r.Context(), database.SearchTicketsPagedParams{
+		Limit:     math.MaxInt32,
+		UserID:    user.ID,
+		ServiceID: int32(id),
+	})
+	if err != nil {
+		slog.Error("list service tickets", "err", err, "service_id", id)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"tickets": tickets})
+}
@@ -0,0 +1,296 @@
+package controller
+
+import (
+	"billing3/controller/middlewares"
+	"billing3/database"
+	"billing3/service"
+	"billing3/utils"
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+// loginTwoFactor is the second step of login for users with two-factor authentication. It exchanges the token
+// from login and a TOTP code or a recovery code for a session token.
+func loginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	type reqStruct struct {
+		Token string `json:"token" validate:"required"`
+		Code  string `json:"code" validate:"required"`
+	}
+
+	req, err := decode[reqStruct](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userId := service.DecodeTwoFactorLoginToken(req.Token)
+	if userId == 0 {
+		writeError(w, http.StatusBadRequest, "Invalid token")
+		return
+	}
+
+	user, err := database.Q.FindUserById(r.Context(), userId)
+	if err != nil {
+		slog.Error("login two factor", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// codes are counted with the passwords in the login throttle
+	ip := utils.ClientIP(r)
+	if !attemptThrottle(w, r, service.LoginThrottle, user.Email, ip, &user) {
+		return
+	}
+
+	err = service.VerifyTwoFactor(r.Context(), userId, req.Code)
+	if err != nil {
+		if errors.Is(err, service.ErrTwoFactorInvalidCode) {
+			writeError(w, http.StatusOK, "Invalid code")
+			return
+		}
+		if errors.Is(err, service.ErrTwoFactorNotEnabled) {
+			// disabled after the first step, the password has been checked anyway
+			writeError(w, http.StatusBadRequest, "Invalid token")
+			return
+		}
+		slog.Error("login two factor", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = service.LoginThrottle.Succeed(r.Context(), user.Email, ip)
+	if err != nil {
+		slog.Error("login two factor", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	token, err := service.NewSessionToken(r.Context(), userId, r.UserAgent(), ip)
+	if err != nil {
+		slog.Error("login two factor", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{
+		"token": token,
+	})
+}
+
+func twoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.MustGetUser(r)
+
+	enabled, err := service.TwoFactorEnabled(r.Context(), user.ID)
+	if err != nil {
+		slog.Error("two factor status", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	methods, err := service.TwoFactorMethods(r.Context(), user.ID)
+	if err != nil {
+		slog.Error("two factor status", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	required, err := service.TwoFactorRequired(r.Context(), user)
+	if err != nil {
+		slog.Error("two factor status", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	codes, err := database.Q.CountUserRecoveryCodes(r.Context(), user.ID)
+	if err != nil {
+		slog.Error("two factor status", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{
+		"enabled":        enabled,
+		"methods":        methods,
+		"required":       required,
+		"recovery_codes": codes,
+	})
+}
+
+func twoFactorEnroll(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.MustGetUser(r)
+
+	secret, url, err := service.EnrollTOTP(r.Context(), user)
+	if err != nil {
+		if errors.Is(err, service.ErrTwoFactorEnabled) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		slog.Error("two factor enroll", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{
+		"secret": secret,
+		"url":    url,
+	})
+}
+
+func twoFactorConfirm(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.MustGetUser(r)
+
+	type reqStruct struct {
+		Code string `json:"code" validate:"required"`
+	}
+	req, err := decode[reqStruct](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	codes, err := service.ConfirmTOTP(r.Context(), user.ID, req.Code)
+	if err != nil {
+		if errors.Is(err, service.ErrTwoFactorEnabled) || errors.Is(err, service.ErrTwoFactorNotEnabled) || errors.Is(err, service.ErrTwoFactorInvalidCode) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		slog.Error("two factor confirm", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{
+		"recovery_codes": codes,
+	})
+}
+
+// reauthenticate checks the password of the user, and a TOTP or recovery code if two-factor authentication is
+// enabled, before a change to how the user logs in, so that a stolen session is not enough. The error response is
+// written and false is returned if they are wrong. Attempts are counted in the login throttle like logins.
+func reauthenticate(w http.ResponseWriter, r *http.Request, user *database.User, password string, code string) bool {
+	ip := utils.ClientIP(r)
+	if !attemptThrottle(w, r, service.LoginThrottle, user.Email, ip, user) {
+		return false
+	}
+
+	if !utils.ComparePassword(user.Password, password) {
+		writeError(w, http.StatusBadRequest, "Wrong password")
+		return false
+	}
+
+	enabled, err := service.TwoFactorEnabled(r.Context(), user.ID)
+	if err != nil {
+		slog.Error("reauthenticate", "err", err, "user_id", user.ID)
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	if enabled {
+		err = service.VerifyTwoFactor(r.Context(), user.ID, code)
+		if err != nil {
+			if errors.Is(err, service.ErrTwoFactorNotEnabled) || errors.Is(err, service.ErrTwoFactorInvalidCode) {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return false
+			}
+			slog.Error("reauthenticate", "err", err, "user_id", user.ID)
+			w.WriteHeader(http.StatusInternalServerError)
+			return false
+		}
+	}
+
+	err = service.LoginThrottle.Succeed(r.Context(), user.Email, ip)
+	if err != nil {
+		slog.Error("reauthenticate", "err", err, "user_id", user.ID)
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// twoFactorDisable requires the password and a code, so that a stolen session can not turn it off.
+func twoFactorDisable(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.MustGetUser(r)
+
+	type reqStruct struct {
+		Password string `json:"password" validate:"required"`
+		Code     string `json:"code" validate:"required"`
+	}
+	req, err := decode[reqStruct](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	enabled, err := service.TwoFactorEnabled(r.Context(), user.ID)
+	if err != nil {
+		slog.Error("two factor disable", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !enabled {
+		writeError(w, http.StatusBadRequest, service.ErrTwoFactorNotEnabled.Error())
+		return
+	}
+
+	if !reauthenticate(w, r, user, req.Password, req.Code) {
+		return
+	}
+
+	err = service.DisableTwoFactor(r.Context(), user.ID)
+	if err != nil {
+		slog.Error("two factor disable", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{})
+}
+
+// twoFactorRecoveryCodes replaces the recovery codes, e.g. after some of them have been used.
+func twoFactorRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.MustGetUser(r)
+
+	type reqStruct struct {
+		Code string `json:"code" validate:"required"`
+	}
+	req, err := decode[reqStruct](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// codes are counted with the passwords in the login throttle
+	ip := utils.ClientIP(r)
+	if !attemptThrottle(w, r, service.LoginThrottle, user.Email, ip, user) {
+		return
+	}
+
+	err = service.VerifyTwoFactor(r.Context(), user.ID, req.Code)
+	if err != nil {
+		if errors.Is(err, service.ErrTwoFactorNotEnabled) || errors.Is(err, service.ErrTwoFactorInvalidCode) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		slog.Error("two factor recovery codes", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = service.LoginThrottle.Succeed(r.Context(), user.Email, ip)
+	if err != nil {
+		slog.Error("two factor recovery codes", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	codes, err := service.RegenerateRecoveryCodes(r.Context(), user.ID)
+	if err != nil {
+		slog.Error("two factor recovery codes", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{
+		"recovery_codes": codes,
+	})
+}
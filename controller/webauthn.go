@@ -0,0 +1,304 @@
+package controller
+
+import (
+	"billing3/controller/middlewares"
+	"billing3/database"
+	"billing3/database/types"
+	"billing3/service"
+	"billing3/utils"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// writeWebauthnError writes an error returned by the webauthn functions of service.
+func writeWebauthnError(w http.ResponseWriter, err error, msg string) {
+	if errors.Is(err, service.ErrWebauthnSession) || errors.Is(err, service.ErrWebauthnFailed) {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	slog.Error(msg, "err", err)
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+// webauthnRegisterBegin starts registering a passkey, after checking the password and the two-factor code. Finishing
+// it needs the session returned here, so webauthnRegisterFinish does not ask for them again, which would also need a
+// new TOTP code.
+func webauthnRegisterBegin(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.MustGetUser(r)
+
+	type reqStruct struct {
+		Password string `json:"password" validate:"required"`
+		Code     string `json:"code"` // required if two-factor authentication is enabled
+	}
+	req, err := decode[reqStruct](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !reauthenticate(w, r, user, req.Password, req.Code) {
+		return
+	}
+
+	options, session, err := service.BeginWebauthnRegistration(r.Context(), user.ID)
+	if err != nil {
+		slog.Error("webauthn register begin", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"options": options, "session": session})
+}
+
+func webauthnRegisterFinish(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.MustGetUser(r)
+
+	type reqStruct struct {
+		Session    string          `json:"session" validate:"required"`
+		Name       string          `json:"name" validate:"required,max=100"`
+		Credential json.RawMessage `json:"credential" validate:"required"`
+	}
+	req, err := decode[reqStruct](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	id, err := service.FinishWebauthnRegistration(r.Context(), user.ID, req.Session, req.Name, req.Credential)
+	if err != nil {
+		writeWebauthnError(w, err, "webauthn register finish")
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"id": id})
+}
+
+// webauthnLoginBegin starts a passwordless login.
+func webauthnLoginBegin(w http.ResponseWriter, r *http.Request) {
+	options, session, err := service.BeginWebauthnLogin(r.Context())
+	if err != nil {
+		slog.Error("webauthn login begin", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"options": options, "session": session})
+}
+
+func webauthnLoginFinish(w http.ResponseWriter, r *http.Request) {
+	type reqStruct struct {
+		Session    string          `json:"session" validate:"required"`
+		Credential json.RawMessage `json:"credential" validate:"required"`
+	}
+	req, err := decode[reqStruct](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userId, err := service.FinishWebauthnLogin(r.Context(), req.Session, req.Credential)
+	if err != nil {
+		writeWebauthnError(w, err, "webauthn login finish")
+		return
+	}
+
+	token, err := service.NewSessionToken(r.Context(), userId, r.UserAgent(), utils.ClientIP(r))
+	if err != nil {
+		slog.Error("webauthn login finish", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"token": token})
+}
+
+// webauthnTwoFactorBegin starts verifying a passkey as the second factor, after the password is checked by login.
+func webauthnTwoFactorBegin(w http.ResponseWriter, r *http.Request) {
+	type reqStruct struct {
+		Token string `json:"token" validate:"required"`
+	}
+	req, err := decode[reqStruct](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userId := service.DecodeTwoFactorLoginToken(req.Token)
+	if userId == 0 {
+		writeError(w, http.StatusBadRequest, "Invalid token")
+		return
+	}
+
+	options, session, err := service.BeginWebauthnTwoFactor(r.Context(), userId)
+	if err != nil {
+		if errors.Is(err, service.ErrTwoFactorNotEnabled) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		slog.Error("webauthn two factor begin", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"options": options, "session": session})
+}
+
+func webauthnTwoFactorFinish(w http.ResponseWriter, r *http.Request) {
+	type reqStruct struct {
+		Token      string          `json:"token" validate:"required"`
+		Session    string          `json:"session" validate:"required"`
+		Credential json.RawMessage `json:"credential" validate:"required"`
+	}
+	req, err := decode[reqStruct](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userId := service.DecodeTwoFactorLoginToken(req.Token)
+	if userId == 0 {
+		writeError(w, http.StatusBadRequest, "Invalid token")
+		return
+	}
+
+	user, err := database.Q.FindUserById(r.Context(), userId)
+	if err != nil {
+		slog.Error("webauthn two factor finish", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// counted with the passwords and codes in the login throttle, a failed assertion stays counted
+	ip := utils.ClientIP(r)
+	if !attemptThrottle(w, r, service.LoginThrottle, user.Email, ip, &user) {
+		return
+	}
+
+	err = service.FinishWebauthnTwoFactor(r.Context(), userId, req.Session, req.Credential)
+	if err != nil {
+		writeWebauthnError(w, err, "webauthn two factor finish")
+		return
+	}
+
+	err = service.LoginThrottle.Succeed(r.Context(), user.Email, ip)
+	if err != nil {
+		slog.Error("webauthn two factor finish", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	token, err := service.NewSessionToken(r.Context(), userId, r.UserAgent(), ip)
+	if err != nil {
+		slog.Error("webauthn two factor finish", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{"token": token})
+}
+
+func webauthnCredentialList(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.MustGetUser(r)
+
+	credentials, err := database.Q.ListWebauthnCredentialsByUser(r.Context(), user.ID)
+	if err != nil {
+		slog.Error("list webauthn credentials", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// the public keys are not needed by the frontend
+	type credential struct {
+		ID         int32           `json:"id"`
+		Name       string          `json:"name"`
+		CreatedAt  types.Timestamp `json:"created_at"`
+		LastUsedAt types.Timestamp `json:"last_used_at"`
+	}
+	result := make([]credential, len(credentials))
+	for i, c := range credentials {
+		result[i] = credential{ID: c.ID, Name: c.Name, CreatedAt: c.CreatedAt, LastUsedAt: c.LastUsedAt}
+	}
+
+	writeResp(w, http.StatusOK, D{"credentials": result})
+}
+
+func webauthnCredentialRename(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.MustGetUser(r)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	type reqStruct struct {
+		Name string `json:"name" validate:"required,max=100"`
+	}
+	req, err := decode[reqStruct](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	n, err := database.Q.UpdateWebauthnCredentialName(r.Context(), database.UpdateWebauthnCredentialNameParams{
+		ID:     int32(id),
+		UserID: user.ID,
+		Name:   req.Name,
+	})
+	if err != nil {
+		slog.Error("rename webauthn credential", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if n == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	writeResp(w, http.StatusOK, D{})
+}
+
+func webauthnCredentialDelete(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.MustGetUser(r)
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	type reqStruct struct {
+		Password string `json:"password" validate:"required"`
+		Code     string `json:"code"` // required if two-factor authentication is enabled
+	}
+	req, err := decode[reqStruct](r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !reauthenticate(w, r, user, req.Password, req.Code) {
+		return
+	}
+
+	n, err := database.Q.DeleteWebauthnCredential(r.Context(), database.DeleteWebauthnCredentialParams{
+		ID:     int32(id),
+		UserID: user.ID,
+	})
+	if err != nil {
+		slog.Error("delete webauthn credential", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if n == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	slog.Info("passkey deleted", "user_id", user.ID, "id", id)
+
+	writeResp(w, http.StatusOK, D{})
+}
@@ -3,9 +3,11 @@ package database
 import (
 	"context"
 	"embed"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -21,12 +23,20 @@ var sqls embed.FS
 var Q *Queries
 var Conn *pgxpool.Pool
 
-func Init() {
-	ctx := context.Background()
+// PGErrorUniqueViolation is the error code of a unique constraint violation.
+const PGErrorUniqueViolation = "23505"
 
+func Init() {
 	time.Sleep(time.Second * 5) // wait for postgres to be ready in docker-compose
 
-	config, err := pgxpool.ParseConfig(os.Getenv("DATABASE"))
+	Connect(os.Getenv("DATABASE"))
+}
+
+// Connect connects to the database, creates the tables and the first admin user. It panics on errors.
+func Connect(dsn string) {
+	ctx := context.Background()
+
+	config, err := pgxpool.ParseConfig(dsn)
 	if err != nil {
 		panic(fmt.Sprintf("pgx parse config: %s", err.Error()))
 	}
@@ -45,16 +55,26 @@ func Init() {
 
 	// create tables
 
-	bytes, err := sqls.ReadFile("schema/000-schema.sql")
+	// schema files are applied in lexical order, and every statement
+	// must be safe to run more than once
+	entries, err := sqls.ReadDir("schema")
 	if err != nil {
 		slog.Error("create tables", "err", err)
 		panic(err)
 	}
 
-	_, err = Conn.Exec(context.Background(), string(bytes))
-	if err != nil {
-		slog.Error("create tables", "err", err)
-		panic(err)
+	for _, entry := range entries {
+		bytes, err := sqls.ReadFile("schema/" + entry.Name())
+		if err != nil {
+			slog.Error("create tables", "err", err, "file", entry.Name())
+			panic(err)
+		}
+
+		_, err = Conn.Exec(context.Background(), string(bytes))
+		if err != nil {
+			slog.Error("create tables", "err", err, "file", entry.Name())
+			panic(err)
+		}
 	}
 
 	// create admin user
@@ -72,11 +92,22 @@ func Init() {
 			panic(err)
 		}
 
+		// the base_currency setting, USD is its default
+		currency := "USD"
+		setting, err := Q.FindSettingByKey(context.Background(), "base_currency")
+		if err == nil && strings.TrimSpace(setting.Value) != "" {
+			currency = strings.ToUpper(strings.TrimSpace(setting.Value))
+		} else if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			slog.Error("find base currency", "err", err)
+			panic(err)
+		}
+
 		_, err = Q.CreateUser(context.Background(), CreateUserParams{
 			Email:    "admin@example.com",
 			Name:     "admin",
 			Role:     "admin",
 			Password: string(hashed),
+			Currency: currency,
 		})
 		if err != nil {
 			slog.Error("create admin user", "err", err)
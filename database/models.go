@@ -10,12 +10,111 @@ import (
 	"github.com/shopspring/decimal"
 )
 
+type ApiKey struct {
+	ID         int32           `json:"id"`
+	UserID     int32           `json:"user_id"`
+	Name       string          `json:"name"`
+	Prefix     string          `json:"prefix"`
+	KeyHash    string          `json:"key_hash"`
+	Scopes     []string        `json:"scopes"`
+	AllowedIps []string        `json:"allowed_ips"`
+	ExpiresAt  types.Timestamp `json:"expires_at"`
+	LastUsedAt types.Timestamp `json:"last_used_at"`
+	LastUsedIp pgtype.Text     `json:"last_used_ip"`
+	CreatedAt  types.Timestamp `json:"created_at"`
+}
+
+type AuditLog struct {
+	ID         int32           `json:"id"`
+	ActorID    pgtype.Int4     `json:"actor_id"`
+	Ip         string          `json:"ip"`
+	EntityType string          `json:"entity_type"`
+	EntityID   string          `json:"entity_id"`
+	Action     string          `json:"action"`
+	Before     []byte          `json:"before"`
+	After      []byte          `json:"after"`
+	CreatedAt  types.Timestamp `json:"created_at"`
+}
+
+type CancellationRequest struct {
+	ID          int32           `json:"id"`
+	ServiceID   int32           `json:"service_id"`
+	UserID      int32           `json:"user_id"`
+	Type        string          `json:"type"`
+	Reason      string          `json:"reason"`
+	Status      string          `json:"status"`
+	CreatedAt   types.Timestamp `json:"created_at"`
+	ProcessedAt types.Timestamp `json:"processed_at"`
+}
+
 type Category struct {
 	ID          int32  `json:"id"`
 	Name        string `json:"name"`
 	Description string `json:"description"`
 }
 
+type Coupon struct {
+	ID             int32           `json:"id"`
+	Code           string          `json:"code"`
+	Type           string          `json:"type"`
+	Value          decimal.Decimal `json:"value"`
+	Recurring      bool            `json:"recurring"`
+	ProductIds     []int32         `json:"product_ids"`
+	Durations      []int32         `json:"durations"`
+	MaxUses        int32           `json:"max_uses"`
+	MaxUsesPerUser int32           `json:"max_uses_per_user"`
+	ExpiresAt      types.Timestamp `json:"expires_at"`
+	Enabled        bool            `json:"enabled"`
+	CreatedAt      types.Timestamp `json:"created_at"`
+}
+
+type CouponUse struct {
+	ID        int32           `json:"id"`
+	CouponID  int32           `json:"coupon_id"`
+	UserID    int32           `json:"user_id"`
+	ServiceID pgtype.Int4     `json:"service_id"`
+	CreatedAt types.Timestamp `json:"created_at"`
+}
+
+type CreditTransaction struct {
+	ID          int32           `json:"id"`
+	UserID      int32           `json:"user_id"`
+	Amount      decimal.Decimal `json:"amount"`
+	Description string          `json:"description"`
+	Type        string          `json:"type"`
+	InvoiceID   pgtype.Int4     `json:"invoice_id"`
+	CreatedAt   types.Timestamp `json:"created_at"`
+	Currency    string          `json:"currency"`
+}
+
+type EmailLog struct {
+	ID        int32           `json:"id"`
+	Recipient string          `json:"recipient"`
+	Subject   string          `json:"subject"`
+	Html      string          `json:"html"`
+	Text      string          `json:"text"`
+	Status    string          `json:"status"`
+	Attempts  int32           `json:"attempts"`
+	LastError string          `json:"last_error"`
+	CreatedAt types.Timestamp `json:"created_at"`
+	SentAt    types.Timestamp `json:"sent_at"`
+	Sensitive bool            `json:"sensitive"`
+}
+
+type EmailTemplate struct {
+	Event     string          `json:"event"`
+	Subject   string          `json:"subject"`
+	Html      string          `json:"html"`
+	Text      string          `json:"text"`
+	UpdatedAt types.Timestamp `json:"updated_at"`
+}
+
+type ExchangeRate struct {
+	Currency  string          `json:"currency"`
+	Rate      decimal.Decimal `json:"rate"`
+	UpdatedAt types.Timestamp `json:"updated_at"`
+}
+
 type Gateway struct {
 	ID          int32                 `json:"id"`
 	DisplayName string                `json:"display_name"`
@@ -34,6 +133,13 @@ type Invoice struct {
 	DueAt              types.Timestamp `json:"due_at"`
 	Amount             decimal.Decimal `json:"amount"`
 	CreatedAt          types.Timestamp `json:"created_at"`
+	Net                decimal.Decimal `json:"net"`
+	Tax                decimal.Decimal `json:"tax"`
+	TaxName            string          `json:"tax_name"`
+	TaxRate            decimal.Decimal `json:"tax_rate"`
+	ReverseCharge      bool            `json:"reverse_charge"`
+	Currency           string          `json:"currency"`
+	Number             pgtype.Text     `json:"number"`
 }
 
 type InvoiceItem struct {
@@ -46,6 +152,11 @@ type InvoiceItem struct {
 	CreatedAt   types.Timestamp `json:"created_at"`
 }
 
+type InvoiceNumberSequence struct {
+	Period     int32 `json:"period"`
+	LastNumber int32 `json:"last_number"`
+}
+
 type InvoicePayment struct {
 	ID          int32           `json:"id"`
 	InvoiceID   int32           `json:"invoice_id"`
@@ -54,6 +165,15 @@ type InvoicePayment struct {
 	Amount      decimal.Decimal `json:"amount"`
 	ReferenceID string          `json:"reference_id"`
 	Gateway     string          `json:"gateway"`
+	RefundOf    pgtype.Int4     `json:"refund_of"`
+}
+
+type LoginThrottle struct {
+	Key         string          `json:"key"`
+	Failures    int32           `json:"failures"`
+	WindowStart types.Timestamp `json:"window_start"`
+	Lockouts    int32           `json:"lockouts"`
+	LockedUntil types.Timestamp `json:"locked_until"`
 }
 
 type Product struct {
@@ -67,6 +187,7 @@ type Product struct {
 	Settings     types.ProductSettings `json:"settings"`
 	Stock        int32                 `json:"stock"`
 	StockControl int32                 `json:"stock_control"`
+	Dunning      types.DunningPolicy   `json:"dunning"`
 }
 
 type ProductOption struct {
@@ -99,14 +220,45 @@ type Service struct {
 	ExpiresAt          types.Timestamp       `json:"expires_at"`
 	CreatedAt          types.Timestamp       `json:"created_at"`
 	CancelledAt        types.Timestamp       `json:"cancelled_at"`
+	ProductID          pgtype.Int4           `json:"product_id"`
+	CouponID           pgtype.Int4           `json:"coupon_id"`
+	Currency           string                `json:"currency"`
+	StatusReason       string                `json:"status_reason"`
 }
 
-type Session struct {
+type ServiceDunningStep struct {
 	ID        int32           `json:"id"`
-	Token     string          `json:"token"`
-	UserID    int32           `json:"user_id"`
+	ServiceID int32           `json:"service_id"`
+	DueAt     types.Timestamp `json:"due_at"`
+	Step      string          `json:"step"`
+	Days      int32           `json:"days"`
+	InvoiceID pgtype.Int4     `json:"invoice_id"`
 	CreatedAt types.Timestamp `json:"created_at"`
-	ExpiresAt types.Timestamp `json:"expires_at"`
+}
+
+type ServiceUpgrade struct {
+	ID        int32                 `json:"id"`
+	ServiceID int32                 `json:"service_id"`
+	ProductID int32                 `json:"product_id"`
+	Settings  types.ServiceSettings `json:"settings"`
+	Price     decimal.Decimal       `json:"price"`
+	Amount    decimal.Decimal       `json:"amount"`
+	Status    string                `json:"status"`
+	InvoiceID pgtype.Int4           `json:"invoice_id"`
+	CreatedAt types.Timestamp       `json:"created_at"`
+	ExpiresAt types.Timestamp       `json:"expires_at"`
+}
+
+type Session struct {
+	ID             int32           `json:"id"`
+	Token          string          `json:"token"`
+	UserID         int32           `json:"user_id"`
+	CreatedAt      types.Timestamp `json:"created_at"`
+	ExpiresAt      types.Timestamp `json:"expires_at"`
+	UserAgent      string          `json:"user_agent"`
+	Ip             string          `json:"ip"`
+	LastSeenAt     types.Timestamp `json:"last_seen_at"`
+	ImpersonatorID pgtype.Int4     `json:"impersonator_id"`
 }
 
 type Setting struct {
@@ -115,15 +267,106 @@ type Setting struct {
 	Value string `json:"value"`
 }
 
+type StaffRole struct {
+	ID          int32    `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type TaxRule struct {
+	ID            int32           `json:"id"`
+	Name          string          `json:"name"`
+	Country       string          `json:"country"`
+	State         string          `json:"state"`
+	Rate          decimal.Decimal `json:"rate"`
+	ReverseCharge bool            `json:"reverse_charge"`
+	CreatedAt     types.Timestamp `json:"created_at"`
+}
+
+type Ticket struct {
+	ID           int32           `json:"id"`
+	UserID       int32           `json:"user_id"`
+	DepartmentID int32           `json:"department_id"`
+	Subject      string          `json:"subject"`
+	Status       string          `json:"status"`
+	Priority     string          `json:"priority"`
+	ServiceID    pgtype.Int4     `json:"service_id"`
+	InvoiceID    pgtype.Int4     `json:"invoice_id"`
+	AssignedTo   pgtype.Int4     `json:"assigned_to"`
+	CreatedAt    types.Timestamp `json:"created_at"`
+	UpdatedAt    types.Timestamp `json:"updated_at"`
+}
+
+type TicketAttachment struct {
+	ID          int32  `json:"id"`
+	MessageID   int32  `json:"message_id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int32  `json:"size"`
+	Data        []byte `json:"data"`
+}
+
+type TicketDepartment struct {
+	ID          int32  `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type TicketMessage struct {
+	ID        int32           `json:"id"`
+	TicketID  int32           `json:"ticket_id"`
+	UserID    int32           `json:"user_id"`
+	Staff     bool            `json:"staff"`
+	Body      string          `json:"body"`
+	CreatedAt types.Timestamp `json:"created_at"`
+}
+
 type User struct {
-	ID       int32       `json:"id"`
-	Email    string      `json:"email"`
-	Name     string      `json:"name"`
-	Role     string      `json:"role"`
-	Password string      `json:"-"`
-	Address  pgtype.Text `json:"address"`
-	City     pgtype.Text `json:"city"`
-	State    pgtype.Text `json:"state"`
-	Country  pgtype.Text `json:"country"`
-	ZipCode  pgtype.Text `json:"zip_code"`
+	ID          int32       `json:"id"`
+	Email       string      `json:"email"`
+	Name        string      `json:"name"`
+	Role        string      `json:"role"`
+	Password    string      `json:"-"`
+	Address     pgtype.Text `json:"address"`
+	City        pgtype.Text `json:"city"`
+	State       pgtype.Text `json:"state"`
+	Country     pgtype.Text `json:"country"`
+	ZipCode     pgtype.Text `json:"zip_code"`
+	VatNumber   string      `json:"vat_number"`
+	Currency    string      `json:"currency"`
+	StaffRoleID pgtype.Int4 `json:"staff_role_id"`
+}
+
+type UserRecoveryCode struct {
+	ID       int32           `json:"id"`
+	UserID   int32           `json:"user_id"`
+	CodeHash string          `json:"code_hash"`
+	UsedAt   types.Timestamp `json:"used_at"`
+}
+
+type UserTotp struct {
+	UserID    int32           `json:"user_id"`
+	Secret    string          `json:"secret"`
+	Enabled   bool            `json:"enabled"`
+	LastStep  int64           `json:"last_step"`
+	CreatedAt types.Timestamp `json:"created_at"`
+}
+
+type WebauthnCredential struct {
+	ID           int32           `json:"id"`
+	UserID       int32           `json:"user_id"`
+	Name         string          `json:"name"`
+	CredentialID []byte          `json:"credential_id"`
+	Credential   []byte          `json:"credential"`
+	CreatedAt    types.Timestamp `json:"created_at"`
+	LastUsedAt   types.Timestamp `json:"last_used_at"`
+}
+
+type WebauthnSession struct {
+	Token     string          `json:"token"`
+	Type      string          `json:"type"`
+	UserID    pgtype.Int4     `json:"user_id"`
+	Data      []byte          `json:"data"`
+	ExpiresAt types.Timestamp `json:"expires_at"`
 }
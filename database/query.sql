@@ -16,16 +16,16 @@ SELECT * FROM users WHERE position(@search::text in email)>0 OR position(@search
 SELECT count(*) FROM users WHERE position(@search::text in email)>0 OR position(@search::text in name)>0;
 
 -- name: CreateUser :one
-INSERT INTO users (email, name, role, password, address, city, state, country, zip_code) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id;
+INSERT INTO users (email, name, role, password, address, city, state, country, zip_code, currency) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id;
 
 -- name: DeleteUser :exec
 DELETE FROM users WHERE id = $1;
 
 -- name: UpdateUserProfile :exec
-UPDATE users SET name = $1, address = $2, city = $3, state = $4, country = $5, zip_code = $6 WHERE id = $7;
+UPDATE users SET name = $1, address = $2, city = $3, state = $4, country = $5, zip_code = $6, vat_number = $7, currency = $8 WHERE id = $9;
 
 -- name: UpdateUser :exec
-UPDATE users SET email = $2, name = $3, role = $4, address = $5, city = $6, state = $7, country = $8, zip_code = $9 WHERE id = $1;
+UPDATE users SET email = $2, name = $3, role = $4, address = $5, city = $6, state = $7, country = $8, zip_code = $9, vat_number = $10, currency = $11 WHERE id = $1;
 
 -- name: UpdateUserPassword :exec
 UPDATE users SET password = $2 WHERE id = $1;
@@ -33,24 +33,195 @@ UPDATE users SET password = $2 WHERE id = $1;
 -- name: CountUsers :one
 SELECT COUNT(id) FROM users;
 
+-- name: FindUserByIdForUpdate :one
+SELECT * FROM users WHERE id = $1 FOR UPDATE;
+
 -- SESSIONS --
 
 -- name: FindSessionByToken :one
 SELECT * FROM sessions WHERE token = $1 AND expires_at > CURRENT_TIMESTAMP;
 
 -- name: CreateSession :exec
-INSERT INTO sessions (token, user_id, expires_at) VALUES ($1, $2, $3);
+INSERT INTO sessions (token, user_id, expires_at, user_agent, ip, impersonator_id) VALUES ($1, $2, $3, $4, $5, $6);
 
 -- name: DeleteSession :exec
 DELETE FROM sessions WHERE token = $1;
 
+-- name: ListSessionsByUser :many
+SELECT * FROM sessions WHERE user_id = $1 AND expires_at > CURRENT_TIMESTAMP ORDER BY last_seen_at DESC;
+
+-- name: UpdateSessionLastSeen :exec
+UPDATE sessions SET last_seen_at = CURRENT_TIMESTAMP, ip = $2 WHERE id = $1 AND last_seen_at < CURRENT_TIMESTAMP - INTERVAL '1 minute';
+
+-- name: DeleteSessionByUser :execrows
+DELETE FROM sessions WHERE id = $1 AND user_id = $2;
+
+-- name: DeleteSessionsByUser :exec
+DELETE FROM sessions WHERE user_id = $1;
+
 -- name: UpdateSessionExpiryTime :exec
 UPDATE sessions SET expires_at = $2 WHERE token = $1;
 
+-- name: DeleteExpiredImpersonations :many
+DELETE FROM sessions WHERE expires_at < CURRENT_TIMESTAMP AND impersonator_id IS NOT NULL RETURNING *;
+
 -- name: DeleteExpiredSessions :exec
 DELETE FROM sessions WHERE expires_at < CURRENT_TIMESTAMP;
 
 
+-- TWO FACTOR --
+
+-- name: FindUserTotp :one
+SELECT * FROM user_totp WHERE user_id = $1;
+
+-- name: FindUserTotpForUpdate :one
+SELECT * FROM user_totp WHERE user_id = $1 FOR UPDATE;
+
+-- name: UpsertUserTotp :exec
+INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE SET secret = $2, enabled = FALSE, last_step = 0, created_at = CURRENT_TIMESTAMP;
+
+-- name: EnableUserTotp :exec
+UPDATE user_totp SET enabled = TRUE, last_step = $2 WHERE user_id = $1;
+
+-- name: UpdateUserTotpLastStep :exec
+UPDATE user_totp SET last_step = $2 WHERE user_id = $1;
+
+-- name: DeleteUserTotp :exec
+DELETE FROM user_totp WHERE user_id = $1;
+
+-- name: CreateUserRecoveryCode :exec
+INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2);
+
+-- name: DeleteUserRecoveryCodes :exec
+DELETE FROM user_recovery_codes WHERE user_id = $1;
+
+-- name: UseUserRecoveryCode :execrows
+UPDATE user_recovery_codes SET used_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;
+
+-- name: CountUserRecoveryCodes :one
+SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = $1 AND used_at IS NULL;
+
+
+-- WEBAUTHN --
+
+-- name: ListWebauthnCredentialsByUser :many
+SELECT * FROM webauthn_credentials WHERE user_id = $1 ORDER BY id;
+
+-- name: FindWebauthnCredentialByCredentialId :one
+SELECT * FROM webauthn_credentials WHERE credential_id = $1;
+
+-- name: CreateWebauthnCredential :one
+INSERT INTO webauthn_credentials (user_id, name, credential_id, credential) VALUES ($1, $2, $3, $4) RETURNING id;
+
+-- name: UpdateWebauthnCredentialName :execrows
+UPDATE webauthn_credentials SET name = $3 WHERE id = $1 AND user_id = $2;
+
+-- name: UpdateWebauthnCredentialUsed :exec
+UPDATE webauthn_credentials SET credential = $2, last_used_at = CURRENT_TIMESTAMP WHERE id = $1;
+
+-- name: DeleteWebauthnCredential :execrows
+DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2;
+
+-- name: CountWebauthnCredentials :one
+SELECT COUNT(*) FROM webauthn_credentials WHERE user_id = $1;
+
+-- name: CreateWebauthnSession :exec
+INSERT INTO webauthn_sessions (token, type, user_id, data, expires_at) VALUES ($1, $2, $3, $4, $5);
+
+-- name: DeleteWebauthnSession :one
+DELETE FROM webauthn_sessions WHERE token = $1 AND type = $2 AND expires_at > CURRENT_TIMESTAMP RETURNING *;
+
+-- name: DeleteExpiredWebauthnSessions :exec
+DELETE FROM webauthn_sessions WHERE expires_at < CURRENT_TIMESTAMP;
+
+
+-- API KEYS --
+
+-- name: ListApiKeysByUser :many
+SELECT * FROM api_keys WHERE user_id = $1 ORDER BY id;
+
+-- name: FindApiKeyByHash :one
+SELECT * FROM api_keys WHERE key_hash = $1 AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP);
+
+-- name: CreateApiKey :one
+INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, allowed_ips, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id;
+
+-- name: UpdateApiKeyUsed :exec
+UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP, last_used_ip = $2 WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - INTERVAL '1 minute');
+
+-- name: DeleteApiKey :execrows
+DELETE FROM api_keys WHERE id = $1 AND user_id = $2;
+
+-- name: CountApiKeys :one
+SELECT COUNT(*) FROM api_keys WHERE user_id = $1;
+
+
+-- STAFF ROLES --
+
+-- name: ListStaffRoles :many
+SELECT * FROM staff_roles ORDER BY id;
+
+-- name: FindStaffRoleById :one
+SELECT * FROM staff_roles WHERE id = $1;
+
+-- name: CreateStaffRole :one
+INSERT INTO staff_roles (name, description, permissions) VALUES ($1, $2, $3) RETURNING id;
+
+-- name: UpdateStaffRole :execrows
+UPDATE staff_roles SET name = $2, description = $3, permissions = $4 WHERE id = $1;
+
+-- name: DeleteStaffRole :execrows
+DELETE FROM staff_roles WHERE id = $1;
+
+-- name: UpdateUserStaffRole :execrows
+UPDATE users SET staff_role_id = $2 WHERE id = $1;
+
+
+-- AUDIT LOGS --
+
+-- name: CreateAuditLog :exec
+INSERT INTO audit_logs (actor_id, ip, entity_type, entity_id, action, before, after) VALUES ($1, $2, $3, $4, $5, $6, $7);
+
+-- name: SearchAuditLogsPaged :many
+SELECT audit_logs.*, COALESCE(users.name, '')::text AS actor_name FROM audit_logs
+LEFT JOIN users ON users.id = audit_logs.actor_id
+WHERE (@actor_id::integer = 0 OR @actor_id::integer = actor_id) AND (@entity_type::text = '' OR @entity_type::text = entity_type)
+AND (@entity_id::text = '' OR @entity_id::text = entity_id) AND (@action::text = '' OR @action::text = action)
+ORDER BY audit_logs.id DESC LIMIT $1 OFFSET $2;
+
+-- name: SearchAuditLogsCount :one
+SELECT COUNT(*) FROM audit_logs
+WHERE (@actor_id::integer = 0 OR @actor_id::integer = actor_id) AND (@entity_type::text = '' OR @entity_type::text = entity_type)
+AND (@entity_id::text = '' OR @entity_id::text = entity_id) AND (@action::text = '' OR @action::text = action);
+
+
+-- LOGIN THROTTLES --
+
+-- name: ListLoginLockouts :many
+SELECT * FROM login_throttles WHERE key = ANY(@keys::text[]) AND locked_until > CURRENT_TIMESTAMP ORDER BY locked_until DESC;
+
+-- name: RecordLoginFailure :one
+INSERT INTO login_throttles (key, failures) VALUES ($1, 1)
+ON CONFLICT (key) DO UPDATE SET
+    failures = CASE WHEN login_throttles.window_start < CURRENT_TIMESTAMP - INTERVAL '15 minutes' THEN 1 ELSE login_throttles.failures + 1 END,
+    window_start = CASE WHEN login_throttles.window_start < CURRENT_TIMESTAMP - INTERVAL '15 minutes' THEN CURRENT_TIMESTAMP ELSE login_throttles.window_start END,
+    lockouts = CASE WHEN login_throttles.locked_until < CURRENT_TIMESTAMP - INTERVAL '1 day' THEN 0 ELSE login_throttles.lockouts END
+RETURNING *;
+
+-- name: LockLoginThrottle :exec
+UPDATE login_throttles SET failures = 0, lockouts = $2, locked_until = $3 WHERE key = $1;
+
+-- name: ReleaseLoginAttempt :exec
+UPDATE login_throttles SET failures = failures - 1 WHERE key = $1 AND failures > 0;
+
+-- name: DeleteLoginThrottle :exec
+DELETE FROM login_throttles WHERE key = $1;
+
+-- name: DeleteExpiredLoginThrottles :exec
+DELETE FROM login_throttles WHERE window_start < CURRENT_TIMESTAMP - INTERVAL '1 day' AND (locked_until IS NULL OR locked_until < CURRENT_TIMESTAMP - INTERVAL '1 day');
+
+
 -- CATEGORIES --
 
 -- name: FindCategoryById :one
@@ -77,7 +248,7 @@ SELECT * FROM products WHERE category_id = $1 AND enabled = TRUE;
 SELECT * FROM products ORDER BY id;
 
 -- name: SearchProduct :many
-SELECT products.id as id, products.name, products.description, products.category_id, products.extension, products.enabled, products.pricing, products.settings, products.stock, products.stock_control, categories.name AS category_name FROM products INNER JOIN categories ON categories.id = products.category_id  WHERE (category_id = $1 OR $1 < 1) ORDER BY products.id;
+SELECT products.id as id, products.name, products.description, products.category_id, products.extension, products.enabled, products.pricing, products.settings, products.stock, products.stock_control, products.dunning, categories.name AS category_name FROM products INNER JOIN categories ON categories.id = products.category_id  WHERE (category_id = $1 OR $1 < 1) ORDER BY products.id;
 
 -- name: ListEnabledProducts :many
 SELECT * FROM products WHERE enabled ORDER BY id;
@@ -92,10 +263,10 @@ SELECT * FROM products WHERE id = $1;
 SELECT * FROM products WHERE category_id = $1 ORDER BY id;
 
 -- name: UpdateProduct :exec
-UPDATE products SET name = $1, description = $2, category_id = $3, extension = $4, enabled = $5, pricing = $6, settings = $7, stock = $8, stock_control = $9 WHERE id = $10;
+UPDATE products SET name = $1, description = $2, category_id = $3, extension = $4, enabled = $5, pricing = $6, settings = $7, stock = $8, stock_control = $9, dunning = $10 WHERE id = $11;
 
 -- name: CreateProduct :one
-INSERT INTO products (name, description, category_id, extension, enabled, pricing, settings, stock, stock_control) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id;
+INSERT INTO products (name, description, category_id, extension, enabled, pricing, settings, stock, stock_control, dunning) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id;
 
 -- name: DeleteProduct :exec
 DELETE FROM products WHERE id = $1;
@@ -114,6 +285,9 @@ INSERT INTO product_options (product_id, name, display_name, type, regex, values
 -- name: CountUnpaidInvoiceForService :one
 SELECT COUNT(*) FROM invoices INNER JOIN invoice_items ON invoices.id = invoice_items.invoice_id WHERE invoice_items.item_id = $1 AND invoice_items.type = 'service' AND invoices.status = 'UNPAID';
 
+-- name: ListUnpaidInvoiceIdsForService :many
+SELECT DISTINCT invoices.id FROM invoices INNER JOIN invoice_items ON invoices.id = invoice_items.invoice_id WHERE invoice_items.item_id = $1 AND invoice_items.type = 'service' AND invoices.status = 'UNPAID' ORDER BY invoices.id;
+
 -- name: SearchInvoicesPaged :many
 SELECT * FROM invoices WHERE (@status::text = '' OR @status::text = status) AND (@user_id::integer = 0 OR @user_id::integer = user_id) ORDER BY id DESC LIMIT $1 OFFSET $2;
 
@@ -132,14 +306,20 @@ SELECT * FROM invoices WHERE id = $1 FOR UPDATE;
 -- name: UpdateInvoice :exec
 UPDATE invoices SET status = $1, cancellation_reason = $2, paid_at = $3, due_at = $4 WHERE id = $5;
 
--- name: UpdateInvoiceAmount :exec
-UPDATE invoices SET amount = (SELECT SUM(amount) FROM invoice_items WHERE invoice_id = $1) WHERE id = $1;
+-- name: UpdateInvoiceTotals :exec
+UPDATE invoices SET amount = $2, net = $3, tax = $4, tax_name = $5, tax_rate = $6, reverse_charge = $7 WHERE id = $1;
+
+-- name: UpdateInvoiceNumber :exec
+UPDATE invoices SET number = $2 WHERE id = $1;
+
+-- name: NextInvoiceNumber :one
+INSERT INTO invoice_number_sequences (period, last_number) VALUES ($1, 1) ON CONFLICT (period) DO UPDATE SET last_number = invoice_number_sequences.last_number + 1 RETURNING last_number;
 
 -- name: UpdateInvoicePaid :exec
 UPDATE invoices SET status = 'PAID', paid_at = CURRENT_TIMESTAMP WHERE id = $1;
 
 -- name: CreateInvoice :one
-INSERT INTO invoices (user_id, status, cancellation_reason, paid_at, due_at, amount) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;
+INSERT INTO invoices (user_id, status, cancellation_reason, paid_at, due_at, amount, currency) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id;
 
 -- name: ListInvoiceItems :many
 SELECT * FROM invoice_items WHERE invoice_id = $1 ORDER BY id;
@@ -150,6 +330,9 @@ INSERT INTO invoice_items (invoice_id, description, amount, type, item_id) VALUE
 -- name: DeleteInvoiceItem :exec
 DELETE FROM invoice_items WHERE id = $1 AND invoice_id = $2;
 
+-- name: DeleteInvoiceItemsByType :exec
+DELETE FROM invoice_items WHERE invoice_id = $1 AND type = $2;
+
 -- name: DeleteAllInvoiceItems :exec
 DELETE FROM invoice_items WHERE invoice_id = $1;
 
@@ -162,15 +345,33 @@ SELECT invoices.* FROM invoices INNER JOIN invoice_items ON invoices.id = invoic
 -- name: AddInvoicePayment :one
 INSERT INTO invoice_payments (invoice_id, description, amount, reference_id, gateway) VALUES ($1, $2, $3, $4, $5) RETURNING id;
 
+-- name: AddInvoiceRefund :one
+INSERT INTO invoice_payments (invoice_id, description, amount, reference_id, gateway, refund_of) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;
+
+-- name: FindInvoicePaymentById :one
+SELECT * FROM invoice_payments WHERE id = $1;
+
+-- name: SelectInvoicePaymentForUpdate :one
+SELECT * FROM invoice_payments WHERE id = $1 FOR UPDATE;
+
+-- name: FindInvoicePaymentByReference :one
+SELECT * FROM invoice_payments WHERE gateway = $1 AND reference_id = $2 LIMIT 1;
+
+-- name: TotalRefundedForPayment :one
+SELECT COALESCE(-SUM(amount::decimal), 0)::decimal FROM invoice_payments WHERE refund_of = $1;
+
 -- name: ListInvoicePayments :many
 SELECT * FROM invoice_payments WHERE invoice_id = $1 ORDER BY id ASC;
 
 -- name: TotalInvoicePayment :one
-SELECT SUM(amount::decimal)::decimal FROM invoice_payments WHERE invoice_id = $1;
+SELECT COALESCE(SUM(amount::decimal), 0)::decimal FROM invoice_payments WHERE invoice_id = $1;
 
 -- name: FindOverdueInvoices :many
 SELECT * FROM invoices WHERE status = 'UNPAID' AND due_at <= CURRENT_TIMESTAMP ORDER BY id;
 
+-- name: UpdateInvoiceRefunded :exec
+UPDATE invoices SET status = 'REFUNDED' WHERE id = $1;
+
 -- name: UpdateInvoiceCancelled :exec
 UPDATE invoices SET status = 'CANCELLED', cancellation_reason = $1 WHERE id = $2;
 
@@ -186,7 +387,7 @@ SELECT * FROM services WHERE id = $1 FOR UPDATE;
 SELECT COUNT(id) FROM services WHERE (@label::text = '' OR @label::text = label) AND (@server::integer = 0 OR (settings::jsonb ? 'server' AND (settings->>'server')::integer = @server::integer)) AND (@user_id::integer = 0 OR @user_id::integer = user_id) AND (@status::text = '' OR @status::text = status);
 
 -- name: SearchServicesPaged :many
-SELECT services.label, users.name, services.id, services.status, services.user_id, services.price, services.created_at, services.billing_cycle, services.expires_at FROM services INNER JOIN users ON services.user_id = users.id WHERE (@label::text = '' OR @label::text = label) AND (@server::integer = 0 OR (settings ? 'server' AND (settings->>'server')::integer = @server::integer)) AND (@user_id::integer = 0 OR @user_id::integer = user_id) AND (@status::text = '' OR @status::text = status) ORDER BY services.id DESC LIMIT $1 OFFSET $2;
+SELECT services.label, users.name, services.id, services.status, services.user_id, services.price, services.created_at, services.billing_cycle, services.expires_at, services.currency FROM services INNER JOIN users ON services.user_id = users.id WHERE (@label::text = '' OR @label::text = label) AND (@server::integer = 0 OR (settings ? 'server' AND (settings->>'server')::integer = @server::integer)) AND (@user_id::integer = 0 OR @user_id::integer = user_id) AND (@status::text = '' OR @status::text = status) ORDER BY services.id DESC LIMIT $1 OFFSET $2;
 
 -- name: FindServiceByIdWithName :one
 SELECT services.*, users.name FROM services INNER JOIN users ON services.user_id = users.id WHERE services.id = $1;
@@ -195,7 +396,7 @@ SELECT services.*, users.name FROM services INNER JOIN users ON services.user_id
 SELECT * FROM services WHERE id = $1;
 
 -- name: CreateService :one
-INSERT INTO services (label, user_id, status, billing_cycle, price, extension, settings, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id;
+INSERT INTO services (label, user_id, status, billing_cycle, price, extension, settings, expires_at, product_id, coupon_id, currency) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id;
 
 -- name: UpdateServiceLabel :exec
 UPDATE services SET label = $1 WHERE id = $2;
@@ -204,7 +405,10 @@ UPDATE services SET label = $1 WHERE id = $2;
 UPDATE services SET settings = $1 WHERE id = $2;
 
 -- name: UpdateServiceStatus :exec
-UPDATE services SET status = $1 WHERE id = $2;
+UPDATE services SET status = $1, status_reason = '' WHERE id = $2;
+
+-- name: UpdateServiceStatusWithReason :exec
+UPDATE services SET status = $1, status_reason = $2 WHERE id = $3;
 
 -- name: UpdateService :exec
 UPDATE services SET label = $1, billing_cycle = $2, price = $3, expires_at = $4 WHERE id = $5;
@@ -221,8 +425,12 @@ SELECT COUNT(id) FROM services WHERE (status = 'PENDING' OR status = 'ACTIVE' OR
 -- name: UpdateServiceCancelled :exec
 UPDATE services SET cancellation_reason = $1, cancelled_at = $2 WHERE id = $3;
 
--- name: FindOverdueServices :many
-SELECT * FROM services WHERE (status = 'SUSPENDED' OR status = 'ACTIVE' OR status = 'PENDING') AND expires_at <= CURRENT_TIMESTAMP ORDER BY id;
+-- name: FindServicesForDunning :many
+SELECT * FROM services WHERE (status = 'SUSPENDED' OR status = 'ACTIVE' OR status = 'PENDING') AND expires_at <= $1
+AND NOT EXISTS (SELECT 1 FROM cancellation_requests WHERE cancellation_requests.service_id = services.id AND cancellation_requests.status = 'PENDING') ORDER BY id;
+
+-- name: FindServicesSuspendedByDunning :many
+SELECT * FROM services WHERE status = 'SUSPENDED' AND status_reason LIKE 'suspend: %' AND expires_at > $1 ORDER BY id;
 
 -- name: FindServicesForRenewal :many
 SELECT * FROM services 
@@ -234,7 +442,31 @@ AND NOT EXISTS (
     WHERE invoice_items.item_id = services.id 
     AND invoice_items.type = 'service' 
     AND invoices.status = 'UNPAID'
-);
+)
+AND NOT EXISTS (SELECT 1 FROM cancellation_requests WHERE cancellation_requests.service_id = services.id AND cancellation_requests.status = 'PENDING');
+
+-- name: UpdateServicePlan :exec
+UPDATE services SET product_id = $2, price = $3, settings = $4 WHERE id = $1;
+
+-- SERVICE UPGRADES --
+
+-- name: CreateServiceUpgrade :one
+INSERT INTO service_upgrades (service_id, product_id, settings, price, amount, status, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id;
+
+-- name: FindServiceUpgradeByIdForUpdate :one
+SELECT * FROM service_upgrades WHERE id = $1 FOR UPDATE;
+
+-- name: UpdateServiceUpgradeInvoice :exec
+UPDATE service_upgrades SET invoice_id = $2 WHERE id = $1;
+
+-- name: UpdateServiceUpgradeStatus :exec
+UPDATE service_upgrades SET status = $2 WHERE id = $1;
+
+-- name: ListServiceUpgrades :many
+SELECT * FROM service_upgrades WHERE service_id = $1 ORDER BY id DESC;
+
+-- name: CountPendingServiceUpgrades :one
+SELECT COUNT(*) FROM service_upgrades INNER JOIN invoices ON service_upgrades.invoice_id = invoices.id WHERE service_upgrades.service_id = $1 AND service_upgrades.status = 'PENDING' AND invoices.status = 'UNPAID';
 
 -- GATEWAYS --
 
@@ -284,6 +516,241 @@ SELECT * FROM servers WHERE id = $1;
 UPDATE servers SET settings = $1 WHERE id = $2;
 
 
+-- CREDIT --
+
+-- name: CreateCreditTransaction :one
+INSERT INTO credit_transactions (user_id, amount, description, type, invoice_id, currency) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;
+
+-- name: ListCreditTransactionsByUser :many
+SELECT * FROM credit_transactions WHERE user_id = $1 ORDER BY id DESC;
+
+-- name: GetUserCreditBalance :one
+SELECT COALESCE(SUM(amount::decimal), 0)::decimal FROM credit_transactions WHERE user_id = $1 AND currency = $2;
+
+
+-- COUPONS --
+
+-- name: ListCoupons :many
+SELECT * FROM coupons ORDER BY id DESC;
+
+-- name: FindCouponById :one
+SELECT * FROM coupons WHERE id = $1;
+
+-- name: FindCouponByIdForUpdate :one
+SELECT * FROM coupons WHERE id = $1 FOR UPDATE;
+
+-- name: FindCouponByCode :one
+SELECT * FROM coupons WHERE code = $1;
+
+-- name: CreateCoupon :one
+INSERT INTO coupons (code, type, value, recurring, product_ids, durations, max_uses, max_uses_per_user, expires_at, enabled) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id;
+
+-- name: UpdateCoupon :exec
+UPDATE coupons SET code = $1, type = $2, value = $3, recurring = $4, product_ids = $5, durations = $6, max_uses = $7, max_uses_per_user = $8, expires_at = $9, enabled = $10 WHERE id = $11;
+
+-- name: DeleteCoupon :exec
+DELETE FROM coupons WHERE id = $1;
+
+-- name: CreateCouponUse :exec
+INSERT INTO coupon_uses (coupon_id, user_id, service_id) VALUES ($1, $2, $3);
+
+-- name: CountCouponUses :one
+SELECT COUNT(*) FROM coupon_uses WHERE coupon_id = $1;
+
+-- name: CountCouponUsesByUser :one
+SELECT COUNT(*) FROM coupon_uses WHERE coupon_id = $1 AND user_id = $2;
+
+-- name: DeleteCouponUsesByService :exec
+DELETE FROM coupon_uses WHERE service_id = $1;
+
+
+-- TAX RULES --
+
+-- name: ListTaxRules :many
+SELECT * FROM tax_rules ORDER BY country, state;
+
+-- name: FindTaxRuleById :one
+SELECT * FROM tax_rules WHERE id = $1;
+
+-- name: FindTaxRule :one
+SELECT * FROM tax_rules WHERE UPPER(country) = UPPER(@country::text) AND (state = '' OR UPPER(state) = UPPER(@state::text)) ORDER BY state DESC LIMIT 1;
+
+-- name: CreateTaxRule :one
+INSERT INTO tax_rules (name, country, state, rate, reverse_charge) VALUES ($1, $2, $3, $4, $5) RETURNING id;
+
+-- name: UpdateTaxRule :exec
+UPDATE tax_rules SET name = $1, country = $2, state = $3, rate = $4, reverse_charge = $5 WHERE id = $6;
+
+-- name: DeleteTaxRule :exec
+DELETE FROM tax_rules WHERE id = $1;
+
+
+-- EXCHANGE RATES --
+
+-- name: ListExchangeRates :many
+SELECT * FROM exchange_rates ORDER BY currency;
+
+-- name: FindExchangeRate :one
+SELECT * FROM exchange_rates WHERE currency = $1;
+
+-- name: UpsertExchangeRate :exec
+INSERT INTO exchange_rates (currency, rate) VALUES ($1, $2) ON CONFLICT (currency) DO UPDATE SET rate = EXCLUDED.rate, updated_at = CURRENT_TIMESTAMP;
+
+-- name: DeleteExchangeRate :exec
+DELETE FROM exchange_rates WHERE currency = $1;
+
+
+-- CANCELLATION REQUESTS --
+
+-- name: CreateCancellationRequest :one
+INSERT INTO cancellation_requests (service_id, user_id, type, reason, status) VALUES ($1, $2, $3, $4, $5) RETURNING id;
+
+-- name: FindCancellationRequestById :one
+SELECT * FROM cancellation_requests WHERE id = $1;
+
+-- name: FindPendingCancellationRequestByService :one
+SELECT * FROM cancellation_requests WHERE service_id = $1 AND status = 'PENDING';
+
+-- name: ListCancellationRequestsByService :many
+SELECT * FROM cancellation_requests WHERE service_id = $1 ORDER BY id DESC;
+
+-- name: SearchCancellationRequestsPaged :many
+SELECT cancellation_requests.*, services.label AS service_label, users.name AS username FROM cancellation_requests
+INNER JOIN services ON services.id = cancellation_requests.service_id
+INNER JOIN users ON users.id = cancellation_requests.user_id
+WHERE (@status::text = '' OR @status::text = cancellation_requests.status) ORDER BY cancellation_requests.id DESC LIMIT $1 OFFSET $2;
+
+-- name: SearchCancellationRequestsCount :one
+SELECT COUNT(*) FROM cancellation_requests WHERE (@status::text = '' OR @status::text = status);
+
+-- name: FindDueCancellationRequests :many
+SELECT cancellation_requests.* FROM cancellation_requests INNER JOIN services ON services.id = cancellation_requests.service_id
+WHERE cancellation_requests.status = 'PENDING' AND cancellation_requests.type = 'end_of_term' AND services.expires_at <= CURRENT_TIMESTAMP ORDER BY cancellation_requests.id;
+
+-- name: UpdateCancellationRequestStatus :execrows
+UPDATE cancellation_requests SET status = @new_status::text, processed_at = CURRENT_TIMESTAMP WHERE id = $1 AND status = 'PENDING';
+
+-- DUNNING --
+
+-- name: CreateServiceDunningStep :execrows
+INSERT INTO service_dunning_steps (service_id, due_at, step, days, invoice_id) VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING;
+
+-- name: ListServiceDunningSteps :many
+SELECT * FROM service_dunning_steps WHERE service_id = $1 ORDER BY id DESC;
+
+-- TICKETS --
+
+-- name: ListTicketDepartments :many
+SELECT * FROM ticket_departments ORDER BY id;
+
+-- name: FindTicketDepartmentById :one
+SELECT * FROM ticket_departments WHERE id = $1;
+
+-- name: CreateTicketDepartment :one
+INSERT INTO ticket_departments (name, description) VALUES ($1, $2) RETURNING id;
+
+-- name: UpdateTicketDepartment :exec
+UPDATE ticket_departments SET name = $1, description = $2 WHERE id = $3;
+
+-- name: DeleteTicketDepartment :exec
+DELETE FROM ticket_departments WHERE id = $1;
+
+-- name: CreateTicket :one
+INSERT INTO tickets (user_id, department_id, subject, status, priority, service_id, invoice_id) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id;
+
+-- name: FindTicketById :one
+SELECT * FROM tickets WHERE id = $1;
+
+-- name: FindTicketByIdForUpdate :one
+SELECT * FROM tickets WHERE id = $1 FOR UPDATE;
+
+-- name: SearchTicketsPaged :many
+SELECT tickets.*, users.name AS username, ticket_departments.name AS department_name FROM tickets
+INNER JOIN users ON users.id = tickets.user_id
+INNER JOIN ticket_departments ON ticket_departments.id = tickets.department_id
+WHERE (@status::text = '' OR @status::text = tickets.status) AND (@user_id::integer = 0 OR @user_id::integer = tickets.user_id)
+AND (@department_id::integer = 0 OR @department_id::integer = tickets.department_id) AND (@assigned_to::integer = 0 OR @assigned_to::integer = tickets.assigned_to)
+AND (@service_id::integer = 0 OR @service_id::integer = tickets.service_id)
+ORDER BY tickets.updated_at DESC LIMIT $1 OFFSET $2;
+
+-- name: SearchTicketsCount :one
+SELECT COUNT(*) FROM tickets
+WHERE (@status::text = '' OR @status::text = status) AND (@user_id::integer = 0 OR @user_id::integer = user_id)
+AND (@department_id::integer = 0 OR @department_id::integer = department_id) AND (@assigned_to::integer = 0 OR @assigned_to::integer = assigned_to)
+AND (@service_id::integer = 0 OR @service_id::integer = service_id);
+
+-- name: UpdateTicketStatus :exec
+UPDATE tickets SET status = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1;
+
+-- name: UpdateTicket :exec
+UPDATE tickets SET department_id = $2, priority = $3, assigned_to = $4, service_id = $5, invoice_id = $6 WHERE id = $1;
+
+-- name: CreateTicketMessage :one
+INSERT INTO ticket_messages (ticket_id, user_id, staff, body) VALUES ($1, $2, $3, $4) RETURNING id;
+
+-- name: ListTicketMessages :many
+SELECT ticket_messages.*, users.name AS username FROM ticket_messages INNER JOIN users ON users.id = ticket_messages.user_id
+WHERE ticket_messages.ticket_id = $1 ORDER BY ticket_messages.id;
+
+-- name: CreateTicketAttachment :one
+INSERT INTO ticket_attachments (message_id, filename, content_type, size, data) VALUES ($1, $2, $3, $4, $5) RETURNING id;
+
+-- name: ListTicketAttachments :many
+SELECT ticket_attachments.id, ticket_attachments.message_id, ticket_attachments.filename, ticket_attachments.content_type, ticket_attachments.size FROM ticket_attachments
+INNER JOIN ticket_messages ON ticket_messages.id = ticket_attachments.message_id WHERE ticket_messages.ticket_id = $1 ORDER BY ticket_attachments.id;
+
+-- name: FindTicketAttachment :one
+SELECT ticket_attachments.* FROM ticket_attachments INNER JOIN ticket_messages ON ticket_messages.id = ticket_attachments.message_id
+WHERE ticket_attachments.id = $1 AND ticket_messages.ticket_id = $2;
+
+-- EMAIL TEMPLATES --
+
+-- name: FindEmailTemplate :one
+SELECT * FROM email_templates WHERE event = $1;
+
+-- name: ListEmailTemplates :many
+SELECT * FROM email_templates ORDER BY event;
+
+-- name: UpsertEmailTemplate :exec
+INSERT INTO email_templates (event, subject, html, text) VALUES ($1, $2, $3, $4)
+ON CONFLICT (event) DO UPDATE SET subject = EXCLUDED.subject, html = EXCLUDED.html, text = EXCLUDED.text, updated_at = CURRENT_TIMESTAMP;
+
+-- name: DeleteEmailTemplate :exec
+DELETE FROM email_templates WHERE event = $1;
+
+-- EMAIL LOGS --
+
+-- name: CreateEmailLog :one
+INSERT INTO email_logs (recipient, subject, html, text, status, sensitive) VALUES ($1, $2, $3, $4, 'QUEUED', $5) RETURNING id;
+
+-- name: FindEmailLogById :one
+SELECT * FROM email_logs WHERE id = $1;
+
+-- name: SearchEmailLogsPaged :many
+SELECT id, recipient, subject, status, attempts, last_error, created_at, sent_at FROM email_logs WHERE (@status::text = '' OR @status::text = status) AND (@recipient::text = '' OR @recipient::text = recipient) ORDER BY id DESC LIMIT $1 OFFSET $2;
+
+-- name: SearchEmailLogsCount :one
+SELECT COUNT(*) FROM email_logs WHERE (@status::text = '' OR @status::text = status) AND (@recipient::text = '' OR @recipient::text = recipient);
+
+-- name: UpdateEmailLogSent :exec
+UPDATE email_logs SET status = 'SENT', attempts = attempts + 1, last_error = '', sent_at = CURRENT_TIMESTAMP,
+    html = CASE WHEN sensitive THEN '' ELSE html END, text = CASE WHEN sensitive THEN '' ELSE text END WHERE id = $1;
+
+-- name: UpdateEmailLogFailed :exec
+UPDATE email_logs SET status = $2, attempts = attempts + 1, last_error = $3,
+    html = CASE WHEN sensitive AND $2 = 'FAILED' THEN '' ELSE html END, text = CASE WHEN sensitive AND $2 = 'FAILED' THEN '' ELSE text END WHERE id = $1;
+
+-- name: UpdateEmailLogQueued :exec
+UPDATE email_logs SET status = 'QUEUED' WHERE id = $1;
+
+-- name: UpdateEmailLogsDiscarded :execrows
+UPDATE email_logs SET status = 'FAILED', last_error = CASE WHEN last_error = '' THEN 'the job was discarded' ELSE last_error END,
+    html = CASE WHEN sensitive THEN '' ELSE html END, text = CASE WHEN sensitive THEN '' ELSE text END
+WHERE status = 'QUEUED' AND NOT EXISTS (
+    SELECT 1 FROM river_job WHERE river_job.kind = 'email' AND (river_job.args->>'log_id')::int = email_logs.id
+    AND river_job.state IN ('available', 'pending', 'running', 'retryable', 'scheduled')
+);
+
 -- SETTINGS --
 
 -- name: FindSettingByKey :one
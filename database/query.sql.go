@@ -38,6 +38,33 @@ func (q *Queries) AddInvoicePayment(ctx context.Context, arg AddInvoicePaymentPa
 	return id, err
 }
 
+const addInvoiceRefund = `-- name: AddInvoiceRefund :one
+INSERT INTO invoice_payments (invoice_id, description, amount, reference_id, gateway, refund_of) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id
+`
+
+type AddInvoiceRefundParams struct {
+	InvoiceID   int32           `json:"invoice_id"`
+	Description string          `json:"description"`
+	Amount      decimal.Decimal `json:"amount"`
+	ReferenceID string          `json:"reference_id"`
+	Gateway     string          `json:"gateway"`
+	RefundOf    pgtype.Int4     `json:"refund_of"`
+}
+
+func (q *Queries) AddInvoiceRefund(ctx context.Context, arg AddInvoiceRefundParams) (int32, error) {
+	row := q.db.QueryRow(ctx, addInvoiceRefund,
+		arg.InvoiceID,
+		arg.Description,
+		arg.Amount,
+		arg.ReferenceID,
+		arg.Gateway,
+		arg.RefundOf,
+	)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
 const attemptDecreaseProductStock = `-- name: AttemptDecreaseProductStock :execrows
 UPDATE products SET stock = stock - 1 WHERE id = $1 AND stock_control = 2 AND stock > 0
 `
@@ -50,6 +77,55 @@ func (q *Queries) AttemptDecreaseProductStock(ctx context.Context, id int32) (in
 	return result.RowsAffected(), nil
 }
 
+const countApiKeys = `-- name: CountApiKeys :one
+SELECT COUNT(*) FROM api_keys WHERE user_id = $1
+`
+
+func (q *Queries) CountApiKeys(ctx context.Context, userID int32) (int64, error) {
+	row := q.db.QueryRow(ctx, countApiKeys, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countCouponUses = `-- name: CountCouponUses :one
+SELECT COUNT(*) FROM coupon_uses WHERE coupon_id = $1
+`
+
+func (q *Queries) CountCouponUses(ctx context.Context, couponID int32) (int64, error) {
+	row := q.db.QueryRow(ctx, countCouponUses, couponID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countCouponUsesByUser = `-- name: CountCouponUsesByUser :one
+SELECT COUNT(*) FROM coupon_uses WHERE coupon_id = $1 AND user_id = $2
+`
+
+type CountCouponUsesByUserParams struct {
+	CouponID int32 `json:"coupon_id"`
+	UserID   int32 `json:"user_id"`
+}
+
+func (q *Queries) CountCouponUsesByUser(ctx context.Context, arg CountCouponUsesByUserParams) (int64, error) {
+	row := q.db.QueryRow(ctx, countCouponUsesByUser, arg.CouponID, arg.UserID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countPendingServiceUpgrades = `-- name: CountPendingServiceUpgrades :one
+SELECT COUNT(*) FROM service_upgrades INNER JOIN invoices ON service_upgrades.invoice_id = invoices.id WHERE service_upgrades.service_id = $1 AND service_upgrades.status = 'PENDING' AND invoices.status = 'UNPAID'
+`
+
+func (q *Queries) CountPendingServiceUpgrades(ctx context.Context, serviceID int32) (int64, error) {
+	row := q.db.QueryRow(ctx, countPendingServiceUpgrades, serviceID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const countServicesByServer = `-- name: CountServicesByServer :one
 SELECT COUNT(id) FROM services WHERE (status = 'PENDING' OR status = 'ACTIVE' OR status = 'SUSPENDED' OR status = 'UNPAID') AND (settings::jsonb ? 'server' AND (settings->>'server')::integer = $1::integer)
 `
@@ -97,6 +173,17 @@ func (q *Queries) CountUnpaidInvoiceForService(ctx context.Context, itemID pgtyp
 	return count, err
 }
 
+const countUserRecoveryCodes = `-- name: CountUserRecoveryCodes :one
+SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = $1 AND used_at IS NULL
+`
+
+func (q *Queries) CountUserRecoveryCodes(ctx context.Context, userID int32) (int64, error) {
+	row := q.db.QueryRow(ctx, countUserRecoveryCodes, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const countUsers = `-- name: CountUsers :one
 SELECT COUNT(id) FROM users
 `
@@ -108,6 +195,98 @@ func (q *Queries) CountUsers(ctx context.Context) (int64, error) {
 	return count, err
 }
 
+const countWebauthnCredentials = `-- name: CountWebauthnCredentials :one
+SELECT COUNT(*) FROM webauthn_credentials WHERE user_id = $1
+`
+
+func (q *Queries) CountWebauthnCredentials(ctx context.Context, userID int32) (int64, error) {
+	row := q.db.QueryRow(ctx, countWebauthnCredentials, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createApiKey = `-- name: CreateApiKey :one
+INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, allowed_ips, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id
+`
+
+type CreateApiKeyParams struct {
+	UserID     int32           `json:"user_id"`
+	Name       string          `json:"name"`
+	Prefix     string          `json:"prefix"`
+	KeyHash    string          `json:"key_hash"`
+	Scopes     []string        `json:"scopes"`
+	AllowedIps []string        `json:"allowed_ips"`
+	ExpiresAt  types.Timestamp `json:"expires_at"`
+}
+
+func (q *Queries) CreateApiKey(ctx context.Context, arg CreateApiKeyParams) (int32, error) {
+	row := q.db.QueryRow(ctx, createApiKey,
+		arg.UserID,
+		arg.Name,
+		arg.Prefix,
+		arg.KeyHash,
+		arg.Scopes,
+		arg.AllowedIps,
+		arg.ExpiresAt,
+	)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createAuditLog = `-- name: CreateAuditLog :exec
+INSERT INTO audit_logs (actor_id, ip, entity_type, entity_id, action, before, after) VALUES ($1, $2, $3, $4, $5, $6, $7)
+`
+
+type CreateAuditLogParams struct {
+	ActorID    pgtype.Int4 `json:"actor_id"`
+	Ip         string      `json:"ip"`
+	EntityType string      `json:"entity_type"`
+	EntityID   string      `json:"entity_id"`
+	Action     string      `json:"action"`
+	Before     []byte      `json:"before"`
+	After      []byte      `json:"after"`
+}
+
+func (q *Queries) CreateAuditLog(ctx context.Context, arg CreateAuditLogParams) error {
+	_, err := q.db.Exec(ctx, createAuditLog,
+		arg.ActorID,
+		arg.Ip,
+		arg.EntityType,
+		arg.EntityID,
+		arg.Action,
+		arg.Before,
+		arg.After,
+	)
+	return err
+}
+
+const createCancellationRequest = `-- name: CreateCancellationRequest :one
+INSERT INTO cancellation_requests (service_id, user_id, type, reason, status) VALUES ($1, $2, $3, $4, $5) RETURNING id
+`
+
+type CreateCancellationRequestParams struct {
+	ServiceID int32  `json:"service_id"`
+	UserID    int32  `json:"user_id"`
+	Type      string `json:"type"`
+	Reason    string `json:"reason"`
+	Status    string `json:"status"`
+}
+
+func (q *Queries) CreateCancellationRequest(ctx context.Context, arg CreateCancellationRequestParams) (int32, error) {
+	row := q.db.QueryRow(ctx, createCancellationRequest,
+		arg.ServiceID,
+		arg.UserID,
+		arg.Type,
+		arg.Reason,
+		arg.Status,
+	)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
 const createCategory = `-- name: CreateCategory :one
 INSERT INTO categories (name, description) VALUES ($1, $2) RETURNING id
 `
@@ -124,6 +303,110 @@ func (q *Queries) CreateCategory(ctx context.Context, arg CreateCategoryParams)
 	return id, err
 }
 
+const createCoupon = `-- name: CreateCoupon :one
+INSERT INTO coupons (code, type, value, recurring, product_ids, durations, max_uses, max_uses_per_user, expires_at, enabled) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id
+`
+
+type CreateCouponParams struct {
+	Code           string          `json:"code"`
+	Type           string          `json:"type"`
+	Value          decimal.Decimal `json:"value"`
+	Recurring      bool            `json:"recurring"`
+	ProductIds     []int32         `json:"product_ids"`
+	Durations      []int32         `json:"durations"`
+	MaxUses        int32           `json:"max_uses"`
+	MaxUsesPerUser int32           `json:"max_uses_per_user"`
+	ExpiresAt      types.Timestamp `json:"expires_at"`
+	Enabled        bool            `json:"enabled"`
+}
+
+func (q *Queries) CreateCoupon(ctx context.Context, arg CreateCouponParams) (int32, error) {
+	row := q.db.QueryRow(ctx, createCoupon,
+		arg.Code,
+		arg.Type,
+		arg.Value,
+		arg.Recurring,
+		arg.ProductIds,
+		arg.Durations,
+		arg.MaxUses,
+		arg.MaxUsesPerUser,
+		arg.ExpiresAt,
+		arg.Enabled,
+	)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createCouponUse = `-- name: CreateCouponUse :exec
+INSERT INTO coupon_uses (coupon_id, user_id, service_id) VALUES ($1, $2, $3)
+`
+
+type CreateCouponUseParams struct {
+	CouponID  int32       `json:"coupon_id"`
+	UserID    int32       `json:"user_id"`
+	ServiceID pgtype.Int4 `json:"service_id"`
+}
+
+func (q *Queries) CreateCouponUse(ctx context.Context, arg CreateCouponUseParams) error {
+	_, err := q.db.Exec(ctx, createCouponUse, arg.CouponID, arg.UserID, arg.ServiceID)
+	return err
+}
+
+const createCreditTransaction = `-- name: CreateCreditTransaction :one
+
+INSERT INTO credit_transactions (user_id, amount, description, type, invoice_id, currency) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id
+`
+
+type CreateCreditTransactionParams struct {
+	UserID      int32           `json:"user_id"`
+	Amount      decimal.Decimal `json:"amount"`
+	Description string          `json:"description"`
+	Type        string          `json:"type"`
+	InvoiceID   pgtype.Int4     `json:"invoice_id"`
+	Currency    string          `json:"currency"`
+}
+
+// CREDIT --
+func (q *Queries) CreateCreditTransaction(ctx context.Context, arg CreateCreditTransactionParams) (int32, error) {
+	row := q.db.QueryRow(ctx, createCreditTransaction,
+		arg.UserID,
+		arg.Amount,
+		arg.Description,
+		arg.Type,
+		arg.InvoiceID,
+		arg.Currency,
+	)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createEmailLog = `-- name: CreateEmailLog :one
+INSERT INTO email_logs (recipient, subject, html, text, status, sensitive) VALUES ($1, $2, $3, $4, 'QUEUED', $5) RETURNING id
+`
+
+type CreateEmailLogParams struct {
+	Recipient string `json:"recipient"`
+	Subject   string `json:"subject"`
+	Html      string `json:"html"`
+	Text      string `json:"text"`
+	Sensitive bool   `json:"sensitive"`
+}
+
+func (q *Queries) CreateEmailLog(ctx context.Context, arg CreateEmailLogParams) (int32, error) {
+	row := q.db.QueryRow(ctx, createEmailLog,
+		arg.Recipient,
+		arg.Subject,
+		arg.Html,
+		arg.Text,
+		arg.Sensitive,
+	)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
 const createGatewayOrIgnore = `-- name: CreateGatewayOrIgnore :exec
 INSERT INTO gateways (name, display_name, settings, enabled, fee) VALUES ($1, $1, '{}'::json, false, '0.00%') ON CONFLICT DO NOTHING
 `
@@ -134,7 +417,7 @@ func (q *Queries) CreateGatewayOrIgnore(ctx context.Context, name string) error
 }
 
 const createInvoice = `-- name: CreateInvoice :one
-INSERT INTO invoices (user_id, status, cancellation_reason, paid_at, due_at, amount) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id
+INSERT INTO invoices (user_id, status, cancellation_reason, paid_at, due_at, amount, currency) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id
 `
 
 type CreateInvoiceParams struct {
@@ -144,6 +427,7 @@ type CreateInvoiceParams struct {
 	PaidAt             types.Timestamp `json:"paid_at"`
 	DueAt              types.Timestamp `json:"due_at"`
 	Amount             decimal.Decimal `json:"amount"`
+	Currency           string          `json:"currency"`
 }
 
 func (q *Queries) CreateInvoice(ctx context.Context, arg CreateInvoiceParams) (int32, error) {
@@ -154,6 +438,7 @@ func (q *Queries) CreateInvoice(ctx context.Context, arg CreateInvoiceParams) (i
 		arg.PaidAt,
 		arg.DueAt,
 		arg.Amount,
+		arg.Currency,
 	)
 	var id int32
 	err := row.Scan(&id)
@@ -184,7 +469,7 @@ func (q *Queries) CreateInvoiceItem(ctx context.Context, arg CreateInvoiceItemPa
 }
 
 const createProduct = `-- name: CreateProduct :one
-INSERT INTO products (name, description, category_id, extension, enabled, pricing, settings, stock, stock_control) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id
+INSERT INTO products (name, description, category_id, extension, enabled, pricing, settings, stock, stock_control, dunning) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id
 `
 
 type CreateProductParams struct {
@@ -197,6 +482,7 @@ type CreateProductParams struct {
 	Settings     types.ProductSettings `json:"settings"`
 	Stock        int32                 `json:"stock"`
 	StockControl int32                 `json:"stock_control"`
+	Dunning      types.DunningPolicy   `json:"dunning"`
 }
 
 func (q *Queries) CreateProduct(ctx context.Context, arg CreateProductParams) (int32, error) {
@@ -210,6 +496,7 @@ func (q *Queries) CreateProduct(ctx context.Context, arg CreateProductParams) (i
 		arg.Settings,
 		arg.Stock,
 		arg.StockControl,
+		arg.Dunning,
 	)
 	var id int32
 	err := row.Scan(&id)
@@ -261,7 +548,7 @@ func (q *Queries) CreateServer(ctx context.Context, arg CreateServerParams) (int
 }
 
 const createService = `-- name: CreateService :one
-INSERT INTO services (label, user_id, status, billing_cycle, price, extension, settings, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id
+INSERT INTO services (label, user_id, status, billing_cycle, price, extension, settings, expires_at, product_id, coupon_id, currency) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id
 `
 
 type CreateServiceParams struct {
@@ -273,6 +560,9 @@ type CreateServiceParams struct {
 	Extension    string                `json:"extension"`
 	Settings     types.ServiceSettings `json:"settings"`
 	ExpiresAt    types.Timestamp       `json:"expires_at"`
+	ProductID    pgtype.Int4           `json:"product_id"`
+	CouponID     pgtype.Int4           `json:"coupon_id"`
+	Currency     string                `json:"currency"`
 }
 
 func (q *Queries) CreateService(ctx context.Context, arg CreateServiceParams) (int32, error) {
@@ -285,6 +575,66 @@ func (q *Queries) CreateService(ctx context.Context, arg CreateServiceParams) (i
 		arg.Extension,
 		arg.Settings,
 		arg.ExpiresAt,
+		arg.ProductID,
+		arg.CouponID,
+		arg.Currency,
+	)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createServiceDunningStep = `-- name: CreateServiceDunningStep :execrows
+INSERT INTO service_dunning_steps (service_id, due_at, step, days, invoice_id) VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING
+`
+
+type CreateServiceDunningStepParams struct {
+	ServiceID int32           `json:"service_id"`
+	DueAt     types.Timestamp `json:"due_at"`
+	Step      string          `json:"step"`
+	Days      int32           `json:"days"`
+	InvoiceID pgtype.Int4     `json:"invoice_id"`
+}
+
+func (q *Queries) CreateServiceDunningStep(ctx context.Context, arg CreateServiceDunningStepParams) (int64, error) {
+	result, err := q.db.Exec(ctx, createServiceDunningStep,
+		arg.ServiceID,
+		arg.DueAt,
+		arg.Step,
+		arg.Days,
+		arg.InvoiceID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const createServiceUpgrade = `-- name: CreateServiceUpgrade :one
+
+INSERT INTO service_upgrades (service_id, product_id, settings, price, amount, status, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id
+`
+
+type CreateServiceUpgradeParams struct {
+	ServiceID int32                 `json:"service_id"`
+	ProductID int32                 `json:"product_id"`
+	Settings  types.ServiceSettings `json:"settings"`
+	Price     decimal.Decimal       `json:"price"`
+	Amount    decimal.Decimal       `json:"amount"`
+	Status    string                `json:"status"`
+	ExpiresAt types.Timestamp       `json:"expires_at"`
+}
+
+// SERVICE UPGRADES --
+func (q *Queries) CreateServiceUpgrade(ctx context.Context, arg CreateServiceUpgradeParams) (int32, error) {
+	row := q.db.QueryRow(ctx, createServiceUpgrade,
+		arg.ServiceID,
+		arg.ProductID,
+		arg.Settings,
+		arg.Price,
+		arg.Amount,
+		arg.Status,
+		arg.ExpiresAt,
 	)
 	var id int32
 	err := row.Scan(&id)
@@ -292,22 +642,167 @@ func (q *Queries) CreateService(ctx context.Context, arg CreateServiceParams) (i
 }
 
 const createSession = `-- name: CreateSession :exec
-INSERT INTO sessions (token, user_id, expires_at) VALUES ($1, $2, $3)
+INSERT INTO sessions (token, user_id, expires_at, user_agent, ip, impersonator_id) VALUES ($1, $2, $3, $4, $5, $6)
 `
 
 type CreateSessionParams struct {
-	Token     string          `json:"token"`
-	UserID    int32           `json:"user_id"`
-	ExpiresAt types.Timestamp `json:"expires_at"`
+	Token          string          `json:"token"`
+	UserID         int32           `json:"user_id"`
+	ExpiresAt      types.Timestamp `json:"expires_at"`
+	UserAgent      string          `json:"user_agent"`
+	Ip             string          `json:"ip"`
+	ImpersonatorID pgtype.Int4     `json:"impersonator_id"`
 }
 
 func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) error {
-	_, err := q.db.Exec(ctx, createSession, arg.Token, arg.UserID, arg.ExpiresAt)
+	_, err := q.db.Exec(ctx, createSession,
+		arg.Token,
+		arg.UserID,
+		arg.ExpiresAt,
+		arg.UserAgent,
+		arg.Ip,
+		arg.ImpersonatorID,
+	)
 	return err
 }
 
+const createStaffRole = `-- name: CreateStaffRole :one
+INSERT INTO staff_roles (name, description, permissions) VALUES ($1, $2, $3) RETURNING id
+`
+
+type CreateStaffRoleParams struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+func (q *Queries) CreateStaffRole(ctx context.Context, arg CreateStaffRoleParams) (int32, error) {
+	row := q.db.QueryRow(ctx, createStaffRole, arg.Name, arg.Description, arg.Permissions)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createTaxRule = `-- name: CreateTaxRule :one
+INSERT INTO tax_rules (name, country, state, rate, reverse_charge) VALUES ($1, $2, $3, $4, $5) RETURNING id
+`
+
+type CreateTaxRuleParams struct {
+	Name          string          `json:"name"`
+	Country       string          `json:"country"`
+	State         string          `json:"state"`
+	Rate          decimal.Decimal `json:"rate"`
+	ReverseCharge bool            `json:"reverse_charge"`
+}
+
+func (q *Queries) CreateTaxRule(ctx context.Context, arg CreateTaxRuleParams) (int32, error) {
+	row := q.db.QueryRow(ctx, createTaxRule,
+		arg.Name,
+		arg.Country,
+		arg.State,
+		arg.Rate,
+		arg.ReverseCharge,
+	)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createTicket = `-- name: CreateTicket :one
+INSERT INTO tickets (user_id, department_id, subject, status, priority, service_id, invoice_id) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id
+`
+
+type CreateTicketParams struct {
+	UserID       int32       `json:"user_id"`
+	DepartmentID int32       `json:"department_id"`
+	Subject      string      `json:"subject"`
+	Status       string      `json:"status"`
+	Priority     string      `json:"priority"`
+	ServiceID    pgtype.Int4 `json:"service_id"`
+	InvoiceID    pgtype.Int4 `json:"invoice_id"`
+}
+
+func (q *Queries) CreateTicket(ctx context.Context, arg CreateTicketParams) (int32, error) {
+	row := q.db.QueryRow(ctx, createTicket,
+		arg.UserID,
+		arg.DepartmentID,
+		arg.Subject,
+		arg.Status,
+		arg.Priority,
+		arg.ServiceID,
+		arg.InvoiceID,
+	)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createTicketAttachment = `-- name: CreateTicketAttachment :one
+INSERT INTO ticket_attachments (message_id, filename, content_type, size, data) VALUES ($1, $2, $3, $4, $5) RETURNING id
+`
+
+type CreateTicketAttachmentParams struct {
+	MessageID   int32  `json:"message_id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int32  `json:"size"`
+	Data        []byte `json:"data"`
+}
+
+func (q *Queries) CreateTicketAttachment(ctx context.Context, arg CreateTicketAttachmentParams) (int32, error) {
+	row := q.db.QueryRow(ctx, createTicketAttachment,
+		arg.MessageID,
+		arg.Filename,
+		arg.ContentType,
+		arg.Size,
+		arg.Data,
+	)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createTicketDepartment = `-- name: CreateTicketDepartment :one
+INSERT INTO ticket_departments (name, description) VALUES ($1, $2) RETURNING id
+`
+
+type CreateTicketDepartmentParams struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+func (q *Queries) CreateTicketDepartment(ctx context.Context, arg CreateTicketDepartmentParams) (int32, error) {
+	row := q.db.QueryRow(ctx, createTicketDepartment, arg.Name, arg.Description)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createTicketMessage = `-- name: CreateTicketMessage :one
+INSERT INTO ticket_messages (ticket_id, user_id, staff, body) VALUES ($1, $2, $3, $4) RETURNING id
+`
+
+type CreateTicketMessageParams struct {
+	TicketID int32  `json:"ticket_id"`
+	UserID   int32  `json:"user_id"`
+	Staff    bool   `json:"staff"`
+	Body     string `json:"body"`
+}
+
+func (q *Queries) CreateTicketMessage(ctx context.Context, arg CreateTicketMessageParams) (int32, error) {
+	row := q.db.QueryRow(ctx, createTicketMessage,
+		arg.TicketID,
+		arg.UserID,
+		arg.Staff,
+		arg.Body,
+	)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
 const createUser = `-- name: CreateUser :one
-INSERT INTO users (email, name, role, password, address, city, state, country, zip_code) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id
+INSERT INTO users (email, name, role, password, address, city, state, country, zip_code, currency) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id
 `
 
 type CreateUserParams struct {
@@ -320,6 +815,7 @@ type CreateUserParams struct {
 	State    pgtype.Text `json:"state"`
 	Country  pgtype.Text `json:"country"`
 	ZipCode  pgtype.Text `json:"zip_code"`
+	Currency string      `json:"currency"`
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (int32, error) {
@@ -333,32 +829,189 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (int32,
 		arg.State,
 		arg.Country,
 		arg.ZipCode,
+		arg.Currency,
 	)
 	var id int32
 	err := row.Scan(&id)
 	return id, err
 }
 
-const deleteAllInvoiceItems = `-- name: DeleteAllInvoiceItems :exec
-DELETE FROM invoice_items WHERE invoice_id = $1
+const createUserRecoveryCode = `-- name: CreateUserRecoveryCode :exec
+INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)
 `
 
-func (q *Queries) DeleteAllInvoiceItems(ctx context.Context, invoiceID int32) error {
-	_, err := q.db.Exec(ctx, deleteAllInvoiceItems, invoiceID)
-	return err
+type CreateUserRecoveryCodeParams struct {
+	UserID   int32  `json:"user_id"`
+	CodeHash string `json:"code_hash"`
 }
 
-const deleteCategory = `-- name: DeleteCategory :exec
-DELETE FROM categories WHERE id = $1
-`
-
-func (q *Queries) DeleteCategory(ctx context.Context, id int32) error {
-	_, err := q.db.Exec(ctx, deleteCategory, id)
+func (q *Queries) CreateUserRecoveryCode(ctx context.Context, arg CreateUserRecoveryCodeParams) error {
+	_, err := q.db.Exec(ctx, createUserRecoveryCode, arg.UserID, arg.CodeHash)
 	return err
 }
 
-const deleteExpiredSessions = `-- name: DeleteExpiredSessions :exec
-DELETE FROM sessions WHERE expires_at < CURRENT_TIMESTAMP
+const createWebauthnCredential = `-- name: CreateWebauthnCredential :one
+INSERT INTO webauthn_credentials (user_id, name, credential_id, credential) VALUES ($1, $2, $3, $4) RETURNING id
+`
+
+type CreateWebauthnCredentialParams struct {
+	UserID       int32  `json:"user_id"`
+	Name         string `json:"name"`
+	CredentialID []byte `json:"credential_id"`
+	Credential   []byte `json:"credential"`
+}
+
+func (q *Queries) CreateWebauthnCredential(ctx context.Context, arg CreateWebauthnCredentialParams) (int32, error) {
+	row := q.db.QueryRow(ctx, createWebauthnCredential,
+		arg.UserID,
+		arg.Name,
+		arg.CredentialID,
+		arg.Credential,
+	)
+	var id int32
+	err := row.Scan(&id)
+	return id, err
+}
+
+const createWebauthnSession = `-- name: CreateWebauthnSession :exec
+INSERT INTO webauthn_sessions (token, type, user_id, data, expires_at) VALUES ($1, $2, $3, $4, $5)
+`
+
+type CreateWebauthnSessionParams struct {
+	Token     string          `json:"token"`
+	Type      string          `json:"type"`
+	UserID    pgtype.Int4     `json:"user_id"`
+	Data      []byte          `json:"data"`
+	ExpiresAt types.Timestamp `json:"expires_at"`
+}
+
+func (q *Queries) CreateWebauthnSession(ctx context.Context, arg CreateWebauthnSessionParams) error {
+	_, err := q.db.Exec(ctx, createWebauthnSession,
+		arg.Token,
+		arg.Type,
+		arg.UserID,
+		arg.Data,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
+const deleteAllInvoiceItems = `-- name: DeleteAllInvoiceItems :exec
+DELETE FROM invoice_items WHERE invoice_id = $1
+`
+
+func (q *Queries) DeleteAllInvoiceItems(ctx context.Context, invoiceID int32) error {
+	_, err := q.db.Exec(ctx, deleteAllInvoiceItems, invoiceID)
+	return err
+}
+
+const deleteApiKey = `-- name: DeleteApiKey :execrows
+DELETE FROM api_keys WHERE id = $1 AND user_id = $2
+`
+
+type DeleteApiKeyParams struct {
+	ID     int32 `json:"id"`
+	UserID int32 `json:"user_id"`
+}
+
+func (q *Queries) DeleteApiKey(ctx context.Context, arg DeleteApiKeyParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteApiKey, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteCategory = `-- name: DeleteCategory :exec
+DELETE FROM categories WHERE id = $1
+`
+
+func (q *Queries) DeleteCategory(ctx context.Context, id int32) error {
+	_, err := q.db.Exec(ctx, deleteCategory, id)
+	return err
+}
+
+const deleteCoupon = `-- name: DeleteCoupon :exec
+DELETE FROM coupons WHERE id = $1
+`
+
+func (q *Queries) DeleteCoupon(ctx context.Context, id int32) error {
+	_, err := q.db.Exec(ctx, deleteCoupon, id)
+	return err
+}
+
+const deleteCouponUsesByService = `-- name: DeleteCouponUsesByService :exec
+DELETE FROM coupon_uses WHERE service_id = $1
+`
+
+func (q *Queries) DeleteCouponUsesByService(ctx context.Context, serviceID pgtype.Int4) error {
+	_, err := q.db.Exec(ctx, deleteCouponUsesByService, serviceID)
+	return err
+}
+
+const deleteEmailTemplate = `-- name: DeleteEmailTemplate :exec
+DELETE FROM email_templates WHERE event = $1
+`
+
+func (q *Queries) DeleteEmailTemplate(ctx context.Context, event string) error {
+	_, err := q.db.Exec(ctx, deleteEmailTemplate, event)
+	return err
+}
+
+const deleteExchangeRate = `-- name: DeleteExchangeRate :exec
+DELETE FROM exchange_rates WHERE currency = $1
+`
+
+func (q *Queries) DeleteExchangeRate(ctx context.Context, currency string) error {
+	_, err := q.db.Exec(ctx, deleteExchangeRate, currency)
+	return err
+}
+
+const deleteExpiredImpersonations = `-- name: DeleteExpiredImpersonations :many
+DELETE FROM sessions WHERE expires_at < CURRENT_TIMESTAMP AND impersonator_id IS NOT NULL RETURNING id, token, user_id, created_at, expires_at, user_agent, ip, last_seen_at, impersonator_id
+`
+
+func (q *Queries) DeleteExpiredImpersonations(ctx context.Context) ([]Session, error) {
+	rows, err := q.db.Query(ctx, deleteExpiredImpersonations)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Session{}
+	for rows.Next() {
+		var i Session
+		if err := rows.Scan(
+			&i.ID,
+			&i.Token,
+			&i.UserID,
+			&i.CreatedAt,
+			&i.ExpiresAt,
+			&i.UserAgent,
+			&i.Ip,
+			&i.LastSeenAt,
+			&i.ImpersonatorID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const deleteExpiredLoginThrottles = `-- name: DeleteExpiredLoginThrottles :exec
+DELETE FROM login_throttles WHERE window_start < CURRENT_TIMESTAMP - INTERVAL '1 day' AND (locked_until IS NULL OR locked_until < CURRENT_TIMESTAMP - INTERVAL '1 day')
+`
+
+func (q *Queries) DeleteExpiredLoginThrottles(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, deleteExpiredLoginThrottles)
+	return err
+}
+
+const deleteExpiredSessions = `-- name: DeleteExpiredSessions :exec
+DELETE FROM sessions WHERE expires_at < CURRENT_TIMESTAMP
 `
 
 func (q *Queries) DeleteExpiredSessions(ctx context.Context) error {
@@ -366,6 +1019,15 @@ func (q *Queries) DeleteExpiredSessions(ctx context.Context) error {
 	return err
 }
 
+const deleteExpiredWebauthnSessions = `-- name: DeleteExpiredWebauthnSessions :exec
+DELETE FROM webauthn_sessions WHERE expires_at < CURRENT_TIMESTAMP
+`
+
+func (q *Queries) DeleteExpiredWebauthnSessions(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, deleteExpiredWebauthnSessions)
+	return err
+}
+
 const deleteGatewayByName = `-- name: DeleteGatewayByName :exec
 DELETE FROM gateways WHERE name = $1
 `
@@ -389,6 +1051,29 @@ func (q *Queries) DeleteInvoiceItem(ctx context.Context, arg DeleteInvoiceItemPa
 	return err
 }
 
+const deleteInvoiceItemsByType = `-- name: DeleteInvoiceItemsByType :exec
+DELETE FROM invoice_items WHERE invoice_id = $1 AND type = $2
+`
+
+type DeleteInvoiceItemsByTypeParams struct {
+	InvoiceID int32  `json:"invoice_id"`
+	Type      string `json:"type"`
+}
+
+func (q *Queries) DeleteInvoiceItemsByType(ctx context.Context, arg DeleteInvoiceItemsByTypeParams) error {
+	_, err := q.db.Exec(ctx, deleteInvoiceItemsByType, arg.InvoiceID, arg.Type)
+	return err
+}
+
+const deleteLoginThrottle = `-- name: DeleteLoginThrottle :exec
+DELETE FROM login_throttles WHERE key = $1
+`
+
+func (q *Queries) DeleteLoginThrottle(ctx context.Context, key string) error {
+	_, err := q.db.Exec(ctx, deleteLoginThrottle, key)
+	return err
+}
+
 const deleteProduct = `-- name: DeleteProduct :exec
 DELETE FROM products WHERE id = $1
 `
@@ -434,6 +1119,62 @@ func (q *Queries) DeleteSession(ctx context.Context, token string) error {
 	return err
 }
 
+const deleteSessionByUser = `-- name: DeleteSessionByUser :execrows
+DELETE FROM sessions WHERE id = $1 AND user_id = $2
+`
+
+type DeleteSessionByUserParams struct {
+	ID     int32 `json:"id"`
+	UserID int32 `json:"user_id"`
+}
+
+func (q *Queries) DeleteSessionByUser(ctx context.Context, arg DeleteSessionByUserParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteSessionByUser, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteSessionsByUser = `-- name: DeleteSessionsByUser :exec
+DELETE FROM sessions WHERE user_id = $1
+`
+
+func (q *Queries) DeleteSessionsByUser(ctx context.Context, userID int32) error {
+	_, err := q.db.Exec(ctx, deleteSessionsByUser, userID)
+	return err
+}
+
+const deleteStaffRole = `-- name: DeleteStaffRole :execrows
+DELETE FROM staff_roles WHERE id = $1
+`
+
+func (q *Queries) DeleteStaffRole(ctx context.Context, id int32) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteStaffRole, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteTaxRule = `-- name: DeleteTaxRule :exec
+DELETE FROM tax_rules WHERE id = $1
+`
+
+func (q *Queries) DeleteTaxRule(ctx context.Context, id int32) error {
+	_, err := q.db.Exec(ctx, deleteTaxRule, id)
+	return err
+}
+
+const deleteTicketDepartment = `-- name: DeleteTicketDepartment :exec
+DELETE FROM ticket_departments WHERE id = $1
+`
+
+func (q *Queries) DeleteTicketDepartment(ctx context.Context, id int32) error {
+	_, err := q.db.Exec(ctx, deleteTicketDepartment, id)
+	return err
+}
+
 const deleteUser = `-- name: DeleteUser :exec
 DELETE FROM users WHERE id = $1
 `
@@ -443,125 +1184,411 @@ func (q *Queries) DeleteUser(ctx context.Context, id int32) error {
 	return err
 }
 
-const findCategoryById = `-- name: FindCategoryById :one
-
-SELECT id, name, description FROM categories WHERE id = $1
+const deleteUserRecoveryCodes = `-- name: DeleteUserRecoveryCodes :exec
+DELETE FROM user_recovery_codes WHERE user_id = $1
 `
 
-// CATEGORIES --
-func (q *Queries) FindCategoryById(ctx context.Context, id int32) (Category, error) {
-	row := q.db.QueryRow(ctx, findCategoryById, id)
-	var i Category
-	err := row.Scan(&i.ID, &i.Name, &i.Description)
-	return i, err
+func (q *Queries) DeleteUserRecoveryCodes(ctx context.Context, userID int32) error {
+	_, err := q.db.Exec(ctx, deleteUserRecoveryCodes, userID)
+	return err
 }
 
-const findEnabledProductsByCategory = `-- name: FindEnabledProductsByCategory :many
+const deleteUserTotp = `-- name: DeleteUserTotp :exec
+DELETE FROM user_totp WHERE user_id = $1
+`
+
+func (q *Queries) DeleteUserTotp(ctx context.Context, userID int32) error {
+	_, err := q.db.Exec(ctx, deleteUserTotp, userID)
+	return err
+}
 
-SELECT id, name, description, category_id, extension, enabled, pricing, settings, stock, stock_control FROM products WHERE category_id = $1 AND enabled = TRUE
+const deleteWebauthnCredential = `-- name: DeleteWebauthnCredential :execrows
+DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2
 `
 
-// PRODUCTS --
-func (q *Queries) FindEnabledProductsByCategory(ctx context.Context, categoryID int32) ([]Product, error) {
-	rows, err := q.db.Query(ctx, findEnabledProductsByCategory, categoryID)
+type DeleteWebauthnCredentialParams struct {
+	ID     int32 `json:"id"`
+	UserID int32 `json:"user_id"`
+}
+
+func (q *Queries) DeleteWebauthnCredential(ctx context.Context, arg DeleteWebauthnCredentialParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteWebauthnCredential, arg.ID, arg.UserID)
 	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	items := []Product{}
-	for rows.Next() {
-		var i Product
-		if err := rows.Scan(
-			&i.ID,
-			&i.Name,
-			&i.Description,
-			&i.CategoryID,
-			&i.Extension,
-			&i.Enabled,
-			&i.Pricing,
-			&i.Settings,
-			&i.Stock,
-			&i.StockControl,
-		); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
+		return 0, err
 	}
-	return items, nil
+	return result.RowsAffected(), nil
 }
 
-const findGatewayById = `-- name: FindGatewayById :one
-SELECT id, display_name, name, settings, enabled, fee FROM gateways WHERE id = $1
+const deleteWebauthnSession = `-- name: DeleteWebauthnSession :one
+DELETE FROM webauthn_sessions WHERE token = $1 AND type = $2 AND expires_at > CURRENT_TIMESTAMP RETURNING token, type, user_id, data, expires_at
 `
 
-func (q *Queries) FindGatewayById(ctx context.Context, id int32) (Gateway, error) {
-	row := q.db.QueryRow(ctx, findGatewayById, id)
-	var i Gateway
+type DeleteWebauthnSessionParams struct {
+	Token string `json:"token"`
+	Type  string `json:"type"`
+}
+
+func (q *Queries) DeleteWebauthnSession(ctx context.Context, arg DeleteWebauthnSessionParams) (WebauthnSession, error) {
+	row := q.db.QueryRow(ctx, deleteWebauthnSession, arg.Token, arg.Type)
+	var i WebauthnSession
 	err := row.Scan(
-		&i.ID,
-		&i.DisplayName,
-		&i.Name,
-		&i.Settings,
-		&i.Enabled,
-		&i.Fee,
+		&i.Token,
+		&i.Type,
+		&i.UserID,
+		&i.Data,
+		&i.ExpiresAt,
 	)
 	return i, err
 }
 
-const findGatewayByName = `-- name: FindGatewayByName :one
-SELECT id, display_name, name, settings, enabled, fee FROM gateways WHERE name = $1
+const enableUserTotp = `-- name: EnableUserTotp :exec
+UPDATE user_totp SET enabled = TRUE, last_step = $2 WHERE user_id = $1
 `
 
-func (q *Queries) FindGatewayByName(ctx context.Context, name string) (Gateway, error) {
-	row := q.db.QueryRow(ctx, findGatewayByName, name)
-	var i Gateway
+type EnableUserTotpParams struct {
+	UserID   int32 `json:"user_id"`
+	LastStep int64 `json:"last_step"`
+}
+
+func (q *Queries) EnableUserTotp(ctx context.Context, arg EnableUserTotpParams) error {
+	_, err := q.db.Exec(ctx, enableUserTotp, arg.UserID, arg.LastStep)
+	return err
+}
+
+const findApiKeyByHash = `-- name: FindApiKeyByHash :one
+SELECT id, user_id, name, prefix, key_hash, scopes, allowed_ips, expires_at, last_used_at, last_used_ip, created_at FROM api_keys WHERE key_hash = $1 AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+`
+
+func (q *Queries) FindApiKeyByHash(ctx context.Context, keyHash string) (ApiKey, error) {
+	row := q.db.QueryRow(ctx, findApiKeyByHash, keyHash)
+	var i ApiKey
 	err := row.Scan(
 		&i.ID,
-		&i.DisplayName,
+		&i.UserID,
 		&i.Name,
-		&i.Settings,
-		&i.Enabled,
-		&i.Fee,
+		&i.Prefix,
+		&i.KeyHash,
+		&i.Scopes,
+		&i.AllowedIps,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+		&i.LastUsedIp,
+		&i.CreatedAt,
 	)
 	return i, err
 }
 
-const findInvoiceById = `-- name: FindInvoiceById :one
-SELECT id, user_id, status, cancellation_reason, paid_at, due_at, amount, created_at FROM invoices WHERE id = $1
+const findCancellationRequestById = `-- name: FindCancellationRequestById :one
+SELECT id, service_id, user_id, type, reason, status, created_at, processed_at FROM cancellation_requests WHERE id = $1
 `
 
-func (q *Queries) FindInvoiceById(ctx context.Context, id int32) (Invoice, error) {
-	row := q.db.QueryRow(ctx, findInvoiceById, id)
-	var i Invoice
+func (q *Queries) FindCancellationRequestById(ctx context.Context, id int32) (CancellationRequest, error) {
+	row := q.db.QueryRow(ctx, findCancellationRequestById, id)
+	var i CancellationRequest
 	err := row.Scan(
 		&i.ID,
+		&i.ServiceID,
 		&i.UserID,
+		&i.Type,
+		&i.Reason,
 		&i.Status,
-		&i.CancellationReason,
-		&i.PaidAt,
-		&i.DueAt,
-		&i.Amount,
 		&i.CreatedAt,
+		&i.ProcessedAt,
 	)
 	return i, err
 }
 
-const findInvoiceByIdWithUsername = `-- name: FindInvoiceByIdWithUsername :one
-SELECT invoices.id, invoices.user_id, invoices.status, invoices.cancellation_reason, invoices.paid_at, invoices.due_at, invoices.amount, invoices.created_at, users.name AS username FROM invoices INNER JOIN users ON invoices.user_id = users.id WHERE invoices.id = $1
+const findCategoryById = `-- name: FindCategoryById :one
+
+SELECT id, name, description FROM categories WHERE id = $1
 `
 
-type FindInvoiceByIdWithUsernameRow struct {
-	ID                 int32           `json:"id"`
-	UserID             int32           `json:"user_id"`
-	Status             string          `json:"status"`
-	CancellationReason pgtype.Text     `json:"cancellation_reason"`
-	PaidAt             types.Timestamp `json:"paid_at"`
-	DueAt              types.Timestamp `json:"due_at"`
-	Amount             decimal.Decimal `json:"amount"`
-	CreatedAt          types.Timestamp `json:"created_at"`
+// CATEGORIES --
+func (q *Queries) FindCategoryById(ctx context.Context, id int32) (Category, error) {
+	row := q.db.QueryRow(ctx, findCategoryById, id)
+	var i Category
+	err := row.Scan(&i.ID, &i.Name, &i.Description)
+	return i, err
+}
+
+const findCouponByCode = `-- name: FindCouponByCode :one
+SELECT id, code, type, value, recurring, product_ids, durations, max_uses, max_uses_per_user, expires_at, enabled, created_at FROM coupons WHERE code = $1
+`
+
+func (q *Queries) FindCouponByCode(ctx context.Context, code string) (Coupon, error) {
+	row := q.db.QueryRow(ctx, findCouponByCode, code)
+	var i Coupon
+	err := row.Scan(
+		&i.ID,
+		&i.Code,
+		&i.Type,
+		&i.Value,
+		&i.Recurring,
+		&i.ProductIds,
+		&i.Durations,
+		&i.MaxUses,
+		&i.MaxUsesPerUser,
+		&i.ExpiresAt,
+		&i.Enabled,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const findCouponById = `-- name: FindCouponById :one
+SELECT id, code, type, value, recurring, product_ids, durations, max_uses, max_uses_per_user, expires_at, enabled, created_at FROM coupons WHERE id = $1
+`
+
+func (q *Queries) FindCouponById(ctx context.Context, id int32) (Coupon, error) {
+	row := q.db.QueryRow(ctx, findCouponById, id)
+	var i Coupon
+	err := row.Scan(
+		&i.ID,
+		&i.Code,
+		&i.Type,
+		&i.Value,
+		&i.Recurring,
+		&i.ProductIds,
+		&i.Durations,
+		&i.MaxUses,
+		&i.MaxUsesPerUser,
+		&i.ExpiresAt,
+		&i.Enabled,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const findCouponByIdForUpdate = `-- name: FindCouponByIdForUpdate :one
+SELECT id, code, type, value, recurring, product_ids, durations, max_uses, max_uses_per_user, expires_at, enabled, created_at FROM coupons WHERE id = $1 FOR UPDATE
+`
+
+func (q *Queries) FindCouponByIdForUpdate(ctx context.Context, id int32) (Coupon, error) {
+	row := q.db.QueryRow(ctx, findCouponByIdForUpdate, id)
+	var i Coupon
+	err := row.Scan(
+		&i.ID,
+		&i.Code,
+		&i.Type,
+		&i.Value,
+		&i.Recurring,
+		&i.ProductIds,
+		&i.Durations,
+		&i.MaxUses,
+		&i.MaxUsesPerUser,
+		&i.ExpiresAt,
+		&i.Enabled,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const findDueCancellationRequests = `-- name: FindDueCancellationRequests :many
+SELECT cancellation_requests.id, cancellation_requests.service_id, cancellation_requests.user_id, cancellation_requests.type, cancellation_requests.reason, cancellation_requests.status, cancellation_requests.created_at, cancellation_requests.processed_at FROM cancellation_requests INNER JOIN services ON services.id = cancellation_requests.service_id
+WHERE cancellation_requests.status = 'PENDING' AND cancellation_requests.type = 'end_of_term' AND services.expires_at <= CURRENT_TIMESTAMP ORDER BY cancellation_requests.id
+`
+
+func (q *Queries) FindDueCancellationRequests(ctx context.Context) ([]CancellationRequest, error) {
+	rows, err := q.db.Query(ctx, findDueCancellationRequests)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []CancellationRequest{}
+	for rows.Next() {
+		var i CancellationRequest
+		if err := rows.Scan(
+			&i.ID,
+			&i.ServiceID,
+			&i.UserID,
+			&i.Type,
+			&i.Reason,
+			&i.Status,
+			&i.CreatedAt,
+			&i.ProcessedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const findEmailLogById = `-- name: FindEmailLogById :one
+SELECT id, recipient, subject, html, text, status, attempts, last_error, created_at, sent_at, sensitive FROM email_logs WHERE id = $1
+`
+
+func (q *Queries) FindEmailLogById(ctx context.Context, id int32) (EmailLog, error) {
+	row := q.db.QueryRow(ctx, findEmailLogById, id)
+	var i EmailLog
+	err := row.Scan(
+		&i.ID,
+		&i.Recipient,
+		&i.Subject,
+		&i.Html,
+		&i.Text,
+		&i.Status,
+		&i.Attempts,
+		&i.LastError,
+		&i.CreatedAt,
+		&i.SentAt,
+		&i.Sensitive,
+	)
+	return i, err
+}
+
+const findEmailTemplate = `-- name: FindEmailTemplate :one
+SELECT event, subject, html, text, updated_at FROM email_templates WHERE event = $1
+`
+
+func (q *Queries) FindEmailTemplate(ctx context.Context, event string) (EmailTemplate, error) {
+	row := q.db.QueryRow(ctx, findEmailTemplate, event)
+	var i EmailTemplate
+	err := row.Scan(
+		&i.Event,
+		&i.Subject,
+		&i.Html,
+		&i.Text,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const findEnabledProductsByCategory = `-- name: FindEnabledProductsByCategory :many
+
+SELECT id, name, description, category_id, extension, enabled, pricing, settings, stock, stock_control, dunning FROM products WHERE category_id = $1 AND enabled = TRUE
+`
+
+// PRODUCTS --
+func (q *Queries) FindEnabledProductsByCategory(ctx context.Context, categoryID int32) ([]Product, error) {
+	rows, err := q.db.Query(ctx, findEnabledProductsByCategory, categoryID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Product{}
+	for rows.Next() {
+		var i Product
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Description,
+			&i.CategoryID,
+			&i.Extension,
+			&i.Enabled,
+			&i.Pricing,
+			&i.Settings,
+			&i.Stock,
+			&i.StockControl,
+			&i.Dunning,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const findExchangeRate = `-- name: FindExchangeRate :one
+SELECT currency, rate, updated_at FROM exchange_rates WHERE currency = $1
+`
+
+func (q *Queries) FindExchangeRate(ctx context.Context, currency string) (ExchangeRate, error) {
+	row := q.db.QueryRow(ctx, findExchangeRate, currency)
+	var i ExchangeRate
+	err := row.Scan(&i.Currency, &i.Rate, &i.UpdatedAt)
+	return i, err
+}
+
+const findGatewayById = `-- name: FindGatewayById :one
+SELECT id, display_name, name, settings, enabled, fee FROM gateways WHERE id = $1
+`
+
+func (q *Queries) FindGatewayById(ctx context.Context, id int32) (Gateway, error) {
+	row := q.db.QueryRow(ctx, findGatewayById, id)
+	var i Gateway
+	err := row.Scan(
+		&i.ID,
+		&i.DisplayName,
+		&i.Name,
+		&i.Settings,
+		&i.Enabled,
+		&i.Fee,
+	)
+	return i, err
+}
+
+const findGatewayByName = `-- name: FindGatewayByName :one
+SELECT id, display_name, name, settings, enabled, fee FROM gateways WHERE name = $1
+`
+
+func (q *Queries) FindGatewayByName(ctx context.Context, name string) (Gateway, error) {
+	row := q.db.QueryRow(ctx, findGatewayByName, name)
+	var i Gateway
+	err := row.Scan(
+		&i.ID,
+		&i.DisplayName,
+		&i.Name,
+		&i.Settings,
+		&i.Enabled,
+		&i.Fee,
+	)
+	return i, err
+}
+
+const findInvoiceById = `-- name: FindInvoiceById :one
+SELECT id, user_id, status, cancellation_reason, paid_at, due_at, amount, created_at, net, tax, tax_name, tax_rate, reverse_charge, currency, number FROM invoices WHERE id = $1
+`
+
+func (q *Queries) FindInvoiceById(ctx context.Context, id int32) (Invoice, error) {
+	row := q.db.QueryRow(ctx, findInvoiceById, id)
+	var i Invoice
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Status,
+		&i.CancellationReason,
+		&i.PaidAt,
+		&i.DueAt,
+		&i.Amount,
+		&i.CreatedAt,
+		&i.Net,
+		&i.Tax,
+		&i.TaxName,
+		&i.TaxRate,
+		&i.ReverseCharge,
+		&i.Currency,
+		&i.Number,
+	)
+	return i, err
+}
+
+const findInvoiceByIdWithUsername = `-- name: FindInvoiceByIdWithUsername :one
+SELECT invoices.id, invoices.user_id, invoices.status, invoices.cancellation_reason, invoices.paid_at, invoices.due_at, invoices.amount, invoices.created_at, invoices.net, invoices.tax, invoices.tax_name, invoices.tax_rate, invoices.reverse_charge, invoices.currency, invoices.number, users.name AS username FROM invoices INNER JOIN users ON invoices.user_id = users.id WHERE invoices.id = $1
+`
+
+type FindInvoiceByIdWithUsernameRow struct {
+	ID                 int32           `json:"id"`
+	UserID             int32           `json:"user_id"`
+	Status             string          `json:"status"`
+	CancellationReason pgtype.Text     `json:"cancellation_reason"`
+	PaidAt             types.Timestamp `json:"paid_at"`
+	DueAt              types.Timestamp `json:"due_at"`
+	Amount             decimal.Decimal `json:"amount"`
+	CreatedAt          types.Timestamp `json:"created_at"`
+	Net                decimal.Decimal `json:"net"`
+	Tax                decimal.Decimal `json:"tax"`
+	TaxName            string          `json:"tax_name"`
+	TaxRate            decimal.Decimal `json:"tax_rate"`
+	ReverseCharge      bool            `json:"reverse_charge"`
+	Currency           string          `json:"currency"`
+	Number             pgtype.Text     `json:"number"`
 	Username           string          `json:"username"`
 }
 
@@ -577,13 +1604,20 @@ func (q *Queries) FindInvoiceByIdWithUsername(ctx context.Context, id int32) (Fi
 		&i.DueAt,
 		&i.Amount,
 		&i.CreatedAt,
+		&i.Net,
+		&i.Tax,
+		&i.TaxName,
+		&i.TaxRate,
+		&i.ReverseCharge,
+		&i.Currency,
+		&i.Number,
 		&i.Username,
 	)
 	return i, err
 }
 
 const findInvoiceByService = `-- name: FindInvoiceByService :many
-SELECT invoices.id, invoices.user_id, invoices.status, invoices.cancellation_reason, invoices.paid_at, invoices.due_at, invoices.amount, invoices.created_at FROM invoices INNER JOIN invoice_items ON invoices.id = invoice_items.invoice_id WHERE invoice_items.item_id = $1 AND invoice_items.type = 'service' ORDER BY invoices.id DESC
+SELECT invoices.id, invoices.user_id, invoices.status, invoices.cancellation_reason, invoices.paid_at, invoices.due_at, invoices.amount, invoices.created_at, invoices.net, invoices.tax, invoices.tax_name, invoices.tax_rate, invoices.reverse_charge, invoices.currency, invoices.number FROM invoices INNER JOIN invoice_items ON invoices.id = invoice_items.invoice_id WHERE invoice_items.item_id = $1 AND invoice_items.type = 'service' ORDER BY invoices.id DESC
 `
 
 func (q *Queries) FindInvoiceByService(ctx context.Context, itemID pgtype.Int4) ([]Invoice, error) {
@@ -604,6 +1638,13 @@ func (q *Queries) FindInvoiceByService(ctx context.Context, itemID pgtype.Int4)
 			&i.DueAt,
 			&i.Amount,
 			&i.CreatedAt,
+			&i.Net,
+			&i.Tax,
+			&i.TaxName,
+			&i.TaxRate,
+			&i.ReverseCharge,
+			&i.Currency,
+			&i.Number,
 		); err != nil {
 			return nil, err
 		}
@@ -615,8 +1656,53 @@ func (q *Queries) FindInvoiceByService(ctx context.Context, itemID pgtype.Int4)
 	return items, nil
 }
 
+const findInvoicePaymentById = `-- name: FindInvoicePaymentById :one
+SELECT id, invoice_id, created_at, description, amount, reference_id, gateway, refund_of FROM invoice_payments WHERE id = $1
+`
+
+func (q *Queries) FindInvoicePaymentById(ctx context.Context, id int32) (InvoicePayment, error) {
+	row := q.db.QueryRow(ctx, findInvoicePaymentById, id)
+	var i InvoicePayment
+	err := row.Scan(
+		&i.ID,
+		&i.InvoiceID,
+		&i.CreatedAt,
+		&i.Description,
+		&i.Amount,
+		&i.ReferenceID,
+		&i.Gateway,
+		&i.RefundOf,
+	)
+	return i, err
+}
+
+const findInvoicePaymentByReference = `-- name: FindInvoicePaymentByReference :one
+SELECT id, invoice_id, created_at, description, amount, reference_id, gateway, refund_of FROM invoice_payments WHERE gateway = $1 AND reference_id = $2 LIMIT 1
+`
+
+type FindInvoicePaymentByReferenceParams struct {
+	Gateway     string `json:"gateway"`
+	ReferenceID string `json:"reference_id"`
+}
+
+func (q *Queries) FindInvoicePaymentByReference(ctx context.Context, arg FindInvoicePaymentByReferenceParams) (InvoicePayment, error) {
+	row := q.db.QueryRow(ctx, findInvoicePaymentByReference, arg.Gateway, arg.ReferenceID)
+	var i InvoicePayment
+	err := row.Scan(
+		&i.ID,
+		&i.InvoiceID,
+		&i.CreatedAt,
+		&i.Description,
+		&i.Amount,
+		&i.ReferenceID,
+		&i.Gateway,
+		&i.RefundOf,
+	)
+	return i, err
+}
+
 const findOverdueInvoices = `-- name: FindOverdueInvoices :many
-SELECT id, user_id, status, cancellation_reason, paid_at, due_at, amount, created_at FROM invoices WHERE status = 'UNPAID' AND due_at <= CURRENT_TIMESTAMP ORDER BY id
+SELECT id, user_id, status, cancellation_reason, paid_at, due_at, amount, created_at, net, tax, tax_name, tax_rate, reverse_charge, currency, number FROM invoices WHERE status = 'UNPAID' AND due_at <= CURRENT_TIMESTAMP ORDER BY id
 `
 
 func (q *Queries) FindOverdueInvoices(ctx context.Context) ([]Invoice, error) {
@@ -637,6 +1723,13 @@ func (q *Queries) FindOverdueInvoices(ctx context.Context) ([]Invoice, error) {
 			&i.DueAt,
 			&i.Amount,
 			&i.CreatedAt,
+			&i.Net,
+			&i.Tax,
+			&i.TaxName,
+			&i.TaxRate,
+			&i.ReverseCharge,
+			&i.Currency,
+			&i.Number,
 		); err != nil {
 			return nil, err
 		}
@@ -648,45 +1741,28 @@ func (q *Queries) FindOverdueInvoices(ctx context.Context) ([]Invoice, error) {
 	return items, nil
 }
 
-const findOverdueServices = `-- name: FindOverdueServices :many
-SELECT id, label, user_id, status, cancellation_reason, billing_cycle, price, extension, settings, expires_at, created_at, cancelled_at FROM services WHERE (status = 'SUSPENDED' OR status = 'ACTIVE' OR status = 'PENDING') AND expires_at <= CURRENT_TIMESTAMP ORDER BY id
+const findPendingCancellationRequestByService = `-- name: FindPendingCancellationRequestByService :one
+SELECT id, service_id, user_id, type, reason, status, created_at, processed_at FROM cancellation_requests WHERE service_id = $1 AND status = 'PENDING'
 `
 
-func (q *Queries) FindOverdueServices(ctx context.Context) ([]Service, error) {
-	rows, err := q.db.Query(ctx, findOverdueServices)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	items := []Service{}
-	for rows.Next() {
-		var i Service
-		if err := rows.Scan(
-			&i.ID,
-			&i.Label,
-			&i.UserID,
-			&i.Status,
-			&i.CancellationReason,
-			&i.BillingCycle,
-			&i.Price,
-			&i.Extension,
-			&i.Settings,
-			&i.ExpiresAt,
-			&i.CreatedAt,
-			&i.CancelledAt,
-		); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return items, nil
+func (q *Queries) FindPendingCancellationRequestByService(ctx context.Context, serviceID int32) (CancellationRequest, error) {
+	row := q.db.QueryRow(ctx, findPendingCancellationRequestByService, serviceID)
+	var i CancellationRequest
+	err := row.Scan(
+		&i.ID,
+		&i.ServiceID,
+		&i.UserID,
+		&i.Type,
+		&i.Reason,
+		&i.Status,
+		&i.CreatedAt,
+		&i.ProcessedAt,
+	)
+	return i, err
 }
 
 const findProductById = `-- name: FindProductById :one
-SELECT id, name, description, category_id, extension, enabled, pricing, settings, stock, stock_control FROM products WHERE id = $1
+SELECT id, name, description, category_id, extension, enabled, pricing, settings, stock, stock_control, dunning FROM products WHERE id = $1
 `
 
 func (q *Queries) FindProductById(ctx context.Context, id int32) (Product, error) {
@@ -703,6 +1779,7 @@ func (q *Queries) FindProductById(ctx context.Context, id int32) (Product, error
 		&i.Settings,
 		&i.Stock,
 		&i.StockControl,
+		&i.Dunning,
 	)
 	return i, err
 }
@@ -740,7 +1817,7 @@ func (q *Queries) FindProductOptionsByProduct(ctx context.Context, productID int
 }
 
 const findProductsByCategory = `-- name: FindProductsByCategory :many
-SELECT id, name, description, category_id, extension, enabled, pricing, settings, stock, stock_control FROM products WHERE category_id = $1 ORDER BY id
+SELECT id, name, description, category_id, extension, enabled, pricing, settings, stock, stock_control, dunning FROM products WHERE category_id = $1 ORDER BY id
 `
 
 func (q *Queries) FindProductsByCategory(ctx context.Context, categoryID int32) ([]Product, error) {
@@ -763,6 +1840,7 @@ func (q *Queries) FindProductsByCategory(ctx context.Context, categoryID int32)
 			&i.Settings,
 			&i.Stock,
 			&i.StockControl,
+			&i.Dunning,
 		); err != nil {
 			return nil, err
 		}
@@ -791,7 +1869,7 @@ func (q *Queries) FindServerById(ctx context.Context, id int32) (Server, error)
 }
 
 const findServiceById = `-- name: FindServiceById :one
-SELECT id, label, user_id, status, cancellation_reason, billing_cycle, price, extension, settings, expires_at, created_at, cancelled_at FROM services WHERE id = $1
+SELECT id, label, user_id, status, cancellation_reason, billing_cycle, price, extension, settings, expires_at, created_at, cancelled_at, product_id, coupon_id, currency, status_reason FROM services WHERE id = $1
 `
 
 func (q *Queries) FindServiceById(ctx context.Context, id int32) (Service, error) {
@@ -810,12 +1888,16 @@ func (q *Queries) FindServiceById(ctx context.Context, id int32) (Service, error
 		&i.ExpiresAt,
 		&i.CreatedAt,
 		&i.CancelledAt,
+		&i.ProductID,
+		&i.CouponID,
+		&i.Currency,
+		&i.StatusReason,
 	)
 	return i, err
 }
 
 const findServiceByIdForUpdate = `-- name: FindServiceByIdForUpdate :one
-SELECT id, label, user_id, status, cancellation_reason, billing_cycle, price, extension, settings, expires_at, created_at, cancelled_at FROM services WHERE id = $1 FOR UPDATE
+SELECT id, label, user_id, status, cancellation_reason, billing_cycle, price, extension, settings, expires_at, created_at, cancelled_at, product_id, coupon_id, currency, status_reason FROM services WHERE id = $1 FOR UPDATE
 `
 
 func (q *Queries) FindServiceByIdForUpdate(ctx context.Context, id int32) (Service, error) {
@@ -834,12 +1916,16 @@ func (q *Queries) FindServiceByIdForUpdate(ctx context.Context, id int32) (Servi
 		&i.ExpiresAt,
 		&i.CreatedAt,
 		&i.CancelledAt,
+		&i.ProductID,
+		&i.CouponID,
+		&i.Currency,
+		&i.StatusReason,
 	)
 	return i, err
 }
 
 const findServiceByIdWithName = `-- name: FindServiceByIdWithName :one
-SELECT services.id, services.label, services.user_id, services.status, services.cancellation_reason, services.billing_cycle, services.price, services.extension, services.settings, services.expires_at, services.created_at, services.cancelled_at, users.name FROM services INNER JOIN users ON services.user_id = users.id WHERE services.id = $1
+SELECT services.id, services.label, services.user_id, services.status, services.cancellation_reason, services.billing_cycle, services.price, services.extension, services.settings, services.expires_at, services.created_at, services.cancelled_at, services.product_id, services.coupon_id, services.currency, services.status_reason, users.name FROM services INNER JOIN users ON services.user_id = users.id WHERE services.id = $1
 `
 
 type FindServiceByIdWithNameRow struct {
@@ -855,6 +1941,10 @@ type FindServiceByIdWithNameRow struct {
 	ExpiresAt          types.Timestamp       `json:"expires_at"`
 	CreatedAt          types.Timestamp       `json:"created_at"`
 	CancelledAt        types.Timestamp       `json:"cancelled_at"`
+	ProductID          pgtype.Int4           `json:"product_id"`
+	CouponID           pgtype.Int4           `json:"coupon_id"`
+	Currency           string                `json:"currency"`
+	StatusReason       string                `json:"status_reason"`
 	Name               string                `json:"name"`
 }
 
@@ -874,6 +1964,10 @@ func (q *Queries) FindServiceByIdWithName(ctx context.Context, id int32) (FindSe
 		&i.ExpiresAt,
 		&i.CreatedAt,
 		&i.CancelledAt,
+		&i.ProductID,
+		&i.CouponID,
+		&i.Currency,
+		&i.StatusReason,
 		&i.Name,
 	)
 	return i, err
@@ -881,7 +1975,7 @@ func (q *Queries) FindServiceByIdWithName(ctx context.Context, id int32) (FindSe
 
 const findServiceByUser = `-- name: FindServiceByUser :many
 
-SELECT id, label, user_id, status, cancellation_reason, billing_cycle, price, extension, settings, expires_at, created_at, cancelled_at FROM services WHERE user_id = $1 ORDER BY id DESC
+SELECT id, label, user_id, status, cancellation_reason, billing_cycle, price, extension, settings, expires_at, created_at, cancelled_at, product_id, coupon_id, currency, status_reason FROM services WHERE user_id = $1 ORDER BY id DESC
 `
 
 // SERVICES --
@@ -907,6 +2001,10 @@ func (q *Queries) FindServiceByUser(ctx context.Context, userID int32) ([]Servic
 			&i.ExpiresAt,
 			&i.CreatedAt,
 			&i.CancelledAt,
+			&i.ProductID,
+			&i.CouponID,
+			&i.Currency,
+			&i.StatusReason,
 		); err != nil {
 			return nil, err
 		}
@@ -918,17 +2016,82 @@ func (q *Queries) FindServiceByUser(ctx context.Context, userID int32) ([]Servic
 	return items, nil
 }
 
-const findServicesForRenewal = `-- name: FindServicesForRenewal :many
-SELECT id, label, user_id, status, cancellation_reason, billing_cycle, price, extension, settings, expires_at, created_at, cancelled_at FROM services 
-WHERE (status = 'ACTIVE' OR status = 'SUSPENDED' OR status = 'PENDING') 
-AND expires_at <= (CURRENT_TIMESTAMP + interval '7 days') AND expires_at > CURRENT_TIMESTAMP
-AND NOT EXISTS (
-    SELECT 1 FROM invoices 
+const findServiceUpgradeByIdForUpdate = `-- name: FindServiceUpgradeByIdForUpdate :one
+SELECT id, service_id, product_id, settings, price, amount, status, invoice_id, created_at, expires_at FROM service_upgrades WHERE id = $1 FOR UPDATE
+`
+
+func (q *Queries) FindServiceUpgradeByIdForUpdate(ctx context.Context, id int32) (ServiceUpgrade, error) {
+	row := q.db.QueryRow(ctx, findServiceUpgradeByIdForUpdate, id)
+	var i ServiceUpgrade
+	err := row.Scan(
+		&i.ID,
+		&i.ServiceID,
+		&i.ProductID,
+		&i.Settings,
+		&i.Price,
+		&i.Amount,
+		&i.Status,
+		&i.InvoiceID,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const findServicesForDunning = `-- name: FindServicesForDunning :many
+SELECT id, label, user_id, status, cancellation_reason, billing_cycle, price, extension, settings, expires_at, created_at, cancelled_at, product_id, coupon_id, currency, status_reason FROM services WHERE (status = 'SUSPENDED' OR status = 'ACTIVE' OR status = 'PENDING') AND expires_at <= $1
+AND NOT EXISTS (SELECT 1 FROM cancellation_requests WHERE cancellation_requests.service_id = services.id AND cancellation_requests.status = 'PENDING') ORDER BY id
+`
+
+func (q *Queries) FindServicesForDunning(ctx context.Context, expiresAt types.Timestamp) ([]Service, error) {
+	rows, err := q.db.Query(ctx, findServicesForDunning, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Service{}
+	for rows.Next() {
+		var i Service
+		if err := rows.Scan(
+			&i.ID,
+			&i.Label,
+			&i.UserID,
+			&i.Status,
+			&i.CancellationReason,
+			&i.BillingCycle,
+			&i.Price,
+			&i.Extension,
+			&i.Settings,
+			&i.ExpiresAt,
+			&i.CreatedAt,
+			&i.CancelledAt,
+			&i.ProductID,
+			&i.CouponID,
+			&i.Currency,
+			&i.StatusReason,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const findServicesForRenewal = `-- name: FindServicesForRenewal :many
+SELECT id, label, user_id, status, cancellation_reason, billing_cycle, price, extension, settings, expires_at, created_at, cancelled_at, product_id, coupon_id, currency, status_reason FROM services 
+WHERE (status = 'ACTIVE' OR status = 'SUSPENDED' OR status = 'PENDING') 
+AND expires_at <= (CURRENT_TIMESTAMP + interval '7 days') AND expires_at > CURRENT_TIMESTAMP
+AND NOT EXISTS (
+    SELECT 1 FROM invoices 
     JOIN invoice_items ON invoices.id = invoice_items.invoice_id 
     WHERE invoice_items.item_id = services.id 
     AND invoice_items.type = 'service' 
     AND invoices.status = 'UNPAID'
 )
+AND NOT EXISTS (SELECT 1 FROM cancellation_requests WHERE cancellation_requests.service_id = services.id AND cancellation_requests.status = 'PENDING')
 `
 
 func (q *Queries) FindServicesForRenewal(ctx context.Context) ([]Service, error) {
@@ -953,6 +2116,51 @@ func (q *Queries) FindServicesForRenewal(ctx context.Context) ([]Service, error)
 			&i.ExpiresAt,
 			&i.CreatedAt,
 			&i.CancelledAt,
+			&i.ProductID,
+			&i.CouponID,
+			&i.Currency,
+			&i.StatusReason,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const findServicesSuspendedByDunning = `-- name: FindServicesSuspendedByDunning :many
+SELECT id, label, user_id, status, cancellation_reason, billing_cycle, price, extension, settings, expires_at, created_at, cancelled_at, product_id, coupon_id, currency, status_reason FROM services WHERE status = 'SUSPENDED' AND status_reason LIKE 'suspend: %' AND expires_at > $1 ORDER BY id
+`
+
+func (q *Queries) FindServicesSuspendedByDunning(ctx context.Context, expiresAt types.Timestamp) ([]Service, error) {
+	rows, err := q.db.Query(ctx, findServicesSuspendedByDunning, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Service{}
+	for rows.Next() {
+		var i Service
+		if err := rows.Scan(
+			&i.ID,
+			&i.Label,
+			&i.UserID,
+			&i.Status,
+			&i.CancellationReason,
+			&i.BillingCycle,
+			&i.Price,
+			&i.Extension,
+			&i.Settings,
+			&i.ExpiresAt,
+			&i.CreatedAt,
+			&i.CancelledAt,
+			&i.ProductID,
+			&i.CouponID,
+			&i.Currency,
+			&i.StatusReason,
 		); err != nil {
 			return nil, err
 		}
@@ -966,7 +2174,7 @@ func (q *Queries) FindServicesForRenewal(ctx context.Context) ([]Service, error)
 
 const findSessionByToken = `-- name: FindSessionByToken :one
 
-SELECT id, token, user_id, created_at, expires_at FROM sessions WHERE token = $1 AND expires_at > CURRENT_TIMESTAMP
+SELECT id, token, user_id, created_at, expires_at, user_agent, ip, last_seen_at, impersonator_id FROM sessions WHERE token = $1 AND expires_at > CURRENT_TIMESTAMP
 `
 
 // SESSIONS --
@@ -979,6 +2187,10 @@ func (q *Queries) FindSessionByToken(ctx context.Context, token string) (Session
 		&i.UserID,
 		&i.CreatedAt,
 		&i.ExpiresAt,
+		&i.UserAgent,
+		&i.Ip,
+		&i.LastSeenAt,
+		&i.ImpersonatorID,
 	)
 	return i, err
 }
@@ -996,8 +2208,152 @@ func (q *Queries) FindSettingByKey(ctx context.Context, key string) (Setting, er
 	return i, err
 }
 
+const findStaffRoleById = `-- name: FindStaffRoleById :one
+SELECT id, name, description, permissions FROM staff_roles WHERE id = $1
+`
+
+func (q *Queries) FindStaffRoleById(ctx context.Context, id int32) (StaffRole, error) {
+	row := q.db.QueryRow(ctx, findStaffRoleById, id)
+	var i StaffRole
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+		&i.Permissions,
+	)
+	return i, err
+}
+
+const findTaxRule = `-- name: FindTaxRule :one
+SELECT id, name, country, state, rate, reverse_charge, created_at FROM tax_rules WHERE UPPER(country) = UPPER($1::text) AND (state = '' OR UPPER(state) = UPPER($2::text)) ORDER BY state DESC LIMIT 1
+`
+
+type FindTaxRuleParams struct {
+	Country string `json:"country"`
+	State   string `json:"state"`
+}
+
+func (q *Queries) FindTaxRule(ctx context.Context, arg FindTaxRuleParams) (TaxRule, error) {
+	row := q.db.QueryRow(ctx, findTaxRule, arg.Country, arg.State)
+	var i TaxRule
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Country,
+		&i.State,
+		&i.Rate,
+		&i.ReverseCharge,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const findTaxRuleById = `-- name: FindTaxRuleById :one
+SELECT id, name, country, state, rate, reverse_charge, created_at FROM tax_rules WHERE id = $1
+`
+
+func (q *Queries) FindTaxRuleById(ctx context.Context, id int32) (TaxRule, error) {
+	row := q.db.QueryRow(ctx, findTaxRuleById, id)
+	var i TaxRule
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Country,
+		&i.State,
+		&i.Rate,
+		&i.ReverseCharge,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const findTicketAttachment = `-- name: FindTicketAttachment :one
+SELECT ticket_attachments.id, ticket_attachments.message_id, ticket_attachments.filename, ticket_attachments.content_type, ticket_attachments.size, ticket_attachments.data FROM ticket_attachments INNER JOIN ticket_messages ON ticket_messages.id = ticket_attachments.message_id
+WHERE ticket_attachments.id = $1 AND ticket_messages.ticket_id = $2
+`
+
+type FindTicketAttachmentParams struct {
+	ID       int32 `json:"id"`
+	TicketID int32 `json:"ticket_id"`
+}
+
+func (q *Queries) FindTicketAttachment(ctx context.Context, arg FindTicketAttachmentParams) (TicketAttachment, error) {
+	row := q.db.QueryRow(ctx, findTicketAttachment, arg.ID, arg.TicketID)
+	var i TicketAttachment
+	err := row.Scan(
+		&i.ID,
+		&i.MessageID,
+		&i.Filename,
+		&i.ContentType,
+		&i.Size,
+		&i.Data,
+	)
+	return i, err
+}
+
+const findTicketById = `-- name: FindTicketById :one
+SELECT id, user_id, department_id, subject, status, priority, service_id, invoice_id, assigned_to, created_at, updated_at FROM tickets WHERE id = $1
+`
+
+func (q *Queries) FindTicketById(ctx context.Context, id int32) (Ticket, error) {
+	row := q.db.QueryRow(ctx, findTicketById, id)
+	var i Ticket
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.DepartmentID,
+		&i.Subject,
+		&i.Status,
+		&i.Priority,
+		&i.ServiceID,
+		&i.InvoiceID,
+		&i.AssignedTo,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const findTicketByIdForUpdate = `-- name: FindTicketByIdForUpdate :one
+SELECT id, user_id, department_id, subject, status, priority, service_id, invoice_id, assigned_to, created_at, updated_at FROM tickets WHERE id = $1 FOR UPDATE
+`
+
+func (q *Queries) FindTicketByIdForUpdate(ctx context.Context, id int32) (Ticket, error) {
+	row := q.db.QueryRow(ctx, findTicketByIdForUpdate, id)
+	var i Ticket
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.DepartmentID,
+		&i.Subject,
+		&i.Status,
+		&i.Priority,
+		&i.ServiceID,
+		&i.InvoiceID,
+		&i.AssignedTo,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const findTicketDepartmentById = `-- name: FindTicketDepartmentById :one
+SELECT id, name, description FROM ticket_departments WHERE id = $1
+`
+
+func (q *Queries) FindTicketDepartmentById(ctx context.Context, id int32) (TicketDepartment, error) {
+	row := q.db.QueryRow(ctx, findTicketDepartmentById, id)
+	var i TicketDepartment
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Description,
+	)
+	return i, err
+}
+
 const findUserByEmail = `-- name: FindUserByEmail :one
-SELECT id, email, name, role, password, address, city, state, country, zip_code FROM users WHERE email = $1
+SELECT id, email, name, role, password, address, city, state, country, zip_code, vat_number, currency, staff_role_id FROM users WHERE email = $1
 `
 
 func (q *Queries) FindUserByEmail(ctx context.Context, email string) (User, error) {
@@ -1014,12 +2370,15 @@ func (q *Queries) FindUserByEmail(ctx context.Context, email string) (User, erro
 		&i.State,
 		&i.Country,
 		&i.ZipCode,
+		&i.VatNumber,
+		&i.Currency,
+		&i.StaffRoleID,
 	)
 	return i, err
 }
 
 const findUserById = `-- name: FindUserById :one
-SELECT id, email, name, role, password, address, city, state, country, zip_code FROM users WHERE id = $1
+SELECT id, email, name, role, password, address, city, state, country, zip_code, vat_number, currency, staff_role_id FROM users WHERE id = $1
 `
 
 func (q *Queries) FindUserById(ctx context.Context, id int32) (User, error) {
@@ -1036,24 +2395,133 @@ func (q *Queries) FindUserById(ctx context.Context, id int32) (User, error) {
 		&i.State,
 		&i.Country,
 		&i.ZipCode,
+		&i.VatNumber,
+		&i.Currency,
+		&i.StaffRoleID,
 	)
 	return i, err
 }
 
-const listCategories = `-- name: ListCategories :many
-SELECT id, name, description FROM categories ORDER BY id
+const findUserByIdForUpdate = `-- name: FindUserByIdForUpdate :one
+SELECT id, email, name, role, password, address, city, state, country, zip_code, vat_number, currency, staff_role_id FROM users WHERE id = $1 FOR UPDATE
 `
 
-func (q *Queries) ListCategories(ctx context.Context) ([]Category, error) {
-	rows, err := q.db.Query(ctx, listCategories)
+func (q *Queries) FindUserByIdForUpdate(ctx context.Context, id int32) (User, error) {
+	row := q.db.QueryRow(ctx, findUserByIdForUpdate, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.Name,
+		&i.Role,
+		&i.Password,
+		&i.Address,
+		&i.City,
+		&i.State,
+		&i.Country,
+		&i.ZipCode,
+		&i.VatNumber,
+		&i.Currency,
+		&i.StaffRoleID,
+	)
+	return i, err
+}
+
+const findUserTotp = `-- name: FindUserTotp :one
+SELECT user_id, secret, enabled, last_step, created_at FROM user_totp WHERE user_id = $1
+`
+
+func (q *Queries) FindUserTotp(ctx context.Context, userID int32) (UserTotp, error) {
+	row := q.db.QueryRow(ctx, findUserTotp, userID)
+	var i UserTotp
+	err := row.Scan(
+		&i.UserID,
+		&i.Secret,
+		&i.Enabled,
+		&i.LastStep,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const findUserTotpForUpdate = `-- name: FindUserTotpForUpdate :one
+SELECT user_id, secret, enabled, last_step, created_at FROM user_totp WHERE user_id = $1 FOR UPDATE
+`
+
+func (q *Queries) FindUserTotpForUpdate(ctx context.Context, userID int32) (UserTotp, error) {
+	row := q.db.QueryRow(ctx, findUserTotpForUpdate, userID)
+	var i UserTotp
+	err := row.Scan(
+		&i.UserID,
+		&i.Secret,
+		&i.Enabled,
+		&i.LastStep,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const findWebauthnCredentialByCredentialId = `-- name: FindWebauthnCredentialByCredentialId :one
+SELECT id, user_id, name, credential_id, credential, created_at, last_used_at FROM webauthn_credentials WHERE credential_id = $1
+`
+
+func (q *Queries) FindWebauthnCredentialByCredentialId(ctx context.Context, credentialID []byte) (WebauthnCredential, error) {
+	row := q.db.QueryRow(ctx, findWebauthnCredentialByCredentialId, credentialID)
+	var i WebauthnCredential
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.CredentialID,
+		&i.Credential,
+		&i.CreatedAt,
+		&i.LastUsedAt,
+	)
+	return i, err
+}
+
+const getUserCreditBalance = `-- name: GetUserCreditBalance :one
+SELECT COALESCE(SUM(amount::decimal), 0)::decimal FROM credit_transactions WHERE user_id = $1 AND currency = $2
+`
+
+type GetUserCreditBalanceParams struct {
+	UserID   int32  `json:"user_id"`
+	Currency string `json:"currency"`
+}
+
+func (q *Queries) GetUserCreditBalance(ctx context.Context, arg GetUserCreditBalanceParams) (decimal.Decimal, error) {
+	row := q.db.QueryRow(ctx, getUserCreditBalance, arg.UserID, arg.Currency)
+	var column_1 decimal.Decimal
+	err := row.Scan(&column_1)
+	return column_1, err
+}
+
+const listApiKeysByUser = `-- name: ListApiKeysByUser :many
+SELECT id, user_id, name, prefix, key_hash, scopes, allowed_ips, expires_at, last_used_at, last_used_ip, created_at FROM api_keys WHERE user_id = $1 ORDER BY id
+`
+
+func (q *Queries) ListApiKeysByUser(ctx context.Context, userID int32) ([]ApiKey, error) {
+	rows, err := q.db.Query(ctx, listApiKeysByUser, userID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []Category{}
+	items := []ApiKey{}
 	for rows.Next() {
-		var i Category
-		if err := rows.Scan(&i.ID, &i.Name, &i.Description); err != nil {
+		var i ApiKey
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Name,
+			&i.Prefix,
+			&i.KeyHash,
+			&i.Scopes,
+			&i.AllowedIps,
+			&i.ExpiresAt,
+			&i.LastUsedAt,
+			&i.LastUsedIp,
+			&i.CreatedAt,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -1064,25 +2532,668 @@ func (q *Queries) ListCategories(ctx context.Context) ([]Category, error) {
 	return items, nil
 }
 
-const listEnabledGateways = `-- name: ListEnabledGateways :many
-SELECT display_name, name FROM gateways WHERE enabled = true ORDER BY id ASC
+const listCancellationRequestsByService = `-- name: ListCancellationRequestsByService :many
+SELECT id, service_id, user_id, type, reason, status, created_at, processed_at FROM cancellation_requests WHERE service_id = $1 ORDER BY id DESC
 `
 
-type ListEnabledGatewaysRow struct {
-	DisplayName string `json:"display_name"`
-	Name        string `json:"name"`
-}
-
-func (q *Queries) ListEnabledGateways(ctx context.Context) ([]ListEnabledGatewaysRow, error) {
-	rows, err := q.db.Query(ctx, listEnabledGateways)
+func (q *Queries) ListCancellationRequestsByService(ctx context.Context, serviceID int32) ([]CancellationRequest, error) {
+	rows, err := q.db.Query(ctx, listCancellationRequestsByService, serviceID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []ListEnabledGatewaysRow{}
+	items := []CancellationRequest{}
 	for rows.Next() {
-		var i ListEnabledGatewaysRow
-		if err := rows.Scan(&i.DisplayName, &i.Name); err != nil {
+		var i CancellationRequest
+		if err := rows.Scan(
+			&i.ID,
+			&i.ServiceID,
+			&i.UserID,
+			&i.Type,
+			&i.Reason,
+			&i.Status,
+			&i.CreatedAt,
+			&i.ProcessedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listCategories = `-- name: ListCategories :many
+SELECT id, name, description FROM categories ORDER BY id
+`
+
+func (q *Queries) ListCategories(ctx context.Context) ([]Category, error) {
+	rows, err := q.db.Query(ctx, listCategories)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Category{}
+	for rows.Next() {
+		var i Category
+		if err := rows.Scan(&i.ID, &i.Name, &i.Description); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listCoupons = `-- name: ListCoupons :many
+
+SELECT id, code, type, value, recurring, product_ids, durations, max_uses, max_uses_per_user, expires_at, enabled, created_at FROM coupons ORDER BY id DESC
+`
+
+// COUPONS --
+func (q *Queries) ListCoupons(ctx context.Context) ([]Coupon, error) {
+	rows, err := q.db.Query(ctx, listCoupons)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Coupon{}
+	for rows.Next() {
+		var i Coupon
+		if err := rows.Scan(
+			&i.ID,
+			&i.Code,
+			&i.Type,
+			&i.Value,
+			&i.Recurring,
+			&i.ProductIds,
+			&i.Durations,
+			&i.MaxUses,
+			&i.MaxUsesPerUser,
+			&i.ExpiresAt,
+			&i.Enabled,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listCreditTransactionsByUser = `-- name: ListCreditTransactionsByUser :many
+SELECT id, user_id, amount, description, type, invoice_id, created_at, currency FROM credit_transactions WHERE user_id = $1 ORDER BY id DESC
+`
+
+func (q *Queries) ListCreditTransactionsByUser(ctx context.Context, userID int32) ([]CreditTransaction, error) {
+	rows, err := q.db.Query(ctx, listCreditTransactionsByUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []CreditTransaction{}
+	for rows.Next() {
+		var i CreditTransaction
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Amount,
+			&i.Description,
+			&i.Type,
+			&i.InvoiceID,
+			&i.CreatedAt,
+			&i.Currency,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listEmailTemplates = `-- name: ListEmailTemplates :many
+SELECT event, subject, html, text, updated_at FROM email_templates ORDER BY event
+`
+
+func (q *Queries) ListEmailTemplates(ctx context.Context) ([]EmailTemplate, error) {
+	rows, err := q.db.Query(ctx, listEmailTemplates)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []EmailTemplate{}
+	for rows.Next() {
+		var i EmailTemplate
+		if err := rows.Scan(
+			&i.Event,
+			&i.Subject,
+			&i.Html,
+			&i.Text,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listEnabledGateways = `-- name: ListEnabledGateways :many
+SELECT display_name, name FROM gateways WHERE enabled = true ORDER BY id ASC
+`
+
+type ListEnabledGatewaysRow struct {
+	DisplayName string `json:"display_name"`
+	Name        string `json:"name"`
+}
+
+func (q *Queries) ListEnabledGateways(ctx context.Context) ([]ListEnabledGatewaysRow, error) {
+	rows, err := q.db.Query(ctx, listEnabledGateways)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListEnabledGatewaysRow{}
+	for rows.Next() {
+		var i ListEnabledGatewaysRow
+		if err := rows.Scan(&i.DisplayName, &i.Name); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listEnabledProducts = `-- name: ListEnabledProducts :many
+SELECT id, name, description, category_id, extension, enabled, pricing, settings, stock, stock_control, dunning FROM products WHERE enabled ORDER BY id
+`
+
+func (q *Queries) ListEnabledProducts(ctx context.Context) ([]Product, error) {
+	rows, err := q.db.Query(ctx, listEnabledProducts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Product{}
+	for rows.Next() {
+		var i Product
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Description,
+			&i.CategoryID,
+			&i.Extension,
+			&i.Enabled,
+			&i.Pricing,
+			&i.Settings,
+			&i.Stock,
+			&i.StockControl,
+			&i.Dunning,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listExchangeRates = `-- name: ListExchangeRates :many
+
+SELECT currency, rate, updated_at FROM exchange_rates ORDER BY currency
+`
+
+// EXCHANGE RATES --
+func (q *Queries) ListExchangeRates(ctx context.Context) ([]ExchangeRate, error) {
+	rows, err := q.db.Query(ctx, listExchangeRates)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ExchangeRate{}
+	for rows.Next() {
+		var i ExchangeRate
+		if err := rows.Scan(&i.Currency, &i.Rate, &i.UpdatedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listGatewayNames = `-- name: ListGatewayNames :many
+SELECT name FROM gateways ORDER BY id ASC
+`
+
+func (q *Queries) ListGatewayNames(ctx context.Context) ([]string, error) {
+	rows, err := q.db.Query(ctx, listGatewayNames)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		items = append(items, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listGateways = `-- name: ListGateways :many
+
+SELECT id, display_name, name, settings, enabled, fee FROM gateways ORDER BY id ASC
+`
+
+// GATEWAYS --
+func (q *Queries) ListGateways(ctx context.Context) ([]Gateway, error) {
+	rows, err := q.db.Query(ctx, listGateways)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Gateway{}
+	for rows.Next() {
+		var i Gateway
+		if err := rows.Scan(
+			&i.ID,
+			&i.DisplayName,
+			&i.Name,
+			&i.Settings,
+			&i.Enabled,
+			&i.Fee,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listInvoiceItems = `-- name: ListInvoiceItems :many
+SELECT id, invoice_id, description, amount, type, item_id, created_at FROM invoice_items WHERE invoice_id = $1 ORDER BY id
+`
+
+func (q *Queries) ListInvoiceItems(ctx context.Context, invoiceID int32) ([]InvoiceItem, error) {
+	rows, err := q.db.Query(ctx, listInvoiceItems, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []InvoiceItem{}
+	for rows.Next() {
+		var i InvoiceItem
+		if err := rows.Scan(
+			&i.ID,
+			&i.InvoiceID,
+			&i.Description,
+			&i.Amount,
+			&i.Type,
+			&i.ItemID,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listInvoicePayments = `-- name: ListInvoicePayments :many
+SELECT id, invoice_id, created_at, description, amount, reference_id, gateway, refund_of FROM invoice_payments WHERE invoice_id = $1 ORDER BY id ASC
+`
+
+func (q *Queries) ListInvoicePayments(ctx context.Context, invoiceID int32) ([]InvoicePayment, error) {
+	rows, err := q.db.Query(ctx, listInvoicePayments, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []InvoicePayment{}
+	for rows.Next() {
+		var i InvoicePayment
+		if err := rows.Scan(
+			&i.ID,
+			&i.InvoiceID,
+			&i.CreatedAt,
+			&i.Description,
+			&i.Amount,
+			&i.ReferenceID,
+			&i.Gateway,
+			&i.RefundOf,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listLoginLockouts = `-- name: ListLoginLockouts :many
+SELECT key, failures, window_start, lockouts, locked_until FROM login_throttles WHERE key = ANY($1::text[]) AND locked_until > CURRENT_TIMESTAMP ORDER BY locked_until DESC
+`
+
+func (q *Queries) ListLoginLockouts(ctx context.Context, keys []string) ([]LoginThrottle, error) {
+	rows, err := q.db.Query(ctx, listLoginLockouts, keys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []LoginThrottle{}
+	for rows.Next() {
+		var i LoginThrottle
+		if err := rows.Scan(
+			&i.Key,
+			&i.Failures,
+			&i.WindowStart,
+			&i.Lockouts,
+			&i.LockedUntil,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listProducts = `-- name: ListProducts :many
+SELECT id, name, description, category_id, extension, enabled, pricing, settings, stock, stock_control, dunning FROM products ORDER BY id
+`
+
+func (q *Queries) ListProducts(ctx context.Context) ([]Product, error) {
+	rows, err := q.db.Query(ctx, listProducts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Product{}
+	for rows.Next() {
+		var i Product
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Description,
+			&i.CategoryID,
+			&i.Extension,
+			&i.Enabled,
+			&i.Pricing,
+			&i.Settings,
+			&i.Stock,
+			&i.StockControl,
+			&i.Dunning,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listServers = `-- name: ListServers :many
+
+SELECT id, label, extension, settings FROM servers ORDER BY id ASC
+`
+
+// SERVERS --
+func (q *Queries) ListServers(ctx context.Context) ([]Server, error) {
+	rows, err := q.db.Query(ctx, listServers)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Server{}
+	for rows.Next() {
+		var i Server
+		if err := rows.Scan(
+			&i.ID,
+			&i.Label,
+			&i.Extension,
+			&i.Settings,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listServiceDunningSteps = `-- name: ListServiceDunningSteps :many
+SELECT id, service_id, due_at, step, days, invoice_id, created_at FROM service_dunning_steps WHERE service_id = $1 ORDER BY id DESC
+`
+
+func (q *Queries) ListServiceDunningSteps(ctx context.Context, serviceID int32) ([]ServiceDunningStep, error) {
+	rows, err := q.db.Query(ctx, listServiceDunningSteps, serviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ServiceDunningStep{}
+	for rows.Next() {
+		var i ServiceDunningStep
+		if err := rows.Scan(
+			&i.ID,
+			&i.ServiceID,
+			&i.DueAt,
+			&i.Step,
+			&i.Days,
+			&i.InvoiceID,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listServiceUpgrades = `-- name: ListServiceUpgrades :many
+SELECT id, service_id, product_id, settings, price, amount, status, invoice_id, created_at, expires_at FROM service_upgrades WHERE service_id = $1 ORDER BY id DESC
+`
+
+func (q *Queries) ListServiceUpgrades(ctx context.Context, serviceID int32) ([]ServiceUpgrade, error) {
+	rows, err := q.db.Query(ctx, listServiceUpgrades, serviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ServiceUpgrade{}
+	for rows.Next() {
+		var i ServiceUpgrade
+		if err := rows.Scan(
+			&i.ID,
+			&i.ServiceID,
+			&i.ProductID,
+			&i.Settings,
+			&i.Price,
+			&i.Amount,
+			&i.Status,
+			&i.InvoiceID,
+			&i.CreatedAt,
+			&i.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSessionsByUser = `-- name: ListSessionsByUser :many
+SELECT id, token, user_id, created_at, expires_at, user_agent, ip, last_seen_at, impersonator_id FROM sessions WHERE user_id = $1 AND expires_at > CURRENT_TIMESTAMP ORDER BY last_seen_at DESC
+`
+
+func (q *Queries) ListSessionsByUser(ctx context.Context, userID int32) ([]Session, error) {
+	rows, err := q.db.Query(ctx, listSessionsByUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Session{}
+	for rows.Next() {
+		var i Session
+		if err := rows.Scan(
+			&i.ID,
+			&i.Token,
+			&i.UserID,
+			&i.CreatedAt,
+			&i.ExpiresAt,
+			&i.UserAgent,
+			&i.Ip,
+			&i.LastSeenAt,
+			&i.ImpersonatorID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listStaffRoles = `-- name: ListStaffRoles :many
+SELECT id, name, description, permissions FROM staff_roles ORDER BY id
+`
+
+func (q *Queries) ListStaffRoles(ctx context.Context) ([]StaffRole, error) {
+	rows, err := q.db.Query(ctx, listStaffRoles)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []StaffRole{}
+	for rows.Next() {
+		var i StaffRole
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Description,
+			&i.Permissions,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTaxRules = `-- name: ListTaxRules :many
+
+SELECT id, name, country, state, rate, reverse_charge, created_at FROM tax_rules ORDER BY country, state
+`
+
+// TAX RULES --
+func (q *Queries) ListTaxRules(ctx context.Context) ([]TaxRule, error) {
+	rows, err := q.db.Query(ctx, listTaxRules)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []TaxRule{}
+	for rows.Next() {
+		var i TaxRule
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Country,
+			&i.State,
+			&i.Rate,
+			&i.ReverseCharge,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTicketAttachments = `-- name: ListTicketAttachments :many
+SELECT ticket_attachments.id, ticket_attachments.message_id, ticket_attachments.filename, ticket_attachments.content_type, ticket_attachments.size FROM ticket_attachments
+INNER JOIN ticket_messages ON ticket_messages.id = ticket_attachments.message_id WHERE ticket_messages.ticket_id = $1 ORDER BY ticket_attachments.id
+`
+
+type ListTicketAttachmentsRow struct {
+	ID          int32  `json:"id"`
+	MessageID   int32  `json:"message_id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int32  `json:"size"`
+}
+
+func (q *Queries) ListTicketAttachments(ctx context.Context, ticketID int32) ([]ListTicketAttachmentsRow, error) {
+	rows, err := q.db.Query(ctx, listTicketAttachments, ticketID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []ListTicketAttachmentsRow{}
+	for rows.Next() {
+		var i ListTicketAttachmentsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.MessageID,
+			&i.Filename,
+			&i.ContentType,
+			&i.Size,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -1093,30 +3204,23 @@ func (q *Queries) ListEnabledGateways(ctx context.Context) ([]ListEnabledGateway
 	return items, nil
 }
 
-const listEnabledProducts = `-- name: ListEnabledProducts :many
-SELECT id, name, description, category_id, extension, enabled, pricing, settings, stock, stock_control FROM products WHERE enabled ORDER BY id
+const listTicketDepartments = `-- name: ListTicketDepartments :many
+SELECT id, name, description FROM ticket_departments ORDER BY id
 `
 
-func (q *Queries) ListEnabledProducts(ctx context.Context) ([]Product, error) {
-	rows, err := q.db.Query(ctx, listEnabledProducts)
+func (q *Queries) ListTicketDepartments(ctx context.Context) ([]TicketDepartment, error) {
+	rows, err := q.db.Query(ctx, listTicketDepartments)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []Product{}
+	items := []TicketDepartment{}
 	for rows.Next() {
-		var i Product
+		var i TicketDepartment
 		if err := rows.Scan(
 			&i.ID,
 			&i.Name,
 			&i.Description,
-			&i.CategoryID,
-			&i.Extension,
-			&i.Enabled,
-			&i.Pricing,
-			&i.Settings,
-			&i.Stock,
-			&i.StockControl,
 		); err != nil {
 			return nil, err
 		}
@@ -1128,23 +3232,42 @@ func (q *Queries) ListEnabledProducts(ctx context.Context) ([]Product, error) {
 	return items, nil
 }
 
-const listGatewayNames = `-- name: ListGatewayNames :many
-SELECT name FROM gateways ORDER BY id ASC
+const listTicketMessages = `-- name: ListTicketMessages :many
+SELECT ticket_messages.id, ticket_messages.ticket_id, ticket_messages.user_id, ticket_messages.staff, ticket_messages.body, ticket_messages.created_at, users.name AS username FROM ticket_messages INNER JOIN users ON users.id = ticket_messages.user_id
+WHERE ticket_messages.ticket_id = $1 ORDER BY ticket_messages.id
 `
 
-func (q *Queries) ListGatewayNames(ctx context.Context) ([]string, error) {
-	rows, err := q.db.Query(ctx, listGatewayNames)
+type ListTicketMessagesRow struct {
+	ID        int32           `json:"id"`
+	TicketID  int32           `json:"ticket_id"`
+	UserID    int32           `json:"user_id"`
+	Staff     bool            `json:"staff"`
+	Body      string          `json:"body"`
+	CreatedAt types.Timestamp `json:"created_at"`
+	Username  string          `json:"username"`
+}
+
+func (q *Queries) ListTicketMessages(ctx context.Context, ticketID int32) ([]ListTicketMessagesRow, error) {
+	rows, err := q.db.Query(ctx, listTicketMessages, ticketID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []string{}
+	items := []ListTicketMessagesRow{}
 	for rows.Next() {
-		var name string
-		if err := rows.Scan(&name); err != nil {
+		var i ListTicketMessagesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.TicketID,
+			&i.UserID,
+			&i.Staff,
+			&i.Body,
+			&i.CreatedAt,
+			&i.Username,
+		); err != nil {
 			return nil, err
 		}
-		items = append(items, name)
+		items = append(items, i)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -1152,32 +3275,23 @@ func (q *Queries) ListGatewayNames(ctx context.Context) ([]string, error) {
 	return items, nil
 }
 
-const listGateways = `-- name: ListGateways :many
-
-SELECT id, display_name, name, settings, enabled, fee FROM gateways ORDER BY id ASC
+const listUnpaidInvoiceIdsForService = `-- name: ListUnpaidInvoiceIdsForService :many
+SELECT DISTINCT invoices.id FROM invoices INNER JOIN invoice_items ON invoices.id = invoice_items.invoice_id WHERE invoice_items.item_id = $1 AND invoice_items.type = 'service' AND invoices.status = 'UNPAID' ORDER BY invoices.id
 `
 
-// GATEWAYS --
-func (q *Queries) ListGateways(ctx context.Context) ([]Gateway, error) {
-	rows, err := q.db.Query(ctx, listGateways)
+func (q *Queries) ListUnpaidInvoiceIdsForService(ctx context.Context, itemID pgtype.Int4) ([]int32, error) {
+	rows, err := q.db.Query(ctx, listUnpaidInvoiceIdsForService, itemID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []Gateway{}
+	items := []int32{}
 	for rows.Next() {
-		var i Gateway
-		if err := rows.Scan(
-			&i.ID,
-			&i.DisplayName,
-			&i.Name,
-			&i.Settings,
-			&i.Enabled,
-			&i.Fee,
-		); err != nil {
+		var id int32
+		if err := rows.Scan(&id); err != nil {
 			return nil, err
 		}
-		items = append(items, i)
+		items = append(items, id)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -1185,27 +3299,35 @@ func (q *Queries) ListGateways(ctx context.Context) ([]Gateway, error) {
 	return items, nil
 }
 
-const listInvoiceItems = `-- name: ListInvoiceItems :many
-SELECT id, invoice_id, description, amount, type, item_id, created_at FROM invoice_items WHERE invoice_id = $1 ORDER BY id
+const listUsers = `-- name: ListUsers :many
+
+SELECT id, email, name, role, password, address, city, state, country, zip_code, vat_number, currency, staff_role_id FROM users ORDER BY id
 `
 
-func (q *Queries) ListInvoiceItems(ctx context.Context, invoiceID int32) ([]InvoiceItem, error) {
-	rows, err := q.db.Query(ctx, listInvoiceItems, invoiceID)
+// USERS --
+func (q *Queries) ListUsers(ctx context.Context) ([]User, error) {
+	rows, err := q.db.Query(ctx, listUsers)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []InvoiceItem{}
+	items := []User{}
 	for rows.Next() {
-		var i InvoiceItem
+		var i User
 		if err := rows.Scan(
 			&i.ID,
-			&i.InvoiceID,
-			&i.Description,
-			&i.Amount,
-			&i.Type,
-			&i.ItemID,
-			&i.CreatedAt,
+			&i.Email,
+			&i.Name,
+			&i.Role,
+			&i.Password,
+			&i.Address,
+			&i.City,
+			&i.State,
+			&i.Country,
+			&i.ZipCode,
+			&i.VatNumber,
+			&i.Currency,
+			&i.StaffRoleID,
 		); err != nil {
 			return nil, err
 		}
@@ -1217,27 +3339,27 @@ func (q *Queries) ListInvoiceItems(ctx context.Context, invoiceID int32) ([]Invo
 	return items, nil
 }
 
-const listInvoicePayments = `-- name: ListInvoicePayments :many
-SELECT id, invoice_id, created_at, description, amount, reference_id, gateway FROM invoice_payments WHERE invoice_id = $1 ORDER BY id ASC
+const listWebauthnCredentialsByUser = `-- name: ListWebauthnCredentialsByUser :many
+SELECT id, user_id, name, credential_id, credential, created_at, last_used_at FROM webauthn_credentials WHERE user_id = $1 ORDER BY id
 `
 
-func (q *Queries) ListInvoicePayments(ctx context.Context, invoiceID int32) ([]InvoicePayment, error) {
-	rows, err := q.db.Query(ctx, listInvoicePayments, invoiceID)
+func (q *Queries) ListWebauthnCredentialsByUser(ctx context.Context, userID int32) ([]WebauthnCredential, error) {
+	rows, err := q.db.Query(ctx, listWebauthnCredentialsByUser, userID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []InvoicePayment{}
+	items := []WebauthnCredential{}
 	for rows.Next() {
-		var i InvoicePayment
+		var i WebauthnCredential
 		if err := rows.Scan(
 			&i.ID,
-			&i.InvoiceID,
+			&i.UserID,
+			&i.Name,
+			&i.CredentialID,
+			&i.Credential,
 			&i.CreatedAt,
-			&i.Description,
-			&i.Amount,
-			&i.ReferenceID,
-			&i.Gateway,
+			&i.LastUsedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -1249,30 +3371,145 @@ func (q *Queries) ListInvoicePayments(ctx context.Context, invoiceID int32) ([]I
 	return items, nil
 }
 
-const listProducts = `-- name: ListProducts :many
-SELECT id, name, description, category_id, extension, enabled, pricing, settings, stock, stock_control FROM products ORDER BY id
+const lockLoginThrottle = `-- name: LockLoginThrottle :exec
+UPDATE login_throttles SET failures = 0, lockouts = $2, locked_until = $3 WHERE key = $1
 `
 
-func (q *Queries) ListProducts(ctx context.Context) ([]Product, error) {
-	rows, err := q.db.Query(ctx, listProducts)
+type LockLoginThrottleParams struct {
+	Key         string          `json:"key"`
+	Lockouts    int32           `json:"lockouts"`
+	LockedUntil types.Timestamp `json:"locked_until"`
+}
+
+func (q *Queries) LockLoginThrottle(ctx context.Context, arg LockLoginThrottleParams) error {
+	_, err := q.db.Exec(ctx, lockLoginThrottle, arg.Key, arg.Lockouts, arg.LockedUntil)
+	return err
+}
+
+const nextInvoiceNumber = `-- name: NextInvoiceNumber :one
+INSERT INTO invoice_number_sequences (period, last_number) VALUES ($1, 1) ON CONFLICT (period) DO UPDATE SET last_number = invoice_number_sequences.last_number + 1 RETURNING last_number
+`
+
+func (q *Queries) NextInvoiceNumber(ctx context.Context, period int32) (int32, error) {
+	row := q.db.QueryRow(ctx, nextInvoiceNumber, period)
+	var last_number int32
+	err := row.Scan(&last_number)
+	return last_number, err
+}
+
+const recordLoginFailure = `-- name: RecordLoginFailure :one
+INSERT INTO login_throttles (key, failures) VALUES ($1, 1)
+ON CONFLICT (key) DO UPDATE SET
+    failures = CASE WHEN login_throttles.window_start < CURRENT_TIMESTAMP - INTERVAL '15 minutes' THEN 1 ELSE login_throttles.failures + 1 END,
+    window_start = CASE WHEN login_throttles.window_start < CURRENT_TIMESTAMP - INTERVAL '15 minutes' THEN CURRENT_TIMESTAMP ELSE login_throttles.window_start END,
+    lockouts = CASE WHEN login_throttles.locked_until < CURRENT_TIMESTAMP - INTERVAL '1 day' THEN 0 ELSE login_throttles.lockouts END
+RETURNING key, failures, window_start, lockouts, locked_until
+`
+
+func (q *Queries) RecordLoginFailure(ctx context.Context, key string) (LoginThrottle, error) {
+	row := q.db.QueryRow(ctx, recordLoginFailure, key)
+	var i LoginThrottle
+	err := row.Scan(
+		&i.Key,
+		&i.Failures,
+		&i.WindowStart,
+		&i.Lockouts,
+		&i.LockedUntil,
+	)
+	return i, err
+}
+
+const releaseLoginAttempt = `-- name: ReleaseLoginAttempt :exec
+UPDATE login_throttles SET failures = failures - 1 WHERE key = $1 AND failures > 0
+`
+
+func (q *Queries) ReleaseLoginAttempt(ctx context.Context, key string) error {
+	_, err := q.db.Exec(ctx, releaseLoginAttempt, key)
+	return err
+}
+
+const searchAuditLogsCount = `-- name: SearchAuditLogsCount :one
+SELECT COUNT(*) FROM audit_logs
+WHERE ($1::integer = 0 OR $1::integer = actor_id) AND ($2::text = '' OR $2::text = entity_type)
+AND ($3::text = '' OR $3::text = entity_id) AND ($4::text = '' OR $4::text = action)
+`
+
+type SearchAuditLogsCountParams struct {
+	ActorID    int32  `json:"actor_id"`
+	EntityType string `json:"entity_type"`
+	EntityID   string `json:"entity_id"`
+	Action     string `json:"action"`
+}
+
+func (q *Queries) SearchAuditLogsCount(ctx context.Context, arg SearchAuditLogsCountParams) (int64, error) {
+	row := q.db.QueryRow(ctx, searchAuditLogsCount,
+		arg.ActorID,
+		arg.EntityType,
+		arg.EntityID,
+		arg.Action,
+	)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const searchAuditLogsPaged = `-- name: SearchAuditLogsPaged :many
+SELECT audit_logs.id, audit_logs.actor_id, audit_logs.ip, audit_logs.entity_type, audit_logs.entity_id, audit_logs.action, audit_logs.before, audit_logs.after, audit_logs.created_at, COALESCE(users.name, '')::text AS actor_name FROM audit_logs
+LEFT JOIN users ON users.id = audit_logs.actor_id
+WHERE ($3::integer = 0 OR $3::integer = actor_id) AND ($4::text = '' OR $4::text = entity_type)
+AND ($5::text = '' OR $5::text = entity_id) AND ($6::text = '' OR $6::text = action)
+ORDER BY audit_logs.id DESC LIMIT $1 OFFSET $2
+`
+
+type SearchAuditLogsPagedParams struct {
+	Limit      int32  `json:"limit"`
+	Offset     int32  `json:"offset"`
+	ActorID    int32  `json:"actor_id"`
+	EntityType string `json:"entity_type"`
+	EntityID   string `json:"entity_id"`
+	Action     string `json:"action"`
+}
+
+type SearchAuditLogsPagedRow struct {
+	ID         int32           `json:"id"`
+	ActorID    pgtype.Int4     `json:"actor_id"`
+	Ip         string          `json:"ip"`
+	EntityType string          `json:"entity_type"`
+	EntityID   string          `json:"entity_id"`
+	Action     string          `json:"action"`
+	Before     []byte          `json:"before"`
+	After      []byte          `json:"after"`
+	CreatedAt  types.Timestamp `json:"created_at"`
+	ActorName  string          `json:"actor_name"`
+}
+
+func (q *Queries) SearchAuditLogsPaged(ctx context.Context, arg SearchAuditLogsPagedParams) ([]SearchAuditLogsPagedRow, error) {
+	rows, err := q.db.Query(ctx, searchAuditLogsPaged,
+		arg.Limit,
+		arg.Offset,
+		arg.ActorID,
+		arg.EntityType,
+		arg.EntityID,
+		arg.Action,
+	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []Product{}
+	items := []SearchAuditLogsPagedRow{}
 	for rows.Next() {
-		var i Product
+		var i SearchAuditLogsPagedRow
 		if err := rows.Scan(
 			&i.ID,
-			&i.Name,
-			&i.Description,
-			&i.CategoryID,
-			&i.Extension,
-			&i.Enabled,
-			&i.Pricing,
-			&i.Settings,
-			&i.Stock,
-			&i.StockControl,
+			&i.ActorID,
+			&i.Ip,
+			&i.EntityType,
+			&i.EntityID,
+			&i.Action,
+			&i.Before,
+			&i.After,
+			&i.CreatedAt,
+			&i.ActorName,
 		); err != nil {
 			return nil, err
 		}
@@ -1284,26 +3521,63 @@ func (q *Queries) ListProducts(ctx context.Context) ([]Product, error) {
 	return items, nil
 }
 
-const listServers = `-- name: ListServers :many
+const searchCancellationRequestsCount = `-- name: SearchCancellationRequestsCount :one
+SELECT COUNT(*) FROM cancellation_requests WHERE ($1::text = '' OR $1::text = status)
+`
 
-SELECT id, label, extension, settings FROM servers ORDER BY id ASC
+func (q *Queries) SearchCancellationRequestsCount(ctx context.Context, status string) (int64, error) {
+	row := q.db.QueryRow(ctx, searchCancellationRequestsCount, status)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const searchCancellationRequestsPaged = `-- name: SearchCancellationRequestsPaged :many
+SELECT cancellation_requests.id, cancellation_requests.service_id, cancellation_requests.user_id, cancellation_requests.type, cancellation_requests.reason, cancellation_requests.status, cancellation_requests.created_at, cancellation_requests.processed_at, services.label AS service_label, users.name AS username FROM cancellation_requests
+INNER JOIN services ON services.id = cancellation_requests.service_id
+INNER JOIN users ON users.id = cancellation_requests.user_id
+WHERE ($3::text = '' OR $3::text = cancellation_requests.status) ORDER BY cancellation_requests.id DESC LIMIT $1 OFFSET $2
 `
 
-// SERVERS --
-func (q *Queries) ListServers(ctx context.Context) ([]Server, error) {
-	rows, err := q.db.Query(ctx, listServers)
+type SearchCancellationRequestsPagedParams struct {
+	Limit  int32  `json:"limit"`
+	Offset int32  `json:"offset"`
+	Status string `json:"status"`
+}
+
+type SearchCancellationRequestsPagedRow struct {
+	ID           int32           `json:"id"`
+	ServiceID    int32           `json:"service_id"`
+	UserID       int32           `json:"user_id"`
+	Type         string          `json:"type"`
+	Reason       string          `json:"reason"`
+	Status       string          `json:"status"`
+	CreatedAt    types.Timestamp `json:"created_at"`
+	ProcessedAt  types.Timestamp `json:"processed_at"`
+	ServiceLabel string          `json:"service_label"`
+	Username     string          `json:"username"`
+}
+
+func (q *Queries) SearchCancellationRequestsPaged(ctx context.Context, arg SearchCancellationRequestsPagedParams) ([]SearchCancellationRequestsPagedRow, error) {
+	rows, err := q.db.Query(ctx, searchCancellationRequestsPaged, arg.Limit, arg.Offset, arg.Status)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []Server{}
+	items := []SearchCancellationRequestsPagedRow{}
 	for rows.Next() {
-		var i Server
+		var i SearchCancellationRequestsPagedRow
 		if err := rows.Scan(
 			&i.ID,
-			&i.Label,
-			&i.Extension,
-			&i.Settings,
+			&i.ServiceID,
+			&i.UserID,
+			&i.Type,
+			&i.Reason,
+			&i.Status,
+			&i.CreatedAt,
+			&i.ProcessedAt,
+			&i.ServiceLabel,
+			&i.Username,
 		); err != nil {
 			return nil, err
 		}
@@ -1315,32 +3589,67 @@ func (q *Queries) ListServers(ctx context.Context) ([]Server, error) {
 	return items, nil
 }
 
-const listUsers = `-- name: ListUsers :many
+const searchEmailLogsCount = `-- name: SearchEmailLogsCount :one
+SELECT COUNT(*) FROM email_logs WHERE ($1::text = '' OR $1::text = status) AND ($2::text = '' OR $2::text = recipient)
+`
+
+type SearchEmailLogsCountParams struct {
+	Status    string `json:"status"`
+	Recipient string `json:"recipient"`
+}
+
+func (q *Queries) SearchEmailLogsCount(ctx context.Context, arg SearchEmailLogsCountParams) (int64, error) {
+	row := q.db.QueryRow(ctx, searchEmailLogsCount, arg.Status, arg.Recipient)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
 
-SELECT id, email, name, role, password, address, city, state, country, zip_code FROM users ORDER BY id
+const searchEmailLogsPaged = `-- name: SearchEmailLogsPaged :many
+SELECT id, recipient, subject, status, attempts, last_error, created_at, sent_at FROM email_logs WHERE ($3::text = '' OR $3::text = status) AND ($4::text = '' OR $4::text = recipient) ORDER BY id DESC LIMIT $1 OFFSET $2
 `
 
-// USERS --
-func (q *Queries) ListUsers(ctx context.Context) ([]User, error) {
-	rows, err := q.db.Query(ctx, listUsers)
+type SearchEmailLogsPagedParams struct {
+	Limit     int32  `json:"limit"`
+	Offset    int32  `json:"offset"`
+	Status    string `json:"status"`
+	Recipient string `json:"recipient"`
+}
+
+type SearchEmailLogsPagedRow struct {
+	ID        int32           `json:"id"`
+	Recipient string          `json:"recipient"`
+	Subject   string          `json:"subject"`
+	Status    string          `json:"status"`
+	Attempts  int32           `json:"attempts"`
+	LastError string          `json:"last_error"`
+	CreatedAt types.Timestamp `json:"created_at"`
+	SentAt    types.Timestamp `json:"sent_at"`
+}
+
+func (q *Queries) SearchEmailLogsPaged(ctx context.Context, arg SearchEmailLogsPagedParams) ([]SearchEmailLogsPagedRow, error) {
+	rows, err := q.db.Query(ctx, searchEmailLogsPaged,
+		arg.Limit,
+		arg.Offset,
+		arg.Status,
+		arg.Recipient,
+	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	items := []User{}
+	items := []SearchEmailLogsPagedRow{}
 	for rows.Next() {
-		var i User
+		var i SearchEmailLogsPagedRow
 		if err := rows.Scan(
 			&i.ID,
-			&i.Email,
-			&i.Name,
-			&i.Role,
-			&i.Password,
-			&i.Address,
-			&i.City,
-			&i.State,
-			&i.Country,
-			&i.ZipCode,
+			&i.Recipient,
+			&i.Subject,
+			&i.Status,
+			&i.Attempts,
+			&i.LastError,
+			&i.CreatedAt,
+			&i.SentAt,
 		); err != nil {
 			return nil, err
 		}
@@ -1369,7 +3678,7 @@ func (q *Queries) SearchInvoicesCount(ctx context.Context, arg SearchInvoicesCou
 }
 
 const searchInvoicesPaged = `-- name: SearchInvoicesPaged :many
-SELECT id, user_id, status, cancellation_reason, paid_at, due_at, amount, created_at FROM invoices WHERE ($3::text = '' OR $3::text = status) AND ($4::integer = 0 OR $4::integer = user_id) ORDER BY id DESC LIMIT $1 OFFSET $2
+SELECT id, user_id, status, cancellation_reason, paid_at, due_at, amount, created_at, net, tax, tax_name, tax_rate, reverse_charge, currency, number FROM invoices WHERE ($3::text = '' OR $3::text = status) AND ($4::integer = 0 OR $4::integer = user_id) ORDER BY id DESC LIMIT $1 OFFSET $2
 `
 
 type SearchInvoicesPagedParams struct {
@@ -1402,6 +3711,13 @@ func (q *Queries) SearchInvoicesPaged(ctx context.Context, arg SearchInvoicesPag
 			&i.DueAt,
 			&i.Amount,
 			&i.CreatedAt,
+			&i.Net,
+			&i.Tax,
+			&i.TaxName,
+			&i.TaxRate,
+			&i.ReverseCharge,
+			&i.Currency,
+			&i.Number,
 		); err != nil {
 			return nil, err
 		}
@@ -1414,7 +3730,7 @@ func (q *Queries) SearchInvoicesPaged(ctx context.Context, arg SearchInvoicesPag
 }
 
 const searchProduct = `-- name: SearchProduct :many
-SELECT products.id as id, products.name, products.description, products.category_id, products.extension, products.enabled, products.pricing, products.settings, products.stock, products.stock_control, categories.name AS category_name FROM products INNER JOIN categories ON categories.id = products.category_id  WHERE (category_id = $1 OR $1 < 1) ORDER BY products.id
+SELECT products.id as id, products.name, products.description, products.category_id, products.extension, products.enabled, products.pricing, products.settings, products.stock, products.stock_control, products.dunning, categories.name AS category_name FROM products INNER JOIN categories ON categories.id = products.category_id  WHERE (category_id = $1 OR $1 < 1) ORDER BY products.id
 `
 
 type SearchProductRow struct {
@@ -1428,6 +3744,7 @@ type SearchProductRow struct {
 	Settings     types.ProductSettings `json:"settings"`
 	Stock        int32                 `json:"stock"`
 	StockControl int32                 `json:"stock_control"`
+	Dunning      types.DunningPolicy   `json:"dunning"`
 	CategoryName string                `json:"category_name"`
 }
 
@@ -1451,6 +3768,7 @@ func (q *Queries) SearchProduct(ctx context.Context, categoryID int32) ([]Search
 			&i.Settings,
 			&i.Stock,
 			&i.StockControl,
+			&i.Dunning,
 			&i.CategoryName,
 		); err != nil {
 			return nil, err
@@ -1464,7 +3782,7 @@ func (q *Queries) SearchProduct(ctx context.Context, categoryID int32) ([]Search
 }
 
 const searchServicesPaged = `-- name: SearchServicesPaged :many
-SELECT services.label, users.name, services.id, services.status, services.user_id, services.price, services.created_at, services.billing_cycle, services.expires_at FROM services INNER JOIN users ON services.user_id = users.id WHERE ($3::text = '' OR $3::text = label) AND ($4::integer = 0 OR (settings ? 'server' AND (settings->>'server')::integer = $4::integer)) AND ($5::integer = 0 OR $5::integer = user_id) AND ($6::text = '' OR $6::text = status) ORDER BY services.id DESC LIMIT $1 OFFSET $2
+SELECT services.label, users.name, services.id, services.status, services.user_id, services.price, services.created_at, services.billing_cycle, services.expires_at, services.currency FROM services INNER JOIN users ON services.user_id = users.id WHERE ($3::text = '' OR $3::text = label) AND ($4::integer = 0 OR (settings ? 'server' AND (settings->>'server')::integer = $4::integer)) AND ($5::integer = 0 OR $5::integer = user_id) AND ($6::text = '' OR $6::text = status) ORDER BY services.id DESC LIMIT $1 OFFSET $2
 `
 
 type SearchServicesPagedParams struct {
@@ -1486,6 +3804,7 @@ type SearchServicesPagedRow struct {
 	CreatedAt    types.Timestamp `json:"created_at"`
 	BillingCycle int32           `json:"billing_cycle"`
 	ExpiresAt    types.Timestamp `json:"expires_at"`
+	Currency     string          `json:"currency"`
 }
 
 func (q *Queries) SearchServicesPaged(ctx context.Context, arg SearchServicesPagedParams) ([]SearchServicesPagedRow, error) {
@@ -1514,6 +3833,113 @@ func (q *Queries) SearchServicesPaged(ctx context.Context, arg SearchServicesPag
 			&i.CreatedAt,
 			&i.BillingCycle,
 			&i.ExpiresAt,
+			&i.Currency,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const searchTicketsCount = `-- name: SearchTicketsCount :one
+SELECT COUNT(*) FROM tickets
+WHERE ($1::text = '' OR $1::text = status) AND ($2::integer = 0 OR $2::integer = user_id)
+AND ($3::integer = 0 OR $3::integer = department_id) AND ($4::integer = 0 OR $4::integer = assigned_to)
+AND ($5::integer = 0 OR $5::integer = service_id)
+`
+
+type SearchTicketsCountParams struct {
+	Status       string `json:"status"`
+	UserID       int32  `json:"user_id"`
+	DepartmentID int32  `json:"department_id"`
+	AssignedTo   int32  `json:"assigned_to"`
+	ServiceID    int32  `json:"service_id"`
+}
+
+func (q *Queries) SearchTicketsCount(ctx context.Context, arg SearchTicketsCountParams) (int64, error) {
+	row := q.db.QueryRow(ctx, searchTicketsCount,
+		arg.Status,
+		arg.UserID,
+		arg.DepartmentID,
+		arg.AssignedTo,
+		arg.ServiceID,
+	)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const searchTicketsPaged = `-- name: SearchTicketsPaged :many
+SELECT tickets.id, tickets.user_id, tickets.department_id, tickets.subject, tickets.status, tickets.priority, tickets.service_id, tickets.invoice_id, tickets.assigned_to, tickets.created_at, tickets.updated_at, users.name AS username, ticket_departments.name AS department_name FROM tickets
+INNER JOIN users ON users.id = tickets.user_id
+INNER JOIN ticket_departments ON ticket_departments.id = tickets.department_id
+WHERE ($3::text = '' OR $3::text = tickets.status) AND ($4::integer = 0 OR $4::integer = tickets.user_id)
+AND ($5::integer = 0 OR $5::integer = tickets.department_id) AND ($6::integer = 0 OR $6::integer = tickets.assigned_to)
+AND ($7::integer = 0 OR $7::integer = tickets.service_id)
+ORDER BY tickets.updated_at DESC LIMIT $1 OFFSET $2
+`
+
+type SearchTicketsPagedParams struct {
+	Limit        int32  `json:"limit"`
+	Offset       int32  `json:"offset"`
+	Status       string `json:"status"`
+	UserID       int32  `json:"user_id"`
+	DepartmentID int32  `json:"department_id"`
+	AssignedTo   int32  `json:"assigned_to"`
+	ServiceID    int32  `json:"service_id"`
+}
+
+type SearchTicketsPagedRow struct {
+	ID             int32           `json:"id"`
+	UserID         int32           `json:"user_id"`
+	DepartmentID   int32           `json:"department_id"`
+	Subject        string          `json:"subject"`
+	Status         string          `json:"status"`
+	Priority       string          `json:"priority"`
+	ServiceID      pgtype.Int4     `json:"service_id"`
+	InvoiceID      pgtype.Int4     `json:"invoice_id"`
+	AssignedTo     pgtype.Int4     `json:"assigned_to"`
+	CreatedAt      types.Timestamp `json:"created_at"`
+	UpdatedAt      types.Timestamp `json:"updated_at"`
+	Username       string          `json:"username"`
+	DepartmentName string          `json:"department_name"`
+}
+
+func (q *Queries) SearchTicketsPaged(ctx context.Context, arg SearchTicketsPagedParams) ([]SearchTicketsPagedRow, error) {
+	rows, err := q.db.Query(ctx, searchTicketsPaged,
+		arg.Limit,
+		arg.Offset,
+		arg.Status,
+		arg.UserID,
+		arg.DepartmentID,
+		arg.AssignedTo,
+		arg.ServiceID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []SearchTicketsPagedRow{}
+	for rows.Next() {
+		var i SearchTicketsPagedRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.DepartmentID,
+			&i.Subject,
+			&i.Status,
+			&i.Priority,
+			&i.ServiceID,
+			&i.InvoiceID,
+			&i.AssignedTo,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Username,
+			&i.DepartmentName,
 		); err != nil {
 			return nil, err
 		}
@@ -1537,7 +3963,7 @@ func (q *Queries) SearchUsersCount(ctx context.Context, search string) (int64, e
 }
 
 const searchUsersPaged = `-- name: SearchUsersPaged :many
-SELECT id, email, name, role, password, address, city, state, country, zip_code FROM users WHERE position($3::text in email)>0 OR position($3::text in name)>0 ORDER BY id LIMIT $1 OFFSET $2
+SELECT id, email, name, role, password, address, city, state, country, zip_code, vat_number, currency, staff_role_id FROM users WHERE position($3::text in email)>0 OR position($3::text in name)>0 ORDER BY id LIMIT $1 OFFSET $2
 `
 
 type SearchUsersPagedParams struct {
@@ -1566,6 +3992,9 @@ func (q *Queries) SearchUsersPaged(ctx context.Context, arg SearchUsersPagedPara
 			&i.State,
 			&i.Country,
 			&i.ZipCode,
+			&i.VatNumber,
+			&i.Currency,
+			&i.StaffRoleID,
 		); err != nil {
 			return nil, err
 		}
@@ -1578,7 +4007,7 @@ func (q *Queries) SearchUsersPaged(ctx context.Context, arg SearchUsersPagedPara
 }
 
 const selectInvoiceForUpdate = `-- name: SelectInvoiceForUpdate :one
-SELECT id, user_id, status, cancellation_reason, paid_at, due_at, amount, created_at FROM invoices WHERE id = $1 FOR UPDATE
+SELECT id, user_id, status, cancellation_reason, paid_at, due_at, amount, created_at, net, tax, tax_name, tax_rate, reverse_charge, currency, number FROM invoices WHERE id = $1 FOR UPDATE
 `
 
 func (q *Queries) SelectInvoiceForUpdate(ctx context.Context, id int32) (Invoice, error) {
@@ -1593,12 +4022,39 @@ func (q *Queries) SelectInvoiceForUpdate(ctx context.Context, id int32) (Invoice
 		&i.DueAt,
 		&i.Amount,
 		&i.CreatedAt,
+		&i.Net,
+		&i.Tax,
+		&i.TaxName,
+		&i.TaxRate,
+		&i.ReverseCharge,
+		&i.Currency,
+		&i.Number,
+	)
+	return i, err
+}
+
+const selectInvoicePaymentForUpdate = `-- name: SelectInvoicePaymentForUpdate :one
+SELECT id, invoice_id, created_at, description, amount, reference_id, gateway, refund_of FROM invoice_payments WHERE id = $1 FOR UPDATE
+`
+
+func (q *Queries) SelectInvoicePaymentForUpdate(ctx context.Context, id int32) (InvoicePayment, error) {
+	row := q.db.QueryRow(ctx, selectInvoicePaymentForUpdate, id)
+	var i InvoicePayment
+	err := row.Scan(
+		&i.ID,
+		&i.InvoiceID,
+		&i.CreatedAt,
+		&i.Description,
+		&i.Amount,
+		&i.ReferenceID,
+		&i.Gateway,
+		&i.RefundOf,
 	)
 	return i, err
 }
 
 const totalInvoicePayment = `-- name: TotalInvoicePayment :one
-SELECT SUM(amount::decimal)::decimal FROM invoice_payments WHERE invoice_id = $1
+SELECT COALESCE(SUM(amount::decimal), 0)::decimal FROM invoice_payments WHERE invoice_id = $1
 `
 
 func (q *Queries) TotalInvoicePayment(ctx context.Context, invoiceID int32) (decimal.Decimal, error) {
@@ -1608,6 +4064,48 @@ func (q *Queries) TotalInvoicePayment(ctx context.Context, invoiceID int32) (dec
 	return column_1, err
 }
 
+const totalRefundedForPayment = `-- name: TotalRefundedForPayment :one
+SELECT COALESCE(-SUM(amount::decimal), 0)::decimal FROM invoice_payments WHERE refund_of = $1
+`
+
+func (q *Queries) TotalRefundedForPayment(ctx context.Context, refundOf pgtype.Int4) (decimal.Decimal, error) {
+	row := q.db.QueryRow(ctx, totalRefundedForPayment, refundOf)
+	var column_1 decimal.Decimal
+	err := row.Scan(&column_1)
+	return column_1, err
+}
+
+const updateApiKeyUsed = `-- name: UpdateApiKeyUsed :exec
+UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP, last_used_ip = $2 WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - INTERVAL '1 minute')
+`
+
+type UpdateApiKeyUsedParams struct {
+	ID         int32       `json:"id"`
+	LastUsedIp pgtype.Text `json:"last_used_ip"`
+}
+
+func (q *Queries) UpdateApiKeyUsed(ctx context.Context, arg UpdateApiKeyUsedParams) error {
+	_, err := q.db.Exec(ctx, updateApiKeyUsed, arg.ID, arg.LastUsedIp)
+	return err
+}
+
+const updateCancellationRequestStatus = `-- name: UpdateCancellationRequestStatus :execrows
+UPDATE cancellation_requests SET status = $2::text, processed_at = CURRENT_TIMESTAMP WHERE id = $1 AND status = 'PENDING'
+`
+
+type UpdateCancellationRequestStatusParams struct {
+	ID        int32  `json:"id"`
+	NewStatus string `json:"new_status"`
+}
+
+func (q *Queries) UpdateCancellationRequestStatus(ctx context.Context, arg UpdateCancellationRequestStatusParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateCancellationRequestStatus, arg.ID, arg.NewStatus)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const updateCategory = `-- name: UpdateCategory :exec
 UPDATE categories SET name = $1, description = $2 WHERE id = $3
 `
@@ -1623,6 +4121,93 @@ func (q *Queries) UpdateCategory(ctx context.Context, arg UpdateCategoryParams)
 	return err
 }
 
+const updateCoupon = `-- name: UpdateCoupon :exec
+UPDATE coupons SET code = $1, type = $2, value = $3, recurring = $4, product_ids = $5, durations = $6, max_uses = $7, max_uses_per_user = $8, expires_at = $9, enabled = $10 WHERE id = $11
+`
+
+type UpdateCouponParams struct {
+	Code           string          `json:"code"`
+	Type           string          `json:"type"`
+	Value          decimal.Decimal `json:"value"`
+	Recurring      bool            `json:"recurring"`
+	ProductIds     []int32         `json:"product_ids"`
+	Durations      []int32         `json:"durations"`
+	MaxUses        int32           `json:"max_uses"`
+	MaxUsesPerUser int32           `json:"max_uses_per_user"`
+	ExpiresAt      types.Timestamp `json:"expires_at"`
+	Enabled        bool            `json:"enabled"`
+	ID             int32           `json:"id"`
+}
+
+func (q *Queries) UpdateCoupon(ctx context.Context, arg UpdateCouponParams) error {
+	_, err := q.db.Exec(ctx, updateCoupon,
+		arg.Code,
+		arg.Type,
+		arg.Value,
+		arg.Recurring,
+		arg.ProductIds,
+		arg.Durations,
+		arg.MaxUses,
+		arg.MaxUsesPerUser,
+		arg.ExpiresAt,
+		arg.Enabled,
+		arg.ID,
+	)
+	return err
+}
+
+const updateEmailLogFailed = `-- name: UpdateEmailLogFailed :exec
+UPDATE email_logs SET status = $2, attempts = attempts + 1, last_error = $3,
+    html = CASE WHEN sensitive AND $2 = 'FAILED' THEN '' ELSE html END, text = CASE WHEN sensitive AND $2 = 'FAILED' THEN '' ELSE text END WHERE id = $1
+`
+
+type UpdateEmailLogFailedParams struct {
+	ID        int32  `json:"id"`
+	Status    string `json:"status"`
+	LastError string `json:"last_error"`
+}
+
+func (q *Queries) UpdateEmailLogFailed(ctx context.Context, arg UpdateEmailLogFailedParams) error {
+	_, err := q.db.Exec(ctx, updateEmailLogFailed, arg.ID, arg.Status, arg.LastError)
+	return err
+}
+
+const updateEmailLogQueued = `-- name: UpdateEmailLogQueued :exec
+UPDATE email_logs SET status = 'QUEUED' WHERE id = $1
+`
+
+func (q *Queries) UpdateEmailLogQueued(ctx context.Context, id int32) error {
+	_, err := q.db.Exec(ctx, updateEmailLogQueued, id)
+	return err
+}
+
+const updateEmailLogSent = `-- name: UpdateEmailLogSent :exec
+UPDATE email_logs SET status = 'SENT', attempts = attempts + 1, last_error = '', sent_at = CURRENT_TIMESTAMP,
+    html = CASE WHEN sensitive THEN '' ELSE html END, text = CASE WHEN sensitive THEN '' ELSE text END WHERE id = $1
+`
+
+func (q *Queries) UpdateEmailLogSent(ctx context.Context, id int32) error {
+	_, err := q.db.Exec(ctx, updateEmailLogSent, id)
+	return err
+}
+
+const updateEmailLogsDiscarded = `-- name: UpdateEmailLogsDiscarded :execrows
+UPDATE email_logs SET status = 'FAILED', last_error = CASE WHEN last_error = '' THEN 'the job was discarded' ELSE last_error END,
+    html = CASE WHEN sensitive THEN '' ELSE html END, text = CASE WHEN sensitive THEN '' ELSE text END
+WHERE status = 'QUEUED' AND NOT EXISTS (
+    SELECT 1 FROM river_job WHERE river_job.kind = 'email' AND (river_job.args->>'log_id')::int = email_logs.id
+    AND river_job.state IN ('available', 'pending', 'running', 'retryable', 'scheduled')
+)
+`
+
+func (q *Queries) UpdateEmailLogsDiscarded(ctx context.Context) (int64, error) {
+	result, err := q.db.Exec(ctx, updateEmailLogsDiscarded)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const updateGateway = `-- name: UpdateGateway :exec
 UPDATE gateways SET display_name = $1, settings = $2, enabled = $3, fee = $4 WHERE name = $5
 `
@@ -1669,15 +4254,6 @@ func (q *Queries) UpdateInvoice(ctx context.Context, arg UpdateInvoiceParams) er
 	return err
 }
 
-const updateInvoiceAmount = `-- name: UpdateInvoiceAmount :exec
-UPDATE invoices SET amount = (SELECT SUM(amount) FROM invoice_items WHERE invoice_id = $1) WHERE id = $1
-`
-
-func (q *Queries) UpdateInvoiceAmount(ctx context.Context, invoiceID int32) error {
-	_, err := q.db.Exec(ctx, updateInvoiceAmount, invoiceID)
-	return err
-}
-
 const updateInvoiceCancelled = `-- name: UpdateInvoiceCancelled :exec
 UPDATE invoices SET status = 'CANCELLED', cancellation_reason = $1 WHERE id = $2
 `
@@ -1713,6 +4289,20 @@ func (q *Queries) UpdateInvoiceItem(ctx context.Context, arg UpdateInvoiceItemPa
 	return err
 }
 
+const updateInvoiceNumber = `-- name: UpdateInvoiceNumber :exec
+UPDATE invoices SET number = $2 WHERE id = $1
+`
+
+type UpdateInvoiceNumberParams struct {
+	ID     int32       `json:"id"`
+	Number pgtype.Text `json:"number"`
+}
+
+func (q *Queries) UpdateInvoiceNumber(ctx context.Context, arg UpdateInvoiceNumberParams) error {
+	_, err := q.db.Exec(ctx, updateInvoiceNumber, arg.ID, arg.Number)
+	return err
+}
+
 const updateInvoicePaid = `-- name: UpdateInvoicePaid :exec
 UPDATE invoices SET status = 'PAID', paid_at = CURRENT_TIMESTAMP WHERE id = $1
 `
@@ -1722,8 +4312,44 @@ func (q *Queries) UpdateInvoicePaid(ctx context.Context, id int32) error {
 	return err
 }
 
+const updateInvoiceRefunded = `-- name: UpdateInvoiceRefunded :exec
+UPDATE invoices SET status = 'REFUNDED' WHERE id = $1
+`
+
+func (q *Queries) UpdateInvoiceRefunded(ctx context.Context, id int32) error {
+	_, err := q.db.Exec(ctx, updateInvoiceRefunded, id)
+	return err
+}
+
+const updateInvoiceTotals = `-- name: UpdateInvoiceTotals :exec
+UPDATE invoices SET amount = $2, net = $3, tax = $4, tax_name = $5, tax_rate = $6, reverse_charge = $7 WHERE id = $1
+`
+
+type UpdateInvoiceTotalsParams struct {
+	ID            int32           `json:"id"`
+	Amount        decimal.Decimal `json:"amount"`
+	Net           decimal.Decimal `json:"net"`
+	Tax           decimal.Decimal `json:"tax"`
+	TaxName       string          `json:"tax_name"`
+	TaxRate       decimal.Decimal `json:"tax_rate"`
+	ReverseCharge bool            `json:"reverse_charge"`
+}
+
+func (q *Queries) UpdateInvoiceTotals(ctx context.Context, arg UpdateInvoiceTotalsParams) error {
+	_, err := q.db.Exec(ctx, updateInvoiceTotals,
+		arg.ID,
+		arg.Amount,
+		arg.Net,
+		arg.Tax,
+		arg.TaxName,
+		arg.TaxRate,
+		arg.ReverseCharge,
+	)
+	return err
+}
+
 const updateProduct = `-- name: UpdateProduct :exec
-UPDATE products SET name = $1, description = $2, category_id = $3, extension = $4, enabled = $5, pricing = $6, settings = $7, stock = $8, stock_control = $9 WHERE id = $10
+UPDATE products SET name = $1, description = $2, category_id = $3, extension = $4, enabled = $5, pricing = $6, settings = $7, stock = $8, stock_control = $9, dunning = $10 WHERE id = $11
 `
 
 type UpdateProductParams struct {
@@ -1736,6 +4362,7 @@ type UpdateProductParams struct {
 	Settings     types.ProductSettings `json:"settings"`
 	Stock        int32                 `json:"stock"`
 	StockControl int32                 `json:"stock_control"`
+	Dunning      types.DunningPolicy   `json:"dunning"`
 	ID           int32                 `json:"id"`
 }
 
@@ -1750,6 +4377,7 @@ func (q *Queries) UpdateProduct(ctx context.Context, arg UpdateProductParams) er
 		arg.Settings,
 		arg.Stock,
 		arg.StockControl,
+		arg.Dunning,
 		arg.ID,
 	)
 	return err
@@ -1856,6 +4484,27 @@ func (q *Queries) UpdateServiceLabel(ctx context.Context, arg UpdateServiceLabel
 	return err
 }
 
+const updateServicePlan = `-- name: UpdateServicePlan :exec
+UPDATE services SET product_id = $2, price = $3, settings = $4 WHERE id = $1
+`
+
+type UpdateServicePlanParams struct {
+	ID        int32                 `json:"id"`
+	ProductID pgtype.Int4           `json:"product_id"`
+	Price     decimal.Decimal       `json:"price"`
+	Settings  types.ServiceSettings `json:"settings"`
+}
+
+func (q *Queries) UpdateServicePlan(ctx context.Context, arg UpdateServicePlanParams) error {
+	_, err := q.db.Exec(ctx, updateServicePlan,
+		arg.ID,
+		arg.ProductID,
+		arg.Price,
+		arg.Settings,
+	)
+	return err
+}
+
 const updateServiceSettings = `-- name: UpdateServiceSettings :exec
 UPDATE services SET settings = $1 WHERE id = $2
 `
@@ -1871,7 +4520,7 @@ func (q *Queries) UpdateServiceSettings(ctx context.Context, arg UpdateServiceSe
 }
 
 const updateServiceStatus = `-- name: UpdateServiceStatus :exec
-UPDATE services SET status = $1 WHERE id = $2
+UPDATE services SET status = $1, status_reason = '' WHERE id = $2
 `
 
 type UpdateServiceStatusParams struct {
@@ -1884,6 +4533,49 @@ func (q *Queries) UpdateServiceStatus(ctx context.Context, arg UpdateServiceStat
 	return err
 }
 
+const updateServiceStatusWithReason = `-- name: UpdateServiceStatusWithReason :exec
+UPDATE services SET status = $1, status_reason = $2 WHERE id = $3
+`
+
+type UpdateServiceStatusWithReasonParams struct {
+	Status       string `json:"status"`
+	StatusReason string `json:"status_reason"`
+	ID           int32  `json:"id"`
+}
+
+func (q *Queries) UpdateServiceStatusWithReason(ctx context.Context, arg UpdateServiceStatusWithReasonParams) error {
+	_, err := q.db.Exec(ctx, updateServiceStatusWithReason, arg.Status, arg.StatusReason, arg.ID)
+	return err
+}
+
+const updateServiceUpgradeInvoice = `-- name: UpdateServiceUpgradeInvoice :exec
+UPDATE service_upgrades SET invoice_id = $2 WHERE id = $1
+`
+
+type UpdateServiceUpgradeInvoiceParams struct {
+	ID        int32       `json:"id"`
+	InvoiceID pgtype.Int4 `json:"invoice_id"`
+}
+
+func (q *Queries) UpdateServiceUpgradeInvoice(ctx context.Context, arg UpdateServiceUpgradeInvoiceParams) error {
+	_, err := q.db.Exec(ctx, updateServiceUpgradeInvoice, arg.ID, arg.InvoiceID)
+	return err
+}
+
+const updateServiceUpgradeStatus = `-- name: UpdateServiceUpgradeStatus :exec
+UPDATE service_upgrades SET status = $2 WHERE id = $1
+`
+
+type UpdateServiceUpgradeStatusParams struct {
+	ID     int32  `json:"id"`
+	Status string `json:"status"`
+}
+
+func (q *Queries) UpdateServiceUpgradeStatus(ctx context.Context, arg UpdateServiceUpgradeStatusParams) error {
+	_, err := q.db.Exec(ctx, updateServiceUpgradeStatus, arg.ID, arg.Status)
+	return err
+}
+
 const updateSessionExpiryTime = `-- name: UpdateSessionExpiryTime :exec
 UPDATE sessions SET expires_at = $2 WHERE token = $1
 `
@@ -1898,6 +4590,20 @@ func (q *Queries) UpdateSessionExpiryTime(ctx context.Context, arg UpdateSession
 	return err
 }
 
+const updateSessionLastSeen = `-- name: UpdateSessionLastSeen :exec
+UPDATE sessions SET last_seen_at = CURRENT_TIMESTAMP, ip = $2 WHERE id = $1 AND last_seen_at < CURRENT_TIMESTAMP - INTERVAL '1 minute'
+`
+
+type UpdateSessionLastSeenParams struct {
+	ID int32  `json:"id"`
+	Ip string `json:"ip"`
+}
+
+func (q *Queries) UpdateSessionLastSeen(ctx context.Context, arg UpdateSessionLastSeenParams) error {
+	_, err := q.db.Exec(ctx, updateSessionLastSeen, arg.ID, arg.Ip)
+	return err
+}
+
 const updateSetting = `-- name: UpdateSetting :exec
 INSERT INTO settings (key, value) VALUES ($1, $2) ON CONFLICT ("key") DO UPDATE SET value = EXCLUDED.value
 `
@@ -1912,20 +4618,125 @@ func (q *Queries) UpdateSetting(ctx context.Context, arg UpdateSettingParams) er
 	return err
 }
 
+const updateStaffRole = `-- name: UpdateStaffRole :execrows
+UPDATE staff_roles SET name = $2, description = $3, permissions = $4 WHERE id = $1
+`
+
+type UpdateStaffRoleParams struct {
+	ID          int32    `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+func (q *Queries) UpdateStaffRole(ctx context.Context, arg UpdateStaffRoleParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateStaffRole,
+		arg.ID,
+		arg.Name,
+		arg.Description,
+		arg.Permissions,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const updateTaxRule = `-- name: UpdateTaxRule :exec
+UPDATE tax_rules SET name = $1, country = $2, state = $3, rate = $4, reverse_charge = $5 WHERE id = $6
+`
+
+type UpdateTaxRuleParams struct {
+	Name          string          `json:"name"`
+	Country       string          `json:"country"`
+	State         string          `json:"state"`
+	Rate          decimal.Decimal `json:"rate"`
+	ReverseCharge bool            `json:"reverse_charge"`
+	ID            int32           `json:"id"`
+}
+
+func (q *Queries) UpdateTaxRule(ctx context.Context, arg UpdateTaxRuleParams) error {
+	_, err := q.db.Exec(ctx, updateTaxRule,
+		arg.Name,
+		arg.Country,
+		arg.State,
+		arg.Rate,
+		arg.ReverseCharge,
+		arg.ID,
+	)
+	return err
+}
+
+const updateTicket = `-- name: UpdateTicket :exec
+UPDATE tickets SET department_id = $2, priority = $3, assigned_to = $4, service_id = $5, invoice_id = $6 WHERE id = $1
+`
+
+type UpdateTicketParams struct {
+	ID           int32       `json:"id"`
+	DepartmentID int32       `json:"department_id"`
+	Priority     string      `json:"priority"`
+	AssignedTo   pgtype.Int4 `json:"assigned_to"`
+	ServiceID    pgtype.Int4 `json:"service_id"`
+	InvoiceID    pgtype.Int4 `json:"invoice_id"`
+}
+
+func (q *Queries) UpdateTicket(ctx context.Context, arg UpdateTicketParams) error {
+	_, err := q.db.Exec(ctx, updateTicket,
+		arg.ID,
+		arg.DepartmentID,
+		arg.Priority,
+		arg.AssignedTo,
+		arg.ServiceID,
+		arg.InvoiceID,
+	)
+	return err
+}
+
+const updateTicketDepartment = `-- name: UpdateTicketDepartment :exec
+UPDATE ticket_departments SET name = $1, description = $2 WHERE id = $3
+`
+
+type UpdateTicketDepartmentParams struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	ID          int32  `json:"id"`
+}
+
+func (q *Queries) UpdateTicketDepartment(ctx context.Context, arg UpdateTicketDepartmentParams) error {
+	_, err := q.db.Exec(ctx, updateTicketDepartment, arg.Name, arg.Description, arg.ID)
+	return err
+}
+
+const updateTicketStatus = `-- name: UpdateTicketStatus :exec
+UPDATE tickets SET status = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1
+`
+
+type UpdateTicketStatusParams struct {
+	ID     int32  `json:"id"`
+	Status string `json:"status"`
+}
+
+func (q *Queries) UpdateTicketStatus(ctx context.Context, arg UpdateTicketStatusParams) error {
+	_, err := q.db.Exec(ctx, updateTicketStatus, arg.ID, arg.Status)
+	return err
+}
+
 const updateUser = `-- name: UpdateUser :exec
-UPDATE users SET email = $2, name = $3, role = $4, address = $5, city = $6, state = $7, country = $8, zip_code = $9 WHERE id = $1
+UPDATE users SET email = $2, name = $3, role = $4, address = $5, city = $6, state = $7, country = $8, zip_code = $9, vat_number = $10, currency = $11 WHERE id = $1
 `
 
 type UpdateUserParams struct {
-	ID      int32       `json:"id"`
-	Email   string      `json:"email"`
-	Name    string      `json:"name"`
-	Role    string      `json:"role"`
-	Address pgtype.Text `json:"address"`
-	City    pgtype.Text `json:"city"`
-	State   pgtype.Text `json:"state"`
-	Country pgtype.Text `json:"country"`
-	ZipCode pgtype.Text `json:"zip_code"`
+	ID        int32       `json:"id"`
+	Email     string      `json:"email"`
+	Name      string      `json:"name"`
+	Role      string      `json:"role"`
+	Address   pgtype.Text `json:"address"`
+	City      pgtype.Text `json:"city"`
+	State     pgtype.Text `json:"state"`
+	Country   pgtype.Text `json:"country"`
+	ZipCode   pgtype.Text `json:"zip_code"`
+	VatNumber string      `json:"vat_number"`
+	Currency  string      `json:"currency"`
 }
 
 func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) error {
@@ -1939,6 +4750,8 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) error {
 		arg.State,
 		arg.Country,
 		arg.ZipCode,
+		arg.VatNumber,
+		arg.Currency,
 	)
 	return err
 }
@@ -1958,17 +4771,19 @@ func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPassword
 }
 
 const updateUserProfile = `-- name: UpdateUserProfile :exec
-UPDATE users SET name = $1, address = $2, city = $3, state = $4, country = $5, zip_code = $6 WHERE id = $7
+UPDATE users SET name = $1, address = $2, city = $3, state = $4, country = $5, zip_code = $6, vat_number = $7, currency = $8 WHERE id = $9
 `
 
 type UpdateUserProfileParams struct {
-	Name    string      `json:"name"`
-	Address pgtype.Text `json:"address"`
-	City    pgtype.Text `json:"city"`
-	State   pgtype.Text `json:"state"`
-	Country pgtype.Text `json:"country"`
-	ZipCode pgtype.Text `json:"zip_code"`
-	ID      int32       `json:"id"`
+	Name      string      `json:"name"`
+	Address   pgtype.Text `json:"address"`
+	City      pgtype.Text `json:"city"`
+	State     pgtype.Text `json:"state"`
+	Country   pgtype.Text `json:"country"`
+	ZipCode   pgtype.Text `json:"zip_code"`
+	VatNumber string      `json:"vat_number"`
+	Currency  string      `json:"currency"`
+	ID        int32       `json:"id"`
 }
 
 func (q *Queries) UpdateUserProfile(ctx context.Context, arg UpdateUserProfileParams) error {
@@ -1979,7 +4794,140 @@ func (q *Queries) UpdateUserProfile(ctx context.Context, arg UpdateUserProfilePa
 		arg.State,
 		arg.Country,
 		arg.ZipCode,
+		arg.VatNumber,
+		arg.Currency,
 		arg.ID,
 	)
 	return err
 }
+
+const updateUserStaffRole = `-- name: UpdateUserStaffRole :execrows
+UPDATE users SET staff_role_id = $2 WHERE id = $1
+`
+
+type UpdateUserStaffRoleParams struct {
+	ID          int32       `json:"id"`
+	StaffRoleID pgtype.Int4 `json:"staff_role_id"`
+}
+
+func (q *Queries) UpdateUserStaffRole(ctx context.Context, arg UpdateUserStaffRoleParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateUserStaffRole, arg.ID, arg.StaffRoleID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const updateUserTotpLastStep = `-- name: UpdateUserTotpLastStep :exec
+UPDATE user_totp SET last_step = $2 WHERE user_id = $1
+`
+
+type UpdateUserTotpLastStepParams struct {
+	UserID   int32 `json:"user_id"`
+	LastStep int64 `json:"last_step"`
+}
+
+func (q *Queries) UpdateUserTotpLastStep(ctx context.Context, arg UpdateUserTotpLastStepParams) error {
+	_, err := q.db.Exec(ctx, updateUserTotpLastStep, arg.UserID, arg.LastStep)
+	return err
+}
+
+const updateWebauthnCredentialName = `-- name: UpdateWebauthnCredentialName :execrows
+UPDATE webauthn_credentials SET name = $3 WHERE id = $1 AND user_id = $2
+`
+
+type UpdateWebauthnCredentialNameParams struct {
+	ID     int32  `json:"id"`
+	UserID int32  `json:"user_id"`
+	Name   string `json:"name"`
+}
+
+func (q *Queries) UpdateWebauthnCredentialName(ctx context.Context, arg UpdateWebauthnCredentialNameParams) (int64, error) {
+	result, err := q.db.Exec(ctx, updateWebauthnCredentialName, arg.ID, arg.UserID, arg.Name)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const updateWebauthnCredentialUsed = `-- name: UpdateWebauthnCredentialUsed :exec
+UPDATE webauthn_credentials SET credential = $2, last_used_at = CURRENT_TIMESTAMP WHERE id = $1
+`
+
+type UpdateWebauthnCredentialUsedParams struct {
+	ID         int32  `json:"id"`
+	Credential []byte `json:"credential"`
+}
+
+func (q *Queries) UpdateWebauthnCredentialUsed(ctx context.Context, arg UpdateWebauthnCredentialUsedParams) error {
+	_, err := q.db.Exec(ctx, updateWebauthnCredentialUsed, arg.ID, arg.Credential)
+	return err
+}
+
+const upsertEmailTemplate = `-- name: UpsertEmailTemplate :exec
+INSERT INTO email_templates (event, subject, html, text) VALUES ($1, $2, $3, $4)
+ON CONFLICT (event) DO UPDATE SET subject = EXCLUDED.subject, html = EXCLUDED.html, text = EXCLUDED.text, updated_at = CURRENT_TIMESTAMP
+`
+
+type UpsertEmailTemplateParams struct {
+	Event   string `json:"event"`
+	Subject string `json:"subject"`
+	Html    string `json:"html"`
+	Text    string `json:"text"`
+}
+
+func (q *Queries) UpsertEmailTemplate(ctx context.Context, arg UpsertEmailTemplateParams) error {
+	_, err := q.db.Exec(ctx, upsertEmailTemplate,
+		arg.Event,
+		arg.Subject,
+		arg.Html,
+		arg.Text,
+	)
+	return err
+}
+
+const upsertExchangeRate = `-- name: UpsertExchangeRate :exec
+INSERT INTO exchange_rates (currency, rate) VALUES ($1, $2) ON CONFLICT (currency) DO UPDATE SET rate = EXCLUDED.rate, updated_at = CURRENT_TIMESTAMP
+`
+
+type UpsertExchangeRateParams struct {
+	Currency string          `json:"currency"`
+	Rate     decimal.Decimal `json:"rate"`
+}
+
+func (q *Queries) UpsertExchangeRate(ctx context.Context, arg UpsertExchangeRateParams) error {
+	_, err := q.db.Exec(ctx, upsertExchangeRate, arg.Currency, arg.Rate)
+	return err
+}
+
+const upsertUserTotp = `-- name: UpsertUserTotp :exec
+INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE SET secret = $2, enabled = FALSE, last_step = 0, created_at = CURRENT_TIMESTAMP
+`
+
+type UpsertUserTotpParams struct {
+	UserID int32  `json:"user_id"`
+	Secret string `json:"secret"`
+}
+
+func (q *Queries) UpsertUserTotp(ctx context.Context, arg UpsertUserTotpParams) error {
+	_, err := q.db.Exec(ctx, upsertUserTotp, arg.UserID, arg.Secret)
+	return err
+}
+
+const useUserRecoveryCode = `-- name: UseUserRecoveryCode :execrows
+UPDATE user_recovery_codes SET used_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+`
+
+type UseUserRecoveryCodeParams struct {
+	UserID   int32  `json:"user_id"`
+	CodeHash string `json:"code_hash"`
+}
+
+func (q *Queries) UseUserRecoveryCode(ctx context.Context, arg UseUserRecoveryCodeParams) (int64, error) {
+	result, err := q.db.Exec(ctx, useUserRecoveryCode, arg.UserID, arg.CodeHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS credit_transactions
+(
+    id          SERIAL PRIMARY KEY,
+    user_id     INTEGER        NOT NULL REFERENCES users,
+    amount      DECIMAL(12, 2) NOT NULL,
+    description VARCHAR(200)   NOT NULL,
+    type        VARCHAR(200)   NOT NULL,
+    invoice_id  INTEGER REFERENCES invoices,
+    created_at  TIMESTAMP      NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
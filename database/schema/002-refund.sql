@@ -0,0 +1 @@
+ALTER TABLE invoice_payments ADD COLUMN IF NOT EXISTS refund_of INTEGER REFERENCES invoice_payments;
@@ -0,0 +1,11 @@
+-- a gateway reference (capture id, payment intent id, refund id...) can only be recorded once,
+-- so that a payment reported by both the browser redirect and a webhook is not added twice.
+-- payments without a reference (e.g. added by admins) are not restricted.
+
+-- payments recorded twice before the index existed keep their amount, but the later copies get a
+-- distinct reference so that the index can be created. They can be found by "#duplicate-" and refunded.
+UPDATE invoice_payments p SET reference_id = LEFT(p.reference_id, 170) || '#duplicate-' || p.id
+WHERE p.reference_id <> ''
+  AND EXISTS (SELECT 1 FROM invoice_payments o WHERE o.gateway = p.gateway AND o.reference_id = p.reference_id AND o.id < p.id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS invoice_payments_gateway_reference_id ON invoice_payments (gateway, reference_id) WHERE reference_id <> '';
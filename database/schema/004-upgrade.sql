@@ -0,0 +1,14 @@
+ALTER TABLE services ADD COLUMN IF NOT EXISTS product_id INTEGER REFERENCES products ON DELETE SET NULL;
+
+CREATE TABLE IF NOT EXISTS service_upgrades
+(
+    id           SERIAL PRIMARY KEY,
+    service_id   INTEGER        NOT NULL REFERENCES services ON DELETE CASCADE,
+    product_id   INTEGER        NOT NULL REFERENCES products ON DELETE CASCADE,
+    settings     JSONB          NOT NULL,
+    price        DECIMAL(12, 2) NOT NULL,
+    amount       DECIMAL(12, 2) NOT NULL,
+    status       VARCHAR(200)   NOT NULL,
+    invoice_id   INTEGER REFERENCES invoices,
+    created_at   TIMESTAMP      NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
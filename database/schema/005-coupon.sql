@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS coupons
+(
+    id                SERIAL PRIMARY KEY,
+    code              VARCHAR(200)   NOT NULL UNIQUE,
+    type              VARCHAR(200)   NOT NULL,
+    value             DECIMAL(12, 2) NOT NULL,
+    recurring         BOOLEAN        NOT NULL,
+    product_ids       INTEGER[]      NOT NULL,
+    durations         INTEGER[]      NOT NULL,
+    max_uses          INTEGER        NOT NULL,
+    max_uses_per_user INTEGER        NOT NULL,
+    expires_at        TIMESTAMP,
+    enabled           BOOLEAN        NOT NULL,
+    created_at        TIMESTAMP      NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS coupon_uses
+(
+    id         SERIAL PRIMARY KEY,
+    coupon_id  INTEGER   NOT NULL REFERENCES coupons ON DELETE CASCADE,
+    user_id    INTEGER   NOT NULL REFERENCES users,
+    service_id INTEGER REFERENCES services ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE services ADD COLUMN IF NOT EXISTS coupon_id INTEGER REFERENCES coupons ON DELETE SET NULL;
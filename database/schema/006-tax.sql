@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS tax_rules
+(
+    id             SERIAL PRIMARY KEY,
+    name           VARCHAR(200)  NOT NULL,
+    country        VARCHAR(200)  NOT NULL,
+    state          VARCHAR(200)  NOT NULL,
+    rate           DECIMAL(6, 3) NOT NULL,
+    reverse_charge BOOLEAN       NOT NULL,
+    created_at     TIMESTAMP     NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (country, state)
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS vat_number VARCHAR(200) NOT NULL DEFAULT '';
+
+-- amount is the gross amount of the invoice
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS net DECIMAL(12, 2);
+UPDATE invoices SET net = amount WHERE net IS NULL;
+ALTER TABLE invoices ALTER COLUMN net SET NOT NULL;
+ALTER TABLE invoices ALTER COLUMN net SET DEFAULT 0;
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS tax DECIMAL(12, 2) NOT NULL DEFAULT 0;
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS tax_name VARCHAR(200) NOT NULL DEFAULT '';
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS tax_rate DECIMAL(6, 3) NOT NULL DEFAULT 0;
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS reverse_charge BOOLEAN NOT NULL DEFAULT FALSE;
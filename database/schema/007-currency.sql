@@ -0,0 +1,13 @@
+-- rate is the amount of the currency that equals 1 unit of the base currency
+CREATE TABLE IF NOT EXISTS exchange_rates
+(
+    currency   VARCHAR(3)     PRIMARY KEY,
+    rate       DECIMAL(18, 8) NOT NULL,
+    updated_at TIMESTAMP      NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- existing rows were created before multi-currency support, when everything was in USD
+ALTER TABLE users ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'USD';
+ALTER TABLE services ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'USD';
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'USD';
+ALTER TABLE credit_transactions ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'USD';
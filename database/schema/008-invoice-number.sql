@@ -0,0 +1,10 @@
+-- legal invoice number, assigned when the invoice is issued or paid depending on the invoice_number_assign setting
+ALTER TABLE invoices ADD COLUMN IF NOT EXISTS number VARCHAR(64) NULL UNIQUE;
+
+-- last invoice number used in each period, period is the year if numbers reset yearly, otherwise 0.
+-- the row is locked by the transaction that assigns a number, so numbers are gap-free.
+CREATE TABLE IF NOT EXISTS invoice_number_sequences
+(
+    period      INTEGER PRIMARY KEY,
+    last_number INTEGER NOT NULL
+);
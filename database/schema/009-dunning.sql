@@ -0,0 +1,16 @@
+-- overrides the global dunning settings, see types.DunningPolicy
+ALTER TABLE products ADD COLUMN IF NOT EXISTS dunning JSONB NOT NULL DEFAULT '{}';
+
+-- dunning steps taken for a service. due_at is the expiry date of the service when the step was taken,
+-- so each step is taken once per billing period.
+CREATE TABLE IF NOT EXISTS service_dunning_steps
+(
+    id         SERIAL PRIMARY KEY,
+    service_id INTEGER     NOT NULL REFERENCES services ON DELETE CASCADE,
+    due_at     TIMESTAMP   NOT NULL,
+    step       VARCHAR(20) NOT NULL,
+    days       INTEGER     NOT NULL,
+    invoice_id INTEGER REFERENCES invoices ON DELETE SET NULL,
+    created_at TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (service_id, due_at, step, days)
+);
@@ -0,0 +1,2 @@
+-- why the status of the service was last changed by the system, e.g. suspended by dunning or unsuspended after payment
+ALTER TABLE services ADD COLUMN IF NOT EXISTS status_reason TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,15 @@
+-- cancellation requested by the client, type is immediate or end_of_term
+CREATE TABLE IF NOT EXISTS cancellation_requests
+(
+    id           SERIAL PRIMARY KEY,
+    service_id   INTEGER     NOT NULL REFERENCES services ON DELETE CASCADE,
+    user_id      INTEGER     NOT NULL REFERENCES users,
+    type         VARCHAR(20) NOT NULL,
+    reason       TEXT        NOT NULL,
+    status       VARCHAR(20) NOT NULL,
+    created_at   TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    processed_at TIMESTAMP
+);
+
+-- a service has at most one pending request
+CREATE UNIQUE INDEX IF NOT EXISTS cancellation_requests_pending ON cancellation_requests (service_id) WHERE status = 'PENDING';
@@ -0,0 +1,9 @@
+-- templates of notification emails edited by admins, events without a row use the built-in template
+CREATE TABLE IF NOT EXISTS email_templates
+(
+    event      VARCHAR(64) PRIMARY KEY,
+    subject    TEXT        NOT NULL,
+    html       TEXT        NOT NULL,
+    text       TEXT        NOT NULL,
+    updated_at TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
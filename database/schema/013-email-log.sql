@@ -0,0 +1,14 @@
+-- every email that is sent, the content is kept so that failed emails can be resent
+CREATE TABLE IF NOT EXISTS email_logs
+(
+    id         SERIAL PRIMARY KEY,
+    recipient  TEXT        NOT NULL,
+    subject    TEXT        NOT NULL,
+    html       TEXT        NOT NULL,
+    text       TEXT        NOT NULL,
+    status     VARCHAR(20) NOT NULL, -- QUEUED, SENT or FAILED
+    attempts   INTEGER     NOT NULL DEFAULT 0,
+    last_error TEXT        NOT NULL DEFAULT '',
+    created_at TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    sent_at    TIMESTAMP
+);
@@ -0,0 +1,43 @@
+CREATE TABLE IF NOT EXISTS ticket_departments
+(
+    id          SERIAL PRIMARY KEY,
+    name        VARCHAR(200) NOT NULL,
+    description TEXT         NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS tickets
+(
+    id            SERIAL PRIMARY KEY,
+    user_id       INTEGER      NOT NULL REFERENCES users,
+    department_id INTEGER      NOT NULL REFERENCES ticket_departments,
+    subject       VARCHAR(200) NOT NULL,
+    status        VARCHAR(20)  NOT NULL,
+    priority      VARCHAR(20)  NOT NULL,
+    service_id    INTEGER REFERENCES services ON DELETE SET NULL,
+    invoice_id    INTEGER REFERENCES invoices ON DELETE SET NULL,
+    assigned_to   INTEGER REFERENCES users ON DELETE SET NULL, -- staff
+    created_at    TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at    TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP  -- last reply or status change
+);
+
+CREATE INDEX IF NOT EXISTS tickets_user_id ON tickets (user_id);
+
+CREATE TABLE IF NOT EXISTS ticket_messages
+(
+    id         SERIAL PRIMARY KEY,
+    ticket_id  INTEGER   NOT NULL REFERENCES tickets ON DELETE CASCADE,
+    user_id    INTEGER   NOT NULL REFERENCES users,
+    staff      BOOLEAN   NOT NULL, -- written by staff
+    body       TEXT      NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS ticket_attachments
+(
+    id           SERIAL PRIMARY KEY,
+    message_id   INTEGER      NOT NULL REFERENCES ticket_messages ON DELETE CASCADE,
+    filename     VARCHAR(255) NOT NULL,
+    content_type VARCHAR(255) NOT NULL,
+    size         INTEGER      NOT NULL,
+    data         BYTEA        NOT NULL
+);
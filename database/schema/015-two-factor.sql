@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS user_totp
+(
+    user_id    INTEGER PRIMARY KEY REFERENCES users ON DELETE CASCADE,
+    secret     VARCHAR(64) NOT NULL,
+    enabled    BOOLEAN     NOT NULL DEFAULT FALSE, -- false until a code is confirmed
+    last_step  BIGINT      NOT NULL DEFAULT 0,     -- time step of the last accepted code, so that codes can not be reused
+    created_at TIMESTAMP   NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS user_recovery_codes
+(
+    id        SERIAL PRIMARY KEY,
+    user_id   INTEGER     NOT NULL REFERENCES users ON DELETE CASCADE,
+    code_hash VARCHAR(64) NOT NULL, -- sha256 hex
+    used_at   TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS user_recovery_codes_user_id ON user_recovery_codes (user_id);
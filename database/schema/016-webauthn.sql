@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS webauthn_credentials
+(
+    id            SERIAL PRIMARY KEY,
+    user_id       INTEGER      NOT NULL REFERENCES users ON DELETE CASCADE,
+    name          VARCHAR(100) NOT NULL,
+    credential_id BYTEA UNIQUE NOT NULL,
+    credential    JSONB        NOT NULL, -- webauthn.Credential, with the public key and the sign count
+    created_at    TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at  TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS webauthn_credentials_user_id ON webauthn_credentials (user_id);
+
+-- state of registration and login ceremonies, each can be finished only once
+CREATE TABLE IF NOT EXISTS webauthn_sessions
+(
+    token      VARCHAR(64) PRIMARY KEY,
+    type       VARCHAR(20) NOT NULL,                         -- register, login or 2fa
+    user_id    INTEGER REFERENCES users ON DELETE CASCADE,   -- null for passwordless login
+    data       JSONB       NOT NULL,                         -- webauthn.SessionData
+    expires_at TIMESTAMP   NOT NULL
+);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS api_keys
+(
+    id           SERIAL PRIMARY KEY,
+    user_id      INTEGER      NOT NULL REFERENCES users ON DELETE CASCADE,
+    name         VARCHAR(100) NOT NULL,
+    prefix       VARCHAR(20)  NOT NULL,                -- the beginning of the key, to tell keys apart
+    key_hash     VARCHAR(64)  UNIQUE NOT NULL,         -- sha256 of the key
+    scopes       TEXT[]       NOT NULL,
+    allowed_ips  TEXT[]       NOT NULL DEFAULT '{}',   -- ip addresses or networks, empty for any
+    expires_at   TIMESTAMP,
+    last_used_at TIMESTAMP,
+    last_used_ip VARCHAR(45),
+    created_at   TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_user_id ON api_keys (user_id);
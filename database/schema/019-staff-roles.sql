@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS staff_roles
+(
+    id          SERIAL PRIMARY KEY,
+    name        VARCHAR(100) UNIQUE NOT NULL,
+    description TEXT         NOT NULL DEFAULT '',
+    permissions TEXT[]       NOT NULL DEFAULT '{}'
+);
+
+-- admins without a staff role have every permission
+ALTER TABLE users ADD COLUMN IF NOT EXISTS staff_role_id INTEGER REFERENCES staff_roles;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS audit_logs
+(
+    id          SERIAL PRIMARY KEY,
+    actor_id    INTEGER REFERENCES users ON DELETE SET NULL, -- null for actions of the system, e.g. dunning
+    ip          VARCHAR(45)  NOT NULL DEFAULT '',
+    entity_type VARCHAR(50)  NOT NULL,
+    entity_id   VARCHAR(100) NOT NULL,
+    action      VARCHAR(100) NOT NULL,
+    before      JSONB,                                       -- the changed fields before the action, secrets redacted
+    after       JSONB,                                       -- the changed fields after the action, secrets redacted
+    created_at  TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS audit_logs_entity ON audit_logs (entity_type, entity_id);
+CREATE INDEX IF NOT EXISTS audit_logs_actor_id ON audit_logs (actor_id);
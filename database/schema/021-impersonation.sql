@@ -0,0 +1,2 @@
+-- sessions created by an admin to log in as the user, see POST /admin/user/{id}/impersonate
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS impersonator_id INTEGER REFERENCES users ON DELETE CASCADE;
@@ -0,0 +1,9 @@
+-- failed login and password reset attempts, see service/login_throttle.go
+CREATE TABLE IF NOT EXISTS login_throttles
+(
+    key          VARCHAR(300) PRIMARY KEY,                    -- e.g. "login:ip:1.2.3.4" or "login:account:user@example.com"
+    failures     INTEGER      NOT NULL DEFAULT 0,             -- attempts since window_start
+    window_start TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    lockouts     INTEGER      NOT NULL DEFAULT 0,             -- the lockout duration doubles with each lockout
+    locked_until TIMESTAMP
+);
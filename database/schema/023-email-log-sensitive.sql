@@ -0,0 +1,2 @@
+-- the body of a sensitive email, e.g. with a password reset link, is cleared once the email is sent or has failed
+ALTER TABLE email_logs ADD COLUMN IF NOT EXISTS sensitive BOOLEAN NOT NULL DEFAULT FALSE;
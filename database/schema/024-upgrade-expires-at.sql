@@ -0,0 +1,2 @@
+-- the expiry time of the service that the amount of the upgrade is prorated to
+ALTER TABLE service_upgrades ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
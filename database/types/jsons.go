@@ -4,19 +4,29 @@ import "github.com/shopspring/decimal"
 
 type ProductPrices = []ProductPrice
 
+// Price and SetupFee are in the base currency. Currencies contains the prices in other
+// currencies, keyed by currency code. Currencies without a price use the exchange rate.
 type ProductPrice struct {
-	DisplayName string          `json:"display_name"`
-	Duration    int32           `json:"duration"` // number of seconds
-	Price       decimal.Decimal `json:"price"`
-	SetupFee    decimal.Decimal `json:"setup_fee"`
+	DisplayName string                   `json:"display_name"`
+	Duration    int32                    `json:"duration"` // number of seconds
+	Price       decimal.Decimal          `json:"price"`
+	SetupFee    decimal.Decimal          `json:"setup_fee"`
+	Currencies  map[string]CurrencyPrice `json:"currencies,omitempty"`
+}
+
+type CurrencyPrice struct {
+	Price    decimal.Decimal `json:"price"`
+	SetupFee decimal.Decimal `json:"setup_fee"`
 }
 
 type ProductOptionValues = []ProductOptionValue
 
+// Like ProductPrice, Price and SetupFee are in the base currency.
 type ProductOptionValuePrice struct {
-	Duration int32           `json:"duration"` // number of seconds
-	Price    decimal.Decimal `json:"price"`
-	SetupFee decimal.Decimal `json:"setup_fee"`
+	Duration   int32                    `json:"duration"` // number of seconds
+	Price      decimal.Decimal          `json:"price"`
+	SetupFee   decimal.Decimal          `json:"setup_fee"`
+	Currencies map[string]CurrencyPrice `json:"currencies,omitempty"`
 }
 
 type ProductOptionValue struct {
@@ -32,3 +42,11 @@ type ServiceSettings map[string]string
 type GatewaySettings map[string]string
 
 type ServerSettings map[string]string
+
+// DunningPolicy overrides the global dunning settings for a product. Nil fields use the global settings.
+// Days are relative to the expiry date of the service, negative days are before it.
+type DunningPolicy struct {
+	ReminderDays  []int32 `json:"reminder_days"`
+	SuspendDays   *int32  `json:"suspend_days"` // negative disables suspension
+	TerminateDays *int32  `json:"terminate_days"`
+}
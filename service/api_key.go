@@ -0,0 +1,186 @@
+package service
+
+import (
+	"billing3/database"
+	"billing3/database/types"
+	"billing3/utils"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// API keys look like "b3_" followed by 40 hex characters. The prefix tells them apart from session tokens.
+const apiKeyPrefix = "b3_"
+
+// apiKeyShownLength is the length of the beginning of a key that is saved and shown, to tell keys apart.
+const apiKeyShownLength = len(apiKeyPrefix) + 8
+
+const maxApiKeys = 25
+
+const (
+	ScopeServicesRead   = "services:read"
+	ScopeServicesAction = "services:action"
+	ScopeInvoicesRead   = "invoices:read"
+	ScopeInvoicesPay    = "invoices:pay"
+	ScopeTicketsRead    = "tickets:read"
+	ScopeTicketsWrite   = "tickets:write"
+	ScopeAdminRead      = "admin:read"
+	ScopeAdminWrite     = "admin:write"
+)
+
+// Scopes lists the scopes an API key can have. "<group>:*", e.g. "admin:*", grants every scope of the group.
+var Scopes = []string{
+	ScopeServicesRead,
+	ScopeServicesAction,
+	ScopeInvoicesRead,
+	ScopeInvoicesPay,
+	ScopeTicketsRead,
+	ScopeTicketsWrite,
+	ScopeAdminRead,
+	ScopeAdminWrite,
+}
+
+var ErrApiKeyInvalid = errors.New("invalid api key")
+var ErrApiKeyScope = errors.New("invalid scope")
+var ErrApiKeyAdminScope = errors.New("admin scopes are only available to admins")
+var ErrApiKeyIP = errors.New("invalid ip address or network")
+var ErrApiKeyExpired = errors.New("expiry time is in the past")
+var ErrTooManyApiKeys = errors.New("too many api keys")
+
+// IsApiKey returns whether the token looks like an API key rather than a session token.
+func IsApiKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+// CreateApiKey creates an API key for the user and returns its id and the key. Only the hash of the key is saved,
+// so it can not be shown again. Errors other than ErrInternalError are meant to be shown to the user.
+func CreateApiKey(ctx context.Context, user *database.User, name string, scopes []string, allowedIps []string, expiresAt types.Timestamp) (int32, string, error) {
+	for _, scope := range scopes {
+		if !validScope(scope) {
+			return 0, "", fmt.Errorf("%w: %s", ErrApiKeyScope, scope)
+		}
+		if strings.HasPrefix(scope, "admin:") && user.Role != "admin" {
+			return 0, "", ErrApiKeyAdminScope
+		}
+	}
+
+	for i, ip := range allowedIps {
+		ip = strings.TrimSpace(ip)
+		if strings.Contains(ip, "/") {
+			_, network, err := net.ParseCIDR(ip)
+			if err != nil {
+				return 0, "", fmt.Errorf("%w: %s", ErrApiKeyIP, ip)
+			}
+			ip = network.String()
+		} else {
+			addr := net.ParseIP(ip)
+			if addr == nil {
+				return 0, "", fmt.Errorf("%w: %s", ErrApiKeyIP, ip)
+			}
+			ip = addr.String()
+		}
+		allowedIps[i] = ip
+	}
+
+	if expiresAt.Valid && expiresAt.Time.Before(time.Now()) {
+		return 0, "", ErrApiKeyExpired
+	}
+
+	count, err := database.Q.CountApiKeys(ctx, user.ID)
+	if err != nil {
+		slog.Error("count api keys", "err", err)
+		return 0, "", ErrInternalError
+	}
+	if count >= maxApiKeys {
+		return 0, "", ErrTooManyApiKeys
+	}
+
+	key := apiKeyPrefix + utils.RandomToken(20)
+
+	id, err := database.Q.CreateApiKey(ctx, database.CreateApiKeyParams{
+		UserID:     user.ID,
+		Name:       name,
+		Prefix:     key[:apiKeyShownLength],
+		KeyHash:    hashApiKey(key),
+		Scopes:     slices.Compact(slices.Sorted(slices.Values(scopes))),
+		AllowedIps: allowedIps,
+		ExpiresAt:  expiresAt,
+	})
+	if err != nil {
+		slog.Error("create api key", "err", err)
+		return 0, "", ErrInternalError
+	}
+
+	slog.Info("api key created", "user_id", user.ID, "id", id, "scopes", scopes)
+
+	return id, key, nil
+}
+
+// AuthenticateApiKey returns the API key and records its use. ErrApiKeyInvalid is returned if the key does not
+// exist, has expired or is not allowed from ip.
+func AuthenticateApiKey(ctx context.Context, key string, ip string) (*database.ApiKey, error) {
+	apiKey, err := database.Q.FindApiKeyByHash(ctx, hashApiKey(key))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrApiKeyInvalid
+		}
+		return nil, fmt.Errorf("db: %w", err)
+	}
+
+	if len(apiKey.AllowedIps) > 0 && !utils.IPAllowed(ip, apiKey.AllowedIps) {
+		slog.Info("api key used from a disallowed ip", "id", apiKey.ID, "ip", ip)
+		return nil, ErrApiKeyInvalid
+	}
+
+	// the last used time is only updated once a minute
+	err = database.Q.UpdateApiKeyUsed(ctx, database.UpdateApiKeyUsedParams{
+		ID:         apiKey.ID,
+		LastUsedIp: pgtype.Text{String: ip, Valid: true},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+
+	return &apiKey, nil
+}
+
+// ApiKeyHasScope returns whether the API key is granted scope, directly or by a "<group>:*" scope.
+func ApiKeyHasScope(apiKey *database.ApiKey, scope string) bool {
+	for _, s := range apiKey.Scopes {
+		if s == scope {
+			return true
+		}
+		if group, ok := strings.CutSuffix(s, ":*"); ok && strings.HasPrefix(scope, group+":") {
+			return true
+		}
+	}
+	return false
+}
+
+func validScope(scope string) bool {
+	if slices.Contains(Scopes, scope) {
+		return true
+	}
+	if group, ok := strings.CutSuffix(scope, ":*"); ok {
+		return slices.ContainsFunc(Scopes, func(s string) bool {
+			return strings.HasPrefix(s, group+":")
+		})
+	}
+	return false
+}
+
+// hashApiKey hashes an API key. Keys are random, so they do not need a slow hash like passwords.
+func hashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
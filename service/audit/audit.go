@@ -0,0 +1,211 @@
+// Package audit records who changed what in the audit log. It is a separate package so that service/extension can
+// use it too.
+package audit
+
+import (
+	"billing3/database"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Entity types
+const (
+	EntityUser             = "user"
+	EntityStaffRole        = "staff_role"
+	EntityCategory         = "category"
+	EntityCoupon           = "coupon"
+	EntityTaxRule          = "tax_rule"
+	EntityCurrency         = "currency"
+	EntityProduct          = "product"
+	EntityInvoice          = "invoice"
+	EntityGateway          = "gateway"
+	EntityService          = "service"
+	EntityCancellation     = "cancellation"
+	EntityTicket           = "ticket"
+	EntityTicketDepartment = "ticket_department"
+	EntityServer           = "server"
+	EntityEmail            = "email"
+	EntityEmailTemplate    = "email_template"
+	EntitySettings         = "settings"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are parts of field names whose values are not saved, e.g. "password" of server settings.
+var sensitiveKeys = []string{"password", "secret", "token", "private_key", "api_key"}
+
+type actorCtx struct{}
+
+type actor struct {
+	userId int32
+	ip     string
+}
+
+// WithActor returns a context whose audit log entries are attributed to the user, from the ip address. Entries
+// without an actor are actions of the system, e.g. dunning.
+func WithActor(ctx context.Context, userId int32, ip string) context.Context {
+	return context.WithValue(ctx, actorCtx{}, actor{userId: userId, ip: ip})
+}
+
+// Log records an action on an entity by the actor in ctx. before and after are the entity before and after the
+// action, or nil if it did not exist, e.g. for "create" and "delete". Only the fields that changed are saved, and
+// secrets are redacted. Errors are logged and not returned, so that the action is not reported as failed after it
+// is done.
+func Log(ctx context.Context, entityType string, entityId any, action string, before any, after any) {
+	write(ctx, database.Q, entityType, entityId, action, before, after)
+}
+
+// LogTx is like Log, but the entry is saved in tx, so it is only kept if tx is commited.
+func LogTx(ctx context.Context, tx pgx.Tx, entityType string, entityId any, action string, before any, after any) {
+	write(ctx, database.Q.WithTx(tx), entityType, entityId, action, before, after)
+}
+
+func write(ctx context.Context, q *database.Queries, entityType string, entityId any, action string, before any, after any) {
+	b, a, err := diff(before, after)
+	if err != nil {
+		slog.Error("audit log", "err", err, "entity_type", entityType, "entity_id", entityId, "action", action)
+		return
+	}
+
+	params := database.CreateAuditLogParams{
+		EntityType: entityType,
+		EntityID:   fmt.Sprint(entityId),
+		Action:     action,
+		Before:     b,
+		After:      a,
+	}
+	if act, ok := ctx.Value(actorCtx{}).(actor); ok {
+		params.ActorID = pgtype.Int4{Int32: act.userId, Valid: true}
+		params.Ip = act.ip
+	}
+
+	err = q.CreateAuditLog(ctx, params)
+	if err != nil {
+		slog.Error("audit log", "err", err, "entity_type", entityType, "entity_id", entityId, "action", action)
+	}
+}
+
+// diff returns the JSON of the fields that differ between before and after, with secrets redacted. Fields that
+// are not in both are ignored. Values that are not JSON objects are returned whole.
+func diff(before any, after any) ([]byte, []byte, error) {
+	b, err := toJSONValue(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	a, err := toJSONValue(after)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bm, bok := b.(map[string]any)
+	am, aok := a.(map[string]any)
+	if bok && aok {
+		// after is often the parameters of the update query, which do not have every field of the entity
+		for k := range bm {
+			if _, ok := am[k]; !ok {
+				delete(bm, k)
+			}
+		}
+		for k := range am {
+			if _, ok := bm[k]; !ok {
+				delete(am, k)
+			}
+		}
+		diffMaps(bm, am)
+	}
+
+	bj, err := marshalRedacted(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	aj, err := marshalRedacted(a)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bj, aj, nil
+}
+
+// diffMaps removes the keys that have the same value in both maps, also from nested maps.
+func diffMaps(before map[string]any, after map[string]any) {
+	for k, bv := range before {
+		av, ok := after[k]
+		if !ok {
+			continue
+		}
+
+		bm, bok := bv.(map[string]any)
+		am, aok := av.(map[string]any)
+		if bok && aok {
+			diffMaps(bm, am)
+			if len(bm) == 0 && len(am) == 0 {
+				delete(before, k)
+				delete(after, k)
+			}
+			continue
+		}
+
+		if reflect.DeepEqual(bv, av) {
+			delete(before, k)
+			delete(after, k)
+		}
+	}
+}
+
+func toJSONValue(v any) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	j, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("marshal: %w", err)
+	}
+	var out any
+	err = json.Unmarshal(j, &out)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal: %w", err)
+	}
+	return out, nil
+}
+
+// marshalRedacted returns nil for nil, which is saved as null.
+func marshalRedacted(v any) ([]byte, error) {
+	if v == nil {
+		return nil, nil
+	}
+	redact(v)
+	return json.Marshal(v)
+}
+
+func redact(v any) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, value := range v {
+			if isSensitive(k) {
+				v[k] = redacted
+			} else {
+				redact(value)
+			}
+		}
+	case []any:
+		for _, value := range v {
+			redact(value)
+		}
+	}
+}
+
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -3,20 +3,18 @@ package service
 import (
 	"billing3/database"
 	"billing3/database/types"
-	"billing3/service/email"
+	"billing3/service/notification"
 	"billing3/utils"
 	"context"
 	"fmt"
 	"log/slog"
-	"os"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
-var PUBLIC_DOMAIN string
-
 // SendVerificationEmail sends a verification email, which contains the link
 // to continue registration process
 func SendVerificationEmail(ctx context.Context, emailAddr string) error {
@@ -25,17 +23,9 @@ func SendVerificationEmail(ctx context.Context, emailAddr string) error {
 		"sub": emailAddr,
 	}, time.Minute*30)
 
-	if PUBLIC_DOMAIN == "" {
-		PUBLIC_DOMAIN = os.Getenv("PUBLIC_DOMAIN")
-	}
-
-	link := PUBLIC_DOMAIN + "/auth/register2?token=" + jwtSign
-	subject := "Verify email"
-	body := fmt.Sprintf(`
-	<p>Click the following link to continue registration:<br><br><a href="%s">%s</a></p>
-	`, link, link)
+	link := notification.PublicDomain() + "/auth/register2?token=" + jwtSign
 
-	err := email.SendMailAsync(ctx, emailAddr, subject, body)
+	err := notification.Send(ctx, notification.EventVerifyEmail, emailAddr, map[string]any{"Link": link})
 	if err != nil {
 		return err
 	}
@@ -59,16 +49,29 @@ func DecodeEmailVerificationToken(token string) string {
 	return claims["sub"].(string)
 }
 
-// NewSessionToken returns a new session token for user
-func NewSessionToken(ctx context.Context, user int32) (string, error) {
+// NewSessionToken returns a new session token for user. The user agent and the ip are shown in the list of sessions.
+func NewSessionToken(ctx context.Context, user int32, userAgent string, ip string) (string, error) {
+	return newSession(ctx, user, userAgent, ip, time.Now().Add(time.Hour*24*30), pgtype.Int4{})
+}
+
+// newSession creates a session. impersonator is the admin who logged in as the user, see Impersonate.
+func newSession(ctx context.Context, user int32, userAgent string, ip string, expiresAt time.Time, impersonator pgtype.Int4) (string, error) {
+	if len(userAgent) > 500 {
+		userAgent = userAgent[:500]
+	}
+	userAgent = strings.ToValidUTF8(userAgent, "")
+
 	token := utils.RandomToken(32)
 	err := database.Q.CreateSession(ctx, database.CreateSessionParams{
 		Token:  token,
 		UserID: user,
 		ExpiresAt: types.Timestamp{Timestamp: pgtype.Timestamp{
 			Valid: true,
-			Time:  time.Now().Add(time.Hour * 24 * 30),
+			Time:  expiresAt,
 		}},
+		UserAgent:      userAgent,
+		Ip:             ip,
+		ImpersonatorID: impersonator,
 	})
 	if err != nil {
 		return "", fmt.Errorf("new auth token: %w", err)
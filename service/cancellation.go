@@ -0,0 +1,268 @@
+package service
+
+import (
+	"billing3/database"
+	"billing3/database/types"
+	"billing3/service/extension"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	CancellationImmediate = "immediate"   // the service is terminated as soon as the request is made
+	CancellationEndOfTerm = "end_of_term" // the service is terminated when it expires, and is not renewed
+)
+
+const (
+	CancellationPending   = "PENDING"
+	CancellationCompleted = "COMPLETED"
+	CancellationRejected  = "REJECTED"
+	CancellationWithdrawn = "WITHDRAWN"
+)
+
+var ErrCancellationPending = errors.New("a cancellation request is pending for the service")
+var ErrCancellationNotPending = errors.New("cancellation request is not pending")
+
+// RequestCancellation creates a cancellation request for a service on behalf of its owner, and cancels the
+// unpaid invoices of the service. Immediate requests terminate the service right away, end of term requests
+// stop the service from being renewed and terminate it when it expires.
+func RequestCancellation(ctx context.Context, serviceId int32, cancellationType string, reason string) (int32, error) {
+	if cancellationType != CancellationImmediate && cancellationType != CancellationEndOfTerm {
+		return 0, fmt.Errorf("invalid cancellation type: %s", cancellationType)
+	}
+
+	tx, err := database.Conn.Begin(ctx)
+	if err != nil {
+		slog.Error("request cancellation", "err", err, "service_id", serviceId)
+		return 0, ErrInternalError
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := database.Q.WithTx(tx)
+
+	s, err := qtx.FindServiceByIdForUpdate(ctx, serviceId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrNotFound
+		}
+		slog.Error("request cancellation", "err", err, "service_id", serviceId)
+		return 0, ErrInternalError
+	}
+
+	if s.Status != ServiceActive && s.Status != ServiceSuspended && s.Status != ServicePending {
+		return 0, ErrServiceCancelled
+	}
+
+	requestId, err := qtx.CreateCancellationRequest(ctx, database.CreateCancellationRequestParams{
+		ServiceID: s.ID,
+		UserID:    s.UserID,
+		Type:      cancellationType,
+		Reason:    strings.TrimSpace(reason),
+		Status:    CancellationPending,
+	})
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == database.PGErrorUniqueViolation {
+			return 0, ErrCancellationPending
+		}
+		slog.Error("request cancellation", "err", err, "service_id", serviceId)
+		return 0, ErrInternalError
+	}
+
+	// the service is not going to be renewed
+	invoices, err := qtx.FindInvoiceByService(ctx, pgtype.Int4{Valid: true, Int32: s.ID})
+	if err != nil {
+		slog.Error("request cancellation", "err", err, "service_id", serviceId)
+		return 0, ErrInternalError
+	}
+	for _, invoice := range invoices {
+		if invoice.Status != InvoiceUnpaid {
+			continue
+		}
+		err = qtx.UpdateInvoiceCancelled(ctx, database.UpdateInvoiceCancelledParams{
+			CancellationReason: pgtype.Text{Valid: true, String: "cancellation requested"},
+			ID:                 invoice.ID,
+		})
+		if err != nil {
+			slog.Error("request cancellation", "err", err, "service_id", serviceId, "invoice_id", invoice.ID)
+			return 0, ErrInternalError
+		}
+	}
+
+	if cancellationType == CancellationImmediate {
+		request, err := qtx.FindCancellationRequestById(ctx, requestId)
+		if err != nil {
+			slog.Error("request cancellation", "err", err, "service_id", serviceId)
+			return 0, ErrInternalError
+		}
+		err = completeCancellation(ctx, tx, &request, &s)
+		if err != nil {
+			if errors.Is(err, extension.ErrActionRunning) {
+				return 0, err
+			}
+			slog.Error("request cancellation", "err", err, "service_id", serviceId)
+			return 0, ErrInternalError
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		slog.Error("request cancellation", "err", err, "service_id", serviceId)
+		return 0, ErrInternalError
+	}
+
+	slog.Info("cancellation requested", "service_id", s.ID, "request_id", requestId, "type", cancellationType)
+
+	return requestId, nil
+}
+
+// WithdrawCancellation withdraws the pending cancellation request of a service. Immediate requests are
+// completed when they are made, so only end of term requests can be withdrawn.
+func WithdrawCancellation(ctx context.Context, serviceId int32) error {
+	request, err := database.Q.FindPendingCancellationRequestByService(ctx, serviceId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("db: %w", err)
+	}
+
+	return setCancellationStatus(ctx, database.Q, request.ID, CancellationWithdrawn)
+}
+
+// RejectCancellation rejects a pending cancellation request. The service is renewed as usual afterwards.
+func RejectCancellation(ctx context.Context, requestId int32) error {
+	return setCancellationStatus(ctx, database.Q, requestId, CancellationRejected)
+}
+
+// ApproveCancellation terminates the service of a pending cancellation request now, without waiting for
+// the end of the billing period.
+func ApproveCancellation(ctx context.Context, requestId int32) error {
+	tx, err := database.Conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := database.Q.WithTx(tx)
+
+	request, err := qtx.FindCancellationRequestById(ctx, requestId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("db: %w", err)
+	}
+
+	s, err := qtx.FindServiceByIdForUpdate(ctx, request.ServiceID)
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	err = completeCancellation(ctx, tx, &request, &s)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	slog.Info("cancellation approved", "service_id", s.ID, "request_id", request.ID)
+
+	return nil
+}
+
+func setCancellationStatus(ctx context.Context, qtx *database.Queries, requestId int32, status string) error {
+	updated, err := qtx.UpdateCancellationRequestStatus(ctx, database.UpdateCancellationRequestStatusParams{
+		ID:        requestId,
+		NewStatus: status,
+	})
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+	if updated == 0 {
+		return ErrCancellationNotPending
+	}
+
+	slog.Info("cancellation request", "request_id", requestId, "status", status)
+
+	return nil
+}
+
+// completeCancellation marks the request as completed, and terminates the service through the extension.
+// ErrCancellationNotPending is returned if the request is not pending, and extension.ErrActionRunning if
+// another action is running on the service.
+//
+// tx is not commited. s should be locked by tx.
+func completeCancellation(ctx context.Context, tx pgx.Tx, request *database.CancellationRequest, s *database.Service) error {
+	qtx := database.Q.WithTx(tx)
+
+	err := setCancellationStatus(ctx, qtx, request.ID, CancellationCompleted)
+	if err != nil {
+		return err
+	}
+
+	if s.Status == ServiceCancelled {
+		// e.g. an admin has cancelled the service in the meantime
+		return nil
+	}
+
+	reason := "cancelled by client"
+	if request.Reason != "" {
+		reason += ": " + request.Reason
+	}
+
+	// the status is changed by the terminate action
+	err = qtx.UpdateServiceCancelled(ctx, database.UpdateServiceCancelledParams{
+		CancellationReason: pgtype.Text{Valid: true, String: reason},
+		CancelledAt:        types.Timestamp{Timestamp: pgtype.Timestamp{Valid: true, Time: time.Now()}},
+		ID:                 s.ID,
+	})
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	err = extension.DoActionAsyncWithReason(ctx, tx, s.Extension, s.ID, "terminate", ServiceCancelled, fmt.Sprintf("cancellation request %d", request.ID))
+	if err != nil {
+		if errors.Is(err, extension.ErrActionRunning) {
+			return err
+		}
+		return fmt.Errorf("do action async: %w", err)
+	}
+
+	return nil
+}
+
+// ProcessCancellationRequests terminates services with a pending end of term cancellation request that
+// have reached their expiry date.
+func ProcessCancellationRequests() error {
+	ctx := context.Background()
+
+	requests, err := database.Q.FindDueCancellationRequests(ctx)
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	for _, request := range requests {
+		err := ApproveCancellation(ctx, request.ID)
+		if err != nil {
+			if errors.Is(err, extension.ErrActionRunning) {
+				slog.Info("cancellation postponed, another action is running", "service_id", request.ServiceID, "request_id", request.ID)
+				continue
+			}
+			slog.Error("process cancellation request", "err", err, "service_id", request.ServiceID, "request_id", request.ID)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package service
+
+import (
+	"billing3/database"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	CouponPercentage = "percentage"
+	CouponFixed      = "fixed"
+)
+
+var ErrCouponUnavailable = errors.New("coupon is no longer available")
+
+// NormalizeCouponCode returns the code as it is stored in the database. Coupon codes are case-insensitive.
+func NormalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// couponDiscount returns the discount of the coupon for the given recurring fee.
+// The discount never exceeds the fee.
+func couponDiscount(coupon *database.Coupon, price decimal.Decimal) decimal.Decimal {
+	var discount decimal.Decimal
+	switch coupon.Type {
+	case CouponPercentage:
+		discount = price.Mul(coupon.Value).Div(decimal.NewFromInt(100)).Round(2)
+	case CouponFixed:
+		discount = coupon.Value
+	default:
+		return decimal.Zero
+	}
+
+	if discount.GreaterThan(price) {
+		discount = price
+	}
+	if discount.LessThan(decimal.Zero) {
+		discount = decimal.Zero
+	}
+	return discount
+}
+
+// couponInCurrency converts the value of a fixed coupon from the base currency to currency.
+// Percentage coupons are not changed.
+func couponInCurrency(ctx context.Context, qtx *database.Queries, coupon *database.Coupon, currency string) error {
+	if coupon.Type != CouponFixed {
+		return nil
+	}
+
+	value, err := ConvertAmount(ctx, qtx, coupon.Value, BaseCurrency(ctx), currency)
+	if err != nil {
+		return err
+	}
+
+	coupon.Value = value
+	return nil
+}
+
+// findCoupon finds the coupon by code and checks that it can be applied to the product and
+// billing cycle. Per-user limits are checked by UseCoupon. Errors other than ErrInternalError
+// are meant to be shown to the user.
+func findCoupon(ctx context.Context, code string, productId int32, duration int32) (*database.Coupon, error) {
+	coupon, err := database.Q.FindCouponByCode(ctx, NormalizeCouponCode(code))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("invalid coupon")
+		}
+		slog.Error("find coupon", "err", err, "code", code)
+		return nil, ErrInternalError
+	}
+
+	if !coupon.Enabled {
+		return nil, fmt.Errorf("invalid coupon")
+	}
+
+	if coupon.ExpiresAt.Valid && coupon.ExpiresAt.Time.Before(time.Now()) {
+		return nil, fmt.Errorf("coupon has expired")
+	}
+
+	// empty list means all products and billing cycles
+	if len(coupon.ProductIds) > 0 && !slices.Contains(coupon.ProductIds, productId) {
+		return nil, fmt.Errorf("coupon is not applicable to this product")
+	}
+	if len(coupon.Durations) > 0 && !slices.Contains(coupon.Durations, duration) {
+		return nil, fmt.Errorf("coupon is not applicable to the selected billing cycle")
+	}
+
+	if coupon.MaxUses > 0 {
+		uses, err := database.Q.CountCouponUses(ctx, coupon.ID)
+		if err != nil {
+			slog.Error("count coupon uses", "err", err, "id", coupon.ID)
+			return nil, ErrInternalError
+		}
+		if uses >= int64(coupon.MaxUses) {
+			return nil, ErrCouponUnavailable
+		}
+	}
+
+	return &coupon, nil
+}
+
+// UseCoupon records that the coupon is used by the user for the service. The coupon is locked so
+// that concurrent orders can not exceed the usage limits. ErrCouponUnavailable is returned if
+// the coupon has been used up, or the user has reached the per-user limit.
+//
+// qtx should be a transaction. qtx is not commited.
+func UseCoupon(ctx context.Context, qtx *database.Queries, couponId int32, userId int32, serviceId int32) error {
+	coupon, err := qtx.FindCouponByIdForUpdate(ctx, couponId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrCouponUnavailable
+		}
+		return fmt.Errorf("db: %w", err)
+	}
+
+	if coupon.MaxUses > 0 {
+		uses, err := qtx.CountCouponUses(ctx, couponId)
+		if err != nil {
+			return fmt.Errorf("db: %w", err)
+		}
+		if uses >= int64(coupon.MaxUses) {
+			return ErrCouponUnavailable
+		}
+	}
+
+	if coupon.MaxUsesPerUser > 0 {
+		uses, err := qtx.CountCouponUsesByUser(ctx, database.CountCouponUsesByUserParams{
+			CouponID: couponId,
+			UserID:   userId,
+		})
+		if err != nil {
+			return fmt.Errorf("db: %w", err)
+		}
+		if uses >= int64(coupon.MaxUsesPerUser) {
+			return ErrCouponUnavailable
+		}
+	}
+
+	err = qtx.CreateCouponUse(ctx, database.CreateCouponUseParams{
+		CouponID:  couponId,
+		UserID:    userId,
+		ServiceID: pgtype.Int4{Valid: true, Int32: serviceId},
+	})
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	slog.Info("coupon used", "coupon_id", couponId, "code", coupon.Code, "user_id", userId, "service_id", serviceId)
+
+	return nil
+}
+
+// ReleaseOrderCoupons gives back the coupon uses of the services that were ordered with the invoice and never paid,
+// so that cancelling an order does not count against the usage limits of its coupon.
+//
+// qtx should be a transaction. qtx is not commited.
+func ReleaseOrderCoupons(ctx context.Context, qtx *database.Queries, invoiceId int32) error {
+	items, err := qtx.ListInvoiceItems(ctx, invoiceId)
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	for _, item := range items {
+		if item.Type != InvoiceItemService || !item.ItemID.Valid {
+			continue
+		}
+		s, err := qtx.FindServiceById(ctx, item.ItemID.Int32)
+		if err != nil {
+			return fmt.Errorf("db: %w", err)
+		}
+		if s.Status != ServiceUnpaid {
+			continue
+		}
+		err = qtx.DeleteCouponUsesByService(ctx, item.ItemID)
+		if err != nil {
+			return fmt.Errorf("db: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package service
+
+import (
+	"billing3/database"
+	"billing3/database/types"
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+// createTestCoupon creates an enabled recurring coupon of 20% with the limits of params, and returns its id and code.
+func createTestCoupon(t *testing.T, params database.CreateCouponParams) (int32, string) {
+	t.Helper()
+
+	params.Code = NormalizeCouponCode(testName("coupon"))
+	params.Type = CouponPercentage
+	params.Value = decimal.NewFromInt(20)
+	params.Recurring = true
+	params.Enabled = true
+	if params.ProductIds == nil {
+		params.ProductIds = []int32{}
+	}
+	if params.Durations == nil {
+		params.Durations = []int32{}
+	}
+
+	id, err := database.Q.CreateCoupon(context.Background(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id, params.Code
+}
+
+// useTestCoupon uses the coupon for a new service of the user in a transaction.
+func useTestCoupon(t *testing.T, couponId int32, userId int32) error {
+	t.Helper()
+	ctx := context.Background()
+
+	serviceId := createTestService(t, userId, time.Now().Add(24*time.Hour))
+
+	tx, err := database.Conn.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+
+	err = UseCoupon(ctx, database.Q.WithTx(tx), couponId, userId, serviceId)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func TestCalculatePricingCoupon(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+
+	productId, _ := createTestProduct(t, 0, decimal.NewFromInt(10))
+	otherProductId, _ := createTestProduct(t, 0, decimal.NewFromInt(10))
+	monthly := int32(30 * 24 * 3600)
+
+	_, code := createTestCoupon(t, database.CreateCouponParams{})
+	_, expired := createTestCoupon(t, database.CreateCouponParams{
+		ExpiresAt: types.Timestamp{Timestamp: pgtype.Timestamp{Valid: true, Time: time.Now().Add(-time.Hour)}},
+	})
+	_, otherProduct := createTestCoupon(t, database.CreateCouponParams{ProductIds: []int32{otherProductId}})
+	_, yearly := createTestCoupon(t, database.CreateCouponParams{Durations: []int32{365 * 24 * 3600}})
+	usedUpId, usedUp := createTestCoupon(t, database.CreateCouponParams{MaxUses: 1})
+	if err := useTestCoupon(t, usedUpId, createTestUser(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		code    string
+		wantErr string
+	}{
+		{"valid", code, ""},
+		{"case-insensitive", strings.ToLower(code), ""},
+		{"unknown", "NO-SUCH-COUPON", "invalid coupon"},
+		{"expired", expired, "coupon has expired"},
+		{"other product", otherProduct, "coupon is not applicable to this product"},
+		{"other billing cycle", yearly, "coupon is not applicable to the selected billing cycle"},
+		{"used up", usedUp, ErrCouponUnavailable.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, pricing, err := CalculatePricing(ctx, OrderRequest{
+				ProductID: int(productId),
+				Duration:  int(monthly),
+				Coupon:    tt.code,
+				Currency:  "USD",
+			})
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("err = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !pricing.Discount.Equal(decimal.NewFromInt(2)) || !pricing.RecurringDiscount.Equal(decimal.NewFromInt(2)) {
+				t.Errorf("discount = %s, recurring discount = %s, want 2", pricing.Discount, pricing.RecurringDiscount)
+			}
+		})
+	}
+}
+
+func TestUseCouponTotalLimit(t *testing.T) {
+	requireDB(t)
+
+	couponId, _ := createTestCoupon(t, database.CreateCouponParams{MaxUses: 2})
+
+	for i := 0; i < 2; i++ {
+		if err := useTestCoupon(t, couponId, createTestUser(t)); err != nil {
+			t.Fatalf("use %d: %v", i, err)
+		}
+	}
+	if err := useTestCoupon(t, couponId, createTestUser(t)); !errors.Is(err, ErrCouponUnavailable) {
+		t.Errorf("err = %v, want %v", err, ErrCouponUnavailable)
+	}
+}
+
+func TestUseCouponPerUserLimit(t *testing.T) {
+	requireDB(t)
+
+	couponId, _ := createTestCoupon(t, database.CreateCouponParams{MaxUsesPerUser: 1})
+	userId := createTestUser(t)
+
+	if err := useTestCoupon(t, couponId, userId); err != nil {
+		t.Fatal(err)
+	}
+	if err := useTestCoupon(t, couponId, userId); !errors.Is(err, ErrCouponUnavailable) {
+		t.Errorf("second use: err = %v, want %v", err, ErrCouponUnavailable)
+	}
+	if err := useTestCoupon(t, couponId, createTestUser(t)); err != nil {
+		t.Errorf("other user: %v", err)
+	}
+}
+
+func TestUseCouponConcurrent(t *testing.T) {
+	requireDB(t)
+
+	const maxUses = 5
+	couponId, _ := createTestCoupon(t, database.CreateCouponParams{MaxUses: maxUses})
+
+	users := make([]int32, testConcurrency)
+	for i := range users {
+		users[i] = createTestUser(t)
+	}
+
+	var used atomic.Int32
+	runConcurrently(testConcurrency, func(i int) {
+		err := useTestCoupon(t, couponId, users[i])
+		if err == nil {
+			used.Add(1)
+		} else if !errors.Is(err, ErrCouponUnavailable) {
+			t.Errorf("use %d: %v", i, err)
+		}
+	})
+
+	if used.Load() != maxUses {
+		t.Errorf("coupon used %d times, want %d", used.Load(), maxUses)
+	}
+}
+
+func TestCloseOverdueInvoicesReleasesCoupon(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+
+	couponId, _ := createTestCoupon(t, database.CreateCouponParams{MaxUses: 1})
+	userId := createTestUser(t)
+
+	// an order that is never paid
+	serviceId := createTestService(t, userId, time.Now())
+	err := database.Q.UpdateServiceStatus(ctx, database.UpdateServiceStatusParams{ID: serviceId, Status: ServiceUnpaid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	invoiceId := createTestInvoice(t, userId, serviceId, decimal.NewFromInt(8))
+	err = database.Q.UpdateInvoice(ctx, database.UpdateInvoiceParams{
+		Status: InvoiceUnpaid,
+		DueAt:  types.Timestamp{Timestamp: pgtype.Timestamp{Valid: true, Time: time.Now().Add(-time.Hour)}},
+		ID:     invoiceId,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := database.Conn.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = UseCoupon(ctx, database.Q.WithTx(tx), couponId, userId, serviceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	err = CloseOverdueInvoices()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uses, err := database.Q.CountCouponUses(ctx, couponId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uses != 0 {
+		t.Errorf("%d coupon uses after the order was closed, want 0", uses)
+	}
+	if err := useTestCoupon(t, couponId, createTestUser(t)); err != nil {
+		t.Errorf("use after release: %v", err)
+	}
+}
+
+func TestReleaseOrderCouponsKeepsPaidServices(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+
+	couponId, _ := createTestCoupon(t, database.CreateCouponParams{})
+	userId := createTestUser(t)
+
+	// the invoice of an ACTIVE service is a renewal, whose coupon use is kept
+	serviceId := createTestService(t, userId, time.Now().Add(24*time.Hour))
+	invoiceId := createTestInvoice(t, userId, serviceId, decimal.NewFromInt(8))
+
+	tx, err := database.Conn.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	qtx := database.Q.WithTx(tx)
+
+	err = UseCoupon(ctx, qtx, couponId, userId, serviceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ReleaseOrderCoupons(ctx, qtx, invoiceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uses, err := qtx.CountCouponUses(ctx, couponId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uses != 1 {
+		t.Errorf("%d coupon uses, want 1", uses)
+	}
+}
@@ -0,0 +1,238 @@
+package service
+
+import (
+	"billing3/database"
+	"billing3/database/types"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	CreditTopUp      = "topup"
+	CreditAdjustment = "adjustment"
+	CreditPayment    = "payment"
+	CreditRefund     = "refund"
+	CreditDowngrade  = "downgrade"
+
+	// GatewayCredit is the gateway name recorded on invoice payments made with account credit.
+	GatewayCredit = "Credit"
+)
+
+// CreditBalance returns the sum of all credit transactions of the user in currency.
+func CreditBalance(ctx context.Context, userId int32, currency string) (decimal.Decimal, error) {
+	balance, err := database.Q.GetUserCreditBalance(ctx, database.GetUserCreditBalanceParams{
+		UserID:   userId,
+		Currency: currency,
+	})
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("db: %w", err)
+	}
+	return balance, nil
+}
+
+// AdjustCredit adds amount to the user's credit balance in currency. amount may be negative.
+func AdjustCredit(ctx context.Context, userId int32, amount decimal.Decimal, currency string, description string) error {
+	slog.Info("adjust credit", "user_id", userId, "amount", amount, "currency", currency, "description", description)
+
+	_, err := database.Q.CreateCreditTransaction(ctx, database.CreateCreditTransactionParams{
+		UserID:      userId,
+		Amount:      amount,
+		Description: description,
+		Type:        CreditAdjustment,
+		InvoiceID:   pgtype.Int4{Valid: false},
+		Currency:    currency,
+	})
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+	return nil
+}
+
+// CreateCreditTopUpInvoice creates an invoice which adds amount to the user's
+// credit balance in currency once paid.
+func CreateCreditTopUpInvoice(ctx context.Context, userId int32, amount decimal.Decimal, currency string) (int32, error) {
+	if amount.LessThanOrEqual(decimal.Zero) {
+		return 0, fmt.Errorf("top-up amount must be positive")
+	}
+
+	tx, err := database.Conn.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := database.Q.WithTx(tx)
+
+	invoiceId, err := qtx.CreateInvoice(ctx, database.CreateInvoiceParams{
+		UserID:             userId,
+		Status:             InvoiceUnpaid,
+		CancellationReason: pgtype.Text{Valid: false},
+		PaidAt:             types.Timestamp{Timestamp: pgtype.Timestamp{Valid: false}},
+		DueAt:              types.Timestamp{Timestamp: pgtype.Timestamp{Valid: true, Time: time.Now().UTC().Add(time.Hour * 168)}},
+		Amount:             amount,
+		Currency:           currency,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("create invoice: %w", err)
+	}
+
+	err = qtx.CreateInvoiceItem(ctx, database.CreateInvoiceItemParams{
+		InvoiceID:   invoiceId,
+		Description: "Account credit top-up",
+		Amount:      amount,
+		Type:        InvoiceItemCredit,
+		ItemID:      pgtype.Int4{Valid: false},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("create invoice item: %w", err)
+	}
+
+	err = updateInvoiceTotals(ctx, qtx, invoiceId)
+	if err != nil {
+		return 0, fmt.Errorf("update invoice totals: %w", err)
+	}
+
+	err = assignInvoiceNumberOnIssue(ctx, qtx, invoiceId)
+	if err != nil {
+		return 0, fmt.Errorf("assign invoice number: %w", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("commit tx: %w", err)
+	}
+
+	slog.Info("create credit top-up invoice", "user_id", userId, "invoice_id", invoiceId, "amount", amount, "currency", currency)
+
+	NotifyInvoiceCreated(ctx, invoiceId)
+
+	return invoiceId, nil
+}
+
+// ApplyCreditToInvoice pays the unpaid part of the invoice with the credit balance of the
+// invoice owner in the currency of the invoice, and returns the amount of credit used. The invoice is partially paid if the
+// balance is not enough to cover it.
+//
+// ErrInsufficientCredit is returned if the user has no credit. ErrInvoiceNotPayable is returned
+// if the invoice is not UNPAID or is a credit top-up invoice.
+func ApplyCreditToInvoice(ctx context.Context, invoiceId int32) (decimal.Decimal, error) {
+	tx, err := database.Conn.Begin(ctx)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := database.Q.WithTx(tx)
+
+	invoice, err := qtx.SelectInvoiceForUpdate(ctx, invoiceId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return decimal.Zero, ErrNotFound
+		}
+		return decimal.Zero, fmt.Errorf("db: %w", err)
+	}
+
+	if invoice.Status != InvoiceUnpaid {
+		return decimal.Zero, ErrInvoiceNotPayable
+	}
+
+	// credit must not be used to buy more credit
+	items, err := qtx.ListInvoiceItems(ctx, invoiceId)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("db: %w", err)
+	}
+	for _, item := range items {
+		if item.Type == InvoiceItemCredit {
+			return decimal.Zero, ErrInvoiceNotPayable
+		}
+	}
+
+	// lock the user row so that concurrent payments can not spend the same credit
+	_, err = qtx.FindUserByIdForUpdate(ctx, invoice.UserID)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("db: %w", err)
+	}
+
+	balance, err := qtx.GetUserCreditBalance(ctx, database.GetUserCreditBalanceParams{
+		UserID:   invoice.UserID,
+		Currency: invoice.Currency,
+	})
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("db: %w", err)
+	}
+
+	if balance.LessThanOrEqual(decimal.Zero) {
+		return decimal.Zero, ErrInsufficientCredit
+	}
+
+	totalPayment, err := qtx.TotalInvoicePayment(ctx, invoiceId)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("db: %w", err)
+	}
+
+	remaining := invoice.Amount.Sub(totalPayment)
+	if remaining.LessThanOrEqual(decimal.Zero) {
+		return decimal.Zero, ErrInvoiceNotPayable
+	}
+
+	amount := decimal.Min(balance, remaining)
+
+	creditTxId, err := qtx.CreateCreditTransaction(ctx, database.CreateCreditTransactionParams{
+		UserID:      invoice.UserID,
+		Amount:      amount.Neg(),
+		Description: fmt.Sprintf("Payment for invoice #%d", invoiceId),
+		Type:        CreditPayment,
+		InvoiceID:   pgtype.Int4{Valid: true, Int32: invoiceId},
+		Currency:    invoice.Currency,
+	})
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("db: %w", err)
+	}
+
+	paid, err := addInvoicePayment(ctx, tx, invoiceId, "Account credit", amount, strconv.Itoa(int(creditTxId)), GatewayCredit)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("commit tx: %w", err)
+	}
+
+	slog.Info("apply credit to invoice", "invoice_id", invoiceId, "user_id", invoice.UserID, "amount", amount, "balance", balance, "paid", paid)
+
+	notifyPaymentReceived(ctx, invoiceId, "Account credit", amount, GatewayCredit)
+
+	return amount, nil
+}
+
+// RefundToCredit returns amount of a credit payment to the credit balance of the invoice owner in qtx,
+// and returns the id of the credit transaction.
+func RefundToCredit(ctx context.Context, qtx *database.Queries, payment *database.InvoicePayment, amount decimal.Decimal) (int32, error) {
+	invoice, err := qtx.FindInvoiceById(ctx, payment.InvoiceID)
+	if err != nil {
+		return 0, fmt.Errorf("db: %w", err)
+	}
+
+	id, err := qtx.CreateCreditTransaction(ctx, database.CreateCreditTransactionParams{
+		UserID:      invoice.UserID,
+		Amount:      amount,
+		Description: fmt.Sprintf("Refund for invoice #%d", invoice.ID),
+		Type:        CreditRefund,
+		InvoiceID:   pgtype.Int4{Valid: true, Int32: invoice.ID},
+		Currency:    invoice.Currency,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("db: %w", err)
+	}
+
+	return id, nil
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"billing3/database"
+	"billing3/service/email"
 	"billing3/utils"
 	"context"
 	"time"
@@ -13,13 +14,29 @@ func InitCron() {
 	}, "close overdue invoices")
 
 	utils.NewCronJob(time.Hour, func() error {
-		return CancelOverdueServices()
-	}, "cancel overdue services")
+		return ProcessDunning()
+	}, "dunning")
 
 	utils.NewCronJob(time.Hour, func() error {
-		return database.Q.DeleteExpiredSessions(context.Background())
+		return ProcessCancellationRequests()
+	}, "cancellation requests")
+
+	utils.NewCronJob(time.Hour, func() error {
+		return DeleteExpiredSessions(context.Background())
 	}, "delete expired sessions")
 
+	utils.NewCronJob(time.Hour, func() error {
+		return database.Q.DeleteExpiredWebauthnSessions(context.Background())
+	}, "delete expired webauthn sessions")
+
+	utils.NewCronJob(time.Hour, func() error {
+		return database.Q.DeleteExpiredLoginThrottles(context.Background())
+	}, "delete expired login throttles")
+
+	utils.NewCronJob(time.Hour, func() error {
+		return email.MarkDiscardedFailed(context.Background())
+	}, "mark discarded emails as failed")
+
 	utils.NewCronJob(time.Hour*24, func() error {
 		return GenerateRenewalInvoices()
 	}, "generate renewal invoices")
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"billing3/database"
+	"billing3/database/types"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+)
+
+var ErrCurrencyUnsupported = errors.New("currency is not supported")
+var ErrCurrencyMismatch = errors.New("currency does not match the invoice")
+
+var currencyRegex = regexp.MustCompile("^[A-Z]{3}$")
+
+// NormalizeCurrency returns the currency code as it is stored in the database, e.g. "eur" becomes "EUR".
+func NormalizeCurrency(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// IsValidCurrencyCode reports whether code looks like an ISO 4217 currency code.
+func IsValidCurrencyCode(code string) bool {
+	return currencyRegex.MatchString(code)
+}
+
+// BaseCurrency returns the currency that product prices, fixed coupons and gateway fees are entered in.
+func BaseCurrency(ctx context.Context) string {
+	return NormalizeCurrency(SettingBaseCurrency.Get(ctx))
+}
+
+// ListCurrencies returns the currencies that clients can use, which are the base currency and
+// every currency with an exchange rate.
+func ListCurrencies(ctx context.Context) ([]string, error) {
+	base := BaseCurrency(ctx)
+
+	rates, err := database.Q.ListExchangeRates(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+
+	currencies := []string{base}
+	for _, rate := range rates {
+		if rate.Currency != base {
+			currencies = append(currencies, rate.Currency)
+		}
+	}
+
+	return currencies, nil
+}
+
+// exchangeRate returns the amount of currency that equals 1 unit of the base currency.
+// ErrCurrencyUnsupported is returned if the currency has no exchange rate.
+func exchangeRate(ctx context.Context, qtx *database.Queries, currency string) (decimal.Decimal, error) {
+	if currency == BaseCurrency(ctx) {
+		return decimal.NewFromInt(1), nil
+	}
+
+	rate, err := qtx.FindExchangeRate(ctx, currency)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return decimal.Zero, ErrCurrencyUnsupported
+		}
+		return decimal.Zero, fmt.Errorf("db: %w", err)
+	}
+
+	if rate.Rate.LessThanOrEqual(decimal.Zero) {
+		return decimal.Zero, ErrCurrencyUnsupported
+	}
+
+	return rate.Rate, nil
+}
+
+// CheckCurrency returns ErrCurrencyUnsupported if clients can not use the currency.
+func CheckCurrency(ctx context.Context, currency string) error {
+	_, err := exchangeRate(ctx, database.Q, currency)
+	return err
+}
+
+// ConvertAmount converts amount from one currency to another using the exchange rates.
+// The result is rounded to 2 decimal places.
+func ConvertAmount(ctx context.Context, qtx *database.Queries, amount decimal.Decimal, from string, to string) (decimal.Decimal, error) {
+	if from == to {
+		return amount, nil
+	}
+
+	fromRate, err := exchangeRate(ctx, qtx, from)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	toRate, err := exchangeRate(ctx, qtx, to)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	return amount.Div(fromRate).Mul(toRate).Round(2), nil
+}
+
+// localPrice returns the price and setup fee in currency. The price set for the currency is used if
+// there is one, otherwise the base currency price is converted with the exchange rate.
+func localPrice(ctx context.Context, price decimal.Decimal, setupFee decimal.Decimal, currencies map[string]types.CurrencyPrice, currency string) (decimal.Decimal, decimal.Decimal, error) {
+	// a currency must have an exchange rate to be used, even if all prices are set
+	rate, err := exchangeRate(ctx, database.Q, currency)
+	if err != nil {
+		return decimal.Zero, decimal.Zero, err
+	}
+
+	if p, ok := currencies[currency]; ok {
+		return p.Price, p.SetupFee, nil
+	}
+
+	return price.Mul(rate).Round(2), setupFee.Mul(rate).Round(2), nil
+}
+
+// CheckInvoiceCurrency returns ErrCurrencyMismatch if currency is not the currency of the invoice.
+// It is used by gateways to verify the currency of a completed payment.
+func CheckInvoiceCurrency(ctx context.Context, invoiceId int32, currency string) error {
+	invoice, err := database.Q.FindInvoiceById(ctx, invoiceId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("db: %w", err)
+	}
+
+	if invoice.Currency != NormalizeCurrency(currency) {
+		return fmt.Errorf("%w: invoice %d is in %s, got %s", ErrCurrencyMismatch, invoiceId, invoice.Currency, currency)
+	}
+
+	return nil
+}
@@ -0,0 +1,352 @@
+package service
+
+import (
+	"billing3/database"
+	"billing3/database/types"
+	"billing3/service/extension"
+	"billing3/service/notification"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	DunningReminder  = "reminder"
+	DunningSuspend   = "suspend"
+	DunningTerminate = "terminate"
+)
+
+// reminders can not be sent earlier than this many days before the expiry date
+const maxDunningReminderDays = 30
+
+// DunningPolicy is the dunning policy of a service, with the product override applied.
+// Days are relative to the expiry date of the service.
+type DunningPolicy struct {
+	ReminderDays  []int32 // sorted
+	SuspendDays   int32   // negative if the service is not suspended
+	TerminateDays int32
+}
+
+// ParseDunningDays parses a comma separated list of days, e.g. "-3,1,3".
+func ParseDunningDays(s string) ([]int32, error) {
+	days := make([]int32, 0)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		d, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number of days: %s", part)
+		}
+		days = append(days, int32(d))
+	}
+	return days, nil
+}
+
+// ValidateDunningOverride returns an error if the dunning override of a product can not be used.
+func ValidateDunningOverride(p *types.DunningPolicy) error {
+	for _, d := range p.ReminderDays {
+		if d < -maxDunningReminderDays {
+			return fmt.Errorf("reminders can not be sent more than %d days before the expiry date", maxDunningReminderDays)
+		}
+	}
+	if p.TerminateDays != nil && *p.TerminateDays < 0 {
+		return fmt.Errorf("terminate days must not be negative")
+	}
+	if p.SuspendDays != nil && p.TerminateDays != nil && *p.SuspendDays > *p.TerminateDays {
+		return fmt.Errorf("suspend days must not be greater than terminate days")
+	}
+	return nil
+}
+
+// ValidateDunningSettings returns an error if the dunning_suspend_days and dunning_terminate_days settings can not be
+// used. An empty suspendDays disables suspension.
+func ValidateDunningSettings(suspendDays string, terminateDays string) error {
+	terminate, err := strconv.Atoi(strings.TrimSpace(terminateDays))
+	if err != nil || terminate < 0 {
+		return fmt.Errorf("terminate days must be a number that is not negative")
+	}
+
+	if strings.TrimSpace(suspendDays) == "" {
+		return nil
+	}
+	suspend, err := strconv.Atoi(strings.TrimSpace(suspendDays))
+	if err != nil || suspend < 0 {
+		return fmt.Errorf("suspend days must be empty or a number that is not negative")
+	}
+	if suspend > terminate {
+		return fmt.Errorf("suspend days must not be greater than terminate days")
+	}
+	return nil
+}
+
+// GetDunningPolicy returns the global dunning policy from the settings, with the product override applied
+// if override is not nil. Invalid settings are ignored.
+func GetDunningPolicy(ctx context.Context, override *types.DunningPolicy) DunningPolicy {
+	p := DunningPolicy{}
+
+	reminderDays, err := ParseDunningDays(SettingDunningReminderDays.Get(ctx))
+	if err != nil {
+		slog.Error("invalid dunning reminder days setting", "err", err)
+	}
+	p.ReminderDays = reminderDays
+
+	suspendDays, err := strconv.Atoi(strings.TrimSpace(SettingDunningSuspendDays.Get(ctx)))
+	if err != nil {
+		suspendDays = -1
+	}
+	p.SuspendDays = int32(suspendDays)
+
+	terminateDays, err := strconv.Atoi(strings.TrimSpace(SettingDunningTerminateDays.Get(ctx)))
+	if err != nil || terminateDays < 0 {
+		// terminating every overdue service at once is worse than using the default
+		slog.Error("invalid dunning terminate days setting", "value", SettingDunningTerminateDays.Get(ctx))
+		terminateDays, _ = strconv.Atoi(SettingDunningTerminateDays.defaultValue)
+	}
+	p.TerminateDays = int32(terminateDays)
+
+	if override != nil {
+		if override.ReminderDays != nil {
+			p.ReminderDays = override.ReminderDays
+		}
+		if override.SuspendDays != nil {
+			p.SuspendDays = *override.SuspendDays
+		}
+		if override.TerminateDays != nil {
+			p.TerminateDays = *override.TerminateDays
+		}
+	}
+
+	p.ReminderDays = slices.DeleteFunc(slices.Clone(p.ReminderDays), func(d int32) bool { return d < -maxDunningReminderDays })
+	slices.Sort(p.ReminderDays)
+
+	return p
+}
+
+// dunningStepDue reports whether the step that is days after dueAt should have been taken at now.
+func dunningStepDue(now time.Time, dueAt time.Time, days int32) bool {
+	return !now.Before(dueAt.Add(time.Duration(days) * time.Hour * 24))
+}
+
+// suspendedByDunning reports whether the service was suspended by ProcessDunning, and not e.g. by an admin.
+func suspendedByDunning(s *database.Service) bool {
+	return s.Status == ServiceSuspended && strings.HasPrefix(s.StatusReason, DunningSuspend+": ")
+}
+
+// unsuspendPaidServices unsuspends the services that were suspended by dunning and have been renewed since. They
+// are normally unsuspended when the invoice is paid, this catches the ones that could not be, because another
+// action was running at the time, e.g. the suspension itself.
+func unsuspendPaidServices(ctx context.Context, now time.Time) error {
+	services, err := database.Q.FindServicesSuspendedByDunning(ctx, types.Timestamp{Timestamp: pgtype.Timestamp{Valid: true, Time: now}})
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	for _, s := range services {
+		slog.Info("unsuspend renewed service", "service_id", s.ID, "expires_at", s.ExpiresAt.Time)
+		err = extension.DoActionAsyncWithReason(ctx, nil, s.Extension, s.ID, "unsuspend", ServiceActive, "unsuspended: renewal paid")
+		if err != nil {
+			if errors.Is(err, extension.ErrActionRunning) {
+				continue
+			}
+			slog.Error("unsuspend renewed service", "err", err, "service_id", s.ID)
+		}
+	}
+	return nil
+}
+
+// ProcessDunning takes the dunning steps of services that are not renewed before their expiry date:
+// - send a reminder email about the unpaid renewal invoice at each reminder day
+// - suspend the service after it has been overdue for the suspend days
+// - terminate the service and cancel its unpaid invoices after it has been overdue for the terminate days
+//
+// Only the latest reminder that is due is sent. Every step is recorded in service_dunning_steps, and is only
+// taken once per billing period. Services suspended by dunning that have been renewed are unsuspended.
+func ProcessDunning() error {
+	ctx := context.Background()
+	now := time.Now()
+
+	err := unsuspendPaidServices(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	services, err := database.Q.FindServicesForDunning(ctx, types.Timestamp{Timestamp: pgtype.Timestamp{Valid: true, Time: now.Add(time.Hour * 24 * maxDunningReminderDays)}})
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	policies := make(map[int32]DunningPolicy) // product id => policy
+
+	for _, s := range services {
+		policy, ok := policies[s.ProductID.Int32]
+		if !ok {
+			var override *types.DunningPolicy
+			if s.ProductID.Valid {
+				product, err := database.Q.FindProductById(ctx, s.ProductID.Int32)
+				if err == nil {
+					override = &product.Dunning
+				} else {
+					slog.Error("dunning: find product", "err", err, "service_id", s.ID, "product_id", s.ProductID.Int32)
+				}
+			}
+			policy = GetDunningPolicy(ctx, override)
+			policies[s.ProductID.Int32] = policy
+		}
+
+		err := serviceDunning(ctx, now, &s, &policy)
+		if err != nil {
+			slog.Error("dunning", "err", err, "service_id", s.ID)
+		}
+	}
+
+	return nil
+}
+
+func serviceDunning(ctx context.Context, now time.Time, s *database.Service, policy *DunningPolicy) error {
+	dueAt := s.ExpiresAt.Time
+
+	invoices, err := database.Q.FindInvoiceByService(ctx, pgtype.Int4{Valid: true, Int32: s.ID})
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+	var unpaid *database.Invoice
+	for i := range invoices {
+		if invoices[i].Status == InvoiceUnpaid {
+			unpaid = &invoices[i]
+			break
+		}
+	}
+
+	if dunningStepDue(now, dueAt, policy.TerminateDays) {
+		return dunningAction(ctx, s, DunningTerminate, policy.TerminateDays, unpaid, "terminate", ServiceCancelled)
+	}
+
+	if policy.SuspendDays >= 0 && s.Status == ServiceActive && dunningStepDue(now, dueAt, policy.SuspendDays) {
+		err := dunningAction(ctx, s, DunningSuspend, policy.SuspendDays, unpaid, "suspend", ServiceSuspended)
+		if err != nil {
+			return err
+		}
+	}
+
+	// a reminder is only useful if there is an invoice to pay
+	if unpaid == nil {
+		return nil
+	}
+
+	for i := len(policy.ReminderDays) - 1; i >= 0; i-- {
+		days := policy.ReminderDays[i]
+		if !dunningStepDue(now, dueAt, days) {
+			continue
+		}
+
+		created, err := database.Q.CreateServiceDunningStep(ctx, database.CreateServiceDunningStepParams{
+			ServiceID: s.ID,
+			DueAt:     s.ExpiresAt,
+			Step:      DunningReminder,
+			Days:      days,
+			InvoiceID: pgtype.Int4{Valid: true, Int32: unpaid.ID},
+		})
+		if err != nil {
+			return fmt.Errorf("db: %w", err)
+		}
+		if created > 0 {
+			slog.Info("dunning reminder", "service_id", s.ID, "invoice_id", unpaid.ID, "days", days)
+			return sendDunningReminder(ctx, s, unpaid, policy)
+		}
+
+		break
+	}
+
+	return nil
+}
+
+// dunningAction records the step and runs the extension action on the service. Nothing is recorded if another
+// action is running, so the step is retried next time.
+func dunningAction(ctx context.Context, s *database.Service, step string, days int32, unpaid *database.Invoice, action string, newStatus string) error {
+	tx, err := database.Conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := database.Q.WithTx(tx)
+
+	invoiceId := pgtype.Int4{Valid: false}
+	if unpaid != nil {
+		invoiceId = pgtype.Int4{Valid: true, Int32: unpaid.ID}
+	}
+
+	created, err := qtx.CreateServiceDunningStep(ctx, database.CreateServiceDunningStepParams{
+		ServiceID: s.ID,
+		DueAt:     s.ExpiresAt,
+		Step:      step,
+		Days:      days,
+		InvoiceID: invoiceId,
+	})
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+	if created == 0 {
+		// already taken, e.g. the action failed and an admin has to look at it
+		return nil
+	}
+
+	if step == DunningTerminate && unpaid != nil {
+		err = qtx.UpdateInvoiceCancelled(ctx, database.UpdateInvoiceCancelledParams{
+			CancellationReason: pgtype.Text{Valid: true, String: "service terminated"},
+			ID:                 unpaid.ID,
+		})
+		if err != nil {
+			return fmt.Errorf("db: %w", err)
+		}
+	}
+
+	reason := fmt.Sprintf("%s: %d days overdue", step, days)
+	if unpaid != nil {
+		reason = fmt.Sprintf("%s: invoice %s %d days overdue", step, InvoiceDisplayNumber(unpaid), days)
+	}
+
+	err = extension.DoActionAsyncWithReason(ctx, tx, s.Extension, s.ID, action, newStatus, reason)
+	if err != nil {
+		if errors.Is(err, extension.ErrActionRunning) {
+			slog.Info("dunning postponed, another action is running", "service_id", s.ID, "step", step)
+			return nil
+		}
+		return fmt.Errorf("do action async: %w", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	slog.Info("dunning", "service_id", s.ID, "step", step, "days", days, "expires_at", s.ExpiresAt.Time)
+
+	return nil
+}
+
+func sendDunningReminder(ctx context.Context, s *database.Service, invoice *database.Invoice, policy *DunningPolicy) error {
+	dueAt := s.ExpiresAt.Time
+
+	suspendAt := ""
+	if policy.SuspendDays >= 0 && s.Status == ServiceActive {
+		suspendAt = dueAt.Add(time.Duration(policy.SuspendDays) * time.Hour * 24).Format("2006-01-02")
+	}
+
+	return notification.SendToUser(ctx, notification.EventInvoiceReminder, s.UserID, map[string]any{
+		"Invoice":     invoiceNotificationData(invoice),
+		"Service":     notification.ServiceData(s),
+		"Overdue":     !dueAt.After(time.Now()),
+		"SuspendAt":   suspendAt,
+		"TerminateAt": dueAt.Add(time.Duration(policy.TerminateDays) * time.Hour * 24).Format("2006-01-02"),
+	})
+}
@@ -0,0 +1,28 @@
+package service
+
+import "testing"
+
+func TestValidateDunningSettings(t *testing.T) {
+	tests := []struct {
+		suspend   string
+		terminate string
+		wantErr   bool
+	}{
+		{"3", "10", false},
+		{"0", "0", false},
+		{"", "10", false},
+		{" 10 ", "10", false},
+		{"11", "10", true},
+		{"-1", "10", true},
+		{"3", "-1", true},
+		{"3", "", true},
+		{"abc", "10", true},
+		{"3", "ten", true},
+	}
+	for _, tt := range tests {
+		err := ValidateDunningSettings(tt.suspend, tt.terminate)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ValidateDunningSettings(%q, %q) = %v, want error %v", tt.suspend, tt.terminate, err, tt.wantErr)
+		}
+	}
+}
@@ -1,11 +1,13 @@
 package email
 
 import (
+	"billing3/database"
+	"bytes"
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"log/slog"
-	"mime/quotedprintable"
 	"net"
 	"os"
 
@@ -13,13 +15,29 @@ import (
 	"github.com/emersion/go-smtp"
 )
 
+const (
+	StatusQueued = "QUEUED" // waiting to be sent, or to be retried
+	StatusSent   = "SENT"
+	StatusFailed = "FAILED" // all attempts failed
+)
+
+var (
+	ErrEmailQueued    = errors.New("email is already queued")
+	ErrEmailSensitive = errors.New("email contains a secret link and can not be resent")
+)
+
 var username, password, endpoint, port, tlsType, from string
 
-func sendmail(to, subject, body string) error {
-	slog.Info("sendmail", "to", to, "from", from, "subject", subject)
+// sendmail sends the message over smtp.
+func sendmail(m *Message) error {
+	slog.Info("sendmail", "to", m.To, "from", m.From, "subject", m.Subject)
+
+	data, err := m.Bytes()
+	if err != nil {
+		return fmt.Errorf("send mail: %w", err)
+	}
 
 	var c *smtp.Client
-	var err error
 	switch tlsType {
 	case "TLS":
 		c, err = smtp.DialTLS(net.JoinHostPort(endpoint, port), &tls.Config{})
@@ -28,7 +46,7 @@ func sendmail(to, subject, body string) error {
 	case "NONE":
 		c, err = smtp.Dial(net.JoinHostPort(endpoint, port))
 	default:
-		panic("unsupported tls type: " + tlsType)
+		return fmt.Errorf("send mail: unsupported tls type: %s", tlsType)
 	}
 	if err != nil {
 		return fmt.Errorf("send mail: dial: %w", err)
@@ -36,86 +54,144 @@ func sendmail(to, subject, body string) error {
 
 	defer c.Close()
 
-	saslClient := sasl.NewLoginClient(username, password)
-	err = c.Auth(saslClient)
-	if err != nil {
-		return fmt.Errorf("send mail: auth: %w", err)
+	// authentication is optional, e.g. for a local relay
+	if username != "" {
+		saslClient := sasl.NewLoginClient(username, password)
+		err = c.Auth(saslClient)
+		if err != nil {
+			return fmt.Errorf("send mail: auth: %w", err)
+		}
 	}
 
-	err = c.Mail(from, nil)
+	err = c.SendMail(m.From, []string{m.To}, bytes.NewReader(data))
 	if err != nil {
 		return fmt.Errorf("send mail: %w", err)
 	}
-	err = c.Rcpt(to, nil)
+
+	err = c.Quit()
 	if err != nil {
 		return fmt.Errorf("send mail: %w", err)
 	}
 
-	w, err := c.Data()
+	return nil
+}
+
+// Init reads smtp settings from env variables. SMTP_USERNAME is optional.
+func Init() {
+	username = os.Getenv("SMTP_USERNAME")
+	password = os.Getenv("SMTP_PASSWORD")
+	endpoint = os.Getenv("SMTP_ENDPOINT")
+	port = os.Getenv("SMTP_PORT")
+	tlsType = os.Getenv("SMTP_TLS")
+	from = os.Getenv("SMTP_FROM")
+
+}
+
+func SendMailAsync(ctx context.Context, to, subject, body string) error {
+	return SendMultipartMailAsync(ctx, to, subject, body, "")
+}
+
+// SendMultipartMailAsync is like SendMailAsync, and also includes text as the plain text version of the email.
+//
+// The email is saved to the email log and sent by a background job, which retries with backoff if it can not
+// be sent.
+func SendMultipartMailAsync(ctx context.Context, to, subject, body, text string) error {
+	return queueMail(ctx, to, subject, body, text, false)
+}
+
+// SendSensitiveMailAsync is like SendMultipartMailAsync, for emails that contain a secret, e.g. a password reset
+// link. The body is not shown in the email log, and is removed from it once the email is sent or has failed.
+func SendSensitiveMailAsync(ctx context.Context, to, subject, body, text string) error {
+	return queueMail(ctx, to, subject, body, text, true)
+}
+
+func queueMail(ctx context.Context, to, subject, body, text string, sensitive bool) error {
+	tx, err := database.Conn.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("send mail: %w", err)
+		return fmt.Errorf("begin tx: %w", err)
 	}
+	defer tx.Rollback(ctx)
+
+	qtx := database.Q.WithTx(tx)
 
-	_, err = fmt.Fprintf(w, "From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/html; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n", from, to, subject)
+	logId, err := qtx.CreateEmailLog(ctx, database.CreateEmailLogParams{
+		Recipient: to,
+		Subject:   subject,
+		Html:      body,
+		Text:      text,
+		Sensitive: sensitive,
+	})
 	if err != nil {
-		return fmt.Errorf("send mail: %w", err)
+		return fmt.Errorf("db: %w", err)
 	}
 
-	qw := quotedprintable.NewWriter(w)
+	_, err = database.River.InsertTx(ctx, tx, EmailArgs{LogId: logId}, nil)
+	if err != nil {
+		return fmt.Errorf("insert job: %w", err)
+	}
 
-	emailTemplate := `
-<!DOCTYPE html>
-<html lang="en">
-<head>
-    <meta charset="UTF-8">
-    <meta name="viewport" content="width=device-width, initial-scale=1.0">
-</head>
-<body>
-    %s
-</body>
-</html>
-	`
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	slog.Info("email queued", "id", logId, "to", to, "subject", subject)
+
+	return nil
+}
 
-	_, err = fmt.Fprintf(qw, emailTemplate, body)
+// Resend sends an email in the email log again. ErrEmailQueued is returned if the email is waiting to be sent,
+// ErrEmailSensitive if its body is not kept, and an error wrapping pgx.ErrNoRows if the email does not exist.
+func Resend(ctx context.Context, logId int32) error {
+	tx, err := database.Conn.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("send mail: write quoted-printable: %w", err)
+		return fmt.Errorf("begin tx: %w", err)
 	}
+	defer tx.Rollback(ctx)
 
-	err = qw.Close()
+	qtx := database.Q.WithTx(tx)
+
+	l, err := qtx.FindEmailLogById(ctx, logId)
 	if err != nil {
-		return fmt.Errorf("send mail: close quoted-printable writer: %w", err)
+		return fmt.Errorf("db: %w", err)
+	}
+
+	if l.Status == StatusQueued {
+		return ErrEmailQueued
+	}
+	if l.Sensitive {
+		return ErrEmailSensitive
 	}
 
-	err = w.Close()
+	err = qtx.UpdateEmailLogQueued(ctx, logId)
 	if err != nil {
-		return fmt.Errorf("send mail: %w", err)
+		return fmt.Errorf("db: %w", err)
 	}
 
-	err = c.Quit()
+	_, err = database.River.InsertTx(ctx, tx, EmailArgs{LogId: logId}, nil)
 	if err != nil {
-		return fmt.Errorf("send mail: %w", err)
+		return fmt.Errorf("insert job: %w", err)
 	}
 
-	return nil
-}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
 
-// Init reads smtp settings from env variables and start background worker
-func Init() {
-	username = os.Getenv("SMTP_USERNAME")
-	password = os.Getenv("SMTP_PASSWORD")
-	endpoint = os.Getenv("SMTP_ENDPOINT")
-	port = os.Getenv("SMTP_PORT")
-	tlsType = os.Getenv("SMTP_TLS")
-	from = os.Getenv("SMTP_FROM")
+	slog.Info("email resend", "id", logId, "to", l.Recipient, "subject", l.Subject)
 
+	return nil
 }
 
-func SendMailAsync(ctx context.Context, to, subject, body string) error {
-	go func() {
-		err := sendmail(to, subject, body)
-		if err != nil {
-			slog.Error("send mail async", "error", err)
-		}
-	}()
+// MarkDiscardedFailed marks the emails that are QUEUED without a job to send them as FAILED, e.g. because the job
+// was discarded after the worker crashed, so that they can be resent.
+func MarkDiscardedFailed(ctx context.Context) error {
+	n, err := database.Q.UpdateEmailLogsDiscarded(ctx)
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+	if n > 0 {
+		slog.Warn("emails marked as failed, their jobs were discarded", "count", n)
+	}
 	return nil
 }
@@ -0,0 +1,394 @@
+package email
+
+import (
+	"billing3/database"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+	"github.com/riverqueue/river"
+	"github.com/riverqueue/river/riverdriver/riverpgxv5"
+	"github.com/riverqueue/river/rivertype"
+)
+
+// smtpStub is a local SMTP server that keeps the messages it receives.
+type smtpStub struct {
+	username string // authentication is required if not empty
+	password string
+	reject   string // recipients that are rejected
+
+	mu       sync.Mutex
+	messages []stubMessage
+}
+
+type stubMessage struct {
+	From string
+	To   []string
+	Data string
+}
+
+func (s *smtpStub) received() []stubMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]stubMessage(nil), s.messages...)
+}
+
+func (s *smtpStub) NewSession(c *smtp.Conn) (smtp.Session, error) {
+	return &stubSession{stub: s}, nil
+}
+
+type stubSession struct {
+	stub   *smtpStub
+	authed bool
+	msg    stubMessage
+}
+
+func (s *stubSession) AuthMechanisms() []string {
+	return []string{sasl.Login}
+}
+
+// Auth implements the server side of the LOGIN mechanism, which go-sasl only has a client for.
+func (s *stubSession) Auth(mech string) (sasl.Server, error) {
+	username := ""
+	return stubLoginServer(func(response []byte) ([]byte, bool, error) {
+		if username == "" {
+			username = string(response)
+			return []byte("Password:"), false, nil
+		}
+		if username != s.stub.username || string(response) != s.stub.password {
+			return nil, false, errors.New("invalid credentials")
+		}
+		s.authed = true
+		return nil, true, nil
+	}), nil
+}
+
+type stubLoginServer func(response []byte) ([]byte, bool, error)
+
+func (f stubLoginServer) Next(response []byte) ([]byte, bool, error) {
+	return f(response)
+}
+
+func (s *stubSession) Mail(from string, opts *smtp.MailOptions) error {
+	if s.stub.username != "" && !s.authed {
+		return smtp.ErrAuthRequired
+	}
+	s.msg.From = from
+	return nil
+}
+
+func (s *stubSession) Rcpt(to string, opts *smtp.RcptOptions) error {
+	if to == s.stub.reject {
+		return &smtp.SMTPError{Code: 550, EnhancedCode: smtp.EnhancedCode{5, 1, 1}, Message: "no such user"}
+	}
+	s.msg.To = append(s.msg.To, to)
+	return nil
+}
+
+func (s *stubSession) Data(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.msg.Data = string(data)
+
+	s.stub.mu.Lock()
+	s.stub.messages = append(s.stub.messages, s.msg)
+	s.stub.mu.Unlock()
+	return nil
+}
+
+func (s *stubSession) Reset() {
+	s.msg = stubMessage{}
+}
+
+func (s *stubSession) Logout() error {
+	return nil
+}
+
+// startSMTPStub starts stub on a local port and points the smtp settings at it.
+func startSMTPStub(t *testing.T, stub *smtpStub) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := smtp.NewServer(stub)
+	srv.Domain = "localhost"
+	srv.AllowInsecureAuth = true
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+
+	oldUsername, oldPassword, oldEndpoint, oldPort, oldTlsType, oldFrom := username, password, endpoint, port, tlsType, from
+	t.Cleanup(func() {
+		username, password, endpoint, port, tlsType, from = oldUsername, oldPassword, oldEndpoint, oldPort, oldTlsType, oldFrom
+	})
+
+	endpoint, port, _ = net.SplitHostPort(l.Addr().String())
+	tlsType = "NONE"
+	username = stub.username
+	password = stub.password
+	from = "billing@example.com"
+}
+
+func TestSendmail(t *testing.T) {
+	stub := &smtpStub{}
+	startSMTPStub(t, stub)
+
+	err := sendmail(&Message{
+		From:    "billing@example.com",
+		To:      "user@example.com",
+		Subject: "Invoice créée",
+		HTML:    "<p>Your invoice</p>",
+		Text:    "Your invoice",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := stub.received()
+	if len(messages) != 1 {
+		t.Fatalf("%d messages received, want 1", len(messages))
+	}
+	m := messages[0]
+	if m.From != "billing@example.com" {
+		t.Errorf("from = %q", m.From)
+	}
+	if len(m.To) != 1 || m.To[0] != "user@example.com" {
+		t.Errorf("to = %v", m.To)
+	}
+	for _, want := range []string{
+		"To: user@example.com\r\n",
+		"Subject: =?utf-8?q?Invoice_cr=C3=A9=C3=A9e?=\r\n",
+		"Content-Type: multipart/alternative;",
+		"Content-Type: text/plain; charset=utf-8",
+		"Your invoice",
+		"<p>Your invoice</p>",
+	} {
+		if !strings.Contains(m.Data, want) {
+			t.Errorf("missing %q in\n%s", want, m.Data)
+		}
+	}
+}
+
+func TestSendmailAuth(t *testing.T) {
+	stub := &smtpStub{username: "billing", password: "secret"}
+	startSMTPStub(t, stub)
+
+	err := sendmail(&Message{From: from, To: "user@example.com", Subject: "Test", HTML: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stub.received()) != 1 {
+		t.Errorf("%d messages received, want 1", len(stub.received()))
+	}
+
+	password = "wrong"
+	err = sendmail(&Message{From: from, To: "user@example.com", Subject: "Test", HTML: "test"})
+	if err == nil || !strings.Contains(err.Error(), "auth") {
+		t.Errorf("err = %v, want an auth error", err)
+	}
+	if len(stub.received()) != 1 {
+		t.Errorf("%d messages received, want 1", len(stub.received()))
+	}
+}
+
+func TestSendmailRejected(t *testing.T) {
+	stub := &smtpStub{reject: "unknown@example.com"}
+	startSMTPStub(t, stub)
+
+	err := sendmail(&Message{From: from, To: "unknown@example.com", Subject: "Test", HTML: "test"})
+	if err == nil || !strings.Contains(err.Error(), "no such user") {
+		t.Errorf("err = %v, want the error of the server", err)
+	}
+}
+
+func TestSendmailUnsupportedTLS(t *testing.T) {
+	startSMTPStub(t, &smtpStub{})
+	tlsType = "SSL"
+
+	err := sendmail(&Message{From: from, To: "user@example.com", Subject: "Test", HTML: "test"})
+	if err == nil {
+		t.Error("expected an error")
+	}
+}
+
+// createTestEmailLog creates a QUEUED email without a job to send it.
+func createTestEmailLog(t *testing.T, sensitive bool) database.EmailLog {
+	t.Helper()
+	ctx := context.Background()
+
+	id, err := database.Q.CreateEmailLog(ctx, database.CreateEmailLogParams{
+		Recipient: "user@example.com",
+		Subject:   "Test",
+		Html:      "<p>https://example.com/auth/reset-password2?token=secret</p>",
+		Text:      "https://example.com/auth/reset-password2?token=secret",
+		Sensitive: sensitive,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := database.Q.FindEmailLogById(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func workEmail(logId int32, attempt int) error {
+	w := &EmailWorker{}
+	return w.Work(context.Background(), &river.Job[EmailArgs]{
+		JobRow: &rivertype.JobRow{Attempt: attempt, MaxAttempts: emailMaxAttempts},
+		Args:   EmailArgs{LogId: logId},
+	})
+}
+
+func TestEmailWorker(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name      string
+		sensitive bool
+		wantBody  bool
+	}{
+		{"normal", false, true},
+		{"sensitive", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &smtpStub{}
+			startSMTPStub(t, stub)
+
+			l := createTestEmailLog(t, tt.sensitive)
+			err := workEmail(l.ID, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			messages := stub.received()
+			if len(messages) != 1 || !strings.Contains(messages[0].Data, "token=3Dsecret") {
+				t.Fatalf("the email with the link was not sent: %+v", messages)
+			}
+
+			l, err = database.Q.FindEmailLogById(ctx, l.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if l.Status != StatusSent || l.Attempts != 1 {
+				t.Errorf("status = %s, attempts = %d", l.Status, l.Attempts)
+			}
+			if hasBody := l.Html != "" || l.Text != ""; hasBody != tt.wantBody {
+				t.Errorf("body kept = %v, want %v", hasBody, tt.wantBody)
+			}
+
+			// the job ran twice, e.g. after a crash before it was completed
+			err = workEmail(l.ID, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stub.received()) != 1 {
+				t.Errorf("the email was sent again")
+			}
+		})
+	}
+}
+
+func TestEmailWorkerFailed(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+
+	stub := &smtpStub{reject: "user@example.com"}
+	startSMTPStub(t, stub)
+
+	l := createTestEmailLog(t, true)
+
+	// retried until the last attempt
+	for _, tt := range []struct {
+		attempt int
+		want    string
+	}{{1, StatusQueued}, {emailMaxAttempts, StatusFailed}} {
+		attempt, want := tt.attempt, tt.want
+		err := workEmail(l.ID, attempt)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		got, err := database.Q.FindEmailLogById(ctx, l.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != want || !strings.Contains(got.LastError, "no such user") {
+			t.Errorf("attempt %d: status = %s, last error = %q", attempt, got.Status, got.LastError)
+		}
+		if hasBody := got.Html != ""; hasBody != (want == StatusQueued) {
+			t.Errorf("attempt %d: body kept = %v", attempt, hasBody)
+		}
+	}
+}
+
+func TestResendSensitive(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+
+	l := createTestEmailLog(t, true)
+	err := database.Q.UpdateEmailLogSent(ctx, l.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = Resend(ctx, l.ID)
+	if !errors.Is(err, ErrEmailSensitive) {
+		t.Errorf("err = %v, want %v", err, ErrEmailSensitive)
+	}
+}
+
+func TestMarkDiscardedFailed(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+
+	discarded := createTestEmailLog(t, true)
+
+	// a queued email whose job is waiting to run
+	queued := createTestEmailLog(t, false)
+	client, err := river.NewClient(riverpgxv5.New(database.Conn), &river.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job, err := client.Insert(ctx, EmailArgs{LogId: queued.ID}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.JobDelete(ctx, job.Job.ID)
+	})
+
+	err = MarkDiscardedFailed(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := database.Q.FindEmailLogById(ctx, discarded.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != StatusFailed || got.LastError == "" || got.Html != "" {
+		t.Errorf("discarded: status = %s, last error = %q, body = %q", got.Status, got.LastError, got.Html)
+	}
+
+	got, err = database.Q.FindEmailLogById(ctx, queued.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != StatusQueued {
+		t.Errorf("queued: status = %s", got.Status)
+	}
+}
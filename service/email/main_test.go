@@ -0,0 +1,45 @@
+package email
+
+import (
+	"billing3/database"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/riverqueue/river/riverdriver/riverpgxv5"
+	"github.com/riverqueue/river/rivermigrate"
+)
+
+// Tests that need Postgres are skipped unless TEST_DATABASE is set, see service/main_test.go.
+var testDatabase = os.Getenv("TEST_DATABASE")
+
+func TestMain(m *testing.M) {
+	if testDatabase != "" {
+		database.Connect(testDatabase)
+
+		// the email log is checked for jobs in river_job
+		migrator, err := rivermigrate.New(riverpgxv5.New(database.Conn), nil)
+		if err != nil {
+			panic(err)
+		}
+		_, err = migrator.Migrate(context.Background(), rivermigrate.DirectionUp, nil)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	code := m.Run()
+
+	if testDatabase != "" {
+		database.Close()
+	}
+	os.Exit(code)
+}
+
+// requireDB skips the test if no test database is configured.
+func requireDB(t *testing.T) {
+	t.Helper()
+	if testDatabase == "" {
+		t.Skip("TEST_DATABASE is not set")
+	}
+}
@@ -0,0 +1,97 @@
+package email
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"time"
+)
+
+const emailTemplate = `
+<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+</head>
+<body>
+    %s
+</body>
+</html>
+	`
+
+// Message is an email with an html body, and an optional plain text version of the body.
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	HTML    string // the content of <body>
+	Text    string
+}
+
+// Bytes returns the message in RFC 5322 format. The message is multipart/alternative if Text is not empty,
+// otherwise it only has the html part.
+func (m *Message) Bytes() ([]byte, error) {
+	buf := bytes.Buffer{}
+
+	fmt.Fprintf(&buf, "From: %s\r\n", m.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", m.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+
+	html := fmt.Sprintf(emailTemplate, m.HTML)
+
+	if m.Text == "" {
+		fmt.Fprintf(&buf, "Content-Type: text/html; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n")
+		err := writeQuotedPrintable(&buf, html)
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+
+	// the preferred part comes last
+	for _, part := range []struct{ contentType, content string }{{"text/plain", m.Text}, {"text/html", html}} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("create part: %w", err)
+		}
+		err = writeQuotedPrintable(pw, part.content)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := mw.Close()
+	if err != nil {
+		return nil, fmt.Errorf("close multipart writer: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qw := quotedprintable.NewWriter(w)
+
+	_, err := io.WriteString(qw, s)
+	if err != nil {
+		return fmt.Errorf("write quoted-printable: %w", err)
+	}
+
+	err = qw.Close()
+	if err != nil {
+		return fmt.Errorf("close quoted-printable writer: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package email
+
+import (
+	"billing3/database"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/riverqueue/river"
+)
+
+// emailMaxAttempts is the number of attempts before an email is marked as FAILED. With the default backoff of
+// river, the last attempt is about 4 hours after the first one.
+const emailMaxAttempts = 10
+
+type EmailArgs struct {
+	LogId int32 `json:"log_id"`
+}
+
+func (EmailArgs) Kind() string { return "email" }
+
+func (EmailArgs) InsertOpts() river.InsertOpts {
+	return river.InsertOpts{
+		MaxAttempts: emailMaxAttempts,
+	}
+}
+
+type EmailWorker struct {
+	river.WorkerDefaults[EmailArgs]
+}
+
+func (w *EmailWorker) Work(ctx context.Context, job *river.Job[EmailArgs]) error {
+	l, err := database.Q.FindEmailLogById(ctx, job.Args.LogId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return river.JobCancel(fmt.Errorf("email %d not found", job.Args.LogId))
+		}
+		return fmt.Errorf("db: %w", err)
+	}
+
+	if l.Status != StatusQueued {
+		return nil
+	}
+
+	err = sendmail(&Message{
+		From:    from,
+		To:      l.Recipient,
+		Subject: l.Subject,
+		HTML:    l.Html,
+		Text:    l.Text,
+	})
+	if err != nil {
+		status := StatusQueued
+		if job.Attempt >= job.MaxAttempts {
+			status = StatusFailed
+		}
+
+		slog.Error("send email", "err", err, "id", l.ID, "to", l.Recipient, "attempt", job.Attempt, "status", status)
+
+		dbErr := database.Q.UpdateEmailLogFailed(ctx, database.UpdateEmailLogFailedParams{
+			ID:        l.ID,
+			Status:    status,
+			LastError: err.Error(),
+		})
+		if dbErr != nil {
+			slog.Error("update email log", "err", dbErr, "id", l.ID)
+		}
+
+		return err
+	}
+
+	err = database.Q.UpdateEmailLogSent(ctx, l.ID)
+	if err != nil {
+		// the email must not be sent again
+		slog.Error("update email log", "err", err, "id", l.ID)
+	}
+
+	return nil
+}
+
+func init() {
+	river.AddWorker(database.Workers, &EmailWorker{})
+}
@@ -6,3 +6,6 @@ var ErrServiceCancelled = errors.New("service is cancelled")
 var ErrUnpaidInvoiceExists = errors.New("unpaid invoice already exists for the service")
 var ErrNotFound = errors.New("not found")
 var ErrInternalError = errors.New("internal error")
+var ErrInsufficientCredit = errors.New("insufficient credit")
+var ErrInvoiceNotPayable = errors.New("invoice is not payable")
+var ErrDuplicatePayment = errors.New("payment has already been recorded")
@@ -2,11 +2,14 @@ package extension
 
 import (
 	"billing3/database"
+	"billing3/service/audit"
+	"billing3/service/notification"
 	"context"
 	"errors"
 	"fmt"
 	"log/slog"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/riverqueue/river"
 	"github.com/riverqueue/river/rivertype"
 )
@@ -16,6 +19,7 @@ type ExtensionActionArgs struct {
 	Action    string `json:"action"`
 	NewStatus string `json:"new_status"`
 	Extension string `json:"extension"`
+	Reason    string `json:"reason,omitempty"` // saved as the status reason of the service if the status is changed
 }
 
 func (ExtensionActionArgs) Kind() string { return "extension_action" }
@@ -64,15 +68,29 @@ func (w *ExtensionActionWorker) Work(ctx context.Context, job *river.Job[Extensi
 	// update the service status if needed
 
 	if job.Args.NewStatus != "" {
-		err = database.Q.UpdateServiceStatus(ctx, database.UpdateServiceStatusParams{
-			Status: job.Args.NewStatus,
-			ID:     job.Args.ServiceId,
-		})
+		if job.Args.Reason != "" {
+			err = database.Q.UpdateServiceStatusWithReason(ctx, database.UpdateServiceStatusWithReasonParams{
+				Status:       job.Args.NewStatus,
+				StatusReason: job.Args.Reason,
+				ID:           job.Args.ServiceId,
+			})
+		} else {
+			err = database.Q.UpdateServiceStatus(ctx, database.UpdateServiceStatusParams{
+				Status: job.Args.NewStatus,
+				ID:     job.Args.ServiceId,
+			})
+		}
 		if err != nil {
 			slog.Error("update service status", "err", err, "id", job.Args.ServiceId, "status", job.Args.NewStatus)
 		}
 
-		slog.Info("extension action set new status", "service id", job.Args.ServiceId, "new status", job.Args.NewStatus)
+		slog.Info("extension action set new status", "service id", job.Args.ServiceId, "new status", job.Args.NewStatus, "reason", job.Args.Reason)
+		audit.Log(ctx, audit.EntityService, job.Args.ServiceId, "status.update", nil, map[string]string{
+			"status": job.Args.NewStatus,
+			"reason": job.Args.Reason,
+		})
+
+		notifyStatusChange(ctx, &job.Args)
 	}
 
 	slog.Info("extension action done", "service_id", job.Args.ServiceId, "action", job.Args.Action)
@@ -80,6 +98,35 @@ func (w *ExtensionActionWorker) Work(ctx context.Context, job *river.Job[Extensi
 	return nil
 }
 
+// actionEvents maps the actions that change the status of a service to the events that the owner is notified about
+var actionEvents = map[string]string{
+	"create":    notification.EventServiceCreated,
+	"suspend":   notification.EventServiceSuspended,
+	"unsuspend": notification.EventServiceUnsuspended,
+	"terminate": notification.EventServiceTerminated,
+}
+
+func notifyStatusChange(ctx context.Context, args *ExtensionActionArgs) {
+	event, ok := actionEvents[args.Action]
+	if !ok {
+		return
+	}
+
+	s, err := database.Q.FindServiceById(ctx, args.ServiceId)
+	if err != nil {
+		slog.Error("notify service status change", "err", err, "service id", args.ServiceId)
+		return
+	}
+
+	err = notification.SendToUser(ctx, event, s.UserID, map[string]any{
+		"Service": notification.ServiceData(&s),
+		"Reason":  args.Reason,
+	})
+	if err != nil {
+		slog.Error("notify service status change", "err", err, "service id", args.ServiceId)
+	}
+}
+
 var ErrActionRunning = errors.New("another action is running for this service")
 
 // DoActionAsync enqueues a task that executes the action, and change the status of the service to new status if
@@ -87,29 +134,60 @@ var ErrActionRunning = errors.New("another action is running for this service")
 // The actions "create", "terminate", and "reinstall" are enqueued to a special queue that only allows one worker
 // to run at a time, to avoid race conditions on these operations.
 func DoActionAsync(ctx context.Context, ext string, serviceId int32, action string, newStatus string) error {
+	return DoActionAsyncTx(ctx, nil, ext, serviceId, action, newStatus)
+}
+
+// DoActionAsyncTx is like DoActionAsync, but the task is enqueued in tx and only runs after tx is commited.
+// The task is enqueued without a transaction if tx is nil.
+func DoActionAsyncTx(ctx context.Context, tx pgx.Tx, ext string, serviceId int32, action string, newStatus string) error {
+	return DoActionAsyncWithReason(ctx, tx, ext, serviceId, action, newStatus, "")
+}
+
+// DoActionAsyncWithReason is like DoActionAsyncTx, and also saves reason as the status reason of the service
+// when the status is changed, e.g. "suspended: invoice #1 overdue".
+func DoActionAsyncWithReason(ctx context.Context, tx pgx.Tx, ext string, serviceId int32, action string, newStatus string, reason string) error {
 	queue := river.QueueDefault
 	if action == "create" || action == "terminate" || action == "reinstall" {
 		queue = database.QueueVM
 	}
 
-	slog.Info("do action async", "ext", ext, "service_id", serviceId, "action", action, "new_status", newStatus, "queue", queue)
+	slog.Info("do action async", "ext", ext, "service_id", serviceId, "action", action, "new_status", newStatus, "reason", reason, "queue", queue)
 
-	resp, err := database.River.Insert(ctx, ExtensionActionArgs{
+	args := ExtensionActionArgs{
 		ServiceId: serviceId,
 		Action:    action,
 		NewStatus: newStatus,
 		Extension: ext,
-	}, &river.InsertOpts{
+		Reason:    reason,
+	}
+	opts := &river.InsertOpts{
 		MaxAttempts: 1,
 		Queue:       queue,
 		Metadata:    []byte(fmt.Sprintf("{\"service_id\": %d}", serviceId)),
-	})
+	}
+
+	var resp *rivertype.JobInsertResult
+	var err error
+	if tx == nil {
+		resp, err = database.River.Insert(ctx, args, opts)
+	} else {
+		resp, err = database.River.InsertTx(ctx, tx, args, opts)
+	}
 	if err != nil {
 		return fmt.Errorf("insert job: %w", err)
 	}
 	if resp.UniqueSkippedAsDuplicate {
 		return ErrActionRunning
 	}
+
+	// recorded when enqueued, since the worker does not know who asked for it
+	after := map[string]string{"extension": ext, "new_status": newStatus, "reason": reason}
+	if tx == nil {
+		audit.Log(ctx, audit.EntityService, serviceId, "action."+action, nil, after)
+	} else {
+		audit.LogTx(ctx, tx, audit.EntityService, serviceId, "action."+action, nil, after)
+	}
+
 	return nil
 }
 
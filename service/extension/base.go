@@ -49,6 +49,10 @@ type Extension interface {
 	// Action performs an action on the service.
 	// Action must support the following actions:
 	// suspend, create, terminate, unsuspend
+	//
+	// Action may support upgrade, which applies the service settings
+	// to the existing service after its plan is changed (e.g. resize
+	// a VM). Extensions that do not support it should return nil.
 	Action(serviceId int32, action string) error
 
 	// ClientActions returns a list of actions that can be performed
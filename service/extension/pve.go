@@ -667,6 +667,110 @@ func (p *PVE) qemuDelete(serviceId int32, lxc bool) error {
 	return nil
 }
 
+// resize applies cpu, memory and disk in service settings to the VM. Disks can only grow, so the disk
+// is left unchanged if it is not smaller than the new size. Changes to cpu and memory of a running KVM
+// VM take effect after it is rebooted.
+func (p *PVE) resize(serviceId int32, lxc bool) error {
+	serviceSettings, serverSettings, err := p.getServiceSettings(serviceId)
+	if err != nil {
+		return fmt.Errorf("pve: resize: %w", err)
+	}
+
+	vmType := "qemu"
+	diskName := "scsi0"
+	if lxc {
+		vmType = "lxc"
+		diskName = "rootfs"
+	}
+
+	cpu := serviceSettings["cpu"]
+	memory := serviceSettings["memory"]
+	disk, err := strconv.Atoi(serviceSettings["disk"])
+	if err != nil {
+		return fmt.Errorf("pve: resize: invalid disk: %s", serviceSettings["disk"])
+	}
+
+	address := serverSettings["address"]
+	port := serverSettings["port"]
+	username := serverSettings["username"]
+	password := serverSettings["password"]
+	node := serverSettings["node"]
+	baseUrl := fmt.Sprintf("https://%s:%s/api2/json", address, port)
+
+	csrf, ticket, err := p.pveAuth(baseUrl, username, password)
+	if err != nil {
+		return fmt.Errorf("pve: %w", err)
+	}
+
+	vmid := int(10000 + serviceId)
+
+	slog.Info("pve resize", "service id", serviceId, "vm type", vmType, "cpu", cpu, "memory", memory, "disk", disk)
+
+	// current disk size
+
+	configResp := pveResp[map[string]any]{}
+	err = p.apiGet(fmt.Sprintf("%s/nodes/%s/%s/%d/config", baseUrl, node, vmType, vmid), &configResp, ticket)
+	if err != nil {
+		return fmt.Errorf("pve: %w", err)
+	}
+
+	currentDisk := 0
+	diskConfig, _ := configResp.Data[diskName].(string)
+	matches := regexp.MustCompile(`size=(\d+)G`).FindStringSubmatch(diskConfig)
+	if matches != nil {
+		currentDisk, _ = strconv.Atoi(matches[1])
+	}
+
+	// cpu and memory
+
+	form := url.Values{}
+	form.Set("cores", cpu)
+	form.Set("memory", memory)
+
+	method := "POST"
+	if lxc {
+		method = "PUT"
+	}
+
+	resp := pveResp[string]{}
+	err = p.apiAction(method, fmt.Sprintf("%s/nodes/%s/%s/%d/config", baseUrl, node, vmType, vmid), form, &resp, csrf, ticket)
+	if err != nil {
+		return fmt.Errorf("pve: %w", err)
+	}
+
+	if resp.Data != "" {
+		err = p.waitForTask(baseUrl, node, ticket, resp.Data)
+		if err != nil {
+			return fmt.Errorf("pve: %w", err)
+		}
+	}
+
+	// disk
+
+	if disk <= currentDisk {
+		slog.Info("pve resize disk skipped", "service id", serviceId, "disk", disk, "current disk", currentDisk)
+		return nil
+	}
+
+	resp = pveResp[string]{}
+	form = url.Values{}
+	form.Set("disk", diskName)
+	form.Set("size", strconv.Itoa(disk)+"G")
+	err = p.apiAction("PUT", fmt.Sprintf("%s/nodes/%s/%s/%d/resize", baseUrl, node, vmType, vmid), form, &resp, csrf, ticket)
+	if err != nil {
+		return fmt.Errorf("pve: %w", err)
+	}
+
+	if resp.Data != "" {
+		err = p.waitForTask(baseUrl, node, ticket, resp.Data)
+		if err != nil {
+			return fmt.Errorf("pve: %w", err)
+		}
+	}
+
+	return nil
+}
+
 func (p *PVE) Action(serviceId int32, action string) error {
 
 	serviceSettings, _, err := p.getServiceSettings(serviceId)
@@ -714,6 +818,8 @@ func (p *PVE) Action(serviceId int32, action string) error {
 		return p.createService(serviceId)
 	case "boot":
 		return p.qemuStart(serviceId, vmType == "lxc")
+	case "upgrade":
+		return p.resize(serviceId, vmType == "lxc")
 	}
 
 	return fmt.Errorf("invalid action \"%s\"", action)
@@ -737,7 +843,7 @@ func (p *PVE) AdminActions(serviceId int32) ([]string, error) {
 		return nil, fmt.Errorf("pve: db: %w", err)
 	}
 	if _, ok := s.Settings["server"]; ok {
-		return []string{"poweroff", "reboot", "terminate", "suspend", "unsuspend", "create", "force_poweroff", "boot"}, nil
+		return []string{"poweroff", "reboot", "terminate", "suspend", "unsuspend", "create", "force_poweroff", "boot", "upgrade"}, nil
 	}
 	return []string{"create"}, nil
 }
@@ -3,6 +3,7 @@ package gateways
 import (
 	"billing3/database"
 	"context"
+	"github.com/jackc/pgx/v5"
 	"log/slog"
 	"os"
 
@@ -28,9 +29,16 @@ type Gateway interface {
 	Settings() []GatewaySetting
 
 	// Pay initialize a one time payment, and returns a URL that the user will be redirected to.
-	// total is the total amount, including the payment gateway fee.
+	// total is the total amount in invoice.Currency, including the payment gateway fee.
 	Pay(invoice *database.Invoice, user *database.User, total decimal.Decimal) (string, error)
 
+	// Refund refunds amount of a payment previously made with this gateway, and returns the
+	// reference id of the refund. amount is positive, in currency (the invoice currency), and
+	// never exceeds the refundable amount. tx is the transaction in which the refund is recorded,
+	// with the payment locked. idempotencyKey is unique to the refund, so that retries of the same
+	// refund are not made twice by the gateway.
+	Refund(ctx context.Context, tx pgx.Tx, payment *database.InvoicePayment, amount decimal.Decimal, currency string, idempotencyKey string) (string, error)
+
 	// Route is called once when the application starts.
 	// The payment gateway may register custom routes to r.
 	Route(r chi.Router) error
@@ -0,0 +1,55 @@
+package gateways
+
+import (
+	"billing3/database"
+	"billing3/service"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"log/slog"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/shopspring/decimal"
+)
+
+// Credit pays invoices with the account credit balance of the user.
+// Gateway fee is ignored since no money is actually transferred.
+type Credit struct{}
+
+func (c *Credit) Settings() []GatewaySetting {
+	return []GatewaySetting{}
+}
+
+func (c *Credit) Pay(invoice *database.Invoice, user *database.User, total decimal.Decimal) (string, error) {
+	amount, err := service.ApplyCreditToInvoice(context.Background(), invoice.ID)
+	if err != nil {
+		if errors.Is(err, service.ErrInsufficientCredit) || errors.Is(err, service.ErrInvoiceNotPayable) {
+			return "", err
+		}
+		return "", fmt.Errorf("credit: %w", err)
+	}
+
+	slog.Info("credit payment", "invoice_id", invoice.ID, "user_id", user.ID, "amount", amount.String())
+
+	return "/dashboard/invoice/" + strconv.Itoa(int(invoice.ID)), nil
+}
+
+// Refund returns the amount to the credit balance of the user, in tx so that the credit is only kept if the refund is
+// recorded.
+func (c *Credit) Refund(ctx context.Context, tx pgx.Tx, payment *database.InvoicePayment, amount decimal.Decimal, currency string, idempotencyKey string) (string, error) {
+	id, err := service.RefundToCredit(ctx, database.Q.WithTx(tx), payment, amount)
+	if err != nil {
+		return "", fmt.Errorf("credit: %w", err)
+	}
+	return strconv.Itoa(int(id)), nil
+}
+
+func (c *Credit) Route(r chi.Router) error {
+	return nil
+}
+
+func init() {
+	registerGateway(service.GatewayCredit, &Credit{})
+}
@@ -0,0 +1,100 @@
+package gateways
+
+import (
+	"billing3/database"
+	"billing3/database/types"
+	"billing3/service"
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+// Tests that need Postgres are skipped unless TEST_DATABASE is set, see service/main_test.go.
+var testDatabase = os.Getenv("TEST_DATABASE")
+
+func TestMain(m *testing.M) {
+	if testDatabase != "" {
+		database.Connect(testDatabase)
+	}
+
+	code := m.Run()
+
+	if testDatabase != "" {
+		database.Close()
+	}
+	os.Exit(code)
+}
+
+// requireDB skips the test if no test database is configured.
+func requireDB(t *testing.T) {
+	t.Helper()
+	if testDatabase == "" {
+		t.Skip("TEST_DATABASE is not set")
+	}
+}
+
+var testSeq atomic.Int64
+
+// testName returns a name that is unique in the test database, also across runs.
+func testName(prefix string) string {
+	return fmt.Sprintf("%s-%d-%d", prefix, time.Now().UnixNano(), testSeq.Add(1))
+}
+
+// createTestPayment creates a user with a PAID invoice of amount, paid in full with gateway, and returns the user id
+// and the payment.
+func createTestPayment(t *testing.T, amount decimal.Decimal, gateway string) (int32, database.InvoicePayment) {
+	t.Helper()
+	ctx := context.Background()
+
+	userId, err := database.Q.CreateUser(ctx, database.CreateUserParams{
+		Email:    testName("user") + "@example.com",
+		Name:     "Test User",
+		Role:     "user",
+		Password: "-",
+		Currency: "USD",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invoiceId, err := database.Q.CreateInvoice(ctx, database.CreateInvoiceParams{
+		UserID:   userId,
+		Status:   service.InvoicePaid,
+		PaidAt:   types.Timestamp{Timestamp: pgtype.Timestamp{Valid: true, Time: time.Now()}},
+		DueAt:    types.Timestamp{Timestamp: pgtype.Timestamp{Valid: true, Time: time.Now()}},
+		Amount:   amount,
+		Currency: "USD",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paymentId, err := database.Q.AddInvoicePayment(ctx, database.AddInvoicePaymentParams{
+		InvoiceID:   invoiceId,
+		Description: "Test payment",
+		Amount:      amount,
+		ReferenceID: testName("payment"),
+		Gateway:     gateway,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payments, err := database.Q.ListInvoicePayments(ctx, invoiceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range payments {
+		if p.ID == paymentId {
+			return userId, p
+		}
+	}
+	t.Fatal("payment not found")
+	return 0, database.InvoicePayment{}
+}
@@ -6,29 +6,45 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
 	"github.com/shopspring/decimal"
 )
 
+// paypalZeroDecimalCurrencies are the currencies that PayPal does not accept decimals for
+var paypalZeroDecimalCurrencies = []string{"HUF", "JPY", "TWD"}
+
 type Paypal struct {
 	httpClient     *http.Client
 	returnTemplate *template.Template
 }
 
+// paypalValue formats amount in currency for the PayPal api
+func paypalValue(amount decimal.Decimal, currency string) string {
+	if slices.Contains(paypalZeroDecimalCurrencies, currency) {
+		return amount.RoundUp(0).String()
+	}
+	return amount.StringFixed(2)
+}
+
 func (p *Paypal) Settings() []GatewaySetting {
 	return []GatewaySetting{
 		{DisplayName: "Client ID", Name: "client_id", Type: "string", Regex: "^.+$"},
 		{DisplayName: "Client Secret", Name: "client_secret", Type: "string", Regex: "^.+$"},
 		{DisplayName: "Sandbox", Name: "sandbox", Type: "select", Values: []string{"Yes", "No"}},
+		{DisplayName: "Webhook ID", Name: "webhook_id", Type: "string", Description: "Webhook URL: <PUBLIC_DOMAIN>/api/gateway/paypal/webhook, events: Checkout order approved, Payment capture completed, Payment capture refunded"},
 	}
 }
 
@@ -67,6 +83,7 @@ func (p *Paypal) Pay(invoice *database.Invoice, user *database.User, total decim
 	}
 	type purchaseUnitStruct struct {
 		ReferenceId string       `json:"reference_id"`
+		CustomId    string       `json:"custom_id"` // invoice id, included in capture webhook events
 		Amount      amountStruct `json:"amount"`
 	}
 	type applicationContextStruct struct {
@@ -83,7 +100,7 @@ func (p *Paypal) Pay(invoice *database.Invoice, user *database.User, total decim
 
 	req := reqStruct{
 		PurchaseUnit: []purchaseUnitStruct{
-			{ReferenceId: strconv.Itoa(int(invoice.ID)), Amount: amountStruct{CurrencyCode: "USD", Value: total.String()}},
+			{ReferenceId: strconv.Itoa(int(invoice.ID)), CustomId: strconv.Itoa(int(invoice.ID)), Amount: amountStruct{CurrencyCode: invoice.Currency, Value: paypalValue(total, invoice.Currency)}},
 		},
 		Intent: "CAPTURE",
 		ApplicationContext: applicationContextStruct{
@@ -136,7 +153,7 @@ func (p *Paypal) Pay(invoice *database.Invoice, user *database.User, total decim
 		return "", fmt.Errorf("http: %s %s %s", httpResp.Status, resp.Error, resp.ErrorDescription)
 	}
 
-	slog.Info("paypal create order", "order_id", resp.Id, "invoice_id", invoice.ID, "total", total.String(), "user_id", user.ID)
+	slog.Info("paypal create order", "order_id", resp.Id, "invoice_id", invoice.ID, "total", total.String(), "currency", invoice.Currency, "user_id", user.ID)
 
 	for _, link := range resp.Links {
 		if link.Rel == "approve" {
@@ -180,126 +197,516 @@ func (p *Paypal) getAccessToken(paypalApi, clientId, clientSecret string) (strin
 	return resp.AccessToken, nil
 }
 
-func (p *Paypal) Route(r chi.Router) error {
+type paypalAmount struct {
+	CurrencyCode string `json:"currency_code"`
+	Value        string `json:"value"`
+}
 
-	r.Get("/return", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html")
-		w.WriteHeader(http.StatusOK)
-		_ = p.returnTemplate.Execute(w, r.URL.Query().Get("token"))
+type paypalPurchaseUnitResp struct {
+	ReferenceId string       `json:"reference_id"` // invoice id
+	Amount      paypalAmount `json:"amount"`
+	Payments    struct {
+		Captures []struct {
+			Id     string       `json:"id"`
+			Status string       `json:"status"`
+			Amount paypalAmount `json:"amount"`
+		} `json:"captures"`
+	} `json:"payments"`
+}
+
+func (p *Paypal) Refund(ctx context.Context, tx pgx.Tx, payment *database.InvoicePayment, amount decimal.Decimal, currency string, idempotencyKey string) (string, error) {
+	if payment.ReferenceID == "" {
+		return "", fmt.Errorf("payment has no reference id")
+	}
+
+	settings, err := getSettings(ctx, "Paypal")
+	if err != nil {
+		return "", err
+	}
+
+	paypalApi := "https://api-m.sandbox.paypal.com"
+	if settings["sandbox"] == "No" {
+		paypalApi = "https://api-m.paypal.com"
+	}
+
+	accessToken, err := p.getAccessToken(paypalApi, settings["client_id"], settings["client_secret"])
+	if err != nil {
+		return "", fmt.Errorf("get access token: %w", err)
+	}
+
+	captureId, err := p.findCaptureId(paypalApi, accessToken, payment.ReferenceID)
+	if err != nil {
+		return "", err
+	}
+
+	type reqStruct struct {
+		Amount      paypalAmount `json:"amount"`
+		NoteToPayer string       `json:"note_to_payer,omitempty"`
+	}
+	reqBytes, err := json.Marshal(reqStruct{
+		Amount: paypalAmount{CurrencyCode: currency, Value: paypalValue(amount, currency)},
 	})
+	if err != nil {
+		return "", fmt.Errorf("http: %w", err)
+	}
 
-	r.Post("/capture", func(w http.ResponseWriter, r *http.Request) {
-		settings, err := getSettings(r.Context(), "Paypal")
+	httpReq, err := http.NewRequest(http.MethodPost, paypalApi+"/v2/payments/captures/"+captureId+"/refund", bytes.NewReader(reqBytes))
+	if err != nil {
+		return "", fmt.Errorf("http: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+accessToken)
+	httpReq.Header.Set("PayPal-Request-Id", idempotencyKey)
+
+	httpResp, err := p.httpClient.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("http: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return "", fmt.Errorf("http: %w", err)
+	}
+
+	if httpResp.StatusCode != http.StatusCreated && httpResp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("http: %s %s", httpResp.Status, string(body))
+	}
+
+	type respStruct struct {
+		Id     string `json:"id"`
+		Status string `json:"status"`
+	}
+	var resp respStruct
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		return "", fmt.Errorf("http: %w", err)
+	}
+
+	if resp.Status != "COMPLETED" && resp.Status != "PENDING" {
+		return "", fmt.Errorf("refund %s: status %s", resp.Id, resp.Status)
+	}
+
+	slog.Info("paypal refund", "capture_id", captureId, "refund_id", resp.Id, "status", resp.Status, "amount", amount.String(), "payment_id", payment.ID)
+
+	return resp.Id, nil
+}
+
+// findCaptureId returns the capture id of a payment reference. Payments recorded before capture ids
+// were stored reference the order id instead, in which case the capture is looked up from the order.
+func (p *Paypal) findCaptureId(paypalApi, accessToken, referenceId string) (string, error) {
+	order, statusCode, err := p.getOrder(paypalApi, accessToken, referenceId)
+	if statusCode == http.StatusNotFound {
+		// not an order id
+		return referenceId, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if len(order.PurchaseUnites) == 0 || len(order.PurchaseUnites[0].Payments.Captures) == 0 {
+		return "", fmt.Errorf("order %s has no captures", referenceId)
+	}
+
+	return order.PurchaseUnites[0].Payments.Captures[0].Id, nil
+}
+
+var errPaypalIncomplete = errors.New("paypal payment incomplete")
+
+type paypalOrderResp struct {
+	Id             string                   `json:"id"`
+	Status         string                   `json:"status"`
+	PurchaseUnites []paypalPurchaseUnitResp `json:"purchase_units"`
+}
+
+// getOrder returns the order details. The http status code is returned along with the error.
+func (p *Paypal) getOrder(paypalApi, accessToken, orderId string) (*paypalOrderResp, int, error) {
+	httpReq, err := http.NewRequest(http.MethodGet, paypalApi+"/v2/checkout/orders/"+url.PathEscape(orderId), nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("http: %w", err)
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+accessToken)
+
+	httpResp, err := p.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, 0, fmt.Errorf("http: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, httpResp.StatusCode, fmt.Errorf("http: get order: %s", httpResp.Status)
+	}
+
+	var order paypalOrderResp
+	err = json.NewDecoder(httpResp.Body).Decode(&order)
+	if err != nil {
+		return nil, httpResp.StatusCode, fmt.Errorf("http: %w", err)
+	}
+
+	return &order, httpResp.StatusCode, nil
+}
+
+// captureOrder captures an approved order and records the payment. Orders that have already been
+// captured, e.g. by the browser and the webhook at the same time, are not captured again, and the
+// existing capture is recorded instead. The payment is recorded only once per capture id.
+func (p *Paypal) captureOrder(ctx context.Context, orderId string) (int32, error) {
+	settings, err := getSettings(ctx, "Paypal")
+	if err != nil {
+		return 0, err
+	}
+
+	paypalApi := "https://api-m.sandbox.paypal.com"
+	if settings["sandbox"] == "No" {
+		paypalApi = "https://api-m.paypal.com"
+	}
+
+	// obtain access token
+
+	accessToken, err := p.getAccessToken(paypalApi, settings["client_id"], settings["client_secret"])
+	if err != nil {
+		return 0, fmt.Errorf("get access token: %w", err)
+	}
+
+	// capture
+	httpReq, err := http.NewRequest(http.MethodPost, paypalApi+"/v2/checkout/orders/"+url.PathEscape(orderId)+"/capture", bytes.NewReader([]byte{}))
+	if err != nil {
+		return 0, fmt.Errorf("http: %w", err)
+	}
+
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+accessToken)
+	httpReq.Header.Set("Prefer", "return=representation")
+	httpReq.Header.Set("PayPal-Request-Id", "capture-"+orderId)
+
+	httpResp, err := p.httpClient.Do(httpReq)
+	if err != nil {
+		return 0, fmt.Errorf("http: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("http: %w", err)
+	}
+
+	var order *paypalOrderResp
+
+	switch {
+	case httpResp.StatusCode == http.StatusCreated || httpResp.StatusCode == http.StatusOK:
+		slog.Info("paypal capture", "order_id", orderId)
+
+		order = &paypalOrderResp{}
+		err = json.Unmarshal(body, order)
 		if err != nil {
-			slog.Error("paypal capture", "err", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+			return 0, fmt.Errorf("http: %w", err)
 		}
+	case httpResp.StatusCode == http.StatusUnprocessableEntity && bytes.Contains(body, []byte("ORDER_ALREADY_CAPTURED")):
+		slog.Info("paypal order already captured", "order_id", orderId)
 
-		paypalApi := "https://api-m.sandbox.paypal.com"
-		if settings["sandbox"] == "No" {
-			paypalApi = "https://api-m.paypal.com"
+		order, _, err = p.getOrder(paypalApi, accessToken, orderId)
+		if err != nil {
+			return 0, err
 		}
+	default:
+		return 0, fmt.Errorf("http: capture order %s: %s %s", orderId, httpResp.Status, string(body))
+	}
 
-		orderId := r.PostFormValue("order_id")
+	if order.Status != "COMPLETED" || len(order.PurchaseUnites) == 0 || len(order.PurchaseUnites[0].Payments.Captures) == 0 {
+		return 0, errPaypalIncomplete
+	}
 
-		// obtain access token
+	invoiceId, err := strconv.Atoi(order.PurchaseUnites[0].ReferenceId)
+	if err != nil {
+		return 0, fmt.Errorf("invalid invoice id %s", order.PurchaseUnites[0].ReferenceId)
+	}
 
-		accessToken, err := p.getAccessToken(paypalApi, settings["client_id"], settings["client_secret"])
+	capture := order.PurchaseUnites[0].Payments.Captures[0]
+
+	// pending captures are recorded by the PAYMENT.CAPTURE.COMPLETED webhook
+	if capture.Status != "COMPLETED" {
+		return int32(invoiceId), errPaypalIncomplete
+	}
+
+	err = p.addCapture(ctx, int32(invoiceId), capture.Id, capture.Amount)
+	if err != nil {
+		return 0, err
+	}
+
+	return int32(invoiceId), nil
+}
+
+// addCapture records a completed capture as a payment of the invoice.
+// The capture id is recorded as reference id, which is required for refunds.
+func (p *Paypal) addCapture(ctx context.Context, invoiceId int32, captureId string, value paypalAmount) error {
+	amount, err := decimal.NewFromString(value.Value)
+	if err != nil {
+		return fmt.Errorf("invalid amount %s", value.Value)
+	}
+
+	err = service.CheckInvoiceCurrency(ctx, invoiceId, value.CurrencyCode)
+	if err != nil {
+		return err
+	}
+
+	_, err = service.InvoiceAddPaymentOnce(ctx, invoiceId, "Paypal payment", amount, captureId, "Paypal")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// verifyWebhook verifies the signature of a webhook event using the verify-webhook-signature api
+func (p *Paypal) verifyWebhook(paypalApi, accessToken, webhookId string, header http.Header, event []byte) error {
+	if webhookId == "" {
+		return fmt.Errorf("webhook id is not configured")
+	}
+
+	type reqStruct struct {
+		AuthAlgo         string          `json:"auth_algo"`
+		CertUrl          string          `json:"cert_url"`
+		TransmissionId   string          `json:"transmission_id"`
+		TransmissionSig  string          `json:"transmission_sig"`
+		TransmissionTime string          `json:"transmission_time"`
+		WebhookId        string          `json:"webhook_id"`
+		WebhookEvent     json.RawMessage `json:"webhook_event"`
+	}
+	reqBytes, err := json.Marshal(reqStruct{
+		AuthAlgo:         header.Get("PAYPAL-AUTH-ALGO"),
+		CertUrl:          header.Get("PAYPAL-CERT-URL"),
+		TransmissionId:   header.Get("PAYPAL-TRANSMISSION-ID"),
+		TransmissionSig:  header.Get("PAYPAL-TRANSMISSION-SIG"),
+		TransmissionTime: header.Get("PAYPAL-TRANSMISSION-TIME"),
+		WebhookId:        webhookId,
+		WebhookEvent:     event,
+	})
+	if err != nil {
+		return fmt.Errorf("http: %w", err)
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, paypalApi+"/v1/notifications/verify-webhook-signature", bytes.NewReader(reqBytes))
+	if err != nil {
+		return fmt.Errorf("http: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+accessToken)
+
+	httpResp, err := p.httpClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("http: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("http: verify webhook signature: %s", httpResp.Status)
+	}
+
+	type respStruct struct {
+		VerificationStatus string `json:"verification_status"`
+	}
+	var resp respStruct
+	err = json.NewDecoder(httpResp.Body).Decode(&resp)
+	if err != nil {
+		return fmt.Errorf("http: %w", err)
+	}
+
+	if resp.VerificationStatus != "SUCCESS" {
+		return fmt.Errorf("verification status %s", resp.VerificationStatus)
+	}
+
+	return nil
+}
+
+// handleWebhookEvent handles a verified webhook event
+func (p *Paypal) handleWebhookEvent(ctx context.Context, paypalApi, accessToken, eventType string, resource json.RawMessage) error {
+	switch eventType {
+	case "CHECKOUT.ORDER.APPROVED":
+		// the buyer approved the order but may never reach the return page, so capture it here
+		var order paypalOrderResp
+		err := json.Unmarshal(resource, &order)
 		if err != nil {
-			slog.Error("paypal capture", "err", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+			return err
 		}
 
-		// capture
-		httpReq, err := http.NewRequest(http.MethodPost, paypalApi+"/v2/checkout/orders/"+orderId+"/capture", bytes.NewReader([]byte{}))
+		_, err = p.captureOrder(ctx, order.Id)
+		if err != nil && !errors.Is(err, errPaypalIncomplete) {
+			return err
+		}
+		return nil
+
+	case "PAYMENT.CAPTURE.COMPLETED":
+		type captureStruct struct {
+			Id                string       `json:"id"`
+			Status            string       `json:"status"`
+			Amount            paypalAmount `json:"amount"`
+			CustomId          string       `json:"custom_id"`
+			SupplementaryData struct {
+				RelatedIds struct {
+					OrderId string `json:"order_id"`
+				} `json:"related_ids"`
+			} `json:"supplementary_data"`
+		}
+		var capture captureStruct
+		err := json.Unmarshal(resource, &capture)
 		if err != nil {
-			slog.Error("paypal capture", "err", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+			return err
 		}
 
-		httpReq.Header.Set("Content-Type", "application/json")
-		httpReq.Header.Set("Authorization", "Bearer "+accessToken)
-		httpReq.Header.Set("Prefer", "return=representation")
+		// orders created before custom_id was set only have the invoice id in the order
+		invoiceId := capture.CustomId
+		if invoiceId == "" {
+			order, _, err := p.getOrder(paypalApi, accessToken, capture.SupplementaryData.RelatedIds.OrderId)
+			if err != nil {
+				return err
+			}
+			if len(order.PurchaseUnites) == 0 {
+				return fmt.Errorf("order %s has no purchase units", order.Id)
+			}
+			invoiceId = order.PurchaseUnites[0].ReferenceId
+		}
 
-		httpResp, err := p.httpClient.Do(httpReq)
+		id, err := strconv.Atoi(invoiceId)
 		if err != nil {
-			slog.Error("paypal capture", "err", err, "order_id", orderId)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+			return fmt.Errorf("invalid invoice id %s", invoiceId)
 		}
-		defer httpResp.Body.Close()
 
-		type amountStruct struct {
-			CurrencyCode string `json:"currency_code"`
-			Value        string `json:"value"`
+		return p.addCapture(ctx, int32(id), capture.Id, capture.Amount)
+
+	case "PAYMENT.CAPTURE.REFUNDED":
+		type refundStruct struct {
+			Id     string       `json:"id"`
+			Status string       `json:"status"`
+			Amount paypalAmount `json:"amount"`
+			Links  []struct {
+				Href string `json:"href"`
+				Rel  string `json:"rel"`
+			} `json:"links"`
+		}
+		var refund refundStruct
+		err := json.Unmarshal(resource, &refund)
+		if err != nil {
+			return err
 		}
-		type purchaseUnitStruct struct {
-			ReferenceId string       `json:"reference_id"` // invoice id
-			Amount      amountStruct `json:"amount"`
+
+		// the "up" link points to the refunded capture
+		captureId := ""
+		for _, link := range refund.Links {
+			if link.Rel == "up" {
+				captureId = link.Href[strings.LastIndex(link.Href, "/")+1:]
+			}
 		}
-		type respStruct struct {
-			Id             string               `json:"id"`
-			Status         string               `json:"status"`
-			PurchaseUnites []purchaseUnitStruct `json:"purchase_units"`
+		if captureId == "" {
+			return fmt.Errorf("refund %s: capture not found", refund.Id)
 		}
 
-		if httpResp.StatusCode != http.StatusCreated {
-			body, err := io.ReadAll(httpResp.Body)
-			if err != nil {
-				slog.Error("paypal capture", "err", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				return
+		payment, err := database.Q.FindInvoicePaymentByReference(ctx, database.FindInvoicePaymentByReferenceParams{
+			Gateway:     "Paypal",
+			ReferenceID: captureId,
+		})
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				slog.Warn("paypal refund of unknown capture", "capture_id", captureId, "refund_id", refund.Id)
+				return nil
 			}
+			return fmt.Errorf("db: %w", err)
+		}
 
-			slog.Error("paypal capture", "order_id", orderId, "status_code", httpResp.Status, "body", string(body))
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+		amount, err := decimal.NewFromString(refund.Amount.Value)
+		if err != nil {
+			return fmt.Errorf("invalid amount %s", refund.Amount.Value)
 		}
 
-		slog.Info("paypal capture", "order_id", orderId)
+		return service.InvoiceAddRefund(ctx, &payment, amount, refund.Id, "refunded on PayPal")
+	}
+
+	return nil
+}
 
-		var resp respStruct
+func (p *Paypal) Route(r chi.Router) error {
 
-		err = json.NewDecoder(httpResp.Body).Decode(&resp)
+	r.Get("/return", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusOK)
+		_ = p.returnTemplate.Execute(w, r.URL.Query().Get("token"))
+	})
+
+	r.Post("/capture", func(w http.ResponseWriter, r *http.Request) {
+		orderId := r.PostFormValue("order_id")
+
+		invoiceId, err := p.captureOrder(r.Context(), orderId)
 		if err != nil {
+			if errors.Is(err, errPaypalIncomplete) {
+				io.WriteString(w, "Payment incomplete")
+				return
+			}
 			slog.Error("paypal capture", "err", err, "order_id", orderId)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		// add payment
-		if resp.Status != "COMPLETED" {
-			io.WriteString(w, "Payment incomplete")
+		// redirect
+		http.Redirect(w, r, fmt.Sprintf("/dashboard/invoice/%d", invoiceId), http.StatusFound)
+	})
+
+	r.Post("/webhook", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 65536))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		invoiceId, err := strconv.Atoi(resp.PurchaseUnites[0].ReferenceId)
+		settings, err := getSettings(r.Context(), "Paypal")
 		if err != nil {
-			slog.Error("paypal capture", "err", "invalid invoice id", "invoice_id", resp.PurchaseUnites[0].ReferenceId)
+			slog.Error("paypal webhook", "err", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		amount, err := decimal.NewFromString(resp.PurchaseUnites[0].Amount.Value)
+		paypalApi := "https://api-m.sandbox.paypal.com"
+		if settings["sandbox"] == "No" {
+			paypalApi = "https://api-m.paypal.com"
+		}
+
+		accessToken, err := p.getAccessToken(paypalApi, settings["client_id"], settings["client_secret"])
 		if err != nil {
-			slog.Error("paypal capture", "err", "invalid amount", "amount", resp.PurchaseUnites[0].Amount.Value)
+			slog.Error("paypal webhook", "err", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		err = service.InvoiceAddPayment(r.Context(), int32(invoiceId), "Paypal payment", amount, resp.Id, "Paypal")
+		err = p.verifyWebhook(paypalApi, accessToken, settings["webhook_id"], r.Header, body)
+		if err != nil {
+			slog.Warn("paypal webhook", "err", err, "ip", r.RemoteAddr)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		type eventStruct struct {
+			Id        string          `json:"id"`
+			EventType string          `json:"event_type"`
+			Resource  json.RawMessage `json:"resource"`
+		}
+		var event eventStruct
+		err = json.Unmarshal(body, &event)
+		if err != nil {
+			slog.Warn("paypal webhook", "err", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		slog.Info("paypal webhook", "event_id", event.Id, "event_type", event.EventType)
+
+		err = p.handleWebhookEvent(r.Context(), paypalApi, accessToken, event.EventType, event.Resource)
 		if err != nil {
-			slog.Error("paypal capture", "err", err)
+			// paypal retries the event on non-2xx responses
+			slog.Error("paypal webhook", "err", err, "event_id", event.Id, "event_type", event.EventType)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		// redirect
-		http.Redirect(w, r, fmt.Sprintf("/dashboard/invoice/%d", invoiceId), http.StatusFound)
+		w.WriteHeader(http.StatusOK)
 	})
+
 	return nil
 }
 
@@ -0,0 +1,82 @@
+package gateways
+
+import (
+	"billing3/database"
+	"billing3/service"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/shopspring/decimal"
+)
+
+var ErrRefundExceedsPayment = errors.New("refund amount exceeds the refundable amount of the payment")
+
+// RefundPayment refunds amount of the payment through the gateway it was made with, and records
+// the refund on the invoice. Payments of unknown gateways (e.g. payments added by admins) are
+// recorded as refunded without contacting any gateway. A zero amount refunds the remaining
+// refundable amount of the payment.
+//
+// The payment is locked while the gateway is called, so concurrent refunds of the same payment
+// are checked against the amount left by the first one.
+func RefundPayment(ctx context.Context, paymentId int32, amount decimal.Decimal, reason string) (*database.InvoicePayment, error) {
+	tx, err := database.Conn.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	payment, refundable, err := service.LockPaymentForRefund(ctx, tx, paymentId)
+	if err != nil {
+		return nil, err
+	}
+
+	invoice, err := database.Q.FindInvoiceById(ctx, payment.InvoiceID)
+	if err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+
+	if amount.IsZero() {
+		amount = refundable
+	}
+
+	if amount.LessThanOrEqual(decimal.Zero) || amount.GreaterThan(refundable) {
+		return nil, ErrRefundExceedsPayment
+	}
+
+	referenceId := ""
+
+	gateway, ok := Gateways[payment.Gateway]
+	if ok {
+		referenceId, err = gateway.Refund(ctx, tx, payment, amount, invoice.Currency, refundIdempotencyKey(payment, refundable, amount))
+		if err != nil {
+			return nil, fmt.Errorf("%s refund: %w", payment.Gateway, err)
+		}
+	} else {
+		slog.Warn("refund without gateway", "payment_id", payment.ID, "gateway", payment.Gateway)
+	}
+
+	slog.Info("refund payment", "payment_id", payment.ID, "invoice_id", payment.InvoiceID, "amount", amount, "currency", invoice.Currency, "refundable", refundable, "gateway", payment.Gateway, "refund_reference_id", referenceId)
+
+	err = service.InvoiceAddRefundTx(ctx, tx, payment, amount, referenceId, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		// the gateway has refunded it, a webhook may still record the refund
+		slog.Error("refund payment: commit", "err", err, "payment_id", payment.ID, "refund_reference_id", referenceId)
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+
+	return payment, nil
+}
+
+// refundIdempotencyKey returns the idempotency key of refunding amount of the payment when refundable is left. The
+// amount refunded so far is part of it, so that a second refund of the same amount is a new refund, while a retry of
+// a refund whose recording failed gets the refund that was already made.
+func refundIdempotencyKey(payment *database.InvoicePayment, refundable decimal.Decimal, amount decimal.Decimal) string {
+	return fmt.Sprintf("refund-%d-%s-%s", payment.ID, payment.Amount.Sub(refundable).StringFixed(2), amount.StringFixed(2))
+}
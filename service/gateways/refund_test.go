@@ -0,0 +1,100 @@
+package gateways
+
+import (
+	"billing3/database"
+	"billing3/service"
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+)
+
+// fakeRefundGateway returns the same refund for the same idempotency key, like Stripe and PayPal.
+type fakeRefundGateway struct {
+	Credit
+	refunds map[string]string
+}
+
+func (g *fakeRefundGateway) Refund(ctx context.Context, tx pgx.Tx, payment *database.InvoicePayment, amount decimal.Decimal, currency string, idempotencyKey string) (string, error) {
+	id, ok := g.refunds[idempotencyKey]
+	if !ok {
+		id = testName("refund")
+		g.refunds[idempotencyKey] = id
+	}
+	return id, nil
+}
+
+func TestRefundIdempotencyKey(t *testing.T) {
+	payment := &database.InvoicePayment{ID: 7, Amount: decimal.NewFromInt(10)}
+
+	first := refundIdempotencyKey(payment, decimal.NewFromInt(10), decimal.NewFromInt(3))
+	second := refundIdempotencyKey(payment, decimal.NewFromInt(7), decimal.NewFromInt(3))
+	if first == second {
+		t.Errorf("equal partial refunds have the same key %q", first)
+	}
+
+	// a retry after the refund was not recorded
+	if retry := refundIdempotencyKey(payment, decimal.NewFromInt(10), decimal.NewFromInt(3)); retry != first {
+		t.Errorf("retry key = %q, want %q", retry, first)
+	}
+}
+
+func TestRefundPaymentEqualPartialRefunds(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+
+	gateway := &fakeRefundGateway{refunds: map[string]string{}}
+	Gateways["TestRefund"] = gateway
+	t.Cleanup(func() { delete(Gateways, "TestRefund") })
+
+	_, payment := createTestPayment(t, decimal.NewFromInt(10), "TestRefund")
+
+	for i := 0; i < 2; i++ {
+		_, err := RefundPayment(ctx, payment.ID, decimal.NewFromInt(3), "partial")
+		if err != nil {
+			t.Fatalf("refund %d: %v", i, err)
+		}
+	}
+
+	if len(gateway.refunds) != 2 {
+		t.Errorf("%d refunds made at the gateway, want 2", len(gateway.refunds))
+	}
+
+	refundable, err := service.RefundableAmount(ctx, &payment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !refundable.Equal(decimal.NewFromInt(4)) {
+		t.Errorf("refundable = %s, want 4", refundable)
+	}
+}
+
+func TestCreditRefundRolledBack(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+
+	userId, payment := createTestPayment(t, decimal.NewFromInt(10), service.GatewayCredit)
+
+	tx, err := database.Conn.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = (&Credit{}).Refund(ctx, tx, &payment, decimal.NewFromInt(10), "USD", "refund")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// e.g. recording the refund failed
+	err = tx.Rollback(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	balance, err := service.CreditBalance(ctx, userId, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !balance.IsZero() {
+		t.Errorf("balance = %s, want 0 after the rollback", balance)
+	}
+}
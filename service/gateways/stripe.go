@@ -0,0 +1,390 @@
+package gateways
+
+import (
+	"billing3/database"
+	"billing3/service"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/shopspring/decimal"
+)
+
+// stripeSignatureTolerance is the maximum age of a webhook event
+const stripeSignatureTolerance = time.Minute * 5
+
+var ErrStripeSignature = errors.New("invalid stripe signature")
+
+// stripeZeroDecimalCurrencies are the currencies whose smallest unit is 1, e.g. 500 means ¥500
+var stripeZeroDecimalCurrencies = []string{"BIF", "CLP", "DJF", "GNF", "JPY", "KMF", "KRW", "MGA", "PYG", "RWF", "UGX", "VND", "VUV", "XAF", "XOF", "XPF"}
+
+type Stripe struct {
+	httpClient *http.Client
+	apiBase    string // https://api.stripe.com, may be replaced with a fake server
+}
+
+func (s *Stripe) Settings() []GatewaySetting {
+	return []GatewaySetting{
+		{DisplayName: "Secret Key", Name: "secret_key", Type: "string", Regex: "^sk_.+$", Placeholder: "sk_live_..."},
+		{DisplayName: "Webhook Signing Secret", Name: "webhook_secret", Type: "string", Regex: "^whsec_.+$", Placeholder: "whsec_...", Description: "Webhook endpoint: <PUBLIC_DOMAIN>/api/gateway/stripe/webhook, event: checkout.session.completed, checkout.session.async_payment_succeeded"},
+	}
+}
+
+func (s *Stripe) Pay(invoice *database.Invoice, user *database.User, total decimal.Decimal) (string, error) {
+
+	if total.LessThanOrEqual(decimal.Zero) {
+		err := service.InvoiceAddPayment(context.Background(), int32(invoice.ID), "Stripe payment (Free)", decimal.Zero, "", "Stripe")
+		if err != nil {
+			return "", fmt.Errorf("stripe free: invoice add payment: %w", err)
+		}
+		return "/dashboard/invoice/" + strconv.Itoa(int(invoice.ID)), nil
+	}
+
+	settings, err := getSettings(context.Background(), "Stripe")
+	if err != nil {
+		return "", err
+	}
+
+	return s.createCheckoutSession(settings["secret_key"], invoice, user, total)
+}
+
+// createCheckoutSession creates a checkout session paying total of the invoice, and returns its url.
+func (s *Stripe) createCheckoutSession(secretKey string, invoice *database.Invoice, user *database.User, total decimal.Decimal) (string, error) {
+	invoiceId := strconv.Itoa(int(invoice.ID))
+	invoiceUrl := fmt.Sprintf("%s/dashboard/invoice/%d", PUBLIC_DOMAIN, invoice.ID)
+
+	// create checkout session
+
+	form := url.Values{}
+	form.Set("mode", "payment")
+	form.Set("success_url", invoiceUrl)
+	form.Set("cancel_url", invoiceUrl)
+	form.Set("client_reference_id", invoiceId)
+	form.Set("customer_email", user.Email)
+	form.Set("metadata[invoice_id]", invoiceId)
+	form.Set("payment_intent_data[metadata][invoice_id]", invoiceId)
+	form.Set("line_items[0][quantity]", "1")
+	form.Set("line_items[0][price_data][currency]", strings.ToLower(invoice.Currency))
+	form.Set("line_items[0][price_data][unit_amount]", strconv.FormatInt(stripeAmount(total, invoice.Currency), 10))
+	form.Set("line_items[0][price_data][product_data][name]", "Invoice #"+invoiceId)
+
+	type respStruct struct {
+		Id  string `json:"id"`
+		Url string `json:"url"`
+	}
+	var resp respStruct
+	err := s.request(http.MethodPost, "/v1/checkout/sessions", secretKey, "", form, &resp)
+	if err != nil {
+		return "", err
+	}
+
+	slog.Info("stripe create checkout session", "session_id", resp.Id, "invoice_id", invoice.ID, "total", total.String(), "currency", invoice.Currency, "user_id", user.ID)
+
+	if resp.Url == "" {
+		return "", fmt.Errorf("checkout session url not found")
+	}
+
+	return resp.Url, nil
+}
+
+func (s *Stripe) Refund(ctx context.Context, tx pgx.Tx, payment *database.InvoicePayment, amount decimal.Decimal, currency string, idempotencyKey string) (string, error) {
+	if payment.ReferenceID == "" {
+		return "", fmt.Errorf("payment has no reference id")
+	}
+
+	settings, err := getSettings(ctx, "Stripe")
+	if err != nil {
+		return "", err
+	}
+
+	return s.createRefund(settings["secret_key"], payment, amount, currency, idempotencyKey)
+}
+
+// createRefund refunds amount of the payment intent of the payment, and returns the refund id.
+func (s *Stripe) createRefund(secretKey string, payment *database.InvoicePayment, amount decimal.Decimal, currency string, idempotencyKey string) (string, error) {
+	form := url.Values{}
+	form.Set("payment_intent", payment.ReferenceID)
+	form.Set("amount", strconv.FormatInt(stripeAmount(amount, currency), 10))
+	form.Set("metadata[invoice_id]", strconv.Itoa(int(payment.InvoiceID)))
+
+	type respStruct struct {
+		Id     string `json:"id"`
+		Status string `json:"status"`
+	}
+	var resp respStruct
+	err := s.request(http.MethodPost, "/v1/refunds", secretKey, idempotencyKey, form, &resp)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.Status != "succeeded" && resp.Status != "pending" {
+		return "", fmt.Errorf("refund %s: status %s", resp.Id, resp.Status)
+	}
+
+	slog.Info("stripe refund", "payment_intent", payment.ReferenceID, "refund_id", resp.Id, "status", resp.Status, "amount", amount.String(), "payment_id", payment.ID)
+
+	return resp.Id, nil
+}
+
+// request sends a form encoded request to the stripe api and decodes the response into resp
+func (s *Stripe) request(method string, path string, secretKey string, idempotencyKey string, form url.Values, resp any) error {
+	httpReq, err := http.NewRequest(method, s.apiBase+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("http: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.Header.Set("Authorization", "Bearer "+secretKey)
+	if idempotencyKey != "" {
+		httpReq.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	httpResp, err := s.httpClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("http: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return fmt.Errorf("http: %w", err)
+	}
+
+	if httpResp.StatusCode != http.StatusOK {
+		type errStruct struct {
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		var e errStruct
+		_ = json.Unmarshal(body, &e)
+		return fmt.Errorf("http: %s %s %s", httpResp.Status, e.Error.Type, e.Error.Message)
+	}
+
+	err = json.Unmarshal(body, resp)
+	if err != nil {
+		return fmt.Errorf("http: %w", err)
+	}
+
+	return nil
+}
+
+// stripeExponent returns the number of decimal places of the smallest unit of the currency
+func stripeExponent(currency string) int32 {
+	if slices.Contains(stripeZeroDecimalCurrencies, strings.ToUpper(currency)) {
+		return 0
+	}
+	return 2
+}
+
+// stripeAmount converts amount to the smallest currency unit
+func stripeAmount(amount decimal.Decimal, currency string) int64 {
+	if stripeExponent(currency) == 0 {
+		return amount.RoundUp(0).IntPart()
+	}
+	return amount.Shift(2).Round(0).IntPart()
+}
+
+// verifyStripeSignature verifies the Stripe-Signature header of a webhook event.
+// The header looks like "t=1492774577,v1=5257a869...,v1=...", and v1 is the hex encoded
+// HMAC-SHA256 of "<t>.<payload>" using the webhook signing secret.
+func verifyStripeSignature(payload []byte, header string, secret string, now time.Time) error {
+	if secret == "" {
+		return fmt.Errorf("%w: webhook secret is not configured", ErrStripeSignature)
+	}
+
+	var timestamp string
+	var signatures [][]byte
+
+	for _, part := range strings.Split(header, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch k {
+		case "t":
+			timestamp = v
+		case "v1":
+			sig, err := hex.DecodeString(v)
+			if err == nil {
+				signatures = append(signatures, sig)
+			}
+		}
+	}
+
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: invalid timestamp", ErrStripeSignature)
+	}
+
+	if now.Sub(time.Unix(t, 0)).Abs() > stripeSignatureTolerance {
+		return fmt.Errorf("%w: timestamp outside the tolerance zone", ErrStripeSignature)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+
+	for _, sig := range signatures {
+		if hmac.Equal(sig, expected) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: no matching signature", ErrStripeSignature)
+}
+
+// stripePayment is the payment of an invoice by a paid checkout session.
+type stripePayment struct {
+	SessionId   string
+	InvoiceId   int32
+	Amount      decimal.Decimal
+	Currency    string
+	ReferenceId string // the payment intent id, which is required for refunds
+}
+
+// parseStripeWebhook verifies the signature of a webhook event and returns the payment in it. Events that do not
+// pay an invoice return nil without an error, they only need to be acknowledged so that stripe stops retrying.
+func parseStripeWebhook(payload []byte, header string, secret string, now time.Time) (*stripePayment, error) {
+	err := verifyStripeSignature(payload, header, secret, now)
+	if err != nil {
+		return nil, err
+	}
+
+	type eventStruct struct {
+		Id   string `json:"id"`
+		Type string `json:"type"`
+		Data struct {
+			Object struct {
+				Id                string `json:"id"`
+				PaymentStatus     string `json:"payment_status"`
+				ClientReferenceId string `json:"client_reference_id"`
+				AmountTotal       int64  `json:"amount_total"`
+				Currency          string `json:"currency"`
+				PaymentIntent     string `json:"payment_intent"`
+			} `json:"object"`
+		} `json:"data"`
+	}
+
+	var event eventStruct
+	err = json.Unmarshal(payload, &event)
+	if err != nil {
+		return nil, fmt.Errorf("json: %w", err)
+	}
+
+	slog.Info("stripe webhook", "event_id", event.Id, "type", event.Type)
+
+	// only checkout sessions are handled
+	if event.Type != "checkout.session.completed" && event.Type != "checkout.session.async_payment_succeeded" {
+		return nil, nil
+	}
+
+	session := event.Data.Object
+
+	// delayed payment methods complete the session before the payment succeeds
+	if session.PaymentStatus != "paid" {
+		slog.Info("stripe checkout session not paid", "session_id", session.Id, "payment_status", session.PaymentStatus)
+		return nil, nil
+	}
+
+	invoiceId, err := strconv.Atoi(session.ClientReferenceId)
+	if err != nil {
+		slog.Error("stripe webhook", "err", "invalid invoice id", "invoice_id", session.ClientReferenceId, "session_id", session.Id)
+		return nil, nil
+	}
+
+	referenceId := session.PaymentIntent
+	if referenceId == "" {
+		referenceId = session.Id
+	}
+
+	return &stripePayment{
+		SessionId:   session.Id,
+		InvoiceId:   int32(invoiceId),
+		Amount:      decimal.New(session.AmountTotal, -stripeExponent(session.Currency)),
+		Currency:    session.Currency,
+		ReferenceId: referenceId,
+	}, nil
+}
+
+func (s *Stripe) Route(r chi.Router) error {
+
+	r.Post("/webhook", func(w http.ResponseWriter, r *http.Request) {
+		payload, err := io.ReadAll(io.LimitReader(r.Body, 65536))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		settings, err := getSettings(r.Context(), "Stripe")
+		if err != nil {
+			slog.Error("stripe webhook", "err", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		payment, err := parseStripeWebhook(payload, r.Header.Get("Stripe-Signature"), settings["webhook_secret"], time.Now())
+		if err != nil {
+			slog.Warn("stripe webhook", "err", err, "ip", r.RemoteAddr)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if payment == nil {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		err = service.CheckInvoiceCurrency(r.Context(), payment.InvoiceId, payment.Currency)
+		if err != nil {
+			slog.Error("stripe webhook", "err", err, "session_id", payment.SessionId)
+			if errors.Is(err, service.ErrCurrencyMismatch) || errors.Is(err, service.ErrNotFound) {
+				// retrying does not help
+				w.WriteHeader(http.StatusOK)
+			} else {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+
+		_, err = service.InvoiceAddPaymentOnce(r.Context(), payment.InvoiceId, "Stripe payment", payment.Amount, payment.ReferenceId, "Stripe")
+		if err != nil {
+			slog.Error("stripe webhook", "err", err, "session_id", payment.SessionId)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+
+	return nil
+}
+
+func init() {
+	s := Stripe{}
+	s.httpClient = &http.Client{
+		Timeout: time.Second * 10,
+	}
+	// e.g. a stripe-mock server for testing
+	s.apiBase = os.Getenv("STRIPE_API_BASE")
+	if s.apiBase == "" {
+		s.apiBase = "https://api.stripe.com"
+	}
+	registerGateway("Stripe", &s)
+}
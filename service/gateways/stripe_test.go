@@ -0,0 +1,263 @@
+package gateways
+
+import (
+	"billing3/database"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// newFakeStripe returns a Stripe client whose api is served by handler.
+func newFakeStripe(t *testing.T, handler http.HandlerFunc) *Stripe {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return &Stripe{httpClient: srv.Client(), apiBase: srv.URL}
+}
+
+func signStripe(payload string, secret string, t time.Time) string {
+	ts := fmt.Sprint(t.Unix())
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "." + payload))
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestStripeCreateCheckoutSession(t *testing.T) {
+	s := newFakeStripe(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/checkout/sessions" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer sk_test_123" {
+			t.Errorf("Authorization = %q", got)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]string{
+			"mode":                                   "payment",
+			"client_reference_id":                    "12",
+			"customer_email":                         "user@example.com",
+			"metadata[invoice_id]":                   "12",
+			"line_items[0][price_data][currency]":    "usd",
+			"line_items[0][price_data][unit_amount]": "1234",
+		}
+		for k, v := range want {
+			if got := r.PostForm.Get(k); got != v {
+				t.Errorf("%s = %q, want %q", k, got, v)
+			}
+		}
+		fmt.Fprint(w, `{"id":"cs_test_1","url":"https://checkout.stripe.com/c/pay/cs_test_1"}`)
+	})
+
+	url, err := s.createCheckoutSession("sk_test_123", &database.Invoice{ID: 12, Currency: "USD"}, &database.User{ID: 3, Email: "user@example.com"}, decimal.RequireFromString("12.34"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "https://checkout.stripe.com/c/pay/cs_test_1" {
+		t.Errorf("url = %q", url)
+	}
+}
+
+func TestStripeCreateCheckoutSessionError(t *testing.T) {
+	s := newFakeStripe(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":{"type":"invalid_request_error","message":"Invalid currency"}}`)
+	})
+
+	_, err := s.createCheckoutSession("sk_test_123", &database.Invoice{ID: 12, Currency: "XXX"}, &database.User{}, decimal.NewFromInt(1))
+	if err == nil || !strings.Contains(err.Error(), "Invalid currency") {
+		t.Errorf("err = %v, want the stripe error message", err)
+	}
+}
+
+func TestStripeCreateRefund(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  string
+		wantErr bool
+	}{
+		{"succeeded", "succeeded", false},
+		{"pending", "pending", false},
+		{"failed", "failed", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeStripe(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v1/refunds" {
+					t.Errorf("path = %s", r.URL.Path)
+				}
+				if got := r.Header.Get("Idempotency-Key"); got != "refund-7-0.00-5.00" {
+					t.Errorf("Idempotency-Key = %q", got)
+				}
+				if err := r.ParseForm(); err != nil {
+					t.Fatal(err)
+				}
+				if got := r.PostForm.Get("payment_intent"); got != "pi_123" {
+					t.Errorf("payment_intent = %q", got)
+				}
+				if got := r.PostForm.Get("amount"); got != "500" {
+					t.Errorf("amount = %q", got)
+				}
+				fmt.Fprintf(w, `{"id":"re_1","status":%q}`, tt.status)
+			})
+
+			payment := &database.InvoicePayment{ID: 7, InvoiceID: 12, ReferenceID: "pi_123"}
+			id, err := s.createRefund("sk_test_123", payment, decimal.NewFromInt(5), "EUR", "refund-7-0.00-5.00")
+			if tt.wantErr {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != "re_1" {
+				t.Errorf("id = %q", id)
+			}
+		})
+	}
+}
+
+func TestStripeRefundWithoutReference(t *testing.T) {
+	s := &Stripe{}
+	_, err := s.Refund(context.Background(), nil, &database.InvoicePayment{ID: 1}, decimal.NewFromInt(1), "USD", "refund-1-0.00-1.00")
+	if err == nil {
+		t.Error("expected an error for a payment without reference id")
+	}
+}
+
+func TestStripeAmount(t *testing.T) {
+	tests := []struct {
+		amount   string
+		currency string
+		want     int64
+	}{
+		{"12.34", "USD", 1234},
+		{"0.005", "usd", 1},
+		{"500", "JPY", 500},
+		{"500.2", "jpy", 501},
+	}
+	for _, tt := range tests {
+		if got := stripeAmount(decimal.RequireFromString(tt.amount), tt.currency); got != tt.want {
+			t.Errorf("stripeAmount(%s, %s) = %d, want %d", tt.amount, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestParseStripeWebhook(t *testing.T) {
+	const secret = "whsec_test"
+	now := time.Unix(1700000000, 0)
+
+	event := func(typ string, status string, invoiceId string, intent string, currency string, amount int64) string {
+		return fmt.Sprintf(`{"id":"evt_1","type":%q,"data":{"object":{"id":"cs_1","payment_status":%q,"client_reference_id":%q,"amount_total":%d,"currency":%q,"payment_intent":%q}}}`,
+			typ, status, invoiceId, amount, currency, intent)
+	}
+
+	tests := []struct {
+		name    string
+		payload string
+		header  string
+		want    *stripePayment
+		wantErr error
+	}{
+		{
+			name:    "paid",
+			payload: event("checkout.session.completed", "paid", "12", "pi_1", "usd", 1234),
+			want:    &stripePayment{SessionId: "cs_1", InvoiceId: 12, Amount: decimal.RequireFromString("12.34"), Currency: "usd", ReferenceId: "pi_1"},
+		},
+		{
+			name:    "async payment succeeded in a zero decimal currency",
+			payload: event("checkout.session.async_payment_succeeded", "paid", "12", "pi_1", "jpy", 500),
+			want:    &stripePayment{SessionId: "cs_1", InvoiceId: 12, Amount: decimal.NewFromInt(500), Currency: "jpy", ReferenceId: "pi_1"},
+		},
+		{
+			name:    "session id without payment intent",
+			payload: event("checkout.session.completed", "paid", "12", "", "usd", 100),
+			want:    &stripePayment{SessionId: "cs_1", InvoiceId: 12, Amount: decimal.NewFromInt(1), Currency: "usd", ReferenceId: "cs_1"},
+		},
+		{
+			name:    "not paid yet",
+			payload: event("checkout.session.completed", "unpaid", "12", "pi_1", "usd", 100),
+		},
+		{
+			name:    "other event",
+			payload: event("payment_intent.created", "paid", "12", "pi_1", "usd", 100),
+		},
+		{
+			name:    "invalid invoice id",
+			payload: event("checkout.session.completed", "paid", "abc", "pi_1", "usd", 100),
+		},
+		{
+			name:    "wrong secret",
+			payload: event("checkout.session.completed", "paid", "12", "pi_1", "usd", 100),
+			header:  signStripe(event("checkout.session.completed", "paid", "12", "pi_1", "usd", 100), "whsec_other", now),
+			wantErr: ErrStripeSignature,
+		},
+		{
+			name:    "expired timestamp",
+			payload: event("checkout.session.completed", "paid", "12", "pi_1", "usd", 100),
+			header:  signStripe(event("checkout.session.completed", "paid", "12", "pi_1", "usd", 100), secret, now.Add(-time.Hour)),
+			wantErr: ErrStripeSignature,
+		},
+		{
+			name:    "missing header",
+			payload: event("checkout.session.completed", "paid", "12", "pi_1", "usd", 100),
+			header:  "-",
+			wantErr: ErrStripeSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.header
+			if header == "" {
+				header = signStripe(tt.payload, secret, now)
+			}
+
+			got, err := parseStripeWebhook([]byte(tt.payload), header, secret, now)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.want == nil {
+				if got != nil {
+					t.Errorf("got %+v, want nil", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("got nil")
+			}
+			if got.SessionId != tt.want.SessionId || got.InvoiceId != tt.want.InvoiceId || !got.Amount.Equal(tt.want.Amount) ||
+				got.Currency != tt.want.Currency || got.ReferenceId != tt.want.ReferenceId {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseStripeWebhookInvalidJSON(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	payload := "{"
+	_, err := parseStripeWebhook([]byte(payload), signStripe(payload, "whsec_test", now), "whsec_test", now)
+	if err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"billing3/database"
+	"billing3/service/audit"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// ImpersonationDuration is how long an impersonation session is valid. It is not extended.
+const ImpersonationDuration = time.Hour
+
+var ErrImpersonateAdmin = errors.New("admins can not be impersonated")
+
+// ErrImpersonationInvalid means that the admin of an impersonation session is no longer allowed to impersonate, so
+// the session must not be used.
+var ErrImpersonationInvalid = errors.New("impersonation is no longer allowed")
+
+// Impersonate returns a session token with which admin is logged in as user, and its expiry time. The session is
+// marked with the admin, see SessionImpersonator. Errors other than ErrInternalError are meant to be shown to the
+// user.
+func Impersonate(ctx context.Context, user *database.User, admin *database.User, userAgent string, ip string) (string, time.Time, error) {
+	// an admin could get the permissions of another admin
+	if user.Role == "admin" {
+		return "", time.Time{}, ErrImpersonateAdmin
+	}
+
+	expiresAt := time.Now().Add(ImpersonationDuration)
+	token, err := newSession(ctx, user.ID, userAgent, ip, expiresAt, pgtype.Int4{Int32: admin.ID, Valid: true})
+	if err != nil {
+		slog.Error("impersonate", "err", err, "user", user.ID, "admin", admin.ID)
+		return "", time.Time{}, ErrInternalError
+	}
+
+	slog.Info("impersonation started", "user", user.ID, "admin", admin.ID)
+	return token, expiresAt, nil
+}
+
+// SessionImpersonator returns the admin who created the impersonation session of user, or nil if it is a normal
+// session. It returns ErrImpersonationInvalid if the admin has lost the permission to impersonate, or if user has
+// become an admin since.
+func SessionImpersonator(ctx context.Context, session *database.Session, user *database.User) (*database.User, error) {
+	if !session.ImpersonatorID.Valid {
+		return nil, nil
+	}
+	if user.Role == "admin" {
+		return nil, ErrImpersonationInvalid
+	}
+
+	admin, err := database.Q.FindUserById(ctx, session.ImpersonatorID.Int32)
+	if err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+
+	ok, err := HasPermission(ctx, &admin, PermUserImpersonate)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrImpersonationInvalid
+	}
+	return &admin, nil
+}
+
+// DeleteExpiredSessions deletes the expired sessions. The end of expired impersonation sessions is saved in the audit
+// log as "impersonate.end" by the admin, like logging out of them.
+func DeleteExpiredSessions(ctx context.Context) error {
+	tx, err := database.Conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	sessions, err := database.Q.WithTx(tx).DeleteExpiredImpersonations(ctx)
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+	for _, session := range sessions {
+		actx := audit.WithActor(ctx, session.ImpersonatorID.Int32, "")
+		audit.LogTx(actx, tx, audit.EntityUser, session.UserID, "impersonate.end", nil, map[string]any{"expired": true})
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	err = database.Q.DeleteExpiredSessions(ctx)
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+	return nil
+}
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/shopspring/decimal"
 )
@@ -21,9 +22,14 @@ const (
 	InvoiceUnpaid    = "UNPAID"
 	InvoicePaid      = "PAID"
 	InvoiceCancelled = "CANCELLED"
-
-	InvoiceItemService = "service"
-	InvoiceItemNone    = ""
+	InvoiceRefunded  = "REFUNDED"
+
+	InvoiceItemService  = "service"
+	InvoiceItemCredit   = "credit"
+	InvoiceItemUpgrade  = "upgrade"
+	InvoiceItemDiscount = "discount"
+	InvoiceItemTax      = "tax"
+	InvoiceItemNone     = ""
 )
 
 // SearchInvoice returns a list of invoice matching the searching criteria.
@@ -58,6 +64,12 @@ func SearchInvoice(ctx context.Context, status string, userId int, page int, ite
 //
 // Setup fee is added if setupFee is positive. Setup fee must not be negative.
 //
+// If a coupon is stored in the service, its discount is added as a negative item. One-time
+// coupons are only applied to the first invoice, i.e. when the service is UNPAID.
+//
+// Tax is added according to the tax rule of the user. The invoice is in the currency of the
+// service.
+//
 // qtx should be a transaction. qtx is not commited.
 func CreateRenewalInvoice(ctx context.Context, qtx *database.Queries, serviceId int32, setupFee decimal.Decimal) (int32, error) {
 	if setupFee.LessThan(decimal.Zero) {
@@ -81,6 +93,16 @@ func CreateRenewalInvoice(ctx context.Context, qtx *database.Queries, serviceId
 		return 0, ErrServiceCancelled
 	}
 
+	// the service must not be renewed if the client has asked to cancel it
+	_, err = qtx.FindPendingCancellationRequestByService(ctx, serviceId)
+	if err == nil {
+		slog.Debug("abort create renewal invoice", "err", ErrCancellationPending, "service", serviceId)
+		return 0, ErrCancellationPending
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("find cancellation request: %w", err)
+	}
+
 	// there must not be unpaid invoice for the service
 	existingInvoices, err := qtx.CountUnpaidInvoiceForService(ctx, pgtype.Int4{Valid: true, Int32: serviceId})
 	if err != nil {
@@ -94,6 +116,26 @@ func CreateRenewalInvoice(ctx context.Context, qtx *database.Queries, serviceId
 
 	slog.Debug("create renewal invoice pass", "service", serviceId)
 
+	// coupon discount
+	discount := decimal.Zero
+	var couponCode string
+	if service.CouponID.Valid {
+		coupon, err := qtx.FindCouponById(ctx, service.CouponID.Int32)
+		if err != nil {
+			return 0, fmt.Errorf("find coupon: %w", err)
+		}
+
+		if coupon.Recurring || service.Status == ServiceUnpaid {
+			err = couponInCurrency(ctx, qtx, &coupon, service.Currency)
+			if err != nil {
+				return 0, fmt.Errorf("coupon in currency: %w", err)
+			}
+
+			discount = couponDiscount(&coupon, service.Price)
+			couponCode = coupon.Code
+		}
+	}
+
 	// create the invoice
 	invoiceId, err := qtx.CreateInvoice(ctx, database.CreateInvoiceParams{
 		UserID:             service.UserID,
@@ -101,7 +143,8 @@ func CreateRenewalInvoice(ctx context.Context, qtx *database.Queries, serviceId
 		CancellationReason: pgtype.Text{Valid: false},
 		PaidAt:             types.Timestamp{Timestamp: pgtype.Timestamp{Valid: false}},
 		DueAt:              types.Timestamp{Timestamp: pgtype.Timestamp{Valid: true, Time: time.Now().UTC().Add(time.Hour * 168)}},
-		Amount:             decimal.Sum(service.Price, setupFee),
+		Amount:             decimal.Sum(service.Price, setupFee).Sub(discount),
+		Currency:           service.Currency,
 	})
 	if err != nil {
 		return 0, fmt.Errorf("create invoice: %w", err)
@@ -140,23 +183,104 @@ func CreateRenewalInvoice(ctx context.Context, qtx *database.Queries, serviceId
 		}
 	}
 
-	slog.Info("create renewal invoice", "service", serviceId, "setup fee", setupFee, "service price", service.Price, "user", service.UserID, "label", service.Label, "service status", service.Status, "service expire", service.ExpiresAt.Time, "service billing cycle", service.BillingCycle)
+	// create invoice item for coupon discount
+	if discount.GreaterThan(decimal.Zero) {
+		err = qtx.CreateInvoiceItem(ctx, database.CreateInvoiceItemParams{
+			InvoiceID:   invoiceId,
+			Description: fmt.Sprintf("#%d - %s - Coupon %s", serviceId, service.Label, couponCode),
+			Amount:      discount.Neg(),
+			Type:        InvoiceItemDiscount,
+			ItemID:      service.CouponID,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("create invoice item: %w", err)
+		}
+	}
+
+	err = updateInvoiceTotals(ctx, qtx, invoiceId)
+	if err != nil {
+		return 0, fmt.Errorf("update invoice totals: %w", err)
+	}
+
+	err = assignInvoiceNumberOnIssue(ctx, qtx, invoiceId)
+	if err != nil {
+		return 0, fmt.Errorf("assign invoice number: %w", err)
+	}
+
+	slog.Info("create renewal invoice", "service", serviceId, "setup fee", setupFee, "discount", discount, "service price", service.Price, "user", service.UserID, "label", service.Label, "service status", service.Status, "service expire", service.ExpiresAt.Time, "service billing cycle", service.BillingCycle)
 
 	return invoiceId, nil
 }
 
 // InvoiceAddPayment adds payment to invoice. The invoice is marked as PAID if total payment exceeds invoice amount.
+//
+// ErrDuplicatePayment is returned if a payment with the same gateway and non-empty reference id already exists.
 func InvoiceAddPayment(ctx context.Context, invoiceId int32, description string, amount decimal.Decimal, referenceId string, gateway string) error {
 	slog.Info("add payment", "invoice_id", invoiceId, "description", description, "amount", amount, "reference_id", referenceId, "gateway", gateway)
 
-	invoice, err := database.Q.FindInvoiceById(ctx, invoiceId)
+	tx, err := database.Conn.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("db: %w", err)
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = addInvoicePayment(ctx, tx, invoiceId, description, amount, referenceId, gateway)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	notifyPaymentReceived(ctx, invoiceId, description, amount, gateway)
+
+	return nil
+}
+
+// InvoiceAddPaymentOnce is like InvoiceAddPayment, but does nothing if a payment with the same gateway
+// and reference id has already been recorded. It returns false if the payment is a duplicate.
+//
+// It is used by gateways where the same payment may be reported more than once, e.g. by both the
+// browser redirect and a webhook.
+func InvoiceAddPaymentOnce(ctx context.Context, invoiceId int32, description string, amount decimal.Decimal, referenceId string, gateway string) (bool, error) {
+	if referenceId == "" {
+		return false, fmt.Errorf("reference id must not be empty")
+	}
+
+	err := InvoiceAddPayment(ctx, invoiceId, description, amount, referenceId, gateway)
+	if err != nil {
+		if errors.Is(err, ErrDuplicatePayment) {
+			slog.Info("duplicate payment ignored", "invoice_id", invoiceId, "reference_id", referenceId, "gateway", gateway)
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// addInvoicePayment locks the invoice, inserts the payment, and marks the invoice as PAID if total payment
+// exceeds invoice amount, in which case the services in the invoice are settled by onInvoicePaid.
+// It returns true if the invoice has just been marked as PAID.
+//
+// Everything is done in tx, so that concurrent payments of the same invoice are serialized by the row
+// lock and can not settle the invoice twice. tx is not commited.
+func addInvoicePayment(ctx context.Context, tx pgx.Tx, invoiceId int32, description string, amount decimal.Decimal, referenceId string, gateway string) (bool, error) {
+	qtx := database.Q.WithTx(tx)
+
+	invoice, err := qtx.SelectInvoiceForUpdate(ctx, invoiceId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, ErrNotFound
+		}
+		return false, fmt.Errorf("db: %w", err)
 	}
 
 	invoiceAmount := invoice.Amount
 
-	_, err = database.Q.AddInvoicePayment(ctx, database.AddInvoicePaymentParams{
+	_, err = qtx.AddInvoicePayment(ctx, database.AddInvoicePaymentParams{
 		InvoiceID:   invoiceId,
 		Description: description,
 		Amount:      amount,
@@ -164,70 +288,108 @@ func InvoiceAddPayment(ctx context.Context, invoiceId int32, description string,
 		Gateway:     gateway,
 	})
 	if err != nil {
-		return fmt.Errorf("db: %w", err)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == database.PGErrorUniqueViolation {
+			return false, ErrDuplicatePayment
+		}
+		return false, fmt.Errorf("db: %w", err)
 	}
 
-	totalPayment, err := database.Q.TotalInvoicePayment(ctx, invoiceId)
+	totalPayment, err := qtx.TotalInvoicePayment(ctx, invoiceId)
 	if err != nil {
-		return fmt.Errorf("db: %w", err)
+		return false, fmt.Errorf("db: %w", err)
 	}
 
-	// mark the invoice is PAID if the invoice is UNPAID and not overdue
-	if totalPayment.GreaterThanOrEqual(invoiceAmount) && invoice.Status == "UNPAID" && invoice.DueAt.Time.After(time.Now()) {
-		err := database.Q.UpdateInvoicePaid(ctx, invoiceId)
+	// mark the invoice is PAID if the invoice is UNPAID. Overdue invoices are cancelled by CloseOverdueInvoices,
+	// except renewal invoices, which can be paid until the service is terminated.
+	if totalPayment.GreaterThanOrEqual(invoiceAmount) && invoice.Status == "UNPAID" {
+		err := qtx.UpdateInvoicePaid(ctx, invoiceId)
 		if err != nil {
-			return fmt.Errorf("db: %w", err)
+			return false, fmt.Errorf("db: %w", err)
 		}
 		slog.Info("updated invoice paid", "invoice_id", invoiceId, "amount", amount.String(), "total_payment", totalPayment.String())
 
-		OnInvoicePaid(invoiceId)
+		// a PAID invoice always has a number, even if it was created before numbers were assigned on issue
+		err = AssignInvoiceNumber(ctx, qtx, invoiceId)
+		if err != nil {
+			return false, fmt.Errorf("assign invoice number: %w", err)
+		}
+
+		invoice, err = qtx.SelectInvoiceForUpdate(ctx, invoiceId)
+		if err != nil {
+			return false, fmt.Errorf("db: %w", err)
+		}
+
+		err = onInvoicePaid(ctx, tx, &invoice)
+		if err != nil {
+			return false, fmt.Errorf("on invoice paid: %w", err)
+		}
+
+		return true, nil
 	}
 
-	return nil
+	return false, nil
 }
 
-// OnInvoicePaid does the following things to services in the invoice:
+// onInvoicePaid does the following things to services in the invoice:
 // - extend expiry date by billing cycle
 // - mark the service as PENDING if the service is previously UNPAID
 // - call the extension's create action if the service is previously UNPAID
-func OnInvoicePaid(invoiceId int32) {
-	slog.Info("on invoice paid", "invoice_id", invoiceId)
-
-	ctx := context.Background()
+// - call the extension's unsuspend action if the service is SUSPENDED and no longer overdue
+//
+// Credit items in the invoice are added to the user's credit balance, and upgrade items are applied
+// to the service.
+//
+// The invoice must be locked by tx. Database errors are returned so that the payment is rolled back
+// together with the settlement. tx is not commited.
+func onInvoicePaid(ctx context.Context, tx pgx.Tx, invoice *database.Invoice) error {
+	slog.Info("on invoice paid", "invoice_id", invoice.ID)
 
-	tx, err := database.Conn.Begin(ctx)
-	if err != nil {
-		slog.Error("begin tx", "err", err)
-		return
-	}
-	defer tx.Rollback(ctx)
+	qtx := database.Q.WithTx(tx)
 
-	invoice, err := database.Q.SelectInvoiceForUpdate(ctx, invoiceId)
+	items, err := qtx.ListInvoiceItems(ctx, invoice.ID)
 	if err != nil {
-		slog.Error("on invoice paid", "err", err)
-		return
+		return fmt.Errorf("db: %w", err)
 	}
 
-	if invoice.Status != InvoicePaid {
-		return
-	}
+	for _, item := range items {
+		if item.Type == InvoiceItemCredit {
+			slog.Info("credit top-up paid", "invoice_id", invoice.ID, "user_id", invoice.UserID, "amount", item.Amount)
+
+			_, err := qtx.CreateCreditTransaction(ctx, database.CreateCreditTransactionParams{
+				UserID:      invoice.UserID,
+				Amount:      item.Amount,
+				Description: fmt.Sprintf("Top-up (invoice #%d)", invoice.ID),
+				Type:        CreditTopUp,
+				InvoiceID:   pgtype.Int4{Valid: true, Int32: invoice.ID},
+				Currency:    invoice.Currency,
+			})
+			if err != nil {
+				return fmt.Errorf("db: %w", err)
+			}
+			continue
+		}
 
-	items, err := database.Q.ListInvoiceItems(ctx, invoiceId)
-	if err != nil {
-		slog.Error("on invoice paid", "err", err)
-		return
-	}
+		if item.Type == InvoiceItemUpgrade && item.ItemID.Valid {
+			err := applyUpgrade(ctx, tx, item.ItemID.Int32)
+			if err != nil {
+				return fmt.Errorf("apply upgrade: %w", err)
+			}
+			continue
+		}
 
-	for _, item := range items {
 		if item.Type == InvoiceItemService && item.ItemID.Valid {
 
 			itemId := item.ItemID.Int32
-			slog.Info("on invoice paid", "invoice_id", invoiceId, "service_id", itemId, "description", item.Description)
+			slog.Info("on invoice paid", "invoice_id", invoice.ID, "service_id", itemId, "description", item.Description)
 
-			s, err := database.Q.FindServiceById(ctx, itemId)
+			// lock the service row so that expiry time is extended exactly once
+			s, err := qtx.FindServiceByIdForUpdate(ctx, itemId)
 			if err != nil {
-				slog.Error("on invoice paid", "err", err)
-				continue
+				if errors.Is(err, pgx.ErrNoRows) {
+					continue
+				}
+				return fmt.Errorf("db: %w", err)
 			}
 
 			if s.Status == ServiceCancelled {
@@ -238,25 +400,23 @@ func OnInvoicePaid(invoiceId int32) {
 			slog.Info("extend service expiry time", "service_id", itemId, "expires_at", s.ExpiresAt, "billing_cycle", s.BillingCycle)
 
 			expiryTime := s.ExpiresAt.Time.Add(time.Duration(s.BillingCycle) * time.Second)
-			err = database.Q.UpdateServiceExpiryTime(ctx, database.UpdateServiceExpiryTimeParams{
+			err = qtx.UpdateServiceExpiryTime(ctx, database.UpdateServiceExpiryTimeParams{
 				ID:        itemId,
 				ExpiresAt: types.Timestamp{Timestamp: pgtype.Timestamp{Valid: true, Time: expiryTime}},
 			})
 			if err != nil {
-				slog.Error("on invoice paid", "err", err)
-				continue
+				return fmt.Errorf("db: %w", err)
 			}
 
 			// change status to PENDING
 			if s.Status == ServiceUnpaid {
 				slog.Info("service pending", "service_id", itemId)
-				err = database.Q.UpdateServiceStatus(ctx, database.UpdateServiceStatusParams{
+				err = qtx.UpdateServiceStatus(ctx, database.UpdateServiceStatusParams{
 					Status: ServicePending,
 					ID:     itemId,
 				})
 				if err != nil {
-					slog.Error("on invoice paid", "err", err)
-					continue
+					return fmt.Errorf("db: %w", err)
 				}
 
 				// create service
@@ -278,22 +438,41 @@ func OnInvoicePaid(invoiceId int32) {
 
 				slog.Info("create service", "service_id", itemId)
 
-				err = extension.DoActionAsync(ctx, s.Extension, itemId, "create", ServiceActive)
+				// the job is enqueued in tx, so it only runs if the payment is commited
+				err = extension.DoActionAsyncTx(ctx, tx, s.Extension, itemId, "create", ServiceActive)
 				if err != nil {
+					if !errors.Is(err, extension.ErrActionRunning) {
+						return fmt.Errorf("do action async: %w", err)
+					}
 					slog.Error("do action async", "err", err)
 				}
 			}
 
+			// unsuspend the service once the renewal is paid, if it was suspended for not paying it
+			if suspendedByDunning(&s) && expiryTime.After(time.Now()) {
+				reason := fmt.Sprintf("unsuspended: invoice %s paid", InvoiceDisplayNumber(invoice))
+				slog.Info("unsuspend service", "service_id", itemId, "invoice_id", invoice.ID)
+
+				// the job is enqueued in tx, so it only runs if the payment is commited
+				err = extension.DoActionAsyncWithReason(ctx, tx, s.Extension, itemId, "unsuspend", ServiceActive, reason)
+				if err != nil {
+					if !errors.Is(err, extension.ErrActionRunning) {
+						return fmt.Errorf("do action async: %w", err)
+					}
+					// retried by ProcessDunning once the running action is done
+					slog.Info("unsuspend postponed, another action is running", "service_id", itemId)
+				}
+			}
+
 		}
 	}
 
-	err = tx.Commit(ctx)
-	if err != nil {
-		slog.Error("commit tx", "err", err)
-		return
-	}
+	return nil
 }
 
+// CloseOverdueInvoices cancels UNPAID invoices after their due date, and cancels the services ordered
+// with them, giving back their coupon uses. Renewal invoices of services that are not cancelled stay open, so that they can be paid
+// until ProcessDunning terminates the service.
 func CloseOverdueInvoices() error {
 	ctx := context.Background()
 	invoices, err := database.Q.FindOverdueInvoices(ctx)
@@ -302,45 +481,60 @@ func CloseOverdueInvoices() error {
 	}
 
 	for _, invoice := range invoices {
-		slog.Info("cancel overdue invoice", "id", invoice.ID)
-
-		// cancel the invoice
-		err := database.Q.UpdateInvoiceCancelled(ctx, database.UpdateInvoiceCancelledParams{
-			CancellationReason: pgtype.Text{Valid: true, String: "overdue"},
-			ID:                 invoice.ID,
-		})
-		if err != nil {
-			return fmt.Errorf("db: %w", err)
-		}
-
-		// cancel the service if the service is unpaid
 		items, err := database.Q.ListInvoiceItems(ctx, invoice.ID)
 		if err != nil {
 			return fmt.Errorf("db: %w", err)
 		}
 
+		renewal := false
+		unpaidServices := make([]int32, 0)
 		for _, item := range items {
 			if item.Type == InvoiceItemService && item.ItemID.Valid {
-
-				service, err := database.Q.FindServiceById(ctx, item.ID)
+				service, err := database.Q.FindServiceById(ctx, item.ItemID.Int32)
 				if err != nil {
 					return fmt.Errorf("db: %w", err)
 				}
 
-				if service.Status != ServiceUnpaid {
-					continue
+				switch service.Status {
+				case ServiceActive, ServiceSuspended, ServicePending:
+					renewal = true
+				case ServiceUnpaid:
+					unpaidServices = append(unpaidServices, service.ID)
 				}
+			}
+		}
 
-				slog.Info("cancel overdue unpaid service", "id", item.ID, "invoice id", invoice.ID)
+		if renewal {
+			continue
+		}
 
-				err = database.Q.UpdateServiceCancelled(ctx, database.UpdateServiceCancelledParams{
-					CancellationReason: pgtype.Text{Valid: true, String: "invoice overdue"},
-					ID:                 item.ItemID.Int32,
-					CancelledAt:        types.Timestamp{Timestamp: pgtype.Timestamp{Valid: true, Time: time.Now()}},
-				})
-				if err != nil {
-					return fmt.Errorf("db: %w", err)
-				}
+		slog.Info("cancel overdue invoice", "id", invoice.ID)
+
+		// cancel the invoice
+		err = database.Q.UpdateInvoiceCancelled(ctx, database.UpdateInvoiceCancelledParams{
+			CancellationReason: pgtype.Text{Valid: true, String: "overdue"},
+			ID:                 invoice.ID,
+		})
+		if err != nil {
+			return fmt.Errorf("db: %w", err)
+		}
+
+		// cancel the services that are unpaid
+		for _, serviceId := range unpaidServices {
+			slog.Info("cancel overdue unpaid service", "id", serviceId, "invoice id", invoice.ID)
+
+			err = database.Q.UpdateServiceCancelled(ctx, database.UpdateServiceCancelledParams{
+				CancellationReason: pgtype.Text{Valid: true, String: "invoice overdue"},
+				ID:                 serviceId,
+				CancelledAt:        types.Timestamp{Timestamp: pgtype.Timestamp{Valid: true, Time: time.Now()}},
+			})
+			if err != nil {
+				return fmt.Errorf("db: %w", err)
+			}
+
+			err = database.Q.DeleteCouponUsesByService(ctx, pgtype.Int4{Valid: true, Int32: serviceId})
+			if err != nil {
+				return fmt.Errorf("db: %w", err)
 			}
 		}
 
@@ -354,6 +548,10 @@ func CloseOverdueInvoices() error {
 // - service status is ACTIVE or SUSPENDED or PENDING
 // - current time < service expiry date <= current time + 7 days
 // - there is no existing unpaid invoice for the service
+// - there is no pending cancellation request for the service
+//
+// Account credit is applied to the generated invoices if the user has any, and the invoices that are not
+// paid with credit are emailed to the users.
 func GenerateRenewalInvoices() error {
 	ctx := context.Background()
 
@@ -373,7 +571,7 @@ func GenerateRenewalInvoices() error {
 
 		qtx := database.Q.WithTx(tx)
 
-		_, err = CreateRenewalInvoice(ctx, qtx, service.ID, decimal.Zero)
+		invoiceId, err := CreateRenewalInvoice(ctx, qtx, service.ID, decimal.Zero)
 		if err != nil {
 			slog.Error("create renewal invoice", "err", err, "service_id", service.ID)
 			tx.Rollback(ctx)
@@ -382,7 +580,16 @@ func GenerateRenewalInvoices() error {
 
 		if err := tx.Commit(ctx); err != nil {
 			slog.Error("commit tx", "err", err, "service_id", service.ID)
+			continue
+		}
+
+		_, err = ApplyCreditToInvoice(ctx, invoiceId)
+		if err != nil && !errors.Is(err, ErrInsufficientCredit) {
+			slog.Error("apply credit to renewal invoice", "err", err, "service_id", service.ID, "invoice_id", invoiceId)
 		}
+
+		// not sent if the invoice has been paid with credit
+		NotifyInvoiceCreated(ctx, invoiceId)
 	}
 
 	return nil
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"billing3/database"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	InvoiceNumberOnPaid  = "paid"  // invoices get a number when they are marked as PAID
+	InvoiceNumberOnIssue = "issue" // invoices get a number when they are created
+)
+
+// FormatInvoiceNumber returns the invoice number for the n-th invoice of a period.
+// Period is the year if numbers reset yearly, otherwise 0.
+func FormatInvoiceNumber(prefix string, period int32, padding int, n int32) string {
+	number := fmt.Sprintf("%0*d", padding, n)
+	if period != 0 {
+		return fmt.Sprintf("%s%d-%s", prefix, period, number)
+	}
+	return prefix + number
+}
+
+// InvoiceDisplayNumber returns the invoice number, or "#id" if the invoice has no number yet.
+func InvoiceDisplayNumber(invoice *database.Invoice) string {
+	if invoice.Number.Valid {
+		return invoice.Number.String
+	}
+	return fmt.Sprintf("#%d", invoice.ID)
+}
+
+// invoiceNumberPeriod returns the period of the invoice number sequence for an invoice numbered at t.
+func invoiceNumberPeriod(ctx context.Context, t time.Time) int32 {
+	if SettingInvoiceNumberYearlyReset.Get(ctx) == "true" {
+		return int32(t.Year())
+	}
+	return 0
+}
+
+// AssignInvoiceNumber gives the invoice the next invoice number, and does nothing if it already has one.
+//
+// qtx should be a transaction. qtx is not commited. The invoice and the sequence of the period are locked until
+// the transaction ends, so numbers are never used twice and are not lost if the transaction is rolled back.
+func AssignInvoiceNumber(ctx context.Context, qtx *database.Queries, invoiceId int32) error {
+	invoice, err := qtx.SelectInvoiceForUpdate(ctx, invoiceId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("db: %w", err)
+	}
+
+	if invoice.Number.Valid {
+		return nil
+	}
+
+	padding, err := strconv.Atoi(SettingInvoiceNumberPadding.Get(ctx))
+	if err != nil || padding < 0 || padding > 20 {
+		padding = 0
+	}
+
+	period := invoiceNumberPeriod(ctx, time.Now().UTC())
+
+	n, err := qtx.NextInvoiceNumber(ctx, period)
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	number := FormatInvoiceNumber(strings.TrimSpace(SettingInvoiceNumberPrefix.Get(ctx)), period, padding, n)
+
+	err = qtx.UpdateInvoiceNumber(ctx, database.UpdateInvoiceNumberParams{
+		ID:     invoiceId,
+		Number: pgtype.Text{Valid: true, String: number},
+	})
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	slog.Info("assign invoice number", "invoice_id", invoiceId, "number", number)
+
+	return nil
+}
+
+// assignInvoiceNumberOnIssue calls AssignInvoiceNumber if invoices are numbered when they are created.
+// qtx should be a transaction. qtx is not commited.
+func assignInvoiceNumberOnIssue(ctx context.Context, qtx *database.Queries, invoiceId int32) error {
+	if SettingInvoiceNumberAssign.Get(ctx) != InvoiceNumberOnIssue {
+		return nil
+	}
+	return AssignInvoiceNumber(ctx, qtx, invoiceId)
+}
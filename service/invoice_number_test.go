@@ -0,0 +1,146 @@
+package service
+
+import (
+	"billing3/database"
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestFormatInvoiceNumber(t *testing.T) {
+	tests := []struct {
+		prefix  string
+		period  int32
+		padding int
+		n       int32
+		want    string
+	}{
+		{"INV-", 0, 0, 7, "INV-7"},
+		{"INV-", 0, 6, 7, "INV-000007"},
+		{"INV-", 2026, 6, 12, "INV-2026-000012"},
+		{"INV-", 2026, 0, 12, "INV-2026-12"},
+		{"", 0, 3, 5, "005"},
+		{"", 2026, 3, 5, "2026-005"},
+		{"INV-", 0, 2, 12345, "INV-12345"}, // longer than the padding
+	}
+	for _, tt := range tests {
+		got := FormatInvoiceNumber(tt.prefix, tt.period, tt.padding, tt.n)
+		if got != tt.want {
+			t.Errorf("FormatInvoiceNumber(%q, %d, %d, %d) = %q, want %q", tt.prefix, tt.period, tt.padding, tt.n, got, tt.want)
+		}
+	}
+}
+
+// assignTestInvoiceNumber assigns a number to the invoice in a transaction, and returns the number of the invoice.
+func assignTestInvoiceNumber(t *testing.T, invoiceId int32) string {
+	ctx := context.Background()
+
+	tx, err := database.Conn.Begin(ctx)
+	if err != nil {
+		t.Error(err)
+		return ""
+	}
+	defer tx.Rollback(ctx)
+
+	err = AssignInvoiceNumber(ctx, database.Q.WithTx(tx), invoiceId)
+	if err != nil {
+		t.Error(err)
+		return ""
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		t.Error(err)
+		return ""
+	}
+
+	invoice, err := database.Q.FindInvoiceById(ctx, invoiceId)
+	if err != nil {
+		t.Error(err)
+		return ""
+	}
+	if !invoice.Number.Valid {
+		t.Errorf("invoice %d has no number", invoiceId)
+	}
+	return invoice.Number.String
+}
+
+// invoiceNumberSeq returns the number of the invoice in its sequence, which is the trailing digits of the number.
+func invoiceNumberSeq(t *testing.T, number string) int {
+	t.Helper()
+	digits := number[len(strings.TrimRight(number, "0123456789")):]
+	n, err := strconv.Atoi(digits)
+	if err != nil {
+		t.Fatalf("invoice number %q: %v", number, err)
+	}
+	return n
+}
+
+func newTestNumberInvoice(t *testing.T) int32 {
+	t.Helper()
+	userId := createTestUser(t)
+	serviceId := createTestService(t, userId, time.Now().Add(24*time.Hour))
+	return createTestInvoice(t, userId, serviceId, decimal.NewFromInt(10))
+}
+
+func TestAssignInvoiceNumberConcurrent(t *testing.T) {
+	requireDB(t)
+
+	invoices := make([]int32, testConcurrency)
+	for i := range invoices {
+		invoices[i] = newTestNumberInvoice(t)
+	}
+
+	numbers := make([]string, testConcurrency)
+	runConcurrently(testConcurrency, func(i int) {
+		numbers[i] = assignTestInvoiceNumber(t, invoices[i])
+	})
+
+	seen := make(map[string]bool)
+	lo, hi := 0, 0
+	for i, number := range numbers {
+		if seen[number] {
+			t.Errorf("number %q is used twice", number)
+		}
+		seen[number] = true
+
+		n := invoiceNumberSeq(t, number)
+		if i == 0 || n < lo {
+			lo = n
+		}
+		if n > hi {
+			hi = n
+		}
+	}
+
+	// no number is skipped
+	if hi-lo != testConcurrency-1 {
+		t.Errorf("numbers %d to %d for %d invoices", lo, hi, testConcurrency)
+	}
+}
+
+func TestAssignInvoiceNumberOnce(t *testing.T) {
+	requireDB(t)
+
+	invoiceId := newTestNumberInvoice(t)
+
+	// e.g. the invoice is marked as paid by a webhook and an admin at the same time
+	numbers := make([]string, testConcurrency)
+	runConcurrently(testConcurrency, func(i int) {
+		numbers[i] = assignTestInvoiceNumber(t, invoiceId)
+	})
+	for _, number := range numbers {
+		if number != numbers[0] {
+			t.Fatalf("number changed from %q to %q", numbers[0], number)
+		}
+	}
+
+	// the sequence was advanced once
+	next := assignTestInvoiceNumber(t, newTestNumberInvoice(t))
+	if invoiceNumberSeq(t, next) != invoiceNumberSeq(t, numbers[0])+1 {
+		t.Errorf("next number = %q after %q", next, numbers[0])
+	}
+}
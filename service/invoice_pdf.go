@@ -0,0 +1,362 @@
+package service
+
+import (
+	"billing3/database"
+	"billing3/database/types"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+// DefaultInvoiceTemplate is the default value of SettingInvoiceTemplate.
+//
+// The template is a Go text/template, and its output is read line by line:
+//
+//	# text      title
+//	## text     heading
+//	> text      right-aligned text
+//	!|a|b|c     table header row
+//	|a|b|c      table row, the first column takes the remaining width and the others are right-aligned
+//	---         horizontal rule
+//	            an empty line adds vertical space
+//
+// Any other line is a paragraph.
+const DefaultInvoiceTemplate = `# {{ .Company.Name }}
+{{ range lines .Company.Address }}{{ . }}
+{{ end }}{{ if .Company.VatNumber }}VAT number: {{ .Company.VatNumber }}
+{{ end }}{{ if .Company.Email }}{{ .Company.Email }}
+{{ end }}
+{{ if .Invoice.Number.Valid }}## Invoice {{ .Invoice.Number.String }}
+{{ else }}## Invoice #{{ .Invoice.ID }}
+{{ end }}> Status: {{ .Invoice.Status }}
+> Invoice date: {{ date .Invoice.CreatedAt }}
+> Due date: {{ date .Invoice.DueAt }}
+{{ if eq .Invoice.Status "PAID" }}> Paid on: {{ date .Invoice.PaidAt }}
+{{ end }}
+## Bill to
+{{ .Customer.Name }}
+{{ .Customer.Email }}
+{{ if .Customer.Address.Valid }}{{ .Customer.Address.String }}
+{{ end }}{{ .Customer.ZipCode.String }} {{ .Customer.City.String }} {{ .Customer.State.String }}
+{{ .Customer.Country.String }}
+{{ if .Customer.VatNumber }}VAT number: {{ .Customer.VatNumber }}
+{{ end }}
+!|Description|Amount ({{ .Invoice.Currency }})
+{{ range .Items }}|{{ cell .Description }}|{{ money .Amount }}
+{{ end }}---
+{{ if not .Invoice.Tax.IsZero }}|Net|{{ money .Invoice.Net }}
+|{{ if .TaxIncluded }}Includes {{ end }}{{ .Invoice.TaxName }} {{ .Invoice.TaxRate }}%|{{ money .Invoice.Tax }}
+{{ end }}|Total|{{ money .Invoice.Amount }}
+|Paid|{{ money .Paid }}
+|Balance due|{{ money .Due }}
+{{ if .Invoice.ReverseCharge }}
+Reverse charge: VAT to be accounted for by the recipient.
+{{ end }}{{ if .Payments }}
+## Payments
+!|Date|Gateway|Reference|Amount ({{ .Invoice.Currency }})
+{{ range .Payments }}|{{ date .CreatedAt }}|{{ cell .Gateway }}|{{ cell .ReferenceID }}|{{ money .Amount }}
+{{ end }}{{ end }}`
+
+// InvoicePDFCompany is the seller shown on PDF invoices.
+type InvoicePDFCompany struct {
+	Name      string
+	Address   string
+	VatNumber string
+	Email     string
+}
+
+// InvoicePDFCustomer is the client shown on PDF invoices. The fields have the types of database.User, so that
+// templates written for it keep working.
+type InvoicePDFCustomer struct {
+	Name      string
+	Email     string
+	Address   pgtype.Text
+	City      pgtype.Text
+	State     pgtype.Text
+	ZipCode   pgtype.Text
+	Country   pgtype.Text
+	VatNumber string
+}
+
+// InvoicePDFData is passed to the invoice template.
+type InvoicePDFData struct {
+	Company     InvoicePDFCompany
+	Customer    InvoicePDFCustomer
+	Invoice     database.Invoice
+	Items       []database.InvoiceItem // without the tax item if the tax is included in the other items
+	TaxIncluded bool                   // the prices of the items include the tax
+	Payments    []database.InvoicePayment
+	Paid        decimal.Decimal // sum of payments, refunds are negative
+	Due         decimal.Decimal
+}
+
+// newInvoicePDFData returns the data of the invoice template.
+func newInvoicePDFData(company InvoicePDFCompany, user *database.User, invoice *database.Invoice, items []database.InvoiceItem, payments []database.InvoicePayment) InvoicePDFData {
+	paid := decimal.Zero
+	for _, p := range payments {
+		paid = paid.Add(p.Amount)
+	}
+
+	// invoices created in inclusive mode before the tax item was dropped have an informational tax item, which
+	// would be read as a payable line
+	sum := decimal.Zero
+	other := make([]database.InvoiceItem, 0, len(items))
+	for _, item := range items {
+		if item.Type != InvoiceItemTax {
+			sum = sum.Add(item.Amount)
+			other = append(other, item)
+		}
+	}
+	taxIncluded := !invoice.Tax.IsZero() && sum.Equal(invoice.Amount)
+	if taxIncluded {
+		items = other
+	}
+
+	return InvoicePDFData{
+		Company: company,
+		Customer: InvoicePDFCustomer{
+			Name:      user.Name,
+			Email:     user.Email,
+			Address:   user.Address,
+			City:      user.City,
+			State:     user.State,
+			ZipCode:   user.ZipCode,
+			Country:   user.Country,
+			VatNumber: user.VatNumber,
+		},
+		Invoice:     *invoice,
+		Items:       items,
+		TaxIncluded: taxIncluded,
+		Payments:    payments,
+		Paid:        paid,
+		Due:         decimal.Max(invoice.Amount.Sub(paid), decimal.Zero),
+	}
+}
+
+var invoiceTemplateFuncs = template.FuncMap{
+	"money": func(d decimal.Decimal) string {
+		return d.StringFixed(2)
+	},
+	"date": func(t types.Timestamp) string {
+		if !t.Valid {
+			return ""
+		}
+		return t.Time.Format("2006-01-02")
+	},
+	"lines": func(s string) []string {
+		s = strings.TrimSpace(strings.ReplaceAll(s, "\r", ""))
+		if s == "" {
+			return nil
+		}
+		return strings.Split(s, "\n")
+	},
+	// cell makes s safe to use in a table row
+	"cell": func(s string) string {
+		return strings.NewReplacer("|", "/", "\n", " ", "\r", "").Replace(s)
+	},
+}
+
+// ParseInvoiceTemplate parses an invoice template, it is used to validate the template before saving it.
+func ParseInvoiceTemplate(text string) (*template.Template, error) {
+	return template.New("invoice").Funcs(invoiceTemplateFuncs).Parse(text)
+}
+
+// RenderInvoicePDF returns the PDF document of an invoice.
+func RenderInvoicePDF(ctx context.Context, invoiceId int32) ([]byte, error) {
+	invoice, err := database.Q.FindInvoiceById(ctx, invoiceId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("db: %w", err)
+	}
+
+	user, err := database.Q.FindUserById(ctx, invoice.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+
+	items, err := database.Q.ListInvoiceItems(ctx, invoice.ID)
+	if err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+
+	payments, err := database.Q.ListInvoicePayments(ctx, invoice.ID)
+	if err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+
+	company := InvoicePDFCompany{
+		Name:      SettingCompanyName.Get(ctx),
+		Address:   SettingCompanyAddress.Get(ctx),
+		VatNumber: SettingCompanyVatNumber.Get(ctx),
+		Email:     SettingCompanyEmail.Get(ctx),
+	}
+	if company.Name == "" {
+		company.Name = SettingSiteName.Get(ctx)
+	}
+
+	text, err := executeInvoiceTemplate(SettingInvoiceTemplate.Get(ctx), newInvoicePDFData(company, &user, &invoice, items, payments))
+	if err != nil {
+		return nil, err
+	}
+
+	title := fmt.Sprintf("Invoice #%d", invoice.ID)
+	if invoice.Number.Valid {
+		title = "Invoice " + invoice.Number.String
+	}
+
+	return renderInvoiceMarkup(title, text)
+}
+
+// executeInvoiceTemplate returns the markup of the invoice, DefaultInvoiceTemplate is used if templateText is empty.
+func executeInvoiceTemplate(templateText string, data InvoicePDFData) (string, error) {
+	if strings.TrimSpace(templateText) == "" {
+		templateText = DefaultInvoiceTemplate
+	}
+
+	tmpl, err := ParseInvoiceTemplate(templateText)
+	if err != nil {
+		return "", fmt.Errorf("invoice template: %w", err)
+	}
+
+	text := bytes.Buffer{}
+	err = tmpl.Execute(&text, data)
+	if err != nil {
+		return "", fmt.Errorf("invoice template: %w", err)
+	}
+	return text.String(), nil
+}
+
+const pdfLineHeight = 5.0
+
+// pdfWriter draws the line based markup described in DefaultInvoiceTemplate.
+type pdfWriter struct {
+	pdf *fpdf.Fpdf
+	tr  func(string) string
+}
+
+func renderInvoiceMarkup(title string, markup string) ([]byte, error) {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetTitle(title, true)
+	pdf.SetCreator("billing3", true)
+	pdf.SetMargins(15, 15, 15)
+	pdf.SetAutoPageBreak(true, 15)
+	pdf.AliasNbPages("")
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-12)
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.CellFormat(0, 4, fmt.Sprintf("%s - page %d/{nb}", title, pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+	pdf.AddPage()
+
+	w := pdfWriter{pdf: pdf, tr: pdf.UnicodeTranslatorFromDescriptor("")}
+
+	for _, line := range strings.Split(strings.ReplaceAll(markup, "\r", ""), "\n") {
+		line = strings.TrimRight(line, " \t")
+		switch {
+		case line == "":
+			pdf.Ln(pdfLineHeight / 2)
+		case line == "---":
+			w.rule()
+		case strings.HasPrefix(line, "## "):
+			pdf.Ln(pdfLineHeight / 2)
+			w.text(line[3:], 12, "B", "L")
+		case strings.HasPrefix(line, "# "):
+			w.text(line[2:], 18, "B", "L")
+		case strings.HasPrefix(line, "> "):
+			w.text(line[2:], 10, "", "R")
+		case strings.HasPrefix(line, "!|"):
+			w.row(strings.Split(line[2:], "|"), true)
+		case strings.HasPrefix(line, "|"):
+			w.row(strings.Split(line[1:], "|"), false)
+		default:
+			w.text(line, 10, "", "L")
+		}
+	}
+
+	out := bytes.Buffer{}
+	err := pdf.Output(&out)
+	if err != nil {
+		return nil, fmt.Errorf("pdf: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+func (w *pdfWriter) text(s string, size float64, style string, align string) {
+	w.pdf.SetFont("Helvetica", style, size)
+	w.pdf.MultiCell(0, size*0.45, w.tr(strings.TrimSpace(s)), "", align, false)
+}
+
+func (w *pdfWriter) rule() {
+	left, _, right, _ := w.pdf.GetMargins()
+	pageWidth, _ := w.pdf.GetPageSize()
+	y := w.pdf.GetY() + 1
+	w.pdf.SetDrawColor(160, 160, 160)
+	w.pdf.Line(left, y, pageWidth-right, y)
+	w.pdf.SetY(y + 1)
+}
+
+func (w *pdfWriter) row(cols []string, header bool) {
+	left, _, right, bottom := w.pdf.GetMargins()
+	pageWidth, pageHeight := w.pdf.GetPageSize()
+
+	// every column but the first is 30mm wide
+	widths := make([]float64, len(cols))
+	other := 30.0
+	if float64(len(cols)) > (pageWidth-left-right)/other {
+		other = (pageWidth - left - right) / float64(len(cols))
+	}
+	widths[0] = pageWidth - left - right - other*float64(len(cols)-1)
+	for i := 1; i < len(cols); i++ {
+		widths[i] = other
+	}
+
+	if header {
+		w.pdf.SetFont("Helvetica", "B", 10)
+	} else {
+		w.pdf.SetFont("Helvetica", "", 10)
+	}
+
+	lines := make([]string, len(cols))
+	height := pdfLineHeight
+	for i, col := range cols {
+		split := w.pdf.SplitLines([]byte(w.tr(strings.TrimSpace(col))), widths[i]-2)
+		parts := make([]string, len(split))
+		for j := range split {
+			parts[j] = string(split[j])
+		}
+		lines[i] = strings.Join(parts, "\n")
+		height = max(height, float64(len(split))*pdfLineHeight)
+	}
+
+	if w.pdf.GetY()+height > pageHeight-bottom {
+		w.pdf.AddPage()
+	}
+
+	y := w.pdf.GetY()
+	if header {
+		w.pdf.SetFillColor(235, 235, 235)
+		w.pdf.Rect(left, y, pageWidth-left-right, height, "F")
+	}
+
+	x := left
+	for i := range cols {
+		align := "R"
+		if i == 0 {
+			align = "L"
+		}
+		w.pdf.SetXY(x, y)
+		w.pdf.MultiCell(widths[i], pdfLineHeight, lines[i], "", align, false)
+		x += widths[i]
+	}
+	w.pdf.SetXY(left, y+height)
+}
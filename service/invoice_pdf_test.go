@@ -0,0 +1,159 @@
+package service
+
+import (
+	"billing3/database"
+	"billing3/database/types"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+func testInvoicePDFUser() *database.User {
+	return &database.User{
+		ID:        3,
+		Email:     "user@example.com",
+		Name:      "Jane Doe",
+		Password:  "$2a$10$secrethash",
+		Address:   pgtype.Text{Valid: true, String: "1 Main Street"},
+		City:      pgtype.Text{Valid: true, String: "Berlin"},
+		Country:   pgtype.Text{Valid: true, String: "DE"},
+		VatNumber: "DE123456789",
+	}
+}
+
+func testInvoicePDFInvoice(amount string, net string, tax string) *database.Invoice {
+	return &database.Invoice{
+		ID:        12,
+		Status:    InvoiceUnpaid,
+		CreatedAt: types.Timestamp{Timestamp: pgtype.Timestamp{Valid: true, Time: time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)}},
+		DueAt:     types.Timestamp{Timestamp: pgtype.Timestamp{Valid: true, Time: time.Date(2026, 1, 9, 0, 0, 0, 0, time.UTC)}},
+		Amount:    decimal.RequireFromString(amount),
+		Net:       decimal.RequireFromString(net),
+		Tax:       decimal.RequireFromString(tax),
+		TaxName:   "VAT",
+		TaxRate:   decimal.NewFromInt(19),
+		Currency:  "EUR",
+	}
+}
+
+func TestExecuteInvoiceTemplateExclusiveTax(t *testing.T) {
+	invoice := testInvoicePDFInvoice("119", "100", "19")
+	items := []database.InvoiceItem{
+		{Description: "Server | monthly", Amount: decimal.NewFromInt(100), Type: InvoiceItemService},
+		{Description: "VAT 19%", Amount: decimal.NewFromInt(19), Type: InvoiceItemTax},
+	}
+	payments := []database.InvoicePayment{{Amount: decimal.NewFromInt(20), Gateway: "Stripe", ReferenceID: "pi_1"}}
+
+	text, err := executeInvoiceTemplate("", newInvoicePDFData(InvoicePDFCompany{Name: "ACME"}, testInvoicePDFUser(), invoice, items, payments))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"# ACME\n",
+		"## Invoice #12\n",
+		"> Due date: 2026-01-09\n",
+		"Jane Doe\n",
+		"1 Main Street\n",
+		"VAT number: DE123456789\n",
+		"|Server / monthly|100.00\n",
+		"|VAT 19%|19.00\n",
+		"|Net|100.00\n",
+		"|VAT 19%|19.00\n",
+		"|Total|119.00\n",
+		"|Paid|20.00\n",
+		"|Balance due|99.00\n",
+		"|Stripe|pi_1|20.00\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("missing %q in\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "Includes") {
+		t.Errorf("exclusive tax shown as included in\n%s", text)
+	}
+}
+
+func TestExecuteInvoiceTemplateInclusiveTax(t *testing.T) {
+	invoice := testInvoicePDFInvoice("119", "100", "19")
+	items := []database.InvoiceItem{
+		{Description: "Server", Amount: decimal.NewFromInt(119), Type: InvoiceItemService},
+		// added by earlier versions, it is already part of the item above
+		{Description: "Includes VAT 19%", Amount: decimal.NewFromInt(19), Type: InvoiceItemTax},
+	}
+
+	data := newInvoicePDFData(InvoicePDFCompany{Name: "ACME"}, testInvoicePDFUser(), invoice, items, nil)
+	if !data.TaxIncluded {
+		t.Error("TaxIncluded = false")
+	}
+	if len(data.Items) != 1 {
+		t.Errorf("%d items, want the tax item to be left out", len(data.Items))
+	}
+
+	text, err := executeInvoiceTemplate("", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"|Server|119.00\n",
+		"|Net|100.00\n",
+		"|Includes VAT 19%|19.00\n",
+		"|Total|119.00\n",
+		"|Balance due|119.00\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("missing %q in\n%s", want, text)
+		}
+	}
+	if strings.Count(text, "VAT 19%") != 1 {
+		t.Errorf("the included tax is shown more than once in\n%s", text)
+	}
+}
+
+func TestExecuteInvoiceTemplateCustomerFields(t *testing.T) {
+	data := newInvoicePDFData(InvoicePDFCompany{}, testInvoicePDFUser(), testInvoicePDFInvoice("10", "10", "0"), nil, nil)
+
+	text, err := executeInvoiceTemplate("{{ .Customer.Name }} {{ .Customer.City.String }}", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "Jane Doe Berlin" {
+		t.Errorf("text = %q", text)
+	}
+
+	// the customer is not the user, the password hash and other fields of the account are not available
+	for _, field := range []string{"Password", "ID", "Role"} {
+		_, err = executeInvoiceTemplate("{{ .Customer."+field+" }}", data)
+		if err == nil {
+			t.Errorf(".Customer.%s: expected an error", field)
+		}
+	}
+}
+
+func TestExecuteInvoiceTemplateInvalid(t *testing.T) {
+	_, err := executeInvoiceTemplate("{{ .Invoice.ID", InvoicePDFData{})
+	if err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestRenderInvoiceMarkup(t *testing.T) {
+	data := newInvoicePDFData(InvoicePDFCompany{Name: "ACME", Address: "Street 1\nCity"}, testInvoicePDFUser(), testInvoicePDFInvoice("119", "100", "19"),
+		[]database.InvoiceItem{{Description: "Server", Amount: decimal.NewFromInt(100), Type: InvoiceItemService}}, nil)
+	text, err := executeInvoiceTemplate("", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pdf, err := renderInvoiceMarkup("Invoice #12", text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(pdf, []byte("%PDF-")) {
+		t.Errorf("output is not a PDF document: %q", pdf[:min(len(pdf), 16)])
+	}
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"billing3/database"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const testConcurrency = 20
+
+// checkSettledOnce checks that the invoice is PAID and that the service was extended by exactly one billing cycle.
+func checkSettledOnce(t *testing.T, invoiceId int32, serviceId int32, expiresAt time.Time) {
+	t.Helper()
+	ctx := context.Background()
+
+	invoice, err := database.Q.FindInvoiceById(ctx, invoiceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invoice.Status != InvoicePaid {
+		t.Errorf("invoice status = %s, want %s", invoice.Status, InvoicePaid)
+	}
+
+	s, err := database.Q.FindServiceById(ctx, serviceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := expiresAt.Add(time.Duration(s.BillingCycle) * time.Second)
+	if !s.ExpiresAt.Time.Equal(want) {
+		t.Errorf("service expires at %s, want %s (extended %s)", s.ExpiresAt.Time, want, s.ExpiresAt.Time.Sub(expiresAt))
+	}
+}
+
+// newTestRenewal creates a service and an UNPAID renewal invoice of amount for it, and returns their ids and the
+// expiry time of the service as saved.
+func newTestRenewal(t *testing.T, amount decimal.Decimal) (int32, int32, time.Time) {
+	t.Helper()
+
+	userId := createTestUser(t)
+	serviceId := createTestService(t, userId, time.Now().Add(24*time.Hour))
+	invoiceId := createTestInvoice(t, userId, serviceId, amount)
+
+	s, err := database.Q.FindServiceById(context.Background(), serviceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return invoiceId, serviceId, s.ExpiresAt.Time
+}
+
+// runConcurrently calls f with 0..n-1 from n goroutines that start at the same time.
+func runConcurrently(n int, f func(i int)) {
+	var start sync.WaitGroup
+	var done sync.WaitGroup
+	start.Add(1)
+	for i := 0; i < n; i++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			start.Wait()
+			f(i)
+		}()
+	}
+	start.Done()
+	done.Wait()
+}
+
+func TestInvoiceAddPaymentConcurrentFullPayments(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+
+	amount := decimal.NewFromInt(10)
+	invoiceId, serviceId, expiresAt := newTestRenewal(t, amount)
+
+	var errs atomic.Int32
+	runConcurrently(testConcurrency, func(i int) {
+		err := InvoiceAddPayment(ctx, invoiceId, "test payment", amount, testName(fmt.Sprintf("full-%d", i)), "Test")
+		if err != nil {
+			t.Errorf("payment %d: %v", i, err)
+			errs.Add(1)
+		}
+	})
+	if errs.Load() > 0 {
+		return
+	}
+
+	payments, err := database.Q.ListInvoicePayments(ctx, invoiceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != testConcurrency {
+		t.Errorf("%d payments recorded, want %d", len(payments), testConcurrency)
+	}
+
+	checkSettledOnce(t, invoiceId, serviceId, expiresAt)
+}
+
+func TestInvoiceAddPaymentConcurrentPartialPayments(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+
+	// the invoice is paid by the 10th payment, whichever goroutine makes it
+	invoiceId, serviceId, expiresAt := newTestRenewal(t, decimal.NewFromInt(10))
+
+	runConcurrently(testConcurrency, func(i int) {
+		err := InvoiceAddPayment(ctx, invoiceId, "test payment", decimal.NewFromInt(1), testName(fmt.Sprintf("partial-%d", i)), "Test")
+		if err != nil {
+			t.Errorf("payment %d: %v", i, err)
+		}
+	})
+
+	total, err := database.Q.TotalInvoicePayment(ctx, invoiceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !total.Equal(decimal.NewFromInt(testConcurrency)) {
+		t.Errorf("total payment = %s, want %d", total, testConcurrency)
+	}
+
+	checkSettledOnce(t, invoiceId, serviceId, expiresAt)
+}
+
+func TestInvoiceAddPaymentOnceConcurrent(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+
+	amount := decimal.NewFromInt(10)
+	invoiceId, serviceId, expiresAt := newTestRenewal(t, amount)
+
+	// the same capture reported by the browser redirect and webhooks at the same time
+	referenceId := testName("capture")
+
+	var added atomic.Int32
+	runConcurrently(testConcurrency, func(i int) {
+		ok, err := InvoiceAddPaymentOnce(ctx, invoiceId, "test payment", amount, referenceId, "Test")
+		if err != nil {
+			t.Errorf("payment %d: %v", i, err)
+			return
+		}
+		if ok {
+			added.Add(1)
+		}
+	})
+
+	if added.Load() != 1 {
+		t.Errorf("%d calls added the payment, want 1", added.Load())
+	}
+
+	payments, err := database.Q.ListInvoicePayments(ctx, invoiceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 1 {
+		t.Errorf("%d payments recorded, want 1", len(payments))
+	}
+
+	checkSettledOnce(t, invoiceId, serviceId, expiresAt)
+}
+
+func TestInvoiceAddPaymentDuplicateReference(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+
+	invoiceId, _, _ := newTestRenewal(t, decimal.NewFromInt(10))
+	referenceId := testName("capture")
+
+	err := InvoiceAddPayment(ctx, invoiceId, "test payment", decimal.NewFromInt(1), referenceId, "Test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = InvoiceAddPayment(ctx, invoiceId, "test payment", decimal.NewFromInt(1), referenceId, "Test")
+	if !errors.Is(err, ErrDuplicatePayment) {
+		t.Errorf("err = %v, want %v", err, ErrDuplicatePayment)
+	}
+
+	// the same reference of another gateway is a different payment
+	err = InvoiceAddPayment(ctx, invoiceId, "test payment", decimal.NewFromInt(1), referenceId, "Other")
+	if err != nil {
+		t.Errorf("other gateway: %v", err)
+	}
+}
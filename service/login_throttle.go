@@ -0,0 +1,196 @@
+package service
+
+import (
+	"billing3/database"
+	"billing3/database/types"
+	"billing3/service/notification"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Attempts are counted per account and per ip address in the login_throttles table, so that the limits are shared by
+// every instance. They are counted before they are made and uncounted if they succeed, so that concurrent attempts
+// can not exceed the limits. The counts are reset after 15 minutes without exceeding the limit, see
+// RecordLoginFailure. An attempt over a limit locks the account or the ip address for lockoutBase, doubled with
+// every lockout up to lockoutMax. The number of lockouts is forgotten a day after the last one ended.
+const (
+	lockoutBase = 5 * time.Minute
+	lockoutMax  = 24 * time.Hour
+)
+
+// Throttle limits the failed attempts of an action, e.g. logging in.
+type Throttle struct {
+	name         string // prefix of the keys
+	accountLimit int32
+	ipLimit      int32
+	notify       bool // send notification.EventAccountLocked when an account is locked
+}
+
+var (
+	// LoginThrottle counts wrong passwords and two-factor codes.
+	LoginThrottle = &Throttle{name: "login", accountLimit: 5, ipLimit: 20, notify: true}
+	// PasswordResetThrottle counts every password reset request, since they can not fail.
+	PasswordResetThrottle = &Throttle{name: "reset_password", accountLimit: 3, ipLimit: 10}
+)
+
+// Throttles is the list of all throttles.
+var Throttles = []*Throttle{LoginThrottle, PasswordResetThrottle}
+
+// accountKey is keyed by the email address instead of the user, so that the result does not reveal whether an
+// account exists.
+func (t *Throttle) accountKey(email string) string {
+	return t.name + ":account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func (t *Throttle) ipKey(ip string) string {
+	return t.name + ":ip:" + ip
+}
+
+// Attempt counts an attempt for the email address from the ip address before it is made. It returns the time until
+// which the email address or the ip address is locked if the attempt must not be made, or the zero time. Attempts on
+// a locked address are not counted. The attempt counts as failed unless Release or Succeed is called. user is the
+// account of the email address, or nil if there is none.
+func (t *Throttle) Attempt(ctx context.Context, email string, ip string, user *database.User) (time.Time, error) {
+	tx, err := database.Conn.Begin(ctx)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := database.Q.WithTx(tx)
+
+	// the account row is always locked first, so that concurrent attempts can not deadlock
+	until, locked, err := t.reserve(ctx, qtx, t.accountKey(email), t.accountLimit)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !until.IsZero() && !locked {
+		return until, nil
+	}
+	accountLocked := locked
+
+	if until.IsZero() {
+		until, locked, err = t.reserve(ctx, qtx, t.ipKey(ip), t.ipLimit)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if !until.IsZero() && !locked {
+			return until, nil
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("commit tx: %w", err)
+	}
+
+	if accountLocked {
+		slog.Warn("account locked", "throttle", t.name, "email", email, "ip", ip, "until", until)
+		if t.notify && user != nil {
+			err = notification.SendToUser(ctx, notification.EventAccountLocked, user.ID, map[string]any{
+				"Ip":          ip,
+				"LockedUntil": until.UTC().Format("2006-01-02 15:04 UTC"),
+			})
+			if err != nil {
+				slog.Error("send account locked notification", "err", err, "user", user.ID)
+			}
+		}
+	} else if locked {
+		slog.Warn("ip address locked", "throttle", t.name, "ip", ip, "until", until)
+	}
+	return until, nil
+}
+
+// reserve counts an attempt for key in qtx. If key is locked, it returns the end of the lockout, and the attempt must
+// be rolled back. If the attempt is over limit, it locks key and returns the end of the lockout and true. Otherwise
+// it returns the zero time.
+func (t *Throttle) reserve(ctx context.Context, qtx *database.Queries, key string, limit int32) (time.Time, bool, error) {
+	// the row is locked until the end of the transaction, so concurrent attempts wait for each other
+	throttle, err := qtx.RecordLoginFailure(ctx, key)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("db: %w", err)
+	}
+	if throttle.LockedUntil.Valid && throttle.LockedUntil.Time.After(time.Now()) {
+		return throttle.LockedUntil.Time, false, nil
+	}
+	if throttle.Failures <= limit {
+		return time.Time{}, false, nil
+	}
+
+	duration := lockoutMax
+	if throttle.Lockouts < 16 && lockoutBase<<throttle.Lockouts < lockoutMax {
+		duration = lockoutBase << throttle.Lockouts
+	}
+	until := time.Now().Add(duration)
+
+	err = qtx.LockLoginThrottle(ctx, database.LockLoginThrottleParams{
+		Key:         key,
+		Lockouts:    throttle.Lockouts + 1,
+		LockedUntil: types.Timestamp{Timestamp: pgtype.Timestamp{Valid: true, Time: until}},
+	})
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("db: %w", err)
+	}
+	return until, true, nil
+}
+
+// Release uncounts an attempt that did not fail, but does not reset the failed attempts, e.g. for the right password
+// when a two-factor code is still required.
+func (t *Throttle) Release(ctx context.Context, email string, ip string) error {
+	for _, key := range []string{t.accountKey(email), t.ipKey(ip)} {
+		err := database.Q.ReleaseLoginAttempt(ctx, key)
+		if err != nil {
+			return fmt.Errorf("db: %w", err)
+		}
+	}
+	return nil
+}
+
+// Succeed uncounts a successful attempt and resets the failed attempts of the email address. The failed attempts of
+// the ip address are not reset, otherwise an attacker could log in to their own account between guesses.
+func (t *Throttle) Succeed(ctx context.Context, email string, ip string) error {
+	err := database.Q.ReleaseLoginAttempt(ctx, t.ipKey(ip))
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+	return t.reset(ctx, email)
+}
+
+// reset removes the failed attempts and lockouts of the email address.
+func (t *Throttle) reset(ctx context.Context, email string) error {
+	err := database.Q.DeleteLoginThrottle(ctx, t.accountKey(email))
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+	return nil
+}
+
+// AccountLockedUntil returns the time until which logging in to the email address is locked, or the zero time if it
+// is not. Lockouts of ip addresses are not included.
+func AccountLockedUntil(ctx context.Context, email string) (time.Time, error) {
+	lockouts, err := database.Q.ListLoginLockouts(ctx, []string{LoginThrottle.accountKey(email)})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("db: %w", err)
+	}
+	if len(lockouts) == 0 {
+		return time.Time{}, nil
+	}
+	return lockouts[0].LockedUntil.Time, nil
+}
+
+// UnlockAccount removes the lockouts and failed attempts of the email address in every throttle, including the
+// number of past lockouts.
+func UnlockAccount(ctx context.Context, email string) error {
+	for _, t := range Throttles {
+		err := t.reset(ctx, email)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
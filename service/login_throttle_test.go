@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+)
+
+func TestThrottleAttemptConcurrent(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+
+	email := testName("throttle") + "@example.com"
+	ip := testName("ip")
+
+	// a burst of guesses, only the first accountLimit of them may be made
+	var allowed atomic.Int32
+	runConcurrently(testConcurrency, func(i int) {
+		until, err := LoginThrottle.Attempt(ctx, email, ip, nil)
+		if err != nil {
+			t.Errorf("attempt %d: %v", i, err)
+			return
+		}
+		if until.IsZero() {
+			allowed.Add(1)
+		}
+	})
+
+	if allowed.Load() != LoginThrottle.accountLimit {
+		t.Errorf("%d attempts allowed, want %d", allowed.Load(), LoginThrottle.accountLimit)
+	}
+
+	until, err := AccountLockedUntil(ctx, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if until.IsZero() {
+		t.Error("account is not locked")
+	}
+}
+
+func TestThrottleSucceedUncountsAttempt(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+
+	email := testName("throttle") + "@example.com"
+	ip := testName("ip")
+
+	// successful attempts do not use up the limit of the ip address
+	for i := int32(0); i < LoginThrottle.ipLimit+1; i++ {
+		until, err := LoginThrottle.Attempt(ctx, email, ip, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !until.IsZero() {
+			t.Fatalf("attempt %d locked until %s", i, until)
+		}
+		err = LoginThrottle.Succeed(ctx, email, ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package notification
+
+import (
+	"billing3/database"
+	"fmt"
+)
+
+const (
+	EventVerifyEmail         = "verify_email"
+	EventResetPassword       = "reset_password"
+	EventAccountLocked       = "account_locked"
+	EventInvoiceCreated      = "invoice_created"
+	EventInvoiceReminder     = "invoice_reminder"
+	EventPaymentReceived     = "payment_received"
+	EventServiceCreated      = "service_created"
+	EventServiceSuspended    = "service_suspended"
+	EventServiceUnsuspended  = "service_unsuspended"
+	EventServiceTerminated   = "service_terminated"
+	EventTicketReply         = "ticket_reply"
+	EventTicketCustomerReply = "ticket_customer_reply"
+)
+
+var sampleInvoice = map[string]any{
+	"ID":       int32(12),
+	"Number":   "INV-2026-000012",
+	"Status":   "UNPAID",
+	"Amount":   "10.00",
+	"Currency": "USD",
+	"DueAt":    "2026-01-08",
+	"Link":     "https://example.com/dashboard/invoice/12",
+}
+
+var sampleTicket = map[string]any{
+	"ID":         int32(56),
+	"Subject":    "Can not connect to my VPS",
+	"Status":     "ANSWERED",
+	"Priority":   "MEDIUM",
+	"Department": "Technical Support",
+	"Link":       "https://example.com/dashboard/ticket/56",
+}
+
+var sampleService = map[string]any{
+	"ID":        int32(34),
+	"Label":     "VPS 1GB",
+	"Status":    "ACTIVE",
+	"ExpiresAt": "2026-01-08",
+	"Link":      "https://example.com/dashboard/service/34",
+}
+
+// Events is the list of all events. Every template has the variables .SiteName and .PublicDomain, and
+// .User (ID, Name, Email) unless the email is sent to an address without an account.
+var Events = []Event{
+	{
+		Name:        EventVerifyEmail,
+		Description: "Sent when someone registers, to verify the email address. Variables: .Link",
+		Default: Template{
+			Subject: "Verify email",
+			HTML:    `<p>Click the following link to continue registration:<br><br><a href="{{ .Link }}">{{ .Link }}</a></p>`,
+			Text:    "Open the following link to continue registration:\n\n{{ .Link }}\n",
+		},
+		Sample:    map[string]any{"Link": "https://example.com/auth/register2?token=..."},
+		Sensitive: true,
+	},
+	{
+		Name:        EventResetPassword,
+		Description: "Sent when a user asks to reset their password. Variables: .Link",
+		Default: Template{
+			Subject: "Reset Password",
+			HTML:    `<p>Click here to reset your password: <a href="{{ .Link }}">{{ .Link }}</a></p>`,
+			Text:    "Open the following link to reset your password:\n\n{{ .Link }}\n",
+		},
+		Sample:    map[string]any{"Link": "https://example.com/auth/reset-password2?token=..."},
+		Sensitive: true,
+	},
+	{
+		Name:        EventAccountLocked,
+		Description: "Sent when logging in to an account is locked after too many failed attempts. Variables: .Ip, .LockedUntil",
+		Default: Template{
+			Subject: "Your account has been locked",
+			HTML: `<p>Hi {{ .User.Name }},</p>
+<p>Logging in to your account has been locked until {{ .LockedUntil }} after too many failed attempts. The last attempt was from {{ .Ip }}.</p>
+<p>If this was not you, consider resetting your password.</p>`,
+			Text: `Hi {{ .User.Name }},
+
+Logging in to your account has been locked until {{ .LockedUntil }} after too many failed attempts. The last attempt was from {{ .Ip }}.
+
+If this was not you, consider resetting your password.
+`,
+		},
+		Sample: map[string]any{"Ip": "203.0.113.5", "LockedUntil": "2026-01-08 12:30 UTC"},
+	},
+	{
+		Name:        EventInvoiceCreated,
+		Description: "Sent when an invoice is created. Variables: .Invoice (ID, Number, Status, Amount, Currency, DueAt, Link), .Items (Description, Amount)",
+		Default: Template{
+			Subject: "Invoice {{ .Invoice.Number }} of {{ .Invoice.Amount }} {{ .Invoice.Currency }}",
+			HTML: `<p>Hi {{ .User.Name }},</p>
+<p>Invoice {{ .Invoice.Number }} has been created and is due on {{ .Invoice.DueAt }}.</p>
+<ul>
+{{ range .Items }}<li>{{ .Description }}: {{ .Amount }} {{ $.Invoice.Currency }}</li>
+{{ end }}</ul>
+<p>Total: {{ .Invoice.Amount }} {{ .Invoice.Currency }}</p>
+<p><a href="{{ .Invoice.Link }}">View and pay the invoice</a></p>`,
+			Text: `Hi {{ .User.Name }},
+
+Invoice {{ .Invoice.Number }} has been created and is due on {{ .Invoice.DueAt }}.
+
+{{ range .Items }}- {{ .Description }}: {{ .Amount }} {{ $.Invoice.Currency }}
+{{ end }}
+Total: {{ .Invoice.Amount }} {{ .Invoice.Currency }}
+
+View and pay the invoice: {{ .Invoice.Link }}
+`,
+		},
+		Sample: map[string]any{
+			"Invoice": sampleInvoice,
+			"Items":   []map[string]any{{"Description": "VPS 1GB (2026-01-08 - 2026-02-08)", "Amount": "10.00"}},
+		},
+	},
+	{
+		Name:        EventInvoiceReminder,
+		Description: "Sent by dunning about the unpaid renewal invoice of a service. Variables: .Invoice, .Service (ID, Label, Status, ExpiresAt, Link), .Overdue, .SuspendAt (empty if the service is not going to be suspended), .TerminateAt",
+		Default: Template{
+			Subject: `{{ if .Overdue }}Invoice {{ .Invoice.Number }} is overdue{{ else }}Invoice {{ .Invoice.Number }} is due on {{ .Service.ExpiresAt }}{{ end }}`,
+			HTML: `<p>Your service #{{ .Service.ID }} {{ .Service.Label }} {{ if .Overdue }}expired{{ else }}expires{{ end }} on {{ .Service.ExpiresAt }}. Please pay invoice {{ .Invoice.Number }} of {{ .Invoice.Amount }} {{ .Invoice.Currency }} to renew it.</p>
+{{ if .SuspendAt }}<p>The service will be suspended on {{ .SuspendAt }}.</p>
+{{ end }}<p>The service will be terminated on {{ .TerminateAt }}, and its data will be deleted.</p>
+<p><a href="{{ .Invoice.Link }}">Pay the invoice</a></p>`,
+			Text: `Your service #{{ .Service.ID }} {{ .Service.Label }} {{ if .Overdue }}expired{{ else }}expires{{ end }} on {{ .Service.ExpiresAt }}. Please pay invoice {{ .Invoice.Number }} of {{ .Invoice.Amount }} {{ .Invoice.Currency }} to renew it.
+{{ if .SuspendAt }}
+The service will be suspended on {{ .SuspendAt }}.
+{{ end }}
+The service will be terminated on {{ .TerminateAt }}, and its data will be deleted.
+
+Pay the invoice: {{ .Invoice.Link }}
+`,
+		},
+		Sample: map[string]any{
+			"Invoice":     sampleInvoice,
+			"Service":     sampleService,
+			"Overdue":     false,
+			"SuspendAt":   "2026-01-11",
+			"TerminateAt": "2026-01-18",
+		},
+	},
+	{
+		Name:        EventPaymentReceived,
+		Description: "Sent when a payment is added to an invoice. Variables: .Invoice, .Payment (Amount, Gateway, Description), .Paid (the invoice is fully paid), .Due (the remaining amount)",
+		Default: Template{
+			Subject: "Payment received for invoice {{ .Invoice.Number }}",
+			HTML: `<p>Hi {{ .User.Name }},</p>
+<p>We have received your payment of {{ .Payment.Amount }} {{ .Invoice.Currency }} for invoice {{ .Invoice.Number }}.</p>
+{{ if .Paid }}<p>The invoice is now paid. Thank you!</p>{{ else }}<p>{{ .Due }} {{ .Invoice.Currency }} is still due.</p>{{ end }}
+<p><a href="{{ .Invoice.Link }}">View the invoice</a></p>`,
+			Text: `Hi {{ .User.Name }},
+
+We have received your payment of {{ .Payment.Amount }} {{ .Invoice.Currency }} for invoice {{ .Invoice.Number }}.
+{{ if .Paid }}The invoice is now paid. Thank you!{{ else }}{{ .Due }} {{ .Invoice.Currency }} is still due.{{ end }}
+
+View the invoice: {{ .Invoice.Link }}
+`,
+		},
+		Sample: map[string]any{
+			"Invoice": sampleInvoice,
+			"Payment": map[string]any{"Amount": "10.00", "Gateway": "Stripe", "Description": "Stripe payment"},
+			"Paid":    true,
+			"Due":     "0.00",
+		},
+	},
+	{
+		Name:        EventServiceCreated,
+		Description: "Sent when a service has been provisioned. Variables: .Service",
+		Default: Template{
+			Subject: "Service #{{ .Service.ID }} {{ .Service.Label }} is ready",
+			HTML: `<p>Hi {{ .User.Name }},</p>
+<p>Your service #{{ .Service.ID }} {{ .Service.Label }} has been set up.</p>
+<p><a href="{{ .Service.Link }}">Manage the service</a></p>`,
+			Text: `Hi {{ .User.Name }},
+
+Your service #{{ .Service.ID }} {{ .Service.Label }} has been set up.
+
+Manage the service: {{ .Service.Link }}
+`,
+		},
+		Sample: map[string]any{"Service": sampleService},
+	},
+	{
+		Name:        EventServiceSuspended,
+		Description: "Sent when a service is suspended. Variables: .Service, .Reason",
+		Default: Template{
+			Subject: "Service #{{ .Service.ID }} {{ .Service.Label }} has been suspended",
+			HTML: `<p>Hi {{ .User.Name }},</p>
+<p>Your service #{{ .Service.ID }} {{ .Service.Label }} has been suspended.{{ if .Reason }} Reason: {{ .Reason }}{{ end }}</p>
+<p><a href="{{ .Service.Link }}">View the service</a></p>`,
+			Text: `Hi {{ .User.Name }},
+
+Your service #{{ .Service.ID }} {{ .Service.Label }} has been suspended.{{ if .Reason }} Reason: {{ .Reason }}{{ end }}
+
+View the service: {{ .Service.Link }}
+`,
+		},
+		Sample: map[string]any{"Service": sampleService, "Reason": "suspend: invoice INV-2026-000012 3 days overdue"},
+	},
+	{
+		Name:        EventServiceUnsuspended,
+		Description: "Sent when a suspended service is active again. Variables: .Service, .Reason",
+		Default: Template{
+			Subject: "Service #{{ .Service.ID }} {{ .Service.Label }} has been unsuspended",
+			HTML: `<p>Hi {{ .User.Name }},</p>
+<p>Your service #{{ .Service.ID }} {{ .Service.Label }} is active again.</p>
+<p><a href="{{ .Service.Link }}">Manage the service</a></p>`,
+			Text: `Hi {{ .User.Name }},
+
+Your service #{{ .Service.ID }} {{ .Service.Label }} is active again.
+
+Manage the service: {{ .Service.Link }}
+`,
+		},
+		Sample: map[string]any{"Service": sampleService, "Reason": "unsuspended: invoice INV-2026-000012 paid"},
+	},
+	{
+		Name:        EventServiceTerminated,
+		Description: "Sent when a service is terminated. Variables: .Service, .Reason",
+		Default: Template{
+			Subject: "Service #{{ .Service.ID }} {{ .Service.Label }} has been terminated",
+			HTML: `<p>Hi {{ .User.Name }},</p>
+<p>Your service #{{ .Service.ID }} {{ .Service.Label }} has been terminated, and its data has been deleted.{{ if .Reason }} Reason: {{ .Reason }}{{ end }}</p>`,
+			Text: `Hi {{ .User.Name }},
+
+Your service #{{ .Service.ID }} {{ .Service.Label }} has been terminated, and its data has been deleted.{{ if .Reason }} Reason: {{ .Reason }}{{ end }}
+`,
+		},
+		Sample: map[string]any{"Service": sampleService, "Reason": "terminate: invoice INV-2026-000012 10 days overdue"},
+	},
+	{
+		Name:        EventTicketReply,
+		Description: "Sent to the client when staff replies to a ticket. Variables: .Ticket (ID, Subject, Status, Priority, Department, Link), .Message (Author, Body)",
+		Default: Template{
+			Subject: "[Ticket #{{ .Ticket.ID }}] {{ .Ticket.Subject }}",
+			HTML: `<p>Hi {{ .User.Name }},</p>
+<p>{{ .Message.Author }} has replied to your ticket #{{ .Ticket.ID }} {{ .Ticket.Subject }}:</p>
+<blockquote style="white-space: pre-wrap">{{ .Message.Body }}</blockquote>
+<p><a href="{{ .Ticket.Link }}">View the ticket</a></p>`,
+			Text: `Hi {{ .User.Name }},
+
+{{ .Message.Author }} has replied to your ticket #{{ .Ticket.ID }} {{ .Ticket.Subject }}:
+
+{{ .Message.Body }}
+
+View the ticket: {{ .Ticket.Link }}
+`,
+		},
+		Sample: map[string]any{
+			"Ticket":  sampleTicket,
+			"Message": map[string]any{"Author": "Support", "Body": "Hi,\n\nThe VPS has been rebooted, please try again."},
+		},
+	},
+	{
+		Name:        EventTicketCustomerReply,
+		Description: "Sent to the assigned staff when the client replies to a ticket. .User is the staff. Variables: .Ticket (Link is the admin page), .Message (Author, Body)",
+		Default: Template{
+			Subject: "[Ticket #{{ .Ticket.ID }}] {{ .Ticket.Subject }}",
+			HTML: `<p>{{ .Message.Author }} has replied to ticket #{{ .Ticket.ID }} {{ .Ticket.Subject }} ({{ .Ticket.Department }}, {{ .Ticket.Priority }}):</p>
+<blockquote style="white-space: pre-wrap">{{ .Message.Body }}</blockquote>
+<p><a href="{{ .Ticket.Link }}">View the ticket</a></p>`,
+			Text: `{{ .Message.Author }} has replied to ticket #{{ .Ticket.ID }} {{ .Ticket.Subject }} ({{ .Ticket.Department }}, {{ .Ticket.Priority }}):
+
+{{ .Message.Body }}
+
+View the ticket: {{ .Ticket.Link }}
+`,
+		},
+		Sample: map[string]any{
+			"Ticket":  map[string]any{"ID": int32(56), "Subject": "Can not connect to my VPS", "Status": "CUSTOMER_REPLY", "Priority": "MEDIUM", "Department": "Technical Support", "Link": "https://example.com/admin/ticket/56"},
+			"Message": map[string]any{"Author": "John", "Body": "It still does not work."},
+		},
+	},
+}
+
+// ServiceData returns the .Service variable of a service.
+func ServiceData(s *database.Service) map[string]any {
+	expiresAt := ""
+	if s.ExpiresAt.Valid {
+		expiresAt = s.ExpiresAt.Time.Format("2006-01-02")
+	}
+	return map[string]any{
+		"ID":        s.ID,
+		"Label":     s.Label,
+		"Status":    s.Status,
+		"ExpiresAt": expiresAt,
+		"Link":      fmt.Sprintf("%s/dashboard/service/%d", PublicDomain(), s.ID),
+	}
+}
+
+// TicketData returns the .Ticket variable of a ticket. The link is to the admin page if admin is true.
+func TicketData(t *database.Ticket, department string, admin bool) map[string]any {
+	link := fmt.Sprintf("%s/dashboard/ticket/%d", PublicDomain(), t.ID)
+	if admin {
+		link = fmt.Sprintf("%s/admin/ticket/%d", PublicDomain(), t.ID)
+	}
+	return map[string]any{
+		"ID":         t.ID,
+		"Subject":    t.Subject,
+		"Status":     t.Status,
+		"Priority":   t.Priority,
+		"Department": department,
+		"Link":       link,
+	}
+}
@@ -0,0 +1,202 @@
+package notification
+
+import (
+	"billing3/database"
+	"billing3/service/email"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"log/slog"
+	"maps"
+	"os"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var ErrUnknownEvent = errors.New("unknown notification event")
+
+// Template is the email template of an event. Subject and Text are Go text/templates, and HTML is a Go
+// html/template. All of them get the same data.
+type Template struct {
+	Subject string `json:"subject"`
+	HTML    string `json:"html"`
+	Text    string `json:"text"` // the plain text version of the email, optional
+}
+
+// Message is a rendered template.
+type Message struct {
+	Subject string `json:"subject"`
+	HTML    string `json:"html"`
+	Text    string `json:"text"`
+}
+
+// Event is something that users are notified about by email.
+type Event struct {
+	Name        string
+	Description string
+	Default     Template
+	Sample      map[string]any // variables of the event with example values, used by the preview
+	Sensitive   bool           // the email contains a secret link, see email.SendSensitiveMailAsync
+}
+
+// FindEvent returns the event with the name.
+func FindEvent(name string) (*Event, error) {
+	for i := range Events {
+		if Events[i].Name == name {
+			return &Events[i], nil
+		}
+	}
+	return nil, ErrUnknownEvent
+}
+
+// GetTemplate returns the template of the event that is edited by admins, or the default template if it has not
+// been edited. The boolean is true if the template has been edited.
+func GetTemplate(ctx context.Context, event *Event) (Template, bool, error) {
+	t, err := database.Q.FindEmailTemplate(ctx, event.Name)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return event.Default, false, nil
+		}
+		return Template{}, false, fmt.Errorf("db: %w", err)
+	}
+	return Template{Subject: t.Subject, HTML: t.Html, Text: t.Text}, true, nil
+}
+
+// Validate returns an error if the template can not be parsed.
+func (t *Template) Validate() error {
+	if strings.TrimSpace(t.Subject) == "" {
+		return fmt.Errorf("subject must not be empty")
+	}
+	if _, err := texttemplate.New("subject").Parse(t.Subject); err != nil {
+		return fmt.Errorf("subject: %w", err)
+	}
+	if _, err := htmltemplate.New("html").Parse(t.HTML); err != nil {
+		return fmt.Errorf("html: %w", err)
+	}
+	if _, err := texttemplate.New("text").Parse(t.Text); err != nil {
+		return fmt.Errorf("text: %w", err)
+	}
+	return nil
+}
+
+// Render executes the template with data.
+func (t *Template) Render(data map[string]any) (*Message, error) {
+	m := Message{}
+
+	subject, err := executeText(t.Subject, data)
+	if err != nil {
+		return nil, fmt.Errorf("subject: %w", err)
+	}
+	// the subject is a single line
+	m.Subject = strings.Join(strings.Fields(subject), " ")
+
+	tmpl, err := htmltemplate.New("html").Option("missingkey=zero").Parse(t.HTML)
+	if err != nil {
+		return nil, fmt.Errorf("html: %w", err)
+	}
+	html := bytes.Buffer{}
+	err = tmpl.Execute(&html, data)
+	if err != nil {
+		return nil, fmt.Errorf("html: %w", err)
+	}
+	m.HTML = html.String()
+
+	m.Text, err = executeText(t.Text, data)
+	if err != nil {
+		return nil, fmt.Errorf("text: %w", err)
+	}
+
+	return &m, nil
+}
+
+func executeText(text string, data map[string]any) (string, error) {
+	tmpl, err := texttemplate.New("").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	out := bytes.Buffer{}
+	err = tmpl.Execute(&out, data)
+	if err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// Preview renders t with the sample variables of the event.
+func Preview(ctx context.Context, event *Event, t *Template) (*Message, error) {
+	data := commonData(ctx)
+	data["User"] = map[string]any{"ID": int32(1), "Name": "John Doe", "Email": "john@example.com"}
+	maps.Copy(data, event.Sample)
+	return t.Render(data)
+}
+
+// Send renders the template of the event and emails it to the address. The edited template falls back to the
+// default template if it can not be rendered, so that the email is still sent.
+func Send(ctx context.Context, eventName string, to string, data map[string]any) error {
+	event, err := FindEvent(eventName)
+	if err != nil {
+		return err
+	}
+
+	t, edited, err := GetTemplate(ctx, event)
+	if err != nil {
+		return err
+	}
+
+	vars := commonData(ctx)
+	maps.Copy(vars, data)
+
+	m, err := t.Render(vars)
+	if err != nil && edited {
+		slog.Error("render email template, using the default template", "err", err, "event", event.Name)
+		m, err = event.Default.Render(vars)
+	}
+	if err != nil {
+		return fmt.Errorf("render email template %s: %w", event.Name, err)
+	}
+
+	slog.Info("notification", "event", event.Name, "to", to)
+
+	if event.Sensitive {
+		return email.SendSensitiveMailAsync(ctx, to, m.Subject, m.HTML, m.Text)
+	}
+	return email.SendMultipartMailAsync(ctx, to, m.Subject, m.HTML, m.Text)
+}
+
+// SendToUser is like Send, and sends the email to a user. The user is available as .User in the template.
+func SendToUser(ctx context.Context, eventName string, userId int32, data map[string]any) error {
+	user, err := database.Q.FindUserById(ctx, userId)
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	vars := map[string]any{"User": map[string]any{"ID": user.ID, "Name": user.Name, "Email": user.Email}}
+	maps.Copy(vars, data)
+
+	return Send(ctx, eventName, user.Email, vars)
+}
+
+// PublicDomain returns the PUBLIC_DOMAIN environment variable, where the frontend is served. It is read on the first
+// call, after the .env file is loaded.
+var PublicDomain = sync.OnceValue(func() string {
+	return os.Getenv("PUBLIC_DOMAIN")
+})
+
+// SiteName returns the name of the site. It is service.SettingSiteName, which sets it since this package can not
+// import the settings.
+var SiteName = func(ctx context.Context) string {
+	return "billing3"
+}
+
+// commonData returns the variables that are available in every template.
+func commonData(ctx context.Context) map[string]any {
+	return map[string]any{
+		"SiteName":     SiteName(ctx),
+		"PublicDomain": PublicDomain(),
+	}
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"billing3/database"
+	"billing3/service/notification"
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/shopspring/decimal"
+)
+
+// invoiceNotificationData returns the .Invoice variable of email templates.
+func invoiceNotificationData(invoice *database.Invoice) map[string]any {
+	dueAt := ""
+	if invoice.DueAt.Valid {
+		dueAt = invoice.DueAt.Time.Format("2006-01-02")
+	}
+	return map[string]any{
+		"ID":       invoice.ID,
+		"Number":   InvoiceDisplayNumber(invoice),
+		"Status":   invoice.Status,
+		"Amount":   invoice.Amount.StringFixed(2),
+		"Currency": invoice.Currency,
+		"DueAt":    dueAt,
+		"Link":     fmt.Sprintf("%s/dashboard/invoice/%d", notification.PublicDomain(), invoice.ID),
+	}
+}
+
+// NotifyInvoiceCreated emails the invoice to its owner. It should be called after the transaction that creates
+// the invoice is commited. Nothing is sent if the invoice is free or has already been paid, e.g. with credit.
+func NotifyInvoiceCreated(ctx context.Context, invoiceId int32) {
+	invoice, err := database.Q.FindInvoiceById(ctx, invoiceId)
+	if err != nil {
+		slog.Error("notify invoice created", "err", err, "invoice_id", invoiceId)
+		return
+	}
+
+	if invoice.Status != InvoiceUnpaid || invoice.Amount.IsZero() {
+		return
+	}
+
+	items, err := database.Q.ListInvoiceItems(ctx, invoiceId)
+	if err != nil {
+		slog.Error("notify invoice created", "err", err, "invoice_id", invoiceId)
+		return
+	}
+
+	itemsData := make([]map[string]any, len(items))
+	for i, item := range items {
+		itemsData[i] = map[string]any{"Description": item.Description, "Amount": item.Amount.StringFixed(2)}
+	}
+
+	err = notification.SendToUser(ctx, notification.EventInvoiceCreated, invoice.UserID, map[string]any{
+		"Invoice": invoiceNotificationData(&invoice),
+		"Items":   itemsData,
+	})
+	if err != nil {
+		slog.Error("notify invoice created", "err", err, "invoice_id", invoiceId)
+	}
+}
+
+// notifyPaymentReceived tells the owner of the invoice that a payment has been added. It should be called after
+// the transaction that adds the payment is commited.
+func notifyPaymentReceived(ctx context.Context, invoiceId int32, description string, amount decimal.Decimal, gateway string) {
+	if amount.LessThanOrEqual(decimal.Zero) {
+		return
+	}
+
+	invoice, err := database.Q.FindInvoiceById(ctx, invoiceId)
+	if err != nil {
+		slog.Error("notify payment received", "err", err, "invoice_id", invoiceId)
+		return
+	}
+
+	total, err := database.Q.TotalInvoicePayment(ctx, invoiceId)
+	if err != nil {
+		slog.Error("notify payment received", "err", err, "invoice_id", invoiceId)
+		return
+	}
+
+	err = notification.SendToUser(ctx, notification.EventPaymentReceived, invoice.UserID, map[string]any{
+		"Invoice": invoiceNotificationData(&invoice),
+		"Payment": map[string]any{"Amount": amount.StringFixed(2), "Gateway": gateway, "Description": description},
+		"Paid":    invoice.Status == InvoicePaid,
+		"Due":     decimal.Max(invoice.Amount.Sub(total), decimal.Zero).StringFixed(2),
+	})
+	if err != nil {
+		slog.Error("notify payment received", "err", err, "invoice_id", invoiceId)
+	}
+}
@@ -2,7 +2,9 @@ package service
 
 import (
 	"billing3/database"
+	"billing3/database/types"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/shopspring/decimal"
 	"log/slog"
@@ -13,14 +15,24 @@ type OrderRequest struct {
 	ProductID int               `json:"product_id" validate:"required"`
 	Duration  int               `json:"duration" validate:"min=0"`
 	Options   map[string]string `json:"options"`
+	Coupon    string            `json:"coupon" validate:"max=200"`
+	Currency  string            `json:"currency" validate:"max=3"` // base currency if empty
 }
 
 type Pricing struct {
+	Currency     string          `json:"currency"`
 	Duration     int             `json:"duration"`
 	BillingCycle string          `json:"billing_cycle"`
 	RecurringFee decimal.Decimal `json:"recurring_fee"`
 	SetupFee     decimal.Decimal `json:"setup_fee"`
 	Items        []PricingItem   `json:"items"`
+
+	// Discount is deducted from the recurring fee of the first invoice, and RecurringDiscount
+	// from every renewal invoice. Both are zero if no coupon is applied.
+	Coupon            string          `json:"coupon"`
+	Discount          decimal.Decimal `json:"discount"`
+	RecurringDiscount decimal.Decimal `json:"recurring_discount"`
+	CouponID          int32           `json:"-"`
 }
 
 type PricingItem struct {
@@ -28,8 +40,10 @@ type PricingItem struct {
 	Description string          `json:"description"`
 }
 
-// CalculatePricing calculates price for given billing cycle, and configurable options.
-// CalculatePricing returns error if product is disabled or out of stock.
+// CalculatePricing calculates price for given billing cycle, and configurable options, in the
+// requested currency.
+// CalculatePricing returns error if product is disabled or out of stock, the currency is not
+// supported, or the coupon is not applicable.
 // CalculatePricing returns (product, cleaned options, redacted options(with password
 // removed, used for logging), pricing, error)
 func CalculatePricing(ctx context.Context, req OrderRequest) (*database.Product, map[string]string, map[string]string, *Pricing, error) {
@@ -49,35 +63,58 @@ func CalculatePricing(ctx context.Context, req OrderRequest) (*database.Product,
 		return nil, nil, nil, nil, fmt.Errorf("product is out of stock")
 	}
 
+	currency := NormalizeCurrency(req.Currency)
+	if currency == "" {
+		currency = BaseCurrency(ctx)
+	}
+
+	// price returns the price and setup fee in the requested currency
+	price := func(basePrice decimal.Decimal, baseSetupFee decimal.Decimal, currencies map[string]types.CurrencyPrice) (decimal.Decimal, decimal.Decimal, error) {
+		p, setupFee, err := localPrice(ctx, basePrice, baseSetupFee, currencies, currency)
+		if err != nil && !errors.Is(err, ErrCurrencyUnsupported) {
+			slog.Error("local price", "err", err, "currency", currency, "product", req.ProductID)
+			return decimal.Zero, decimal.Zero, ErrInternalError
+		}
+		return p, setupFee, err
+	}
+
 	pricing := Pricing{
-		RecurringFee: decimal.NewFromInt(0),
-		SetupFee:     decimal.NewFromInt(0),
-		Items:        make([]PricingItem, 0),
-		Duration:     req.Duration,
+		Currency:          currency,
+		RecurringFee:      decimal.NewFromInt(0),
+		SetupFee:          decimal.NewFromInt(0),
+		Items:             make([]PricingItem, 0),
+		Duration:          req.Duration,
+		Discount:          decimal.NewFromInt(0),
+		RecurringDiscount: decimal.NewFromInt(0),
 	}
 
 	// product pricing
 
 	found := false
-	for _, price := range product.Pricing {
-		if price.Duration == int32(req.Duration) {
+	for _, productPrice := range product.Pricing {
+		if productPrice.Duration == int32(req.Duration) {
 			found = true
 
-			pricing.RecurringFee = pricing.RecurringFee.Add(price.Price)
+			recurringFee, setupFee, err := price(productPrice.Price, productPrice.SetupFee, productPrice.Currencies)
+			if err != nil {
+				return nil, nil, nil, nil, err
+			}
+
+			pricing.RecurringFee = pricing.RecurringFee.Add(recurringFee)
 			pricing.Items = append(pricing.Items, PricingItem{
 				Description: product.Name,
-				Price:       price.Price,
+				Price:       recurringFee,
 			})
 
-			if price.SetupFee.GreaterThan(decimal.Zero) {
-				pricing.SetupFee = pricing.SetupFee.Add(price.SetupFee)
+			if setupFee.GreaterThan(decimal.Zero) {
+				pricing.SetupFee = pricing.SetupFee.Add(setupFee)
 				pricing.Items = append(pricing.Items, PricingItem{
 					Description: product.Name + " Setup Fee",
-					Price:       price.SetupFee,
+					Price:       setupFee,
 				})
 			}
 
-			pricing.BillingCycle = price.DisplayName
+			pricing.BillingCycle = productPrice.DisplayName
 
 			break
 		}
@@ -130,25 +167,30 @@ func CalculatePricing(ctx context.Context, req OrderRequest) (*database.Product,
 
 				// find pricing for selected billing cycle
 				pricingFound := false
-				for _, price := range optionValue.Prices {
-					if price.Duration != int32(req.Duration) {
+				for _, optionPrice := range optionValue.Prices {
+					if optionPrice.Duration != int32(req.Duration) {
 						continue
 					}
 
-					if price.Price.GreaterThan(decimal.Zero) {
+					recurringFee, setupFee, err := price(optionPrice.Price, optionPrice.SetupFee, optionPrice.Currencies)
+					if err != nil {
+						return nil, nil, nil, nil, err
+					}
+
+					if recurringFee.GreaterThan(decimal.Zero) {
 						pricing.Items = append(pricing.Items, PricingItem{
 							Description: "\u00BB " + option.DisplayName + ": " + optionValue.DisplayName,
-							Price:       price.Price,
+							Price:       recurringFee,
 						})
-						pricing.RecurringFee = pricing.RecurringFee.Add(price.Price)
+						pricing.RecurringFee = pricing.RecurringFee.Add(recurringFee)
 					}
 
-					if price.SetupFee.GreaterThan(decimal.Zero) {
+					if setupFee.GreaterThan(decimal.Zero) {
 						pricing.Items = append(pricing.Items, PricingItem{
 							Description: "\u00BB " + option.DisplayName + ": " + optionValue.DisplayName + " Setup Fee",
-							Price:       price.SetupFee,
+							Price:       setupFee,
 						})
-						pricing.SetupFee = pricing.SetupFee.Add(price.SetupFee)
+						pricing.SetupFee = pricing.SetupFee.Add(setupFee)
 					}
 
 					pricingFound = true
@@ -192,5 +234,37 @@ func CalculatePricing(ctx context.Context, req OrderRequest) (*database.Product,
 		}
 	}
 
+	// coupon
+
+	if req.Coupon != "" {
+		coupon, err := findCoupon(ctx, req.Coupon, product.ID, int32(req.Duration))
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+
+		err = couponInCurrency(ctx, database.Q, coupon, currency)
+		if err != nil {
+			slog.Error("coupon in currency", "err", err, "code", coupon.Code, "currency", currency)
+			return nil, nil, nil, nil, ErrInternalError
+		}
+
+		// setup fees are not discounted
+		discount := couponDiscount(coupon, pricing.RecurringFee)
+
+		pricing.Coupon = coupon.Code
+		pricing.CouponID = coupon.ID
+		pricing.Discount = discount
+		if coupon.Recurring {
+			pricing.RecurringDiscount = discount
+		}
+
+		if discount.GreaterThan(decimal.Zero) {
+			pricing.Items = append(pricing.Items, PricingItem{
+				Description: "Coupon " + coupon.Code,
+				Price:       discount.Neg(),
+			})
+		}
+	}
+
 	return &product, cleanedOptions, redactedOptions, &pricing, nil
 }
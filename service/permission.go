@@ -0,0 +1,115 @@
+package service
+
+import (
+	"billing3/database"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Permissions of the admin area. Admins without a staff role have all of them.
+const (
+	PermUserView        = "user.view"
+	PermUserEdit        = "user.edit" // includes credit, two-factor authentication, sessions and lockouts
+	PermUserImpersonate = "user.impersonate"
+	PermProductView     = "product.view"
+	PermProductEdit     = "product.edit" // categories, products, coupons, tax rules and currencies
+	PermInvoiceView     = "invoice.view"
+	PermInvoiceEdit     = "invoice.edit" // includes items, payments and refunds
+	PermServiceView     = "service.view"
+	PermServiceAction   = "service.action"
+	PermServiceEdit     = "service.edit" // includes status, settings, invoices and cancellation requests
+	PermGatewayView     = "gateway.view"
+	PermGatewayEdit     = "gateway.edit"
+	PermServerView      = "server.view"
+	PermServerEdit      = "server.edit"
+	PermTicketView      = "ticket.view"
+	PermTicketReply     = "ticket.reply" // includes closing and assigning tickets
+	PermDepartmentEdit  = "department.edit"
+	PermEmailView       = "email.view"
+	PermEmailEdit       = "email.edit" // includes templates and resending
+	PermSettingsView    = "settings.view"
+	PermSettingsEdit    = "settings.edit"
+	PermRoleEdit        = "role.edit" // managing staff roles and making users admins, which grants every permission
+	PermAuditView       = "audit.view"
+)
+
+var Permissions = []string{
+	PermUserView,
+	PermUserEdit,
+	PermUserImpersonate,
+	PermProductView,
+	PermProductEdit,
+	PermInvoiceView,
+	PermInvoiceEdit,
+	PermServiceView,
+	PermServiceAction,
+	PermServiceEdit,
+	PermGatewayView,
+	PermGatewayEdit,
+	PermServerView,
+	PermServerEdit,
+	PermTicketView,
+	PermTicketReply,
+	PermDepartmentEdit,
+	PermEmailView,
+	PermEmailEdit,
+	PermSettingsView,
+	PermSettingsEdit,
+	PermRoleEdit,
+	PermAuditView,
+}
+
+var ErrPermissionInvalid = errors.New("invalid permission")
+var ErrStaffRoleNotFound = errors.New("staff role not found")
+
+// UserPermissions returns the permissions of the user. Admins without a staff role have every permission, users
+// that are not admins have none.
+func UserPermissions(ctx context.Context, user *database.User) ([]string, error) {
+	if user.Role != "admin" {
+		return []string{}, nil
+	}
+	if !user.StaffRoleID.Valid {
+		return Permissions, nil
+	}
+
+	role, err := database.Q.FindStaffRoleById(ctx, user.StaffRoleID.Int32)
+	if err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+	return role.Permissions, nil
+}
+
+// HasPermission returns whether the user has the permission.
+func HasPermission(ctx context.Context, user *database.User, permission string) (bool, error) {
+	permissions, err := UserPermissions(ctx, user)
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(permissions, permission), nil
+}
+
+// CheckPermissions returns ErrPermissionInvalid if any of the permissions is unknown.
+func CheckPermissions(permissions []string) error {
+	for _, p := range permissions {
+		if !slices.Contains(Permissions, p) {
+			return fmt.Errorf("%w: %s", ErrPermissionInvalid, p)
+		}
+	}
+	return nil
+}
+
+// CheckStaffRole returns ErrStaffRoleNotFound if the role does not exist.
+func CheckStaffRole(ctx context.Context, id int32) error {
+	_, err := database.Q.FindStaffRoleById(ctx, id)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrStaffRoleNotFound
+		}
+		return fmt.Errorf("db: %w", err)
+	}
+	return nil
+}
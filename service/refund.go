@@ -0,0 +1,212 @@
+package service
+
+import (
+	"billing3/database"
+	"billing3/database/types"
+	"billing3/service/extension"
+	"billing3/utils"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	RefundServiceActionNone    = ""
+	RefundServiceActionSuspend = "suspend"
+	RefundServiceActionCancel  = "cancel"
+)
+
+// RefundableAmount returns the amount of the payment that has not been refunded yet.
+func RefundableAmount(ctx context.Context, payment *database.InvoicePayment) (decimal.Decimal, error) {
+	return refundableAmount(ctx, database.Q, payment)
+}
+
+func refundableAmount(ctx context.Context, q *database.Queries, payment *database.InvoicePayment) (decimal.Decimal, error) {
+	if payment.RefundOf.Valid || payment.Amount.LessThanOrEqual(decimal.Zero) {
+		return decimal.Zero, nil
+	}
+
+	refunded, err := q.TotalRefundedForPayment(ctx, pgtype.Int4{Valid: true, Int32: payment.ID})
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("db: %w", err)
+	}
+
+	return payment.Amount.Sub(refunded), nil
+}
+
+// LockPaymentForRefund locks the payment in tx and returns it with its refundable amount. Other refunds of the
+// payment wait until tx ends, so the amount can not be refunded twice. ErrNotFound is returned if the payment does
+// not exist.
+func LockPaymentForRefund(ctx context.Context, tx pgx.Tx, paymentId int32) (*database.InvoicePayment, decimal.Decimal, error) {
+	qtx := database.Q.WithTx(tx)
+
+	payment, err := qtx.SelectInvoicePaymentForUpdate(ctx, paymentId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, decimal.Zero, ErrNotFound
+		}
+		return nil, decimal.Zero, fmt.Errorf("db: %w", err)
+	}
+
+	refundable, err := refundableAmount(ctx, qtx, &payment)
+	if err != nil {
+		return nil, decimal.Zero, err
+	}
+
+	return &payment, refundable, nil
+}
+
+// InvoiceAddRefund records a refund of the payment as a negative payment, see InvoiceAddRefundTx. The amount is
+// capped at the refundable amount of the payment, since the refund has already happened at the gateway.
+func InvoiceAddRefund(ctx context.Context, payment *database.InvoicePayment, amount decimal.Decimal, referenceId string, reason string) error {
+	tx, err := database.Conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	payment, refundable, err := LockPaymentForRefund(ctx, tx, payment.ID)
+	if err != nil {
+		return err
+	}
+	if amount.GreaterThan(refundable) {
+		amount = refundable
+	}
+
+	err = InvoiceAddRefundTx(ctx, tx, payment, amount, referenceId, reason)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+// InvoiceAddRefundTx records a refund of the payment as a negative payment in tx. The payment must be locked with
+// LockPaymentForRefund. The invoice is marked as REFUNDED if it is PAID and total payment drops to zero or below.
+//
+// The refund must already be processed by the gateway, and referenceId is the refund id returned by it.
+// A refund whose reference id has already been recorded for the gateway is ignored, since the same
+// refund may also be reported by a webhook.
+func InvoiceAddRefundTx(ctx context.Context, tx pgx.Tx, payment *database.InvoicePayment, amount decimal.Decimal, referenceId string, reason string) error {
+	slog.Info("add refund", "invoice_id", payment.InvoiceID, "payment_id", payment.ID, "amount", amount, "reference_id", referenceId, "gateway", payment.Gateway, "reason", reason)
+
+	qtx := database.Q.WithTx(tx)
+
+	invoice, err := qtx.SelectInvoiceForUpdate(ctx, payment.InvoiceID)
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	if referenceId != "" {
+		_, err = qtx.FindInvoicePaymentByReference(ctx, database.FindInvoicePaymentByReferenceParams{
+			Gateway:     payment.Gateway,
+			ReferenceID: referenceId,
+		})
+		if err == nil {
+			slog.Info("duplicate refund ignored", "payment_id", payment.ID, "reference_id", referenceId, "gateway", payment.Gateway)
+			return nil
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("db: %w", err)
+		}
+	}
+
+	description := "Refund"
+	if reason != "" {
+		description = "Refund: " + reason
+	}
+	description = utils.Truncate(description, 200)
+
+	_, err = qtx.AddInvoiceRefund(ctx, database.AddInvoiceRefundParams{
+		InvoiceID:   payment.InvoiceID,
+		Description: description,
+		Amount:      amount.Neg(),
+		ReferenceID: referenceId,
+		Gateway:     payment.Gateway,
+		RefundOf:    pgtype.Int4{Valid: true, Int32: payment.ID},
+	})
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	totalPayment, err := qtx.TotalInvoicePayment(ctx, payment.InvoiceID)
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	if invoice.Status == InvoicePaid && totalPayment.LessThanOrEqual(decimal.Zero) {
+		err = qtx.UpdateInvoiceRefunded(ctx, invoice.ID)
+		if err != nil {
+			return fmt.Errorf("db: %w", err)
+		}
+		slog.Info("updated invoice refunded", "invoice_id", invoice.ID)
+	}
+
+	return nil
+}
+
+// RefundServiceAction suspends or cancels the services in the invoice after a refund.
+// action must be one of RefundServiceActionNone, RefundServiceActionSuspend and RefundServiceActionCancel.
+func RefundServiceAction(ctx context.Context, invoiceId int32, action string, reason string) error {
+	if action == RefundServiceActionNone {
+		return nil
+	}
+
+	items, err := database.Q.ListInvoiceItems(ctx, invoiceId)
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	for _, item := range items {
+		if item.Type != InvoiceItemService || !item.ItemID.Valid {
+			continue
+		}
+
+		s, err := database.Q.FindServiceById(ctx, item.ItemID.Int32)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				continue
+			}
+			return fmt.Errorf("db: %w", err)
+		}
+
+		if s.Status == ServiceCancelled {
+			continue
+		}
+
+		slog.Info("refund service action", "service_id", s.ID, "invoice_id", invoiceId, "action", action)
+
+		switch action {
+		case RefundServiceActionSuspend:
+			err = extension.DoActionAsync(ctx, s.Extension, s.ID, "suspend", ServiceSuspended)
+		case RefundServiceActionCancel:
+			err = database.Q.UpdateServiceCancelled(ctx, database.UpdateServiceCancelledParams{
+				CancellationReason: pgtype.Text{Valid: true, String: "refunded: " + reason},
+				CancelledAt:        types.Timestamp{Timestamp: pgtype.Timestamp{Valid: true, Time: time.Now()}},
+				ID:                 s.ID,
+			})
+			if err != nil {
+				return fmt.Errorf("db: %w", err)
+			}
+			err = extension.DoActionAsync(ctx, s.Extension, s.ID, "terminate", ServiceCancelled)
+		default:
+			return fmt.Errorf("invalid refund service action: %s", action)
+		}
+		if err != nil {
+			return fmt.Errorf("service #%d: %w", s.ID, err)
+		}
+	}
+
+	return nil
+}
@@ -75,27 +75,6 @@ func ServiceClientActions(ctx context.Context, serviceId int32) ([]string, error
 	return actions, nil
 }
 
-// CancelOverdueServices terminates overdue services
-func CancelOverdueServices() error {
-	ctx := context.Background()
-
-	services, err := database.Q.FindOverdueServices(ctx)
-	if err != nil {
-		return fmt.Errorf("db: %w", err)
-	}
-
-	for _, service := range services {
-		slog.Info("terminate overdue service", "id", service.ID)
-
-		err := extension.DoActionAsync(ctx, service.Extension, service.ID, "terminate", ServiceCancelled)
-		if err != nil {
-			return fmt.Errorf("db: %w", err)
-		}
-	}
-
-	return nil
-}
-
 func IsServiceOwner(ctx context.Context, userId int32, serviceId int32) (bool, error) {
 	s, err := database.Q.FindServiceById(ctx, serviceId)
 	if err != nil {
@@ -2,6 +2,7 @@ package service
 
 import (
 	"billing3/database"
+	"billing3/service/notification"
 	"context"
 	"errors"
 	"fmt"
@@ -14,15 +15,52 @@ var (
 	SettingTurnstileSiteKey = newSetting("cf_turnstile_site_key", "", true)
 	SettingTurnstileSecret  = newSetting("cf_turnstile_secret", "", false)
 	SettingIndexMarkdown    = newSetting("index_markdown", "# Welcome to billing3", true)
+	SettingTaxMode          = newSetting("tax_mode", TaxExclusive, true)
+	SettingBaseCurrency     = newSetting("base_currency", "USD", true)
+	SettingCompanyName      = newSetting("company_name", "", true)
+	SettingCompanyAddress   = newSetting("company_address", "", true)
+	SettingCompanyVatNumber = newSetting("company_vat_number", "", true)
+	SettingCompanyEmail     = newSetting("company_email", "", true)
+	SettingInvoiceTemplate  = newSetting("invoice_template", DefaultInvoiceTemplate, false)
+
+	SettingInvoiceNumberPrefix      = newSetting("invoice_number_prefix", "INV-", false)
+	SettingInvoiceNumberYearlyReset = newSetting("invoice_number_yearly_reset", "true", false)
+	SettingInvoiceNumberPadding     = newSetting("invoice_number_padding", "6", false)
+	SettingInvoiceNumberAssign      = newSetting("invoice_number_assign", InvoiceNumberOnPaid, false)
+
+	SettingDunningReminderDays  = newSetting("dunning_reminder_days", "-3,1", false)
+	SettingDunningSuspendDays   = newSetting("dunning_suspend_days", "3", false)
+	SettingDunningTerminateDays = newSetting("dunning_terminate_days", "10", false)
+
+	SettingRequireAdminTwoFactor = newSetting("require_admin_2fa", "false", false)
 
 	Settings = []Setting{
 		SettingSiteName,
 		SettingTurnstileSiteKey,
 		SettingTurnstileSecret,
 		SettingIndexMarkdown,
+		SettingTaxMode,
+		SettingBaseCurrency,
+		SettingCompanyName,
+		SettingCompanyAddress,
+		SettingCompanyVatNumber,
+		SettingCompanyEmail,
+		SettingInvoiceTemplate,
+		SettingInvoiceNumberPrefix,
+		SettingInvoiceNumberYearlyReset,
+		SettingInvoiceNumberPadding,
+		SettingInvoiceNumberAssign,
+		SettingDunningReminderDays,
+		SettingDunningSuspendDays,
+		SettingDunningTerminateDays,
+		SettingRequireAdminTwoFactor,
 	}
 )
 
+func init() {
+	notification.SiteName = SettingSiteName.Get
+}
+
 func newSetting(key, defaultValue string, public bool) Setting {
 	return Setting{
 		key:          key,
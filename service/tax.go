@@ -0,0 +1,185 @@
+package service
+
+import (
+	"billing3/database"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	TaxExclusive = "exclusive" // tax is added on top of the prices
+	TaxInclusive = "inclusive" // prices already include tax
+)
+
+// NormalizeVatNumber removes separators from the VAT number and converts it to upper case, e.g. "DE 123.456.789" becomes "DE123456789".
+func NormalizeVatNumber(vat string) string {
+	vat = strings.ToUpper(vat)
+	return strings.NewReplacer(" ", "", ".", "", "-", "").Replace(vat)
+}
+
+// FindTaxRule returns the tax rule for the country and state. A rule for the state takes
+// precedence over a rule for the whole country. nil is returned if there is no rule.
+func FindTaxRule(ctx context.Context, qtx *database.Queries, country string, state string) (*database.TaxRule, error) {
+	rule, err := qtx.FindTaxRule(ctx, database.FindTaxRuleParams{
+		Country: country,
+		State:   state,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("db: %w", err)
+	}
+	return &rule, nil
+}
+
+// RecalculateInvoice locks the invoice and recalculates its tax and totals. It is called
+// after the items of an existing invoice are changed.
+func RecalculateInvoice(ctx context.Context, invoiceId int32) error {
+	tx, err := database.Conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := database.Q.WithTx(tx)
+
+	_, err = qtx.SelectInvoiceForUpdate(ctx, invoiceId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("db: %w", err)
+	}
+
+	err = updateInvoiceTotals(ctx, qtx, invoiceId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// updateInvoiceTotals replaces the tax item of the invoice using the tax rule of the invoice
+// owner's country and state, and updates the net, tax and gross amount of the invoice, see
+// calculateInvoiceTotals.
+//
+// qtx should be a transaction. qtx is not commited.
+func updateInvoiceTotals(ctx context.Context, qtx *database.Queries, invoiceId int32) error {
+	invoice, err := qtx.FindInvoiceById(ctx, invoiceId)
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	user, err := qtx.FindUserById(ctx, invoice.UserID)
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	err = qtx.DeleteInvoiceItemsByType(ctx, database.DeleteInvoiceItemsByTypeParams{
+		InvoiceID: invoiceId,
+		Type:      InvoiceItemTax,
+	})
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	items, err := qtx.ListInvoiceItems(ctx, invoiceId)
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	rule, err := FindTaxRule(ctx, qtx, user.Country.String, user.State.String)
+	if err != nil {
+		return err
+	}
+
+	params, taxItem := calculateInvoiceTotals(invoiceId, items, rule, user.VatNumber, SettingTaxMode.Get(ctx))
+
+	if taxItem != nil {
+		err = qtx.CreateInvoiceItem(ctx, *taxItem)
+		if err != nil {
+			return fmt.Errorf("db: %w", err)
+		}
+	}
+
+	err = qtx.UpdateInvoiceTotals(ctx, params)
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	slog.Debug("update invoice totals", "invoice_id", invoiceId, "net", params.Net, "tax", params.Tax, "gross", params.Amount, "reverse_charge", params.ReverseCharge)
+
+	return nil
+}
+
+// calculateInvoiceTotals returns the net, tax and gross amount of an invoice with the items, which must not include
+// a tax item, and the tax item to add, or nil. rule is the tax rule of the invoice owner, or nil if there is none.
+//
+// Credit top-up items are not taxed. In exclusive mode the tax is added to the items as a tax item.
+// In inclusive mode the tax is the part of the items that is tax, so the gross amount is the sum of
+// the items, and no tax item is added so that the items still sum to the gross amount. No tax is
+// charged if the rule allows reverse charge and the user has a VAT number.
+func calculateInvoiceTotals(invoiceId int32, items []database.InvoiceItem, rule *database.TaxRule, vatNumber string, mode string) (database.UpdateInvoiceTotalsParams, *database.CreateInvoiceItemParams) {
+	subtotal := decimal.Zero
+	taxable := decimal.Zero
+	for _, item := range items {
+		subtotal = subtotal.Add(item.Amount)
+		if item.Type != InvoiceItemCredit {
+			taxable = taxable.Add(item.Amount)
+		}
+	}
+	if taxable.LessThan(decimal.Zero) {
+		taxable = decimal.Zero
+	}
+
+	params := database.UpdateInvoiceTotalsParams{
+		ID:      invoiceId,
+		Amount:  subtotal,
+		Net:     subtotal,
+		Tax:     decimal.Zero,
+		TaxRate: decimal.Zero,
+	}
+
+	if rule != nil && rule.ReverseCharge && vatNumber != "" {
+		params.TaxName = rule.Name
+		params.ReverseCharge = true
+
+		return params, &database.CreateInvoiceItemParams{
+			InvoiceID:   invoiceId,
+			Description: fmt.Sprintf("%s reverse charge (%s)", rule.Name, vatNumber),
+			Amount:      decimal.Zero,
+			Type:        InvoiceItemTax,
+		}
+	}
+
+	if rule == nil || !rule.Rate.GreaterThan(decimal.Zero) || !taxable.GreaterThan(decimal.Zero) {
+		return params, nil
+	}
+
+	params.TaxName = rule.Name
+	params.TaxRate = rule.Rate
+
+	if mode == TaxInclusive {
+		// only saved in the net and tax of the invoice
+		params.Tax = taxable.Mul(rule.Rate).Div(rule.Rate.Add(decimal.NewFromInt(100))).Round(2)
+		params.Net = subtotal.Sub(params.Tax)
+		return params, nil
+	}
+
+	params.Tax = taxable.Mul(rule.Rate).Div(decimal.NewFromInt(100)).Round(2)
+	params.Amount = subtotal.Add(params.Tax)
+
+	return params, &database.CreateInvoiceItemParams{
+		InvoiceID:   invoiceId,
+		Description: fmt.Sprintf("%s %s%%", rule.Name, rule.Rate.String()),
+		Amount:      params.Tax,
+		Type:        InvoiceItemTax,
+	}
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"billing3/database"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestCalculateInvoiceTotals(t *testing.T) {
+	vat := &database.TaxRule{Name: "VAT", Rate: decimal.NewFromInt(19), ReverseCharge: true}
+	reduced := &database.TaxRule{Name: "VAT", Rate: decimal.NewFromInt(7)}
+	zero := &database.TaxRule{Name: "No tax", Rate: decimal.Zero}
+
+	item := func(typ string, amount string) database.InvoiceItem {
+		return database.InvoiceItem{Type: typ, Amount: decimal.RequireFromString(amount)}
+	}
+	service := func(amount string) []database.InvoiceItem {
+		return []database.InvoiceItem{item(InvoiceItemService, amount)}
+	}
+
+	tests := []struct {
+		name      string
+		items     []database.InvoiceItem
+		rule      *database.TaxRule
+		vatNumber string
+		mode      string
+		net       string
+		tax       string
+		gross     string
+		taxItem   string // description of the tax item, empty if none is added
+		reverse   bool
+	}{
+		{name: "no rule", items: service("100"), mode: TaxExclusive, net: "100", tax: "0", gross: "100"},
+		{name: "zero rate", items: service("100"), rule: zero, mode: TaxExclusive, net: "100", tax: "0", gross: "100"},
+		{name: "exclusive", items: service("100"), rule: vat, mode: TaxExclusive, net: "100", tax: "19", gross: "119", taxItem: "VAT 19%"},
+		{name: "exclusive rounded", items: service("9.99"), rule: vat, mode: TaxExclusive, net: "9.99", tax: "1.9", gross: "11.89", taxItem: "VAT 19%"},
+		{name: "inclusive", items: service("119"), rule: vat, mode: TaxInclusive, net: "100", tax: "19", gross: "119"},
+		// 10 * 19 / 119 = 1.5966...
+		{name: "inclusive rounded up", items: service("10"), rule: vat, mode: TaxInclusive, net: "8.4", tax: "1.6", gross: "10"},
+		// 9.99 * 7 / 107 = 0.6535...
+		{name: "inclusive rounded down", items: service("9.99"), rule: reduced, mode: TaxInclusive, net: "9.34", tax: "0.65", gross: "9.99"},
+		{name: "reverse charge", items: service("100"), rule: vat, vatNumber: "DE123456789", mode: TaxExclusive, net: "100", tax: "0", gross: "100", taxItem: "VAT reverse charge (DE123456789)", reverse: true},
+		{name: "reverse charge inclusive", items: service("100"), rule: vat, vatNumber: "DE123456789", mode: TaxInclusive, net: "100", tax: "0", gross: "100", taxItem: "VAT reverse charge (DE123456789)", reverse: true},
+		{name: "reverse charge without vat number", items: service("100"), rule: vat, mode: TaxExclusive, net: "100", tax: "19", gross: "119", taxItem: "VAT 19%"},
+		{name: "reverse charge not allowed", items: service("100"), rule: reduced, vatNumber: "DE123456789", mode: TaxExclusive, net: "100", tax: "7", gross: "107", taxItem: "VAT 7%"},
+		{
+			name:  "credit item excluded",
+			items: []database.InvoiceItem{item(InvoiceItemService, "100"), item(InvoiceItemCredit, "50")},
+			rule:  vat, mode: TaxExclusive, net: "150", tax: "19", gross: "169", taxItem: "VAT 19%",
+		},
+		{
+			name:  "credit item excluded inclusive",
+			items: []database.InvoiceItem{item(InvoiceItemService, "119"), item(InvoiceItemCredit, "50")},
+			rule:  vat, mode: TaxInclusive, net: "150", tax: "19", gross: "169",
+		},
+		{name: "credit top-up only", items: []database.InvoiceItem{item(InvoiceItemCredit, "50")}, rule: vat, mode: TaxExclusive, net: "50", tax: "0", gross: "50"},
+		{
+			name:  "discount",
+			items: []database.InvoiceItem{item(InvoiceItemService, "100"), item(InvoiceItemDiscount, "-20")},
+			rule:  vat, mode: TaxExclusive, net: "80", tax: "15.2", gross: "95.2", taxItem: "VAT 19%",
+		},
+		{
+			name:  "discount over the price",
+			items: []database.InvoiceItem{item(InvoiceItemService, "10"), item(InvoiceItemDiscount, "-20")},
+			rule:  vat, mode: TaxExclusive, net: "-10", tax: "0", gross: "-10",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, taxItem := calculateInvoiceTotals(12, tt.items, tt.rule, tt.vatNumber, tt.mode)
+
+			if params.ID != 12 {
+				t.Errorf("id = %d", params.ID)
+			}
+			if !params.Net.Equal(decimal.RequireFromString(tt.net)) || !params.Tax.Equal(decimal.RequireFromString(tt.tax)) ||
+				!params.Amount.Equal(decimal.RequireFromString(tt.gross)) {
+				t.Errorf("net, tax, gross = %s, %s, %s, want %s, %s, %s", params.Net, params.Tax, params.Amount, tt.net, tt.tax, tt.gross)
+			}
+			if !params.Net.Add(params.Tax).Equal(params.Amount) {
+				t.Errorf("net %s + tax %s != gross %s", params.Net, params.Tax, params.Amount)
+			}
+			if params.ReverseCharge != tt.reverse {
+				t.Errorf("reverse charge = %v, want %v", params.ReverseCharge, tt.reverse)
+			}
+
+			if tt.taxItem == "" {
+				if taxItem != nil {
+					t.Errorf("tax item %+v, want none", taxItem)
+				}
+				return
+			}
+			if taxItem == nil {
+				t.Fatalf("no tax item, want %q", tt.taxItem)
+			}
+			if taxItem.Description != tt.taxItem || taxItem.Type != InvoiceItemTax || taxItem.InvoiceID != 12 {
+				t.Errorf("tax item = %+v, want %q", taxItem, tt.taxItem)
+			}
+			// the items sum to the gross amount
+			if !taxItem.Amount.Equal(params.Tax) {
+				t.Errorf("tax item amount = %s, want %s", taxItem.Amount, params.Tax)
+			}
+		})
+	}
+}
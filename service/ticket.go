@@ -0,0 +1,335 @@
+package service
+
+import (
+	"billing3/database"
+	"billing3/service/notification"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	TicketOpen          = "OPEN"
+	TicketAnswered      = "ANSWERED"       // the last reply is from staff
+	TicketCustomerReply = "CUSTOMER_REPLY" // the last reply is from the client
+	TicketClosed        = "CLOSED"
+)
+
+const (
+	TicketPriorityLow    = "LOW"
+	TicketPriorityMedium = "MEDIUM"
+	TicketPriorityHigh   = "HIGH"
+)
+
+const (
+	TicketMaxAttachments    = 5
+	TicketMaxAttachmentSize = 5 << 20
+)
+
+var ErrTicketDepartmentNotFound = errors.New("department not found")
+var ErrTicketLinkNotFound = errors.New("the linked service or invoice is not found")
+var ErrTicketAssignee = errors.New("tickets can only be assigned to staff")
+
+// TicketAttachment is a file uploaded with a ticket message. Data is base64 encoded in json.
+type TicketAttachment struct {
+	Filename    string `json:"filename" validate:"required,max=255"`
+	ContentType string `json:"content_type" validate:"max=255"`
+	Data        []byte `json:"data" validate:"required"`
+}
+
+// CreateTicket opens a ticket for the user in arg with the first message. The linked service and invoice must
+// belong to the user. Errors other than ErrInternalError are meant to be shown to the user.
+func CreateTicket(ctx context.Context, arg database.CreateTicketParams, body string, attachments []TicketAttachment) (int32, error) {
+	err := validateTicketAttachments(attachments)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := database.Conn.Begin(ctx)
+	if err != nil {
+		slog.Error("create ticket", "err", err)
+		return 0, ErrInternalError
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := database.Q.WithTx(tx)
+
+	err = checkTicketDepartment(ctx, qtx, arg.DepartmentID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = checkTicketLinks(ctx, qtx, arg.UserID, arg.ServiceID, arg.InvoiceID)
+	if err != nil {
+		return 0, err
+	}
+
+	arg.Subject = strings.TrimSpace(arg.Subject)
+	arg.Status = TicketOpen
+	ticketId, err := qtx.CreateTicket(ctx, arg)
+	if err != nil {
+		slog.Error("create ticket", "err", err)
+		return 0, ErrInternalError
+	}
+
+	_, err = addTicketMessage(ctx, qtx, ticketId, arg.UserID, false, body, attachments)
+	if err != nil {
+		slog.Error("create ticket", "err", err, "ticket_id", ticketId)
+		return 0, ErrInternalError
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		slog.Error("create ticket", "err", err, "ticket_id", ticketId)
+		return 0, ErrInternalError
+	}
+
+	slog.Info("ticket created", "ticket_id", ticketId, "user_id", arg.UserID)
+
+	return ticketId, nil
+}
+
+// ReplyTicket adds a message to a ticket and notifies the other side by email. A reply reopens the ticket if it
+// is closed. Errors other than ErrInternalError and ErrNotFound are meant to be shown to the user.
+func ReplyTicket(ctx context.Context, ticketId int32, author *database.User, staff bool, body string, attachments []TicketAttachment) (int32, error) {
+	err := validateTicketAttachments(attachments)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := database.Conn.Begin(ctx)
+	if err != nil {
+		slog.Error("reply ticket", "err", err, "ticket_id", ticketId)
+		return 0, ErrInternalError
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := database.Q.WithTx(tx)
+
+	ticket, err := qtx.FindTicketByIdForUpdate(ctx, ticketId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrNotFound
+		}
+		slog.Error("reply ticket", "err", err, "ticket_id", ticketId)
+		return 0, ErrInternalError
+	}
+
+	messageId, err := addTicketMessage(ctx, qtx, ticketId, author.ID, staff, body, attachments)
+	if err != nil {
+		slog.Error("reply ticket", "err", err, "ticket_id", ticketId)
+		return 0, ErrInternalError
+	}
+
+	ticket.Status = TicketCustomerReply
+	if staff {
+		ticket.Status = TicketAnswered
+	}
+	err = qtx.UpdateTicketStatus(ctx, database.UpdateTicketStatusParams{
+		ID:     ticketId,
+		Status: ticket.Status,
+	})
+	if err != nil {
+		slog.Error("reply ticket", "err", err, "ticket_id", ticketId)
+		return 0, ErrInternalError
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		slog.Error("reply ticket", "err", err, "ticket_id", ticketId)
+		return 0, ErrInternalError
+	}
+
+	slog.Info("ticket reply", "ticket_id", ticketId, "message_id", messageId, "user_id", author.ID, "staff", staff)
+
+	notifyTicketReply(ctx, &ticket, author, staff, body)
+
+	return messageId, nil
+}
+
+// CloseTicket closes a ticket. Closing a closed ticket does nothing.
+func CloseTicket(ctx context.Context, ticketId int32) error {
+	ticket, err := database.Q.FindTicketById(ctx, ticketId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		return fmt.Errorf("db: %w", err)
+	}
+
+	if ticket.Status == TicketClosed {
+		return nil
+	}
+
+	err = database.Q.UpdateTicketStatus(ctx, database.UpdateTicketStatusParams{
+		ID:     ticketId,
+		Status: TicketClosed,
+	})
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	slog.Info("ticket closed", "ticket_id", ticketId)
+
+	return nil
+}
+
+// UpdateTicket changes the department, priority, assignee and links of a ticket. The assignee must be staff,
+// and the links must belong to the owner of the ticket. Errors other than ErrInternalError and ErrNotFound are
+// meant to be shown to the user.
+func UpdateTicket(ctx context.Context, arg database.UpdateTicketParams) error {
+	ticket, err := database.Q.FindTicketById(ctx, arg.ID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		slog.Error("update ticket", "err", err, "ticket_id", arg.ID)
+		return ErrInternalError
+	}
+
+	err = checkTicketDepartment(ctx, database.Q, arg.DepartmentID)
+	if err != nil {
+		return err
+	}
+
+	err = checkTicketLinks(ctx, database.Q, ticket.UserID, arg.ServiceID, arg.InvoiceID)
+	if err != nil {
+		return err
+	}
+
+	if arg.AssignedTo.Valid {
+		staff, err := database.Q.FindUserById(ctx, arg.AssignedTo.Int32)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			slog.Error("update ticket", "err", err, "ticket_id", arg.ID)
+			return ErrInternalError
+		}
+		if err != nil || staff.Role != "admin" {
+			return ErrTicketAssignee
+		}
+	}
+
+	err = database.Q.UpdateTicket(ctx, arg)
+	if err != nil {
+		slog.Error("update ticket", "err", err, "ticket_id", arg.ID)
+		return ErrInternalError
+	}
+
+	return nil
+}
+
+// validateTicketAttachments checks the number and size of attachments, and detects the content type of
+// attachments without one.
+func validateTicketAttachments(attachments []TicketAttachment) error {
+	if len(attachments) > TicketMaxAttachments {
+		return fmt.Errorf("at most %d attachments are allowed", TicketMaxAttachments)
+	}
+	for i := range attachments {
+		if len(attachments[i].Data) > TicketMaxAttachmentSize {
+			return fmt.Errorf("attachment %s is larger than %d MB", attachments[i].Filename, TicketMaxAttachmentSize>>20)
+		}
+		if attachments[i].ContentType == "" {
+			attachments[i].ContentType = http.DetectContentType(attachments[i].Data)
+		}
+	}
+	return nil
+}
+
+func checkTicketDepartment(ctx context.Context, qtx *database.Queries, departmentId int32) error {
+	_, err := qtx.FindTicketDepartmentById(ctx, departmentId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrTicketDepartmentNotFound
+		}
+		slog.Error("check ticket department", "err", err, "department_id", departmentId)
+		return ErrInternalError
+	}
+	return nil
+}
+
+// checkTicketLinks returns ErrTicketLinkNotFound if the service or invoice does not belong to the user.
+func checkTicketLinks(ctx context.Context, qtx *database.Queries, userId int32, serviceId pgtype.Int4, invoiceId pgtype.Int4) error {
+	if serviceId.Valid {
+		s, err := qtx.FindServiceById(ctx, serviceId.Int32)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			slog.Error("check ticket links", "err", err, "service_id", serviceId.Int32)
+			return ErrInternalError
+		}
+		if err != nil || s.UserID != userId {
+			return ErrTicketLinkNotFound
+		}
+	}
+
+	if invoiceId.Valid {
+		invoice, err := qtx.FindInvoiceById(ctx, invoiceId.Int32)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			slog.Error("check ticket links", "err", err, "invoice_id", invoiceId.Int32)
+			return ErrInternalError
+		}
+		if err != nil || invoice.UserID != userId {
+			return ErrTicketLinkNotFound
+		}
+	}
+
+	return nil
+}
+
+// addTicketMessage adds a message with attachments to a ticket. tx is not commited.
+func addTicketMessage(ctx context.Context, qtx *database.Queries, ticketId int32, userId int32, staff bool, body string, attachments []TicketAttachment) (int32, error) {
+	messageId, err := qtx.CreateTicketMessage(ctx, database.CreateTicketMessageParams{
+		TicketID: ticketId,
+		UserID:   userId,
+		Staff:    staff,
+		Body:     body,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("db: %w", err)
+	}
+
+	for _, a := range attachments {
+		_, err = qtx.CreateTicketAttachment(ctx, database.CreateTicketAttachmentParams{
+			MessageID:   messageId,
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			Size:        int32(len(a.Data)),
+			Data:        a.Data,
+		})
+		if err != nil {
+			return 0, fmt.Errorf("db: %w", err)
+		}
+	}
+
+	return messageId, nil
+}
+
+// notifyTicketReply emails the client about a staff reply, or the assigned staff about a client reply. Client
+// replies to unassigned tickets are not emailed.
+func notifyTicketReply(ctx context.Context, ticket *database.Ticket, author *database.User, staff bool, body string) {
+	if !staff && !ticket.AssignedTo.Valid {
+		return
+	}
+
+	department, err := database.Q.FindTicketDepartmentById(ctx, ticket.DepartmentID)
+	if err != nil {
+		slog.Error("notify ticket reply", "err", err, "ticket_id", ticket.ID)
+		return
+	}
+
+	event, to := notification.EventTicketReply, ticket.UserID
+	if !staff {
+		event, to = notification.EventTicketCustomerReply, ticket.AssignedTo.Int32
+	}
+
+	err = notification.SendToUser(ctx, event, to, map[string]any{
+		"Ticket":  notification.TicketData(ticket, department.Name, !staff),
+		"Message": map[string]any{"Author": author.Name, "Body": body},
+	})
+	if err != nil {
+		slog.Error("notify ticket reply", "err", err, "ticket_id", ticket.ID)
+	}
+}
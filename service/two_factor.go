@@ -0,0 +1,325 @@
+package service
+
+import (
+	"billing3/database"
+	"billing3/utils"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+const recoveryCodeCount = 10
+
+const (
+	TwoFactorTOTP     = "totp"
+	TwoFactorWebauthn = "webauthn"
+)
+
+var ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+var ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+var ErrTwoFactorInvalidCode = errors.New("invalid code")
+
+// TwoFactorEnabled returns whether the user has confirmed a TOTP secret.
+func TwoFactorEnabled(ctx context.Context, userId int32) (bool, error) {
+	t, err := database.Q.FindUserTotp(ctx, userId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, fmt.Errorf("db: %w", err)
+	}
+	return t.Enabled, nil
+}
+
+// TwoFactorMethods returns the second factors the user can log in with, TwoFactorTOTP and TwoFactorWebauthn.
+func TwoFactorMethods(ctx context.Context, userId int32) ([]string, error) {
+	methods := []string{}
+
+	totp, err := TwoFactorEnabled(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if totp {
+		methods = append(methods, TwoFactorTOTP)
+	}
+
+	passkeys, err := database.Q.CountWebauthnCredentials(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+	if passkeys > 0 {
+		methods = append(methods, TwoFactorWebauthn)
+	}
+
+	return methods, nil
+}
+
+// TwoFactorRequired returns whether the user must enable two-factor authentication before using the admin area.
+// A passkey counts as a second factor.
+func TwoFactorRequired(ctx context.Context, user *database.User) (bool, error) {
+	if user.Role != "admin" || SettingRequireAdminTwoFactor.Get(ctx) != "true" {
+		return false, nil
+	}
+
+	methods, err := TwoFactorMethods(ctx, user.ID)
+	if err != nil {
+		return false, err
+	}
+	return len(methods) == 0, nil
+}
+
+// EnrollTOTP generates a new TOTP secret for the user, and returns the secret and its otpauth:// url. The secret
+// is not used until it is confirmed with ConfirmTOTP.
+func EnrollTOTP(ctx context.Context, user *database.User) (string, string, error) {
+	enabled, err := TwoFactorEnabled(ctx, user.ID)
+	if err != nil {
+		return "", "", err
+	}
+	if enabled {
+		return "", "", ErrTwoFactorEnabled
+	}
+
+	secret := utils.TOTPGenerateSecret()
+
+	err = database.Q.UpsertUserTotp(ctx, database.UpsertUserTotpParams{
+		UserID: user.ID,
+		Secret: secret,
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("db: %w", err)
+	}
+
+	return secret, utils.TOTPURL(SettingSiteName.Get(ctx), user.Email, secret), nil
+}
+
+// ConfirmTOTP enables two-factor authentication if the code matches the secret from EnrollTOTP, and returns
+// new recovery codes.
+func ConfirmTOTP(ctx context.Context, userId int32, code string) ([]string, error) {
+	tx, err := database.Conn.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := database.Q.WithTx(tx)
+
+	t, err := qtx.FindUserTotpForUpdate(ctx, userId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrTwoFactorNotEnabled
+		}
+		return nil, fmt.Errorf("db: %w", err)
+	}
+
+	if t.Enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	step, ok := utils.TOTPVerify(t.Secret, code, time.Now())
+	if !ok {
+		return nil, ErrTwoFactorInvalidCode
+	}
+
+	err = qtx.EnableUserTotp(ctx, database.EnableUserTotpParams{
+		UserID:   userId,
+		LastStep: step,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+
+	codes, err := createRecoveryCodes(ctx, qtx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+
+	slog.Info("two-factor authentication enabled", "user_id", userId)
+
+	return codes, nil
+}
+
+// VerifyTwoFactor checks a TOTP code or a recovery code of the user. Each TOTP code and recovery code is
+// accepted only once. ErrTwoFactorInvalidCode is returned if the code is wrong.
+func VerifyTwoFactor(ctx context.Context, userId int32, code string) error {
+	tx, err := database.Conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := database.Q.WithTx(tx)
+
+	t, err := qtx.FindUserTotpForUpdate(ctx, userId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrTwoFactorNotEnabled
+		}
+		return fmt.Errorf("db: %w", err)
+	}
+
+	if !t.Enabled {
+		return ErrTwoFactorNotEnabled
+	}
+
+	if step, ok := utils.TOTPVerify(t.Secret, code, time.Now()); ok {
+		if step <= t.LastStep {
+			return ErrTwoFactorInvalidCode
+		}
+		err = qtx.UpdateUserTotpLastStep(ctx, database.UpdateUserTotpLastStepParams{
+			UserID:   userId,
+			LastStep: step,
+		})
+		if err != nil {
+			return fmt.Errorf("db: %w", err)
+		}
+	} else {
+		n, err := qtx.UseUserRecoveryCode(ctx, database.UseUserRecoveryCodeParams{
+			UserID:   userId,
+			CodeHash: hashRecoveryCode(code),
+		})
+		if err != nil {
+			return fmt.Errorf("db: %w", err)
+		}
+		if n == 0 {
+			return ErrTwoFactorInvalidCode
+		}
+		slog.Info("recovery code used", "user_id", userId)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	return nil
+}
+
+// DisableTwoFactor removes the TOTP secret and the recovery codes of the user.
+func DisableTwoFactor(ctx context.Context, userId int32) error {
+	tx, err := database.Conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := database.Q.WithTx(tx)
+
+	err = qtx.DeleteUserTotp(ctx, userId)
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	err = qtx.DeleteUserRecoveryCodes(ctx, userId)
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+
+	slog.Info("two-factor authentication disabled", "user_id", userId)
+
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user with new ones.
+func RegenerateRecoveryCodes(ctx context.Context, userId int32) ([]string, error) {
+	tx, err := database.Conn.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := database.Q.WithTx(tx)
+
+	err = qtx.DeleteUserRecoveryCodes(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+
+	codes, err := createRecoveryCodes(ctx, qtx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+
+	return codes, nil
+}
+
+// createRecoveryCodes saves the hashes of new recovery codes and returns the codes. tx is not commited.
+func createRecoveryCodes(ctx context.Context, qtx *database.Queries, userId int32) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		token := utils.RandomToken(5)
+		codes[i] = token[:5] + "-" + token[5:]
+
+		err := qtx.CreateUserRecoveryCode(ctx, database.CreateUserRecoveryCodeParams{
+			UserID:   userId,
+			CodeHash: hashRecoveryCode(codes[i]),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("db: %w", err)
+		}
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode hashes a recovery code, ignoring case, spaces and dashes. Recovery codes are random, so
+// they do not need a slow hash like passwords.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewTwoFactorLoginToken returns a short-lived token that proves the password of the user has been checked,
+// to be exchanged for a session token together with a TOTP code or a recovery code.
+func NewTwoFactorLoginToken(userId int32) string {
+	return utils.JWTSign(jwt.MapClaims{
+		"aud": "login_2fa",
+		"sub": strconv.Itoa(int(userId)),
+	}, 5*time.Minute)
+}
+
+// DecodeTwoFactorLoginToken returns the user id in the token, or 0 if the token is invalid.
+func DecodeTwoFactorLoginToken(token string) int32 {
+	claims, err := utils.JWTVerify(token)
+	if err != nil {
+		slog.Debug("jwt verify error", "err", err)
+		return 0
+	}
+
+	if claims["aud"] != "login_2fa" {
+		return 0
+	}
+
+	sub, ok := claims["sub"].(string)
+	if !ok {
+		return 0
+	}
+	userId, err := strconv.Atoi(sub)
+	if err != nil {
+		return 0
+	}
+	return int32(userId)
+}
@@ -0,0 +1,460 @@
+package service
+
+import (
+	"billing3/database"
+	"billing3/database/types"
+	"billing3/service/extension"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	UpgradePending   = "PENDING"
+	UpgradeApplied   = "APPLIED"
+	UpgradeCancelled = "CANCELLED"
+)
+
+var ErrUpgradePending = errors.New("another upgrade is waiting for payment")
+
+type UpgradeRequest struct {
+	ProductID int               `json:"product_id" validate:"required"`
+	Options   map[string]string `json:"options"` // options not included are kept unchanged
+}
+
+type UpgradeQuote struct {
+	ProductID        int32           `json:"product_id"`
+	Pricing          *Pricing        `json:"pricing"` // pricing of the new plan, setup fees are not charged
+	OldPrice         decimal.Decimal `json:"old_price"`
+	NewPrice         decimal.Decimal `json:"new_price"`
+	RemainingSeconds int64           `json:"remaining_seconds"`
+	Amount           decimal.Decimal `json:"amount"` // prorated amount, negative for a downgrade
+
+	// settings are merged into the service settings when the upgrade is applied
+	settings types.ServiceSettings
+	redacted map[string]string
+}
+
+// CalculateUpgrade calculates the prorated price of changing the service to another product or
+// configurable options. The new product must be in the same category and use the same extension.
+// The billing cycle is unchanged.
+//
+// The prorated amount is the difference of the recurring fees for the time remaining until the
+// service expires, which includes the periods of renewal invoices that are already paid. Errors other
+// than ErrInternalError are meant to be shown to the user.
+func CalculateUpgrade(ctx context.Context, s *database.Service, req UpgradeRequest) (*UpgradeQuote, error) {
+	if s.Status != ServiceActive {
+		return nil, fmt.Errorf("service is not active")
+	}
+
+	if !s.ProductID.Valid || s.BillingCycle <= 0 {
+		return nil, fmt.Errorf("service can not be upgraded")
+	}
+
+	current, err := database.Q.FindProductById(ctx, s.ProductID.Int32)
+	if err != nil {
+		slog.Error("find product", "err", err, "id", s.ProductID.Int32)
+		return nil, ErrInternalError
+	}
+
+	// unchanged options keep the current value
+	options := make(map[string]string)
+	productOptions, err := database.Q.FindProductOptionsByProduct(ctx, int32(req.ProductID))
+	if err != nil {
+		slog.Error("find options", "err", err, "id", req.ProductID)
+		return nil, ErrInternalError
+	}
+	for _, option := range productOptions {
+		if v, ok := req.Options[option.Name]; ok {
+			options[option.Name] = v
+		} else if v, ok := s.Settings[option.Name]; ok {
+			options[option.Name] = v
+		}
+	}
+
+	product, cleanedOptions, redactedOptions, pricing, err := CalculatePricing(ctx, OrderRequest{
+		ProductID: req.ProductID,
+		Duration:  int(s.BillingCycle),
+		Options:   options,
+		Currency:  s.Currency,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if product.CategoryID != current.CategoryID || product.Extension != s.Extension {
+		return nil, fmt.Errorf("service can not be changed to this product")
+	}
+
+	remaining := s.ExpiresAt.Time.Sub(time.Now())
+	if remaining <= 0 {
+		return nil, fmt.Errorf("service has expired")
+	}
+
+	fraction := decimal.NewFromInt(int64(remaining / time.Second)).Div(decimal.NewFromInt(int64(s.BillingCycle)))
+
+	// options overwrite product settings
+	settings := make(types.ServiceSettings)
+	for k, v := range product.Settings {
+		settings[k] = v
+	}
+	for k, v := range cleanedOptions {
+		settings[k] = v
+	}
+
+	return &UpgradeQuote{
+		ProductID:        product.ID,
+		Pricing:          pricing,
+		OldPrice:         s.Price,
+		NewPrice:         pricing.RecurringFee,
+		RemainingSeconds: int64(remaining / time.Second),
+		Amount:           pricing.RecurringFee.Sub(s.Price).Mul(fraction).Round(2),
+		settings:         settings,
+		redacted:         redactedOptions,
+	}, nil
+}
+
+// UpgradeService changes the plan of the service. If the prorated amount is positive, an invoice is
+// created and the upgrade is applied once it is paid, and the invoice id is returned. Otherwise the
+// upgrade is applied immediately, the prorated amount is added to the user's credit balance, and 0
+// is returned.
+//
+// ErrUnpaidInvoiceExists is returned if the service has an unpaid renewal invoice, ErrUpgradePending
+// if another upgrade is waiting for payment, and ErrCancellationPending if the client has asked to cancel
+// the service. Like CalculateUpgrade, errors other than ErrInternalError
+// and ErrNotFound are meant to be shown to the user.
+func UpgradeService(ctx context.Context, serviceId int32, req UpgradeRequest) (int32, *UpgradeQuote, error) {
+	tx, err := database.Conn.Begin(ctx)
+	if err != nil {
+		slog.Error("upgrade service", "err", err, "service_id", serviceId)
+		return 0, nil, ErrInternalError
+	}
+	defer tx.Rollback(ctx)
+
+	qtx := database.Q.WithTx(tx)
+
+	s, err := qtx.FindServiceByIdForUpdate(ctx, serviceId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil, ErrNotFound
+		}
+		slog.Error("upgrade service", "err", err, "service_id", serviceId)
+		return 0, nil, ErrInternalError
+	}
+
+	// the renewal invoice is calculated using the current price
+	count, err := qtx.CountUnpaidInvoiceForService(ctx, pgtype.Int4{Valid: true, Int32: serviceId})
+	if err != nil {
+		slog.Error("upgrade service", "err", err, "service_id", serviceId)
+		return 0, nil, ErrInternalError
+	}
+	if count > 0 {
+		return 0, nil, ErrUnpaidInvoiceExists
+	}
+
+	count, err = qtx.CountPendingServiceUpgrades(ctx, serviceId)
+	if err != nil {
+		slog.Error("upgrade service", "err", err, "service_id", serviceId)
+		return 0, nil, ErrInternalError
+	}
+	if count > 0 {
+		return 0, nil, ErrUpgradePending
+	}
+
+	_, err = qtx.FindPendingCancellationRequestByService(ctx, serviceId)
+	if err == nil {
+		return 0, nil, ErrCancellationPending
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		slog.Error("upgrade service", "err", err, "service_id", serviceId)
+		return 0, nil, ErrInternalError
+	}
+
+	quote, err := CalculateUpgrade(ctx, &s, req)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	upgradeId, err := qtx.CreateServiceUpgrade(ctx, database.CreateServiceUpgradeParams{
+		ServiceID: serviceId,
+		ProductID: quote.ProductID,
+		Settings:  quote.settings,
+		Price:     quote.NewPrice,
+		Amount:    quote.Amount,
+		Status:    UpgradePending,
+		ExpiresAt: s.ExpiresAt,
+	})
+	if err != nil {
+		slog.Error("upgrade service", "err", err, "service_id", serviceId)
+		return 0, nil, ErrInternalError
+	}
+
+	slog.Info("service upgrade", "service_id", serviceId, "upgrade_id", upgradeId, "product_id", quote.ProductID, "options", quote.redacted, "old_price", quote.OldPrice, "new_price", quote.NewPrice, "amount", quote.Amount)
+
+	var invoiceId int32
+
+	if quote.Amount.GreaterThan(decimal.Zero) {
+		// the prorated amount is only valid until the service expires
+		dueAt := time.Now().UTC().Add(time.Hour * 168)
+		if s.ExpiresAt.Time.Before(dueAt) {
+			dueAt = s.ExpiresAt.Time
+		}
+
+		invoiceId, err = qtx.CreateInvoice(ctx, database.CreateInvoiceParams{
+			UserID:             s.UserID,
+			Status:             InvoiceUnpaid,
+			CancellationReason: pgtype.Text{Valid: false},
+			PaidAt:             types.Timestamp{Timestamp: pgtype.Timestamp{Valid: false}},
+			DueAt:              types.Timestamp{Timestamp: pgtype.Timestamp{Valid: true, Time: dueAt}},
+			Amount:             quote.Amount,
+			Currency:           s.Currency,
+		})
+		if err != nil {
+			slog.Error("upgrade service", "err", err, "service_id", serviceId)
+			return 0, nil, ErrInternalError
+		}
+
+		err = qtx.CreateInvoiceItem(ctx, database.CreateInvoiceItemParams{
+			InvoiceID:   invoiceId,
+			Description: fmt.Sprintf("#%d - %s - Upgrade (until %s)", serviceId, s.Label, s.ExpiresAt.Time.Format("2006-01-02 MST")),
+			Amount:      quote.Amount,
+			Type:        InvoiceItemUpgrade,
+			ItemID:      pgtype.Int4{Valid: true, Int32: upgradeId},
+		})
+		if err != nil {
+			slog.Error("upgrade service", "err", err, "service_id", serviceId)
+			return 0, nil, ErrInternalError
+		}
+
+		err = updateInvoiceTotals(ctx, qtx, invoiceId)
+		if err != nil {
+			slog.Error("upgrade service", "err", err, "service_id", serviceId)
+			return 0, nil, ErrInternalError
+		}
+
+		err = assignInvoiceNumberOnIssue(ctx, qtx, invoiceId)
+		if err != nil {
+			slog.Error("upgrade service", "err", err, "service_id", serviceId)
+			return 0, nil, ErrInternalError
+		}
+
+		err = qtx.UpdateServiceUpgradeInvoice(ctx, database.UpdateServiceUpgradeInvoiceParams{
+			ID:        upgradeId,
+			InvoiceID: pgtype.Int4{Valid: true, Int32: invoiceId},
+		})
+		if err != nil {
+			slog.Error("upgrade service", "err", err, "service_id", serviceId)
+			return 0, nil, ErrInternalError
+		}
+	} else {
+		err = applyUpgrade(ctx, tx, upgradeId)
+		if err != nil {
+			slog.Error("upgrade service", "err", err, "service_id", serviceId)
+			return 0, nil, ErrInternalError
+		}
+
+		if quote.Amount.LessThan(decimal.Zero) {
+			_, err = qtx.CreateCreditTransaction(ctx, database.CreateCreditTransactionParams{
+				UserID:      s.UserID,
+				Amount:      quote.Amount.Neg(),
+				Description: fmt.Sprintf("Downgrade of service #%d", serviceId),
+				Type:        CreditDowngrade,
+				InvoiceID:   pgtype.Int4{Valid: false},
+				Currency:    s.Currency,
+			})
+			if err != nil {
+				slog.Error("upgrade service", "err", err, "service_id", serviceId)
+				return 0, nil, ErrInternalError
+			}
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		slog.Error("upgrade service", "err", err, "service_id", serviceId)
+		return 0, nil, ErrInternalError
+	}
+
+	if invoiceId != 0 {
+		NotifyInvoiceCreated(ctx, invoiceId)
+	}
+
+	return invoiceId, quote, nil
+}
+
+// applyUpgrade updates the service to the plan of the upgrade, and enqueues the extension's
+// upgrade action to apply the new settings to the running service. An unpaid renewal invoice of the
+// service, created while the upgrade was waiting for payment, is changed to the new price. If such a
+// renewal has already been paid at the old price, the amount of the upgrade does not cover the renewed
+// period, so the upgrade is cancelled and its amount is added to the user's credit balance instead.
+// Upgrades that are not pending are ignored. tx is not commited.
+func applyUpgrade(ctx context.Context, tx pgx.Tx, upgradeId int32) error {
+	qtx := database.Q.WithTx(tx)
+
+	upgrade, err := qtx.FindServiceUpgradeByIdForUpdate(ctx, upgradeId)
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	if upgrade.Status != UpgradePending {
+		return nil
+	}
+
+	// renewal invoices are locked before the service, in the same order as their payments, see addInvoicePayment
+	renewals, err := qtx.ListUnpaidInvoiceIdsForService(ctx, pgtype.Int4{Valid: true, Int32: upgrade.ServiceID})
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+	for _, id := range renewals {
+		_, err = qtx.SelectInvoiceForUpdate(ctx, id)
+		if err != nil {
+			return fmt.Errorf("db: %w", err)
+		}
+	}
+
+	s, err := qtx.FindServiceByIdForUpdate(ctx, upgrade.ServiceID)
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	if s.Status == ServiceCancelled {
+		slog.Info("upgrade of cancelled service", "service_id", s.ID, "upgrade_id", upgradeId)
+		return qtx.UpdateServiceUpgradeStatus(ctx, database.UpdateServiceUpgradeStatusParams{
+			ID:     upgradeId,
+			Status: UpgradeCancelled,
+		})
+	}
+
+	if upgrade.ExpiresAt.Valid && s.ExpiresAt.Time.After(upgrade.ExpiresAt.Time) {
+		slog.Info("upgrade of renewed service", "service_id", s.ID, "upgrade_id", upgradeId, "upgrade_expires_at", upgrade.ExpiresAt.Time, "expires_at", s.ExpiresAt.Time)
+
+		if upgrade.Amount.GreaterThan(decimal.Zero) {
+			_, err = qtx.CreateCreditTransaction(ctx, database.CreateCreditTransactionParams{
+				UserID:      s.UserID,
+				Amount:      upgrade.Amount,
+				Description: fmt.Sprintf("Upgrade of service #%d cancelled, the service was renewed first", s.ID),
+				Type:        CreditRefund,
+				InvoiceID:   upgrade.InvoiceID,
+				Currency:    s.Currency,
+			})
+			if err != nil {
+				return fmt.Errorf("db: %w", err)
+			}
+		}
+
+		return qtx.UpdateServiceUpgradeStatus(ctx, database.UpdateServiceUpgradeStatusParams{
+			ID:     upgradeId,
+			Status: UpgradeCancelled,
+		})
+	}
+
+	// settings that are not part of the plan (e.g. the assigned server) are kept
+	settings := s.Settings
+	for k, v := range upgrade.Settings {
+		settings[k] = v
+	}
+
+	err = qtx.UpdateServicePlan(ctx, database.UpdateServicePlanParams{
+		ID:        s.ID,
+		ProductID: pgtype.Int4{Valid: true, Int32: upgrade.ProductID},
+		Price:     upgrade.Price,
+		Settings:  settings,
+	})
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	err = qtx.UpdateServiceUpgradeStatus(ctx, database.UpdateServiceUpgradeStatusParams{
+		ID:     upgradeId,
+		Status: UpgradeApplied,
+	})
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	slog.Info("apply service upgrade", "service_id", s.ID, "upgrade_id", upgradeId, "product_id", upgrade.ProductID, "price", upgrade.Price)
+
+	for _, id := range renewals {
+		err = repriceRenewalInvoice(ctx, qtx, &s, id, upgrade.Price)
+		if err != nil {
+			return fmt.Errorf("reprice renewal invoice %d: %w", id, err)
+		}
+	}
+
+	if s.Status != ServiceActive && s.Status != ServiceSuspended {
+		return nil
+	}
+
+	err = extension.DoActionAsyncTx(ctx, tx, s.Extension, s.ID, "upgrade", "")
+	if err != nil {
+		if !errors.Is(err, extension.ErrActionRunning) {
+			return fmt.Errorf("do action async: %w", err)
+		}
+		// the action can be run again by an admin
+		slog.Error("upgrade action not enqueued", "err", err, "service_id", s.ID)
+	}
+
+	return nil
+}
+
+// repriceRenewalInvoice changes the service item of an unpaid renewal invoice of s to price, and recalculates the
+// coupon discount and the tax. The invoice must be locked. qtx is not commited.
+func repriceRenewalInvoice(ctx context.Context, qtx *database.Queries, s *database.Service, invoiceId int32, price decimal.Decimal) error {
+	invoice, err := qtx.FindInvoiceById(ctx, invoiceId)
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+	if invoice.Status != InvoiceUnpaid {
+		return nil
+	}
+
+	items, err := qtx.ListInvoiceItems(ctx, invoiceId)
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	for _, item := range items {
+		amount := item.Amount
+		switch {
+		case item.Type == InvoiceItemService && item.ItemID.Valid && item.ItemID.Int32 == s.ID:
+			amount = price
+		case item.Type == InvoiceItemDiscount && s.CouponID.Valid && item.ItemID == s.CouponID:
+			coupon, err := qtx.FindCouponById(ctx, s.CouponID.Int32)
+			if err != nil {
+				return fmt.Errorf("db: %w", err)
+			}
+			err = couponInCurrency(ctx, qtx, &coupon, s.Currency)
+			if err != nil {
+				return fmt.Errorf("coupon in currency: %w", err)
+			}
+			amount = couponDiscount(&coupon, price).Neg()
+		default:
+			continue
+		}
+
+		err = qtx.UpdateInvoiceItem(ctx, database.UpdateInvoiceItemParams{
+			Description: item.Description,
+			Amount:      amount,
+			ID:          item.ID,
+			InvoiceID:   invoiceId,
+		})
+		if err != nil {
+			return fmt.Errorf("db: %w", err)
+		}
+	}
+
+	err = updateInvoiceTotals(ctx, qtx, invoiceId)
+	if err != nil {
+		return fmt.Errorf("update invoice totals: %w", err)
+	}
+
+	slog.Info("reprice renewal invoice", "invoice_id", invoiceId, "service_id", s.ID, "old_amount", invoice.Amount, "price", price)
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"billing3/database"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/shopspring/decimal"
+)
+
+// newTestUpgradableService creates a service of a product priced 10 expiring in 15 days, and a product priced 20 in
+// the same category. It returns the user, service and new product ids.
+func newTestUpgradableService(t *testing.T) (int32, int32, int32) {
+	t.Helper()
+
+	userId := createTestUser(t)
+	productId, categoryId := createTestProduct(t, 0, decimal.NewFromInt(10))
+	newProductId, _ := createTestProduct(t, categoryId, decimal.NewFromInt(20))
+
+	serviceId := createTestService(t, userId, time.Now().Add(15*24*time.Hour))
+	s, err := database.Q.FindServiceById(context.Background(), serviceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = database.Q.UpdateServicePlan(context.Background(), database.UpdateServicePlanParams{
+		ID:        serviceId,
+		ProductID: pgtype.Int4{Valid: true, Int32: productId},
+		Price:     s.Price,
+		Settings:  s.Settings,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return userId, serviceId, newProductId
+}
+
+func TestCalculateUpgradeIncludesPaidRenewal(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+
+	userId, serviceId, newProductId := newTestUpgradableService(t)
+
+	// the next period is already paid, so 45 days remain
+	payTestInvoice(t, createTestInvoice(t, userId, serviceId, decimal.NewFromInt(10)))
+
+	s, err := database.Q.FindServiceById(ctx, serviceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quote, err := CalculateUpgrade(ctx, &s, UpgradeRequest{ProductID: int(newProductId)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// (20 - 10) * 45 / 30
+	if !quote.Amount.Equal(decimal.NewFromInt(15)) {
+		t.Errorf("amount = %s, want 15", quote.Amount)
+	}
+}
+
+func TestUpgradePaidAfterRenewal(t *testing.T) {
+	requireDB(t)
+	ctx := context.Background()
+
+	userId, serviceId, newProductId := newTestUpgradableService(t)
+
+	upgradeInvoiceId, quote, err := UpgradeService(ctx, serviceId, UpgradeRequest{ProductID: int(newProductId)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upgradeInvoiceId == 0 {
+		t.Fatal("no upgrade invoice")
+	}
+
+	// the renewal at the old price is paid before the upgrade
+	payTestInvoice(t, createTestInvoice(t, userId, serviceId, decimal.NewFromInt(10)))
+	payTestInvoice(t, upgradeInvoiceId)
+
+	s, err := database.Q.FindServiceById(ctx, serviceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Price.Equal(decimal.NewFromInt(10)) {
+		t.Errorf("service price = %s, want the old price 10", s.Price)
+	}
+
+	upgrades, err := database.Q.ListServiceUpgrades(ctx, serviceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(upgrades) != 1 || upgrades[0].Status != UpgradeCancelled {
+		t.Errorf("upgrades = %+v, want one %s upgrade", upgrades, UpgradeCancelled)
+	}
+
+	balance, err := CreditBalance(ctx, userId, "USD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !balance.Equal(quote.Amount) {
+		t.Errorf("credit balance = %s, want the upgrade amount %s", balance, quote.Amount)
+	}
+}
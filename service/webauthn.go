@@ -0,0 +1,403 @@
+package service
+
+import (
+	"billing3/database"
+	"billing3/database/types"
+	"billing3/service/notification"
+	"billing3/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// types of webauthn sessions, a session can only finish the ceremony it was started for
+const (
+	webauthnRegister  = "register"
+	webauthnLogin     = "login"
+	webauthnTwoFactor = "2fa"
+)
+
+const webauthnSessionTimeout = 5 * time.Minute
+
+var ErrWebauthnSession = errors.New("passkey session is invalid or expired")
+var ErrWebauthnFailed = errors.New("passkey verification failed")
+
+// webauthnUser implements webauthn.User.
+type webauthnUser struct {
+	user        *database.User
+	credentials []database.WebauthnCredential
+}
+
+func (u *webauthnUser) WebAuthnID() []byte {
+	return webauthnUserHandle(u.user.ID)
+}
+
+func (u *webauthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *webauthnUser) WebAuthnDisplayName() string {
+	return u.user.Name
+}
+
+func (u *webauthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.credentials))
+	for _, c := range u.credentials {
+		var credential webauthn.Credential
+		err := json.Unmarshal(c.Credential, &credential)
+		if err != nil {
+			slog.Error("decode webauthn credential", "err", err, "id", c.ID)
+			continue
+		}
+		credentials = append(credentials, credential)
+	}
+	return credentials
+}
+
+// findCredential returns the stored credential with the webauthn credential id.
+func (u *webauthnUser) findCredential(credentialId []byte) *database.WebauthnCredential {
+	for i := range u.credentials {
+		if string(u.credentials[i].CredentialID) == string(credentialId) {
+			return &u.credentials[i]
+		}
+	}
+	return nil
+}
+
+// webauthnUserHandle is the user handle of a user, which authenticators return in passwordless login.
+func webauthnUserHandle(userId int32) []byte {
+	return []byte(strconv.Itoa(int(userId)))
+}
+
+func loadWebauthnUser(ctx context.Context, userId int32) (*webauthnUser, error) {
+	user, err := database.Q.FindUserById(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+
+	credentials, err := database.Q.ListWebauthnCredentialsByUser(ctx, userId)
+	if err != nil {
+		return nil, fmt.Errorf("db: %w", err)
+	}
+
+	return &webauthnUser{user: &user, credentials: credentials}, nil
+}
+
+// newWebauthn returns the relying party of PUBLIC_DOMAIN, where the frontend is served.
+func newWebauthn(ctx context.Context) (*webauthn.WebAuthn, error) {
+	origin := strings.TrimSuffix(notification.PublicDomain(), "/")
+	u, err := url.Parse(origin)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("webauthn: invalid PUBLIC_DOMAIN: %s", origin)
+	}
+
+	return webauthn.New(&webauthn.Config{
+		RPID:          u.Hostname(),
+		RPDisplayName: SettingSiteName.Get(ctx),
+		RPOrigins:     []string{origin},
+	})
+}
+
+// saveWebauthnSession stores the session data of a ceremony and returns the token to finish it.
+func saveWebauthnSession(ctx context.Context, sessionType string, userId pgtype.Int4, session *webauthn.SessionData) (string, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", fmt.Errorf("encode webauthn session: %w", err)
+	}
+
+	token := utils.RandomToken(32)
+	err = database.Q.CreateWebauthnSession(ctx, database.CreateWebauthnSessionParams{
+		Token:  token,
+		Type:   sessionType,
+		UserID: userId,
+		Data:   data,
+		ExpiresAt: types.Timestamp{Timestamp: pgtype.Timestamp{
+			Valid: true,
+			Time:  time.Now().Add(webauthnSessionTimeout),
+		}},
+	})
+	if err != nil {
+		return "", fmt.Errorf("db: %w", err)
+	}
+
+	return token, nil
+}
+
+// takeWebauthnSession deletes the session and returns its data. ErrWebauthnSession is returned if the session
+// does not exist, has expired, or is not of the type.
+func takeWebauthnSession(ctx context.Context, sessionType string, token string) (*webauthn.SessionData, pgtype.Int4, error) {
+	s, err := database.Q.DeleteWebauthnSession(ctx, database.DeleteWebauthnSessionParams{
+		Token: token,
+		Type:  sessionType,
+	})
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, pgtype.Int4{}, ErrWebauthnSession
+		}
+		return nil, pgtype.Int4{}, fmt.Errorf("db: %w", err)
+	}
+
+	var session webauthn.SessionData
+	err = json.Unmarshal(s.Data, &session)
+	if err != nil {
+		return nil, pgtype.Int4{}, fmt.Errorf("decode webauthn session: %w", err)
+	}
+
+	return &session, s.UserID, nil
+}
+
+// BeginWebauthnRegistration starts registering a new passkey for the user. It returns the options for
+// navigator.credentials.create() and the session token for FinishWebauthnRegistration.
+func BeginWebauthnRegistration(ctx context.Context, userId int32) (*protocol.CredentialCreation, string, error) {
+	w, err := newWebauthn(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	u, err := loadWebauthnUser(ctx, userId)
+	if err != nil {
+		return nil, "", err
+	}
+
+	creation, session, err := w.BeginRegistration(u,
+		webauthn.WithExclusions(webauthn.Credentials(u.WebAuthnCredentials()).CredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("webauthn: %w", err)
+	}
+
+	token, err := saveWebauthnSession(ctx, webauthnRegister, pgtype.Int4{Valid: true, Int32: userId}, session)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return creation, token, nil
+}
+
+// FinishWebauthnRegistration verifies the response of navigator.credentials.create() and saves the passkey with
+// the name. ErrWebauthnSession and ErrWebauthnFailed are meant to be shown to the user.
+func FinishWebauthnRegistration(ctx context.Context, userId int32, sessionToken string, name string, response []byte) (int32, error) {
+	session, sessionUser, err := takeWebauthnSession(ctx, webauthnRegister, sessionToken)
+	if err != nil {
+		return 0, err
+	}
+	if sessionUser.Int32 != userId {
+		return 0, ErrWebauthnSession
+	}
+
+	w, err := newWebauthn(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	u, err := loadWebauthnUser(ctx, userId)
+	if err != nil {
+		return 0, err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(response)
+	if err != nil {
+		slog.Debug("parse webauthn registration", "err", err, "user_id", userId)
+		return 0, ErrWebauthnFailed
+	}
+
+	credential, err := w.CreateCredential(u, *session, parsed)
+	if err != nil {
+		slog.Debug("webauthn registration", "err", err, "user_id", userId)
+		return 0, ErrWebauthnFailed
+	}
+
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return 0, fmt.Errorf("encode webauthn credential: %w", err)
+	}
+
+	id, err := database.Q.CreateWebauthnCredential(ctx, database.CreateWebauthnCredentialParams{
+		UserID:       userId,
+		Name:         name,
+		CredentialID: credential.ID,
+		Credential:   data,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("db: %w", err)
+	}
+
+	slog.Info("passkey registered", "user_id", userId, "id", id)
+
+	return id, nil
+}
+
+// BeginWebauthnLogin starts a passwordless login with a passkey of any user. It returns the options for
+// navigator.credentials.get() and the session token for FinishWebauthnLogin.
+func BeginWebauthnLogin(ctx context.Context) (*protocol.CredentialAssertion, string, error) {
+	w, err := newWebauthn(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// the passkey replaces both the password and the second factor, so the user must be verified
+	assertion, session, err := w.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return nil, "", fmt.Errorf("webauthn: %w", err)
+	}
+
+	token, err := saveWebauthnSession(ctx, webauthnLogin, pgtype.Int4{}, session)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return assertion, token, nil
+}
+
+// FinishWebauthnLogin verifies the response of navigator.credentials.get() and returns the id of the user the
+// passkey belongs to. ErrWebauthnSession and ErrWebauthnFailed are meant to be shown to the user.
+func FinishWebauthnLogin(ctx context.Context, sessionToken string, response []byte) (int32, error) {
+	session, _, err := takeWebauthnSession(ctx, webauthnLogin, sessionToken)
+	if err != nil {
+		return 0, err
+	}
+
+	w, err := newWebauthn(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		slog.Debug("parse webauthn login", "err", err)
+		return 0, ErrWebauthnFailed
+	}
+
+	var u *webauthnUser
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		userId, err := strconv.Atoi(string(userHandle))
+		if err != nil {
+			return nil, fmt.Errorf("invalid user handle")
+		}
+		u, err = loadWebauthnUser(ctx, int32(userId))
+		if err != nil {
+			return nil, err
+		}
+		return u, nil
+	}
+
+	_, credential, err := w.ValidatePasskeyLogin(handler, *session, parsed)
+	if err != nil {
+		slog.Debug("webauthn login", "err", err)
+		return 0, ErrWebauthnFailed
+	}
+
+	err = updateWebauthnCredential(ctx, u, credential)
+	if err != nil {
+		return 0, err
+	}
+
+	return u.user.ID, nil
+}
+
+// BeginWebauthnTwoFactor starts verifying a passkey of the user as the second factor of login. It returns the
+// options for navigator.credentials.get() and the session token for FinishWebauthnTwoFactor.
+func BeginWebauthnTwoFactor(ctx context.Context, userId int32) (*protocol.CredentialAssertion, string, error) {
+	w, err := newWebauthn(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	u, err := loadWebauthnUser(ctx, userId)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(u.credentials) == 0 {
+		return nil, "", ErrTwoFactorNotEnabled
+	}
+
+	assertion, session, err := w.BeginLogin(u)
+	if err != nil {
+		return nil, "", fmt.Errorf("webauthn: %w", err)
+	}
+
+	token, err := saveWebauthnSession(ctx, webauthnTwoFactor, pgtype.Int4{Valid: true, Int32: userId}, session)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return assertion, token, nil
+}
+
+// FinishWebauthnTwoFactor verifies the response of navigator.credentials.get() for the user. ErrWebauthnSession
+// and ErrWebauthnFailed are meant to be shown to the user.
+func FinishWebauthnTwoFactor(ctx context.Context, userId int32, sessionToken string, response []byte) error {
+	session, sessionUser, err := takeWebauthnSession(ctx, webauthnTwoFactor, sessionToken)
+	if err != nil {
+		return err
+	}
+	if sessionUser.Int32 != userId {
+		return ErrWebauthnSession
+	}
+
+	w, err := newWebauthn(ctx)
+	if err != nil {
+		return err
+	}
+
+	u, err := loadWebauthnUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		slog.Debug("parse webauthn login", "err", err, "user_id", userId)
+		return ErrWebauthnFailed
+	}
+
+	credential, err := w.ValidateLogin(u, *session, parsed)
+	if err != nil {
+		slog.Debug("webauthn login", "err", err, "user_id", userId)
+		return ErrWebauthnFailed
+	}
+
+	return updateWebauthnCredential(ctx, u, credential)
+}
+
+// updateWebauthnCredential saves the sign count of a credential after it is used. A credential that looks
+// cloned is rejected.
+func updateWebauthnCredential(ctx context.Context, u *webauthnUser, credential *webauthn.Credential) error {
+	stored := u.findCredential(credential.ID)
+	if stored == nil {
+		return ErrWebauthnFailed
+	}
+
+	if credential.Authenticator.CloneWarning {
+		slog.Warn("passkey sign count went backwards, it may have been cloned", "user_id", u.user.ID, "id", stored.ID)
+		return ErrWebauthnFailed
+	}
+
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return fmt.Errorf("encode webauthn credential: %w", err)
+	}
+
+	err = database.Q.UpdateWebauthnCredentialUsed(ctx, database.UpdateWebauthnCredentialUsedParams{
+		ID:         stored.ID,
+		Credential: data,
+	})
+	if err != nil {
+		return fmt.Errorf("db: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+var realIPHeader = sync.OnceValue(func() string {
+	return os.Getenv("REAL_IP_HEADER")
+})
+
+// ClientIP returns the ip address of the client. Behind a reverse proxy, set REAL_IP_HEADER to the header the
+// proxy puts the client address in, e.g. X-Real-IP or CF-Connecting-IP. The header is trusted only if it is set,
+// because clients can send any header.
+//
+// If the header is a list, e.g. X-Forwarded-For, the last address is used, since that is the one added by the
+// proxy and the ones before it are sent by the client. The address of the connection is used if the header is not a
+// valid ip address.
+func ClientIP(r *http.Request) string {
+	if header := realIPHeader(); header != "" {
+		values := r.Header.Values(header)
+		if len(values) > 0 {
+			hops := strings.Split(values[len(values)-1], ",")
+			if ip := net.ParseIP(strings.TrimSpace(hops[len(hops)-1])); ip != nil {
+				return ip.String()
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String()
+	}
+	return host
+}
+
+// IPAllowed returns whether ip is one of the addresses or in one of the networks (CIDR notation) of list.
+func IPAllowed(ip string, list []string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+
+	for _, entry := range list {
+		if strings.Contains(entry, "/") {
+			_, network, err := net.ParseCIDR(entry)
+			if err == nil && network.Contains(addr) {
+				return true
+			}
+		} else if net.ParseIP(entry).Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     string // REAL_IP_HEADER
+		values     []string
+		remoteAddr string
+		want       string
+	}{
+		{"no header configured", "", []string{"1.2.3.4"}, "10.0.0.1:1234", "10.0.0.1"},
+		{"single address", "X-Real-IP", []string{"1.2.3.4"}, "10.0.0.1:1234", "1.2.3.4"},
+		{"ipv6", "X-Real-IP", []string{" 2001:DB8::1 "}, "10.0.0.1:1234", "2001:db8::1"},
+		{"last hop of a list", "X-Forwarded-For", []string{"6.6.6.6, 1.2.3.4"}, "10.0.0.1:1234", "1.2.3.4"},
+		{"last header", "X-Forwarded-For", []string{"6.6.6.6", "1.2.3.4"}, "10.0.0.1:1234", "1.2.3.4"},
+		{"invalid address", "X-Real-IP", []string{"' OR 1=1 --"}, "10.0.0.1:1234", "10.0.0.1"},
+		{"last hop invalid", "X-Forwarded-For", []string{"1.2.3.4, unknown"}, "10.0.0.1:1234", "10.0.0.1"},
+		{"missing header", "X-Real-IP", nil, "[::1]:1234", "::1"},
+	}
+
+	old := realIPHeader
+	t.Cleanup(func() { realIPHeader = old })
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			realIPHeader = func() string { return tt.header }
+
+			r, _ := http.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.values {
+				r.Header.Add("X-Real-IP", v)
+				r.Header.Add("X-Forwarded-For", v)
+			}
+
+			if got := ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// the SHA1 secret of RFC 6238 Appendix B, "12345678901234567890" in base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// the 8 digit codes of Appendix B, of which 6 digit codes are the last 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := TOTPCode(rfc6238Secret, TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("TOTPCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+
+		step, ok := TOTPVerify(rfc6238Secret, tt.want, time.Unix(tt.unix, 0))
+		if !ok || step != TOTPStep(time.Unix(tt.unix, 0)) {
+			t.Errorf("TOTPVerify at %d = %d, %v", tt.unix, step, ok)
+		}
+	}
+}
+
+func TestTOTPVerifyWindow(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	step := TOTPStep(now)
+
+	tests := []struct {
+		name   string
+		offset int64
+		ok     bool
+	}{
+		{"current step", 0, true},
+		{"previous step", -1, true},
+		{"next step", 1, true},
+		{"two steps ago", -2, false},
+		{"two steps ahead", 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := TOTPCode(rfc6238Secret, step+tt.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, ok := TOTPVerify(rfc6238Secret, code, now)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && got != step+tt.offset {
+				t.Errorf("step = %d, want %d", got, step+tt.offset)
+			}
+		})
+	}
+}
+
+func TestTOTPVerifyInvalid(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, code := range []string{"", "28708", "2870820", "abcdef"} {
+		if _, ok := TOTPVerify(rfc6238Secret, code, now); ok {
+			t.Errorf("TOTPVerify(%q) = true", code)
+		}
+	}
+	if _, ok := TOTPVerify("not base32!", "287082", now); ok {
+		t.Error("TOTPVerify with an invalid secret = true")
+	}
+
+	// spaces as shown by some authenticator apps
+	if _, ok := TOTPVerify(rfc6238Secret, "287 082", now); !ok {
+		t.Error("TOTPVerify with a space = false")
+	}
+}